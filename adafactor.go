@@ -0,0 +1,131 @@
+package convnet
+
+import "math"
+
+// adaFactor applies an AdaFactor update (Shazeer and Stern 2018) using the
+// batch gradients that step left in pglist, and then zeroes them.
+//
+// Consecutive parameter groups of the same length and decay settings are
+// treated as the rows of one matrix; for a fully connected or
+// convolutional layer these are its filters. Only the row and column sums
+// of the squared gradients are kept for a matrix, in t.gsum (one value per
+// row) and t.xsum (one value per column, stored with the first row). A
+// group on its own keeps an ordinary per-element second moment in t.gsum.
+//
+// The step size is relative to the scale of the parameters: each update
+// moves a matrix by about LearningRate times the RMS of its values (but no
+// less than AdaFactorEps2), and updates whose RMS is more than 1 are
+// scaled down.
+func (t *Trainer) adaFactor(pglist []ParamsAndGrads) {
+	// second moment decay, which starts at 0 and approaches 1
+	beta2 := 1 - math.Pow(float64(t.k/t.BatchSize), -0.8)
+
+	for i := 0; i < len(pglist); {
+		end := i + 1
+		for end < len(pglist) && sameGroupShape(pglist[i], pglist[end]) {
+			end++
+		}
+
+		if !pglist[i].Frozen && len(pglist[i].Params) != 0 {
+			if end-i == 1 {
+				t.adaFactorVector(i, pglist[i], beta2)
+			} else {
+				t.adaFactorMatrix(i, pglist[i:end], beta2)
+			}
+		}
+
+		i = end
+	}
+}
+
+func sameGroupShape(a, b ParamsAndGrads) bool {
+	return len(a.Params) == len(b.Params) && a.L1DecayMul == b.L1DecayMul && a.L2DecayMul == b.L2DecayMul && a.Frozen == b.Frozen
+}
+
+func (t *Trainer) adaFactorVector(i int, pg ParamsAndGrads, beta2 float64) {
+	p, g := pg.Params, pg.Grads
+
+	if t.gsum[i] == nil {
+		t.gsum[i] = make([]float64, len(p))
+	}
+	v := t.gsum[i]
+
+	for j := range g {
+		v[j] = beta2*v[j] + (1-beta2)*(g[j]*g[j]+t.AdaFactorEps1)
+	}
+
+	u := func(r, j int) float64 { return g[j] / math.Sqrt(v[j]) }
+	t.adaFactorApply([][]float64{p}, [][]float64{g}, u)
+}
+
+func (t *Trainer) adaFactorMatrix(i int, rows []ParamsAndGrads, beta2 float64) {
+	m := len(rows[0].Params)
+
+	if t.xsum[i] == nil {
+		t.xsum[i] = make([]float64, m)
+	}
+	c := t.xsum[i]
+
+	for j := range c {
+		c[j] *= beta2
+	}
+
+	sumR := 0.0
+	for r, pg := range rows {
+		if t.gsum[i+r] == nil {
+			t.gsum[i+r] = make([]float64, 1)
+		}
+
+		rowSum := 0.0
+		for j, g := range pg.Grads {
+			sq := g*g + t.AdaFactorEps1
+			rowSum += sq
+			c[j] += (1 - beta2) * sq
+		}
+
+		t.gsum[i+r][0] = beta2*t.gsum[i+r][0] + (1-beta2)*rowSum
+		sumR += t.gsum[i+r][0]
+	}
+
+	ps := make([][]float64, len(rows))
+	gs := make([][]float64, len(rows))
+	for r, pg := range rows {
+		ps[r], gs[r] = pg.Params, pg.Grads
+	}
+
+	u := func(r, j int) float64 {
+		vhat := t.gsum[i+r][0] * c[j] / sumR
+		return gs[r][j] / math.Sqrt(vhat)
+	}
+	t.adaFactorApply(ps, gs, u)
+}
+
+// moves ps against the unscaled updates u(r, j), with update clipping and
+// a step size relative to the RMS of ps, then zeroes gs
+func (t *Trainer) adaFactorApply(ps, gs [][]float64, u func(r, j int) float64) {
+	n := 0
+	sumU2, sumX2 := 0.0, 0.0
+	for r, p := range ps {
+		for j, x := range p {
+			uj := u(r, j)
+			sumU2 += uj * uj
+			sumX2 += x * x
+		}
+		n += len(p)
+	}
+
+	scale := 1 / math.Max(1, math.Sqrt(sumU2/float64(n)))
+	alpha := t.LearningRate * math.Max(t.AdaFactorEps2, math.Sqrt(sumX2/float64(n)))
+
+	for r, p := range ps {
+		for j := range p {
+			p[j] -= alpha * scale * u(r, j)
+		}
+	}
+
+	for _, g := range gs {
+		for j := range g {
+			g[j] = 0.0
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package convnet
+
+// layers that hold on to activations between Forward and Backward
+// implement forgetter so that checkpointed blocks can release them
+type forgetter interface {
+	forget()
+}
+
+// layers whose training-mode Forward is not a pure function of the input
+// implement replayer so that checkpointed blocks can be recomputed exactly
+type replayer interface {
+	replay(v *Vol) *Vol
+}
+
+func (n *Net) forwardCheckpointed(v *Vol) *Vol {
+	k := n.CheckpointEvery
+	n.checkpoints = n.checkpoints[:0]
+
+	act := v
+	for start := 0; start < len(n.Layers); start += k {
+		end := start + k
+		if end > len(n.Layers) {
+			end = len(n.Layers)
+		}
+
+		n.checkpoints = append(n.checkpoints, act)
+
+		for i := start; i < end; i++ {
+			act = n.forward(i, act, true)
+		}
+
+		if end == len(n.Layers) {
+			// the last block is needed right away by Backward
+			break
+		}
+
+		// keep only the boundary; the rest is recomputed later
+		for i := start; i < end; i++ {
+			if f, ok := n.Layers[i].(forgetter); ok {
+				f.forget()
+			}
+		}
+	}
+
+	return act
+}
+
+func (n *Net) backwardCheckpointed() {
+	k := n.CheckpointEvery
+	last := len(n.checkpoints) - 1
+
+	for b := last; b >= 0; b-- {
+		start, end := b*k, (b+1)*k
+		if end > len(n.Layers) {
+			end = len(n.Layers)
+		}
+
+		if b == last {
+			// still in memory from Forward; the loss layer was
+			// already handled by BackwardLoss
+			end--
+		} else {
+			// recompute the activations inside this block
+			act := n.checkpoints[b]
+			for i := start; i < end; i++ {
+				if r, ok := n.Layers[i].(replayer); ok {
+					act = r.replay(act)
+				} else {
+					act = n.forward(i, act, true)
+				}
+			}
+
+			// the next block already filled in the gradient
+			// wrt its input, which is our output
+			act.Dw = n.checkpoints[b+1].Dw
+		}
+
+		for i := end - 1; i >= start; i-- {
+			n.backward(i)
+		}
+	}
+
+	n.checkpoints = nil
+}
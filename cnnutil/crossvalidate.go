@@ -0,0 +1,119 @@
+package cnnutil
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/BenLubar/convnet"
+)
+
+// KFoldSplit divides the indices 0 to n-1 into k folds whose sizes differ
+// by at most one. If r is not nil, the indices are shuffled first;
+// otherwise each fold is a contiguous range.
+func KFoldSplit(n, k int, r *rand.Rand) [][]int {
+	if k < 1 || k > n {
+		panic(fmt.Sprintf("cnnutil: cannot split %d samples into %d folds", n, k))
+	}
+
+	var indices []int
+	if r != nil {
+		indices = r.Perm(n)
+	} else {
+		indices = make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+	}
+
+	folds := make([][]int, k)
+	start := 0
+	for i := range folds {
+		end := start + n/k
+		if i < n%k {
+			end++
+		}
+
+		folds[i] = indices[start:end:end]
+		start = end
+	}
+
+	return folds
+}
+
+// TrainFold trains net on the examples in loader for the given number of
+// steps and returns the final training loss.
+type TrainFold func(net *convnet.Net, loader convnet.DataLoader, steps int) float64
+
+// CVResult is the outcome of CrossValidate.
+type CVResult struct {
+	Accuracies []float64 // validation accuracy of each fold
+	Losses     []float64 // final training loss of each fold
+	Mean       float64   // mean of Accuracies
+	StdDev     float64   // population standard deviation of Accuracies
+}
+
+// CrossValidate runs k-fold cross-validation on data. For each fold, it
+// creates a net with newNet, trains it with train on the other folds, and
+// measures its classification accuracy on the held-out fold, comparing
+// the net's PredictClass to the Dim of each expected output. The folds are
+// chosen with KFoldSplit.
+func CrossValidate(newNet func() *convnet.Net, data convnet.DataLoader, k, steps int, train TrainFold, r *rand.Rand) (CVResult, error) {
+	if k < 2 || k > data.Len() {
+		return CVResult{}, fmt.Errorf("cnnutil: cannot cross-validate %d samples with %d folds", data.Len(), k)
+	}
+
+	folds := KFoldSplit(data.Len(), k, r)
+
+	result := CVResult{
+		Accuracies: make([]float64, k),
+		Losses:     make([]float64, k),
+	}
+
+	for i, fold := range folds {
+		var trainIndices []int
+		for j, other := range folds {
+			if j != i {
+				trainIndices = append(trainIndices, other...)
+			}
+		}
+
+		net := newNet()
+		result.Losses[i] = train(net, &subsetLoader{data, trainIndices}, steps)
+
+		correct := 0
+		for _, index := range fold {
+			x, y := data.Example(index)
+
+			c, err := net.PredictClass(x)
+			if err != nil {
+				return result, err
+			}
+
+			if c == y.Dim {
+				correct++
+			}
+		}
+
+		result.Accuracies[i] = float64(correct) / float64(len(fold))
+		result.Mean += result.Accuracies[i] / float64(k)
+	}
+
+	for _, a := range result.Accuracies {
+		result.StdDev += (a - result.Mean) * (a - result.Mean) / float64(k)
+	}
+	result.StdDev = math.Sqrt(result.StdDev)
+
+	return result, nil
+}
+
+// subsetLoader is a view of some of the examples of another DataLoader
+type subsetLoader struct {
+	data    convnet.DataLoader
+	indices []int
+}
+
+func (s *subsetLoader) Len() int { return len(s.indices) }
+func (s *subsetLoader) Example(i int) (*convnet.Vol, convnet.LossData) {
+	return s.data.Example(s.indices[i])
+}
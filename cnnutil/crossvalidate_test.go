@@ -0,0 +1,110 @@
+package cnnutil_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnutil"
+)
+
+// it should cover every index exactly once with nearly equal folds
+func TestKFoldSplit(t *testing.T) {
+	for _, r := range []*rand.Rand{nil, rand.New(rand.NewSource(0))} {
+		folds := cnnutil.KFoldSplit(23, 5, r)
+		if len(folds) != 5 {
+			t.Fatalf("expected 5 folds, but got %d", len(folds))
+		}
+
+		var all []int
+		for i, fold := range folds {
+			if len(fold) != 4 && len(fold) != 5 {
+				t.Errorf("expected fold %d to have 4 or 5 indices, but it has %d", i, len(fold))
+			}
+
+			all = append(all, fold...)
+		}
+
+		sort.Ints(all)
+		for i, index := range all {
+			if index != i {
+				t.Fatalf("expected every index once, but got %v", all)
+			}
+		}
+	}
+}
+
+// it should report the accuracy of each fold
+func TestCrossValidate(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	// the class is which of the two inputs is larger
+	data := &convnet.SliceLoader{}
+	for i := 0; i < 60; i++ {
+		a, b := r.Float64(), r.Float64()
+		label := 0
+		if b > a {
+			label = 1
+		}
+
+		data.X = append(data.X, convnet.NewVol1D([]float64{a, b}))
+		data.Y = append(data.Y, convnet.LossData{Dim: label})
+	}
+
+	newNet := func() *convnet.Net {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+			{Type: convnet.LayerSoftmax, NumClasses: 2},
+		}, r)
+
+		return net
+	}
+
+	trained := 0
+	train := func(net *convnet.Net, loader convnet.DataLoader, steps int) float64 {
+		trained++
+
+		if loader.Len() != 48 {
+			t.Errorf("expected 48 training examples, but got %d", loader.Len())
+		}
+
+		opts := convnet.DefaultTrainerOptions
+		opts.LearningRate = 0.1
+		trainer := convnet.NewTrainer(net, opts)
+
+		loss := 0.0
+		for i := 0; i < steps; i++ {
+			x, y := loader.Example(i % loader.Len())
+			loss = trainer.Train(x, y).Loss
+		}
+
+		return loss
+	}
+
+	result, err := cnnutil.CrossValidate(newNet, data, 5, 2000, train, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trained != 5 || len(result.Accuracies) != 5 || len(result.Losses) != 5 {
+		t.Fatalf("expected 5 folds, but got %d trained, %+v", trained, result)
+	}
+
+	mean, variance := 0.0, 0.0
+	for _, a := range result.Accuracies {
+		mean += a / 5
+	}
+	for _, a := range result.Accuracies {
+		variance += (a - mean) * (a - mean) / 5
+	}
+
+	if math.Abs(result.Mean-mean) > 1e-12 || math.Abs(result.StdDev-math.Sqrt(variance)) > 1e-12 {
+		t.Errorf("expected mean %f and stddev %f, but got %+v", mean, math.Sqrt(variance), result)
+	}
+	if result.Mean < 0.9 {
+		t.Errorf("expected a mean accuracy of at least 0.9, but got %+v", result)
+	}
+}
@@ -1,6 +1,8 @@
 // Package cnnutil contains various utility functions.
 package cnnutil
 
+import "sort"
+
 // Window stores _size_ number of values
 // and returns averages. Useful for keeping running
 // track of validation or training accuracy during SGD
@@ -44,6 +46,64 @@ func (w *Window) Average() float64 {
 
 	return sum / float64(len(w.V))
 }
+
+// returns the p-th percentile (p in [0, 1]) of the values in the window,
+// linearly interpolating between the nearest ranks
+func (w *Window) Percentile(p float64) float64 {
+	sorted := w.sorted()
+	if sorted == nil {
+		return -1
+	}
+
+	return percentile(sorted, p)
+}
+
+// returns a sorted copy of the values in the window, or nil if there are
+// not enough of them yet
+func (w *Window) sorted() []float64 {
+	if len(w.V) < w.MinSize || len(w.V) == 0 {
+		return nil
+	}
+
+	sorted := make([]float64, len(w.V))
+	copy(sorted, w.V)
+	sort.Float64s(sorted)
+
+	return sorted
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 1 {
+		return sorted[len(sorted)-1]
+	}
+
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	frac := pos - float64(i)
+
+	if i+1 >= len(sorted) {
+		return sorted[i]
+	}
+
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+func (w *Window) Median() float64 {
+	return w.Percentile(0.5)
+}
+
+// returns the interquartile range, which unlike the average
+// is not dominated by a few very large values
+func (w *Window) IQR() float64 {
+	sorted := w.sorted()
+	if sorted == nil {
+		return -1
+	}
+
+	return percentile(sorted, 0.75) - percentile(sorted, 0.25)
+}
 func (w *Window) Reset() {
 	w.V = w.V[:0]
 	w.Index = 0
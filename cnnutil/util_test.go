@@ -0,0 +1,94 @@
+package cnnutil_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/BenLubar/convnet/cnnutil"
+)
+
+// it should interpolate between the nearest ranks of the values in the
+// window, whatever order they were added in
+func TestWindowPercentile(t *testing.T) {
+	w := cnnutil.NewWindow(5, 1)
+	for _, x := range []float64{4, 1, 5, 3, 2} {
+		w.Add(x)
+	}
+
+	for _, c := range []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{0.1, 1.4},
+		{0.25, 2},
+		{0.5, 3},
+		{0.9, 4.6},
+		{1, 5},
+		{-1, 1},
+		{2, 5},
+	} {
+		if got := w.Percentile(c.p); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("percentile %g: expected %g, but got %g", c.p, c.want, got)
+		}
+	}
+
+	if got := w.IQR(); math.Abs(got-2) > 1e-12 {
+		t.Errorf("expected an interquartile range of 2, but got %g", got)
+	}
+
+	// old values leave the window
+	for _, x := range []float64{10, 20, 30} {
+		w.Add(x)
+	}
+	if got := w.Percentile(0.5); got != 10 {
+		t.Errorf("expected the median of 3, 2, 10, 20, 30 to be 10, but got %g", got)
+	}
+}
+
+// it should take the middle value of an odd count, and the mean of the two
+// middle values of an even count
+func TestWindowMedian(t *testing.T) {
+	w := cnnutil.NewWindow(10, 1)
+	for _, x := range []float64{7, 1, 3} {
+		w.Add(x)
+	}
+	if got := w.Median(); got != 3 {
+		t.Errorf("expected the median of 7, 1, 3 to be 3, but got %g", got)
+	}
+
+	w.Add(4)
+	if got := w.Median(); got != 3.5 {
+		t.Errorf("expected the median of 7, 1, 3, 4 to be 3.5, but got %g", got)
+	}
+}
+
+// it should return -1 until the window has MinSize values
+func TestWindowMinSize(t *testing.T) {
+	w := cnnutil.NewWindow(10, 3)
+
+	for i := 0; i < 3; i++ {
+		for name, got := range map[string]float64{
+			"percentile": w.Percentile(0.5),
+			"median":     w.Median(),
+			"iqr":        w.IQR(),
+		} {
+			if got != -1 {
+				t.Errorf("%s of %d values: expected -1, but got %g", name, i, got)
+			}
+		}
+
+		w.Add(float64(i))
+	}
+
+	if got := w.IQR(); got != 1 {
+		t.Errorf("expected an interquartile range of 1 for 0, 1, 2, but got %g", got)
+	}
+	if got := w.Median(); got != 1 {
+		t.Errorf("expected a median of 1 for 0, 1, 2, but got %g", got)
+	}
+
+	w.Reset()
+	if got := w.Median(); got != -1 {
+		t.Errorf("expected -1 after Reset, but got %g", got)
+	}
+}
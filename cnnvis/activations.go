@@ -0,0 +1,164 @@
+package cnnvis
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/BenLubar/convnet"
+)
+
+// Colormap converts a value between 0 and 1 to a color.
+type Colormap func(t float64) color.Color
+
+// Grayscale maps 0 to black and 1 to white.
+func Grayscale(t float64) color.Color {
+	return color.Gray{Y: uint8(math.Round(t * 255))}
+}
+
+// Heat maps 0 to black, then goes through red and yellow, and maps 1 to
+// white.
+func Heat(t float64) color.Color {
+	channel := func(start float64) uint8 {
+		c := (t - start) * 3
+		return uint8(math.Round(math.Max(0, math.Min(1, c)) * 255))
+	}
+
+	return color.RGBA{R: channel(0), G: channel(1.0 / 3), B: channel(2.0 / 3), A: 0xff}
+}
+
+// ActivationMaps runs input through net in prediction mode and renders
+// the output of layer layerIndex with RenderActivations in grayscale.
+func ActivationMaps(net *convnet.Net, layerIndex int, input *convnet.Vol) []image.Image {
+	net.Forward(input, false)
+
+	return RenderActivations(net.ActivationAt(layerIndex), Grayscale)
+}
+
+// RenderActivations draws each depth slice of v as its own image, with
+// one pixel per activation, scaling each slice so that its smallest value
+// is 0 and its largest is 1 before applying cmap. A slice where every
+// value is the same is drawn with cmap(0.5).
+//
+// If v is 1x1xN, as for a fully connected layer, it instead returns a
+// single image N pixels wide and 1 pixel tall, with the values scaled
+// together.
+func RenderActivations(v *convnet.Vol, cmap Colormap) []image.Image {
+	if v.Sx == 1 && v.Sy == 1 {
+		lo, hi := valueRange(v.W)
+
+		img := image.NewRGBA(image.Rect(0, 0, v.Depth, 1))
+		for d, w := range v.W {
+			img.Set(d, 0, cmap(normalize(w, lo, hi)))
+		}
+
+		return []image.Image{img}
+	}
+
+	imgs := make([]image.Image, v.Depth)
+	values := make([]float64, v.Sx*v.Sy)
+
+	for d := range imgs {
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				values[y*v.Sx+x] = v.Get(x, y, d)
+			}
+		}
+
+		lo, hi := valueRange(values)
+
+		img := image.NewRGBA(image.Rect(0, 0, v.Sx, v.Sy))
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				img.Set(x, y, cmap(normalize(values[y*v.Sx+x], lo, hi)))
+			}
+		}
+
+		imgs[d] = img
+	}
+
+	return imgs
+}
+
+// SaveGrid tiles imgs into rows of cols images, with padding pixels of
+// black between them, each scaled up by scale, and writes the result to
+// path as a PNG. If cols is zero or less, the grid is roughly square. If
+// scale is zero or less, the images are not scaled.
+func SaveGrid(path string, imgs []image.Image, cols, padding, scale int) error {
+	if scale <= 0 {
+		scale = 1
+	}
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(imgs)))))
+	}
+	if cols > len(imgs) {
+		cols = len(imgs)
+	}
+
+	rows := 0
+	if cols > 0 {
+		rows = (len(imgs) + cols - 1) / cols
+	}
+
+	// every cell is as big as the biggest image
+	cw, ch := 0, 0
+	for _, img := range imgs {
+		b := img.Bounds()
+		if b.Dx()*scale > cw {
+			cw = b.Dx() * scale
+		}
+		if b.Dy()*scale > ch {
+			ch = b.Dy() * scale
+		}
+	}
+
+	grid := image.NewRGBA(image.Rect(0, 0, cols*(cw+padding)+padding, rows*(ch+padding)+padding))
+	draw.Draw(grid, grid.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	for i, img := range imgs {
+		left := (i%cols)*(cw+padding) + padding
+		top := (i/cols)*(ch+padding) + padding
+
+		b := img.Bounds()
+		for x := 0; x < b.Dx()*scale; x++ {
+			for y := 0; y < b.Dy()*scale; y++ {
+				grid.Set(left+x, top+y, img.At(b.Min.X+x/scale, b.Min.Y+y/scale))
+			}
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, grid); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func valueRange(values []float64) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+
+	return
+}
+
+// scales v from [lo, hi] to [0, 1]; if lo and hi are the same, it is 0.5
+func normalize(v, lo, hi float64) float64 {
+	if hi <= lo {
+		return 0.5
+	}
+
+	return (v - lo) / (hi - lo)
+}
@@ -0,0 +1,119 @@
+package cnnvis_test
+
+import (
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnvis"
+)
+
+func gray(img interface{ At(x, y int) color.Color }, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+// it should scale each channel separately and draw constant channels gray
+func TestRenderActivations(t *testing.T) {
+	v := convnet.NewVol(3, 2, 2, 0.0)
+
+	// depth 0 goes from -1 to 4 across the image; depth 1 is constant
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 2; y++ {
+			v.Set(x, y, 0, float64(x+y*3)-1)
+			v.Set(x, y, 1, 7)
+		}
+	}
+
+	imgs := cnnvis.RenderActivations(v, cnnvis.Grayscale)
+	if len(imgs) != 2 {
+		t.Fatalf("expected 2 images, but got %d", len(imgs))
+	}
+
+	for _, img := range imgs {
+		if b := img.Bounds(); b.Dx() != 3 || b.Dy() != 2 {
+			t.Errorf("expected a 3x2 image, but got %dx%d", b.Dx(), b.Dy())
+		}
+	}
+
+	if g := gray(imgs[0], 0, 0); g != 0 {
+		t.Errorf("expected the smallest value to be black, but it is %d", g)
+	}
+	if g := gray(imgs[0], 2, 1); g != 255 {
+		t.Errorf("expected the largest value to be white, but it is %d", g)
+	}
+	if g := gray(imgs[0], 2, 0); g != 102 {
+		t.Errorf("expected 1 to be 40%% gray, but it is %d", g)
+	}
+
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 2; y++ {
+			if g := gray(imgs[1], x, y); g != 128 {
+				t.Errorf("expected the constant channel to be middle gray at (%d, %d), but it is %d", x, y, g)
+			}
+		}
+	}
+
+	// a constant vector is a strip of the middle color
+	strip := cnnvis.RenderActivations(convnet.NewVol(1, 1, 5, math.Pi), cnnvis.Heat)
+	if len(strip) != 1 {
+		t.Fatalf("expected 1 image, but got %d", len(strip))
+	}
+	if b := strip[0].Bounds(); b.Dx() != 5 || b.Dy() != 1 {
+		t.Errorf("expected a 5x1 strip, but got %dx%d", b.Dx(), b.Dy())
+	}
+	expected := color.RGBAModel.Convert(cnnvis.Heat(0.5))
+	for x := 0; x < 5; x++ {
+		if c := color.RGBAModel.Convert(strip[0].At(x, 0)); c != expected {
+			t.Errorf("expected %v at %d, but got %v", expected, x, c)
+		}
+	}
+}
+
+// it should write every map of a layer to one PNG
+func TestSaveGrid(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 5, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	x := convnet.NewVolRand(6, 6, 1, rand.New(rand.NewSource(1)))
+	imgs := cnnvis.ActivationMaps(net, 2, x)
+	if len(imgs) != 5 {
+		t.Fatalf("expected 5 maps, but got %d", len(imgs))
+	}
+
+	dir, err := ioutil.TempDir("", "cnnvis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "grid.png")
+	if err := cnnvis.SaveGrid(path, imgs, 0, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 columns and 2 rows of 12x12 cells
+	if b := img.Bounds(); b.Dx() != 3*13+1 || b.Dy() != 2*13+1 {
+		t.Errorf("expected a 40x27 image, but got %dx%d", b.Dx(), b.Dy())
+	}
+}
@@ -0,0 +1,189 @@
+package cnnvis
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/BenLubar/convnet"
+)
+
+// Normalization chooses which weights are scaled together when converting
+// filters to pixels.
+type Normalization int
+
+const (
+	// NormalizePerFilter maps the smallest and largest weight of each
+	// filter to black and white.
+	NormalizePerFilter Normalization = iota
+	// NormalizeGlobal maps the smallest and largest weight of all of the
+	// filters to black and white, so filters can be compared.
+	NormalizeGlobal
+)
+
+// Collapse chooses how filters with a depth other than 1 or 3 are turned
+// into grayscale images.
+type Collapse int
+
+const (
+	// CollapseAverage averages the weights over the depth.
+	CollapseAverage Collapse = iota
+	// CollapseSlice shows the weights of a single depth, Channel.
+	CollapseSlice
+)
+
+// FilterGridOptions controls how FilterGrid draws filters.
+type FilterGridOptions struct {
+	// Scale is the width and height in pixels of each weight. Zero or
+	// less means 1.
+	Scale int
+	// Padding is the number of pixels between filters and around the
+	// edge of the grid.
+	Padding int
+	// Columns is the number of filters in each row of the grid. Zero or
+	// less makes the grid roughly square.
+	Columns int
+
+	Normalize Normalization
+	Collapse  Collapse
+	// Channel is the depth shown when Collapse is CollapseSlice.
+	Channel int
+}
+
+// FilterGrid draws the filters of a conv layer side by side, like the
+// convnetjs demos. Filters with a depth of 1 are drawn in grayscale and
+// filters with a depth of 3 are drawn in color, with depths 0, 1, and 2
+// as red, green, and blue. Filters of any other depth are collapsed to
+// grayscale as chosen by opts. The padding is black.
+func FilterGrid(l *convnet.ConvLayer, opts FilterGridOptions) image.Image {
+	filters := l.Filters()
+
+	scale := opts.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+
+	cols := opts.Columns
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(filters)))))
+	}
+	if cols > len(filters) {
+		cols = len(filters)
+	}
+
+	rows := 0
+	if cols > 0 {
+		rows = (len(filters) + cols - 1) / cols
+	}
+
+	// each filter as planes of values to draw: one for gray, three for color
+	planes := make([][][]float64, len(filters))
+	sx, sy := 0, 0
+	for i, f := range filters {
+		planes[i] = filterPlanes(f, opts)
+		sx, sy = f.Sx, f.Sy
+	}
+
+	gmin, gmax := math.Inf(1), math.Inf(-1)
+	for _, p := range planes {
+		pmin, pmax := planeRange(p)
+		gmin, gmax = math.Min(gmin, pmin), math.Max(gmax, pmax)
+	}
+
+	pad := opts.Padding
+	w := cols*(sx*scale+pad) + pad
+	h := rows*(sy*scale+pad) + pad
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i+3] = 0xff
+	}
+
+	for i, p := range planes {
+		lo, hi := gmin, gmax
+		if opts.Normalize == NormalizePerFilter {
+			lo, hi = planeRange(p)
+		}
+
+		left := (i%cols)*(sx*scale+pad) + pad
+		top := (i/cols)*(sy*scale+pad) + pad
+
+		for x := 0; x < sx; x++ {
+			for y := 0; y < sy; y++ {
+				var c color.RGBA
+				c.A = 0xff
+
+				if len(p) == 3 {
+					c.R = toByte(p[0][y*sx+x], lo, hi)
+					c.G = toByte(p[1][y*sx+x], lo, hi)
+					c.B = toByte(p[2][y*sx+x], lo, hi)
+				} else {
+					c.R = toByte(p[0][y*sx+x], lo, hi)
+					c.G, c.B = c.R, c.R
+				}
+
+				for dx := 0; dx < scale; dx++ {
+					for dy := 0; dy < scale; dy++ {
+						img.SetRGBA(left+x*scale+dx, top+y*scale+dy, c)
+					}
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// filterPlanes returns the values of f to draw, indexed by channel and
+// then by y*sx+x
+func filterPlanes(f *convnet.Vol, opts FilterGridOptions) [][]float64 {
+	plane := func(value func(x, y int) float64) []float64 {
+		p := make([]float64, f.Sx*f.Sy)
+		for x := 0; x < f.Sx; x++ {
+			for y := 0; y < f.Sy; y++ {
+				p[y*f.Sx+x] = value(x, y)
+			}
+		}
+
+		return p
+	}
+
+	channel := func(d int) []float64 {
+		return plane(func(x, y int) float64 { return f.Get(x, y, d) })
+	}
+
+	switch {
+	case f.Depth == 1:
+		return [][]float64{channel(0)}
+	case f.Depth == 3:
+		return [][]float64{channel(0), channel(1), channel(2)}
+	case opts.Collapse == CollapseSlice:
+		return [][]float64{channel(opts.Channel)}
+	default:
+		return [][]float64{plane(func(x, y int) float64 {
+			sum := 0.0
+			for d := 0; d < f.Depth; d++ {
+				sum += f.Get(x, y, d)
+			}
+
+			return sum / float64(f.Depth)
+		})}
+	}
+}
+
+func planeRange(planes [][]float64) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+
+	for _, p := range planes {
+		for _, v := range p {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+
+	return
+}
+
+// maps lo to 0 and hi to 255; if every value is the same, it is drawn gray
+func toByte(v, lo, hi float64) uint8 {
+	return uint8(math.Round(normalize(v, lo, hi) * 255))
+}
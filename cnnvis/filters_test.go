@@ -0,0 +1,82 @@
+package cnnvis_test
+
+import (
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnvis"
+)
+
+func convLayer(depth, filters int) *convnet.ConvLayer {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: depth},
+		{Type: convnet.LayerConv, Sx: 3, Sy: 2, Filters: filters},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	}, rand.New(rand.NewSource(0)))
+
+	return net.Layers[1].(*convnet.ConvLayer)
+}
+
+// it should lay out the filters in a grid with padding
+func TestFilterGridSize(t *testing.T) {
+	img := cnnvis.FilterGrid(convLayer(5, 7), cnnvis.FilterGridOptions{Scale: 2, Padding: 1})
+
+	// 3 columns and 3 rows of 6x4 pixel filters
+	if b := img.Bounds(); b.Dx() != 3*(6+1)+1 || b.Dy() != 3*(4+1)+1 {
+		t.Errorf("expected a 22x16 image, but got %dx%d", b.Dx(), b.Dy())
+	}
+
+	img = cnnvis.FilterGrid(convLayer(1, 7), cnnvis.FilterGridOptions{Columns: 7})
+	if b := img.Bounds(); b.Dx() != 7*3 || b.Dy() != 2 {
+		t.Errorf("expected a 21x2 image, but got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+// it should draw a hand-made filter with the expected colors
+func TestFilterGridPixels(t *testing.T) {
+	l := convLayer(3, 2)
+
+	// the first filter has one red, one green, and one blue weight, and
+	// the rest of its weights are the lowest
+	f := l.Filters()[0]
+	f.SetConst(-1)
+	f.Set(0, 0, 0, 1)
+	f.Set(1, 0, 1, 1)
+	f.Set(2, 1, 2, 1)
+
+	// the second filter is half as strong
+	g := l.Filters()[1]
+	g.SetConst(-0.5)
+	g.Set(0, 0, 0, 0.5)
+
+	for _, tt := range []struct {
+		normalize cnnvis.Normalization
+		high, low uint8
+	}{
+		{cnnvis.NormalizePerFilter, 255, 0},
+		{cnnvis.NormalizeGlobal, 191, 64},
+	} {
+		img := cnnvis.FilterGrid(l, cnnvis.FilterGridOptions{Columns: 2, Padding: 1, Normalize: tt.normalize})
+
+		black := color.RGBA{0, 0, 0, 255}
+		for _, c := range []struct {
+			x, y     int
+			expected color.RGBA
+		}{
+			{0, 0, black},
+			{1, 1, color.RGBA{255, 0, 0, 255}},
+			{2, 1, color.RGBA{0, 255, 0, 255}},
+			{3, 2, color.RGBA{0, 0, 255, 255}},
+			{3, 1, black},
+			{5, 1, color.RGBA{tt.high, tt.low, tt.low, 255}},
+			{6, 2, color.RGBA{tt.low, tt.low, tt.low, 255}},
+		} {
+			if actual := color.RGBAModel.Convert(img.At(c.x, c.y)); actual != c.expected {
+				t.Errorf("normalization %d: expected (%d, %d) to be %v, but it is %v", tt.normalize, c.x, c.y, c.expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package convnet
+
+// Compile returns a copy of the net that is optimized for making
+// predictions. It starts from InferenceMode, so the scale of each dropout
+// layer is folded into the weights of a later layer where possible, and
+// then removes those dropout layers entirely. The input layer is kept to
+// declare the size of inputs, but Forward skips it.
+//
+// The outputs of the compiled net match those of Forward in prediction
+// mode on the original, up to rounding from folding the dropout scales
+// (exactly, if every drop probability is a power of two). A compiled net
+// cannot be trained: Backward panics. Being compiled is not saved by
+// MarshalJSON, so a net loaded from a compiled net can be trained as usual.
+func (n *Net) Compile() *Net {
+	clone := n.InferenceMode()
+
+	layers := make([]Layer, 0, len(clone.Layers))
+	for _, l := range clone.Layers {
+		if d, ok := l.(*DropoutLayer); ok && d.inference {
+			continue
+		}
+
+		layers = append(layers, l)
+	}
+
+	clone.Layers = layers
+	clone.compiled = true
+
+	return clone
+}
+
+// Compiled reports whether the net was created by Compile.
+func (n *Net) Compiled() bool {
+	return n.compiled
+}
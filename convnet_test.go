@@ -1,11 +1,20 @@
 package convnet_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/gradcheck"
 )
 
 // Simple Fully-Connected Neural Net Classifier.
@@ -123,3 +132,4333 @@ func TestGradient(t *testing.T) {
 		}
 	}
 }
+
+// it should report structural problems as typed errors
+func TestValidate(t *testing.T) {
+	net, _, _ := createTestNet()
+
+	if err := net.Validate(); err != nil {
+		t.Errorf("expected test net to be valid, but got %v", err)
+	}
+
+	// an fc layer that expects the wrong number of inputs
+	net.Layers[1], net.Layers[3] = net.Layers[3], net.Layers[1]
+	if err, ok := net.Validate().(*convnet.ShapeError); !ok {
+		t.Errorf("expected *ShapeError, but got %v", err)
+	}
+
+	err := convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	})
+	if le, ok := err.(*convnet.LayerError); !ok || le.LayerIndex != 1 {
+		t.Errorf("expected *LayerError for layer 1, but got %v", err)
+	}
+
+	// a stride larger than the overhang would otherwise hide the problem
+	err = convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 3, OutSy: 3, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 5, Stride: 3, Filters: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	})
+	if le, ok := err.(*convnet.LayerError); !ok || le.LayerIndex != 1 || le.Reason != "filter is larger than the padded input" {
+		t.Errorf("expected *LayerError for a filter larger than the input, but got %v", err)
+	}
+
+	// padding can make room for the filter
+	err = convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 3, OutSy: 3, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 5, Stride: 3, Pad: 1, Filters: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	})
+	if err != nil {
+		t.Errorf("expected a padded input to fit the filter, but got %v", err)
+	}
+
+	err = convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutDepth: 3},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerMaxout, GroupSize: 3},
+	})
+	if le, ok := err.(*convnet.LayerError); !ok || le.Type != convnet.LayerMaxout {
+		t.Errorf("expected *LayerError for maxout layer, but got %v", err)
+	}
+}
+
+// it should route the gradient of a 1x1 maxout layer to the winning inputs
+func TestMaxoutBackward(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 6},
+		{Type: convnet.LayerMaxout, GroupSize: 3},
+		{Type: convnet.LayerRegression, NumNeurons: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	x := convnet.NewVol1D([]float64{1, 5, 2, -3, -1, -2})
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 0, Val: 1})
+
+	out := net.ActivationAt(1)
+	expected := []float64{0, out.Dw[0], 0, 0, out.Dw[1], 0}
+	for i := range expected {
+		if x.Dw[i] != expected[i] {
+			t.Errorf("expected input gradient %d to be %f, but it is %f", i, expected[i], x.Dw[i])
+		}
+	}
+}
+
+// it should compute the same gradients with and without checkpointing
+func TestCheckpointing(t *testing.T) {
+	layerDefs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh, DropProb: 0.5},
+		{Type: convnet.LayerSVM, NumClasses: 3},
+	}
+
+	makeNet := func() *convnet.Net {
+		net := &convnet.Net{}
+		net.MakeLayers(layerDefs, rand.New(rand.NewSource(0)))
+		return net
+	}
+
+	plain, checkpointed := makeNet(), makeNet()
+	checkpointed.CheckpointEvery = 2
+
+	x1 := convnet.NewVolRand(6, 6, 2, rand.New(rand.NewSource(1)))
+	x2 := x1.Clone()
+
+	plain.Forward(x1, true)
+	loss1 := plain.Backward(convnet.LossData{Dim: 1})
+	checkpointed.Forward(x2, true)
+	loss2 := checkpointed.Backward(convnet.LossData{Dim: 1})
+
+	if loss1 != loss2 {
+		t.Errorf("expected losses to match, but got %f and %f", loss1, loss2)
+	}
+
+	for i := range x1.Dw {
+		if x1.Dw[i] != x2.Dw[i] {
+			t.Errorf("input gradient %d differs: %f vs %f", i, x1.Dw[i], x2.Dw[i])
+		}
+	}
+
+	pg1, pg2 := plain.ParamsAndGrads(), checkpointed.ParamsAndGrads()
+	for i := range pg1 {
+		for j := range pg1[i].Grads {
+			if pg1[i].Grads[j] != pg2[i].Grads[j] {
+				t.Errorf("parameter gradient %d/%d differs: %f vs %f", i, j, pg1[i].Grads[j], pg2[i].Grads[j])
+			}
+		}
+	}
+}
+
+// it should pass the gradient from the loss layer to the layer feeding it
+func TestGradientFlow(t *testing.T) {
+	net, _, r := createTestNet()
+
+	for k := 0; k < 2; k++ {
+		// the fc layer right below the softmax only gets a parameter
+		// gradient if the softmax wrote into its current output
+		fc := net.Layers[len(net.Layers)-2]
+		for _, pg := range fc.ParamsAndGrads() {
+			for j := range pg.Grads {
+				pg.Grads[j] = 0
+			}
+		}
+
+		x := convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1})
+		net.Forward(x, true)
+		net.Backward(convnet.LossData{Dim: r.Intn(3)})
+
+		nonzero := false
+		for _, pg := range fc.ParamsAndGrads() {
+			for _, g := range pg.Grads {
+				if g != 0 {
+					nonzero = true
+				}
+			}
+		}
+
+		if !nonzero {
+			t.Errorf("pass %d: expected layer below softmax to receive a gradient", k)
+		}
+	}
+}
+
+// it should compare Vols exactly and approximately
+func TestVolEqual(t *testing.T) {
+	a := convnet.NewVol1D([]float64{1, 2, 3})
+	b := a.Clone()
+
+	if !a.Equal(b) {
+		t.Error("expected clone to be equal")
+	}
+
+	b.W[1] += 1e-9
+	if a.Equal(b) {
+		t.Error("expected perturbed clone not to be exactly equal")
+	}
+	if !a.ApproxEqual(b, 1e-6) {
+		t.Error("expected perturbed clone to be approximately equal")
+	}
+
+	if d, err := a.MaxAbsDiff(b); err != nil || math.Abs(d-1e-9) > 1e-12 {
+		t.Errorf("expected max difference of 1e-9, but got %g (%v)", d, err)
+	}
+
+	if _, err := a.MaxAbsDiff(convnet.NewVol(3, 1, 1, 0)); err == nil {
+		t.Error("expected an error comparing differently shaped Vols")
+	}
+
+	// both agree that NaN equals NaN and nothing else
+	nan := convnet.NewVol1D([]float64{1, math.NaN(), 3})
+	if !nan.Equal(nan.Clone()) || !nan.ApproxEqual(nan.Clone(), 1e-6) {
+		t.Error("expected NaN to equal NaN")
+	}
+	if nan.Equal(a) || nan.ApproxEqual(a, math.Inf(1)) {
+		t.Error("expected NaN not to equal a number")
+	}
+	otherNaN := convnet.NewVol1D([]float64{1, math.Float64frombits(math.Float64bits(math.NaN()) ^ 1), 3})
+	if !nan.Equal(otherNaN) {
+		t.Error("expected NaNs with different bits to be equal")
+	}
+
+	// unlike ==, Equal tells 0 and -0 apart
+	if convnet.NewVol1D([]float64{0}).Equal(convnet.NewVol1D([]float64{math.Copysign(0, -1)})) {
+		t.Error("expected 0 not to equal -0")
+	}
+
+	inf := convnet.NewVol1D([]float64{1, math.Inf(1), 3})
+	if !inf.Equal(inf.Clone()) || !inf.ApproxEqual(inf.Clone(), 1e-6) {
+		t.Error("expected infinity to equal itself")
+	}
+}
+
+// it should summarize the layers of the net
+func TestSummary(t *testing.T) {
+	net, _, _ := createTestNet()
+
+	const expected = `#     layer        output shape   params
+0     input        1x1x2          0
+1     fc           1x1x5          15
+2     tanh         1x1x5          0
+3     fc           1x1x5          30
+4     tanh         1x1x5          0
+5     fc           1x1x3          18
+6     softmax      1x1x3          0
+total params: 63
+`
+
+	if s := net.Summary(); s != expected {
+		t.Errorf("unexpected summary:\n%s", s)
+	}
+
+	// it should also work for nets loaded from JSON
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded convnet.Net
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := loaded.Summary(); s != expected {
+		t.Errorf("unexpected summary for loaded net:\n%s", s)
+	}
+}
+
+// it should count weights and biases
+func TestNumParameters(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 5, Sy: 3, Filters: 4, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerFC, NumNeurons: 7},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	conv := 5*3*3*4 + 4 // sx*sy*inDepth*filters + filters
+	if c := convnet.NumParameters(net.Layers[1]); c != conv {
+		t.Errorf("expected conv layer to have %d parameters, but it has %d", conv, c)
+	}
+
+	// conv output is 4x6x4, pooled to 2x3x4
+	fc := 2*3*4*7 + 7
+	if c := convnet.NumParameters(net.Layers[4]); c != fc {
+		t.Errorf("expected fc layer to have %d parameters, but it has %d", fc, c)
+	}
+
+	if c := convnet.NumParameters(net.Layers[2]); c != 0 {
+		t.Errorf("expected relu layer to have no parameters, but it has %d", c)
+	}
+
+	softmaxFC := 7*2 + 2
+	if c := net.NumParameters(); c != conv+fc+softmaxFC {
+		t.Errorf("expected net to have %d parameters, but it has %d", conv+fc+softmaxFC, c)
+	}
+
+	// frozen parameters are still parameters
+	if err := net.SetTrainable(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if c := net.NumParameters(); c != conv+fc+softmaxFC {
+		t.Errorf("expected net to have %d parameters with a frozen layer, but it has %d", conv+fc+softmaxFC, c)
+	}
+}
+
+// it should chain receptive fields through conv and pool layers
+func TestReceptiveField(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 16, OutSy: 16, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 3, Pad: 1, Filters: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerConv, Sx: 5, Stride: 2, Pad: 2, Filters: 2},
+		{Type: convnet.LayerBatchNorm},
+		{Type: convnet.LayerConv, Sx: 3, Pad: 1, Filters: 2, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerFC, NumNeurons: 3},
+		{Type: convnet.LayerConv, Sx: 3, Pad: 1, Filters: 2}, // not counted after the fc layer
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	}, rand.New(rand.NewSource(0)))
+
+	if size, stride, pad := net.Layers[4].(*convnet.ConvLayer).ReceptiveField(); size != 5 || stride != 2 || pad != 2 {
+		t.Errorf("expected conv receptive field (5, 2, 2), but got (%d, %d, %d)", size, stride, pad)
+	}
+
+	// 1 + 2*1, + 1*1 for the pool, + 4*2, + 2*4
+	// pad: 1*1 + 0*1 + 2*2 + 1*4
+	if size, stride, pad := convnet.NetReceptiveField(net); size != 20 || stride != 4 || pad != 9 {
+		t.Errorf("expected net receptive field (20, 4, 9), but got (%d, %d, %d)", size, stride, pad)
+	}
+
+	// dilation widens a causal conv
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 16, OutSy: 1, OutDepth: 1},
+		{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 1, Filters: 1},
+		{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 2, Filters: 1},
+		{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 4, Filters: 1},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	}, rand.New(rand.NewSource(0)))
+	if size, stride, pad := convnet.NetReceptiveField(net); size != 8 || stride != 1 || pad != 7 {
+		t.Errorf("expected causal receptive field (8, 1, 7), but got (%d, %d, %d)", size, stride, pad)
+	}
+}
+
+// it should sweep learning rates without modifying the net
+func TestLearningRateFinder(t *testing.T) {
+	net, _, r := createTestNet()
+
+	loader := &convnet.SliceLoader{}
+	for i := 0; i < 20; i++ {
+		loader.X = append(loader.X, convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1}))
+		loader.Y = append(loader.Y, convnet.LossData{Dim: r.Intn(3)})
+	}
+
+	before, _ := json.Marshal(net)
+
+	lrs, losses, err := convnet.LearningRateFinder(net, loader, 1e-5, 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lrs) != 50 || len(losses) != 50 {
+		t.Fatalf("expected 50 results, but got %d learning rates and %d losses", len(lrs), len(losses))
+	}
+	if lrs[0] != 1e-5 {
+		t.Errorf("expected first learning rate to be 1e-5, but it is %g", lrs[0])
+	}
+	for i := 1; i < len(lrs); i++ {
+		if lrs[i] <= lrs[i-1] || lrs[i] >= 1 {
+			t.Errorf("expected learning rates to increase towards 1, but step %d is %g", i, lrs[i])
+		}
+	}
+
+	after, _ := json.Marshal(net)
+	if string(before) != string(after) {
+		t.Error("expected original net to be unmodified")
+	}
+}
+
+// it should leave frozen layers unchanged while training the rest of the net
+func TestTrainable(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerRelu, TrainableZero: true},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	// layer 1 is frozen by its definition, layer 4 by SetTrainable
+	if err := net.SetTrainable(4, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.SetTrainable(3, false); err == nil {
+		t.Error("expected error freezing a pool layer")
+	}
+
+	// round-trip so that the flag must also survive serialization
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net = &convnet.Net{}
+	if err := json.Unmarshal(b, net); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := func(l convnet.Layer) [][]float64 {
+		var params [][]float64
+		for _, pg := range l.ParamsAndGrads() {
+			params = append(params, append([]float64(nil), pg.Params...))
+		}
+		return params
+	}
+
+	conv1, conv2, head := snapshot(net.Layers[1]), snapshot(net.Layers[4]), snapshot(net.Layers[6])
+
+	opts := convnet.DefaultTrainerOptions
+	opts.Method = convnet.MethodAdam
+	opts.L2Decay = 0.001
+	trainer := convnet.NewTrainer(net, opts)
+	for i := 0; i < 10; i++ {
+		trainer.Train(convnet.NewVolRand(6, 6, 2, r), convnet.LossData{Dim: i % 3})
+	}
+
+	check := func(name string, l convnet.Layer, before [][]float64, wantSame bool) {
+		same := true
+		for i, pg := range l.ParamsAndGrads() {
+			for j := range pg.Params {
+				if pg.Params[j] != before[i][j] {
+					same = false
+				}
+			}
+		}
+		if same != wantSame {
+			t.Errorf("%s: expected unchanged=%v, but got %v", name, wantSame, same)
+		}
+	}
+
+	check("first conv", net.Layers[1], conv1, true)
+	check("second conv", net.Layers[4], conv2, true)
+	check("head", net.Layers[6], head, false)
+}
+
+// it should look up rows of the table and train only the rows it used
+func TestEmbedding(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 1},
+		{Type: convnet.LayerEmbedding, NumEmbeddings: 5, EmbeddingDim: 4},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	emb := net.Layers[1]
+	table := emb.ParamsAndGrads()[0].Params
+	before := append([]float64(nil), table...)
+
+	out := emb.Forward(convnet.NewVol1D([]float64{3}), false)
+	if out.Sx != 1 || out.Sy != 1 || out.Depth != 4 {
+		t.Fatalf("expected 1x1x4 output, but got %dx%dx%d", out.Sx, out.Sy, out.Depth)
+	}
+	for i := range out.W {
+		if out.W[i] != table[3*4+i] {
+			t.Errorf("expected output %d to be row 3 of the table", i)
+		}
+	}
+
+	opts := convnet.DefaultTrainerOptions
+	opts.Momentum = 0
+	trainer := convnet.NewTrainer(net, opts)
+	for i := 0; i < 5; i++ {
+		trainer.Train(convnet.NewVol1D([]float64{3}), convnet.LossData{Dim: 1})
+	}
+
+	for i := range table {
+		if changed := table[i] != before[i]; changed != (i/4 == 3) {
+			t.Errorf("table entry %d: expected changed=%v", i, i/4 == 3)
+		}
+	}
+
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := &convnet.Net{}
+	if err := json.Unmarshal(b, clone); err != nil {
+		t.Fatal(err)
+	}
+	if !clone.Layers[1].Forward(convnet.NewVol1D([]float64{2}), false).Equal(emb.Forward(convnet.NewVol1D([]float64{2}), false)) {
+		t.Error("expected embeddings to survive serialization")
+	}
+}
+
+// it should expose the output of every layer after Forward
+func TestActivations(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	if a := net.ActivationAt(2); a != nil {
+		t.Errorf("expected no activation before Forward, but got %v", a.W)
+	}
+	if g := net.Layers[2].GradOutput(); g != nil {
+		t.Errorf("expected no gradient before Forward, but got %v", g.W)
+	}
+
+	// neuron i computes sign*(x0 + x1) with no bias
+	pg := net.Layers[1].ParamsAndGrads()
+	signs := []float64{1, -1, 1, -1}
+	for i, s := range signs {
+		pg[i].Params[0], pg[i].Params[1] = s, s
+	}
+	for j := range pg[len(signs)].Params {
+		pg[len(signs)].Params[j] = 0
+	}
+
+	x := convnet.NewVol1D([]float64{0.5, 0.25})
+	probs := net.Forward(x, false)
+
+	acts := net.Activations()
+	if len(acts) != len(net.Layers) {
+		t.Fatalf("expected %d activations, but got %d", len(net.Layers), len(acts))
+	}
+	if acts[0] != x {
+		t.Error("expected input layer activation to be the input")
+	}
+	if acts[len(acts)-1] != probs {
+		t.Error("expected last activation to be the output of Forward")
+	}
+
+	relu := net.ActivationAt(2)
+	expected := []float64{0.75, 0, 0.75, 0}
+	for i, e := range expected {
+		if relu.W[i] != e {
+			t.Errorf("expected relu output %d to be %f, but it is %f", i, e, relu.W[i])
+		}
+	}
+
+	// the relu only passes the gradient through where it was active
+	net.Backward(convnet.LossData{Dim: 0})
+	reluGrad, fcGrad := net.Layers[2].GradOutput(), net.Layers[1].GradOutput()
+	if reluGrad.Sx != 1 || reluGrad.Sy != 1 || reluGrad.Depth != 4 {
+		t.Fatalf("expected a 1x1x4 gradient, but got %dx%dx%d", reluGrad.Sx, reluGrad.Sy, reluGrad.Depth)
+	}
+	for i, e := range expected {
+		if e == 0 && fcGrad.W[i] != 0 {
+			t.Errorf("expected fc gradient %d to be 0, but it is %f", i, fcGrad.W[i])
+		} else if e != 0 && fcGrad.W[i] != reluGrad.W[i] {
+			t.Errorf("expected fc gradient %d to be %f, but it is %f", i, reluGrad.W[i], fcGrad.W[i])
+		}
+		if reluGrad.W[i] != relu.Dw[i] {
+			t.Errorf("expected relu gradient %d to be %f, but it is %f", i, relu.Dw[i], reluGrad.W[i])
+		}
+	}
+}
+
+// it should record the most recent training steps
+func TestHistory(t *testing.T) {
+	_, trainer, r := createTestNet()
+
+	h := trainer.EnableHistory(3)
+	for i := 0; i < 5; i++ {
+		trainer.Train(convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1}), convnet.LossData{Dim: r.Intn(3)})
+	}
+
+	if h.Len() != 3 {
+		t.Fatalf("expected 3 entries, but got %d", h.Len())
+	}
+	for i, step := range h.Steps {
+		if step != i+3 {
+			t.Errorf("expected entry %d to be step %d, but it is step %d", i, i+3, step)
+		}
+		if h.Losses[i] != h.CostLosses[i]+h.L1Losses[i]+h.L2Losses[i] {
+			t.Errorf("expected entry %d loss to be the sum of its parts", i)
+		}
+	}
+
+	var buf strings.Builder
+	if err := h.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 rows, but got %q", buf.String())
+	}
+	if lines[0] != "step,loss,cost_loss,l1_decay_loss,l2_decay_loss" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "3,") {
+		t.Errorf("expected first row to be step 3, but got %q", lines[1])
+	}
+}
+
+// it should learn with factored second moments
+func TestAdaFactor(t *testing.T) {
+	net, _, r := createTestNet()
+
+	opts := convnet.DefaultTrainerOptions
+	opts.Method = convnet.MethodAdaFactor
+	opts.LearningRate = 0.01
+	trainer := convnet.NewTrainer(net, opts)
+
+	xs := make([]*convnet.Vol, 20)
+	ys := make([]convnet.LossData, len(xs))
+	for i := range xs {
+		xs[i] = convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1})
+		ys[i] = convnet.LossData{Dim: r.Intn(3)}
+	}
+
+	loss := func() float64 {
+		total := 0.0
+		for i, x := range xs {
+			total += net.CostLoss(x, ys[i])
+		}
+		return total
+	}
+
+	before := loss()
+	for epoch := 0; epoch < 50; epoch++ {
+		for i, x := range xs {
+			if res := trainer.Train(x, ys[i]); math.IsNaN(res.Loss) {
+				t.Fatalf("loss is NaN after epoch %d", epoch)
+			}
+		}
+	}
+	after := loss()
+
+	if after >= before {
+		t.Errorf("expected loss to decrease, but it went from %f to %f", before, after)
+	}
+}
+
+// it should allow predictions from many goroutines at once
+func TestPredictConcurrent(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerLRN, K: 1, N: 3, Alpha: 0.1, Beta: 0.75},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerMaxout, DropProb: 0.5},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	r := rand.New(rand.NewSource(1))
+	inputs := make([]*convnet.Vol, 32)
+	expected := make([]*convnet.Vol, len(inputs))
+	for i := range inputs {
+		inputs[i] = convnet.NewVolRand(8, 8, 2, r)
+		expected[i] = net.Forward(inputs[i], false).Clone()
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for k := 0; k < 50; k++ {
+				i := (g + k) % len(inputs)
+				if out := net.Predict(inputs[i]); !out.Equal(expected[i]) {
+					t.Errorf("goroutine %d: prediction %d differs from Forward", g, i)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// it should train a student to match the soft outputs of a teacher
+func TestDistillTrain(t *testing.T) {
+	net, trainer, _ := createTestNet()
+	x := convnet.NewVol1D([]float64{0.3, -0.7})
+	teacherLogits := []float64{2, 0.5, -1}
+
+	trainer.LearningRate = 0.1
+	for i := 0; i < 500; i++ {
+		trainer.DistillTrain(x, 0, teacherLogits, 2, 1)
+	}
+
+	// at temperature 2, the student's logits should be close to the
+	// teacher's, so compare the outputs at temperature 1
+	probs := net.Forward(x, false)
+	es := make([]float64, len(teacherLogits))
+	sum := 0.0
+	for i, l := range teacherLogits {
+		es[i] = math.Exp(l)
+		sum += es[i]
+	}
+	for i := range es {
+		if p := es[i] / sum; math.Abs(probs.W[i]-p) > 0.05 {
+			t.Errorf("class %d: expected probability near %f, but got %f", i, p, probs.W[i])
+		}
+	}
+
+	// with alpha = 0, it should be the same as ordinary training
+	net1, trainer1, _ := createTestNet()
+	net2, trainer2, _ := createTestNet()
+	res1 := trainer1.Train(x, convnet.LossData{Dim: 1})
+	res2 := trainer2.DistillTrain(x, 1, teacherLogits, 3, 0)
+
+	if res1 != res2 {
+		t.Errorf("expected the same result, but got %+v and %+v", res1, res2)
+	}
+
+	b1, _ := json.Marshal(net1)
+	b2, _ := json.Marshal(net2)
+	if string(b1) != string(b2) {
+		t.Error("expected the same parameters after training")
+	}
+
+	// temperatures and weights that would give Inf or NaN soft targets
+	for _, c := range []struct {
+		temperature, alpha float64
+	}{
+		{0, 0.5},
+		{-1, 0.5},
+		{math.NaN(), 0.5},
+		{2, -0.1},
+		{2, 1.5},
+		{2, math.NaN()},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("temperature %g, alpha %g: expected a panic", c.temperature, c.alpha)
+				}
+			}()
+
+			trainer.DistillTrain(x, 0, teacherLogits, c.temperature, c.alpha)
+		}()
+	}
+}
+
+// it should average Vols element-wise and reject mismatched shapes
+func TestMeanVol(t *testing.T) {
+	a := convnet.NewVol1D([]float64{1, 2, 3})
+	b := convnet.NewVol1D([]float64{3, 2, -3})
+
+	sum, err := convnet.SumVol([]*convnet.Vol{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sum.Equal(convnet.NewVol1D([]float64{4, 4, 0})) {
+		t.Errorf("unexpected sum %v", sum.W)
+	}
+
+	mean, err := convnet.MeanVol([]*convnet.Vol{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mean.Equal(convnet.NewVol1D([]float64{2, 2, 0})) {
+		t.Errorf("unexpected mean %v", mean.W)
+	}
+
+	if _, err := convnet.MeanVol(nil); err == nil {
+		t.Error("expected an error for no Vols")
+	}
+	if _, err := convnet.SumVol([]*convnet.Vol{a, convnet.NewVol(1, 1, 2, 0)}); err == nil {
+		t.Error("expected an error for mismatched Vols")
+	}
+}
+
+func BenchmarkMeanVol(b *testing.B) {
+	r := rand.New(rand.NewSource(0))
+	vols := make([]*convnet.Vol, 100)
+	for i := range vols {
+		vols[i] = convnet.NewVolRand(16, 16, 8, r)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := convnet.MeanVol(vols); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// it should give varied, repeatable samples with dropout enabled
+func TestMCDropoutForward(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerBatchNorm},
+		{Type: convnet.LayerFC, NumNeurons: 20, Activation: convnet.LayerRelu, DropProb: 0.5},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+	net.CheckpointEvery = 2
+
+	bn := net.Layers[1].(*convnet.BatchNormLayer)
+	mean, variance := bn.RunningMean().Clone(), bn.RunningVariance().Clone()
+
+	x := convnet.NewVol1D([]float64{0.5, -1, 0.25, 2})
+
+	samples1, err := net.MCDropoutForward(x, 10, rand.New(rand.NewSource(42)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples2, err := net.MCDropoutForward(x, 10, rand.New(rand.NewSource(42)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples1) != 10 {
+		t.Fatalf("expected 10 samples, but got %d", len(samples1))
+	}
+
+	distinct := false
+	for i := range samples1 {
+		if !samples1[i].Equal(samples2[i]) {
+			t.Errorf("sample %d: expected the same seed to give the same sample", i)
+		}
+		if !samples1[i].Equal(samples1[0]) {
+			distinct = true
+		}
+	}
+	if !distinct {
+		t.Error("expected dropout to vary the samples")
+	}
+
+	// only the dropout layers run in training mode
+	if !bn.RunningMean().Equal(mean) || !bn.RunningVariance().Equal(variance) {
+		t.Errorf("expected batch norm statistics to be unchanged, but got %v and %v", bn.RunningMean().W, bn.RunningVariance().W)
+	}
+
+	if _, err := net.MCDropoutForward(x, 0, nil); err == nil {
+		t.Error("expected an error for zero samples")
+	}
+
+	noDropout, _, _ := createTestNet()
+	if _, err := noDropout.MCDropoutForward(x, 1, nil); err == nil {
+		t.Error("expected an error for a net without dropout")
+	}
+}
+
+// it should compute correct gradients for a net with two heads
+func TestMultiHeadNet(t *testing.T) {
+	m := &convnet.MultiHeadNet{Weights: []float64{1, 0.5}}
+	m.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 3},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+	}, [][]convnet.LayerDef{
+		{
+			{Type: convnet.LayerFC, NumNeurons: 5, Activation: convnet.LayerSigmoid},
+			{Type: convnet.LayerSoftmax, NumClasses: 3},
+		},
+		{
+			{Type: convnet.LayerRegression, NumNeurons: 2},
+		},
+	}, rand.New(rand.NewSource(0)))
+
+	x := convnet.NewVol1D([]float64{0.5, -0.3, 0.8})
+	ys := []convnet.LossData{{Dim: 2}, {Dim: 1, Val: 0.7}}
+
+	cost := func() float64 {
+		m.Forward(x, false)
+
+		total := 0.0
+		for i, head := range m.Heads {
+			total += m.Weights[i] * head[len(head)-1].(convnet.LossLayer).BackwardLoss(ys[i])
+		}
+		return total
+	}
+
+	m.Forward(x, true)
+	total, losses := m.Backward(ys)
+
+	if want := losses[0] + 0.5*losses[1]; math.Abs(total-want) > 1e-12 {
+		t.Errorf("expected total loss %f, but got %f", want, total)
+	}
+
+	checkGradients(t, "input", cost, x.W, x.Dw)
+	for i, pg := range m.ParamsAndGrads() {
+		checkGradients(t, fmt.Sprintf("parameter group %d", i), cost, pg.Params, pg.Grads)
+	}
+
+	// and it should survive serialization
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := &convnet.MultiHeadNet{}
+	if err := json.Unmarshal(b, m2); err != nil {
+		t.Fatal(err)
+	}
+	outs1, outs2 := m.Forward(x, false), m2.Forward(x, false)
+	for i := range outs1 {
+		if !outs1[i].Equal(outs2[i]) {
+			t.Errorf("head %d: expected the same output after serialization", i)
+		}
+	}
+
+	trainer := convnet.NewTrainer(nil, convnet.DefaultTrainerOptions)
+	first, _ := trainer.TrainMulti(m, x, ys)
+	var last convnet.TrainingResult
+	for i := 0; i < 50; i++ {
+		last, _ = trainer.TrainMulti(m, x, ys)
+	}
+	if last.Loss >= first.Loss {
+		t.Errorf("expected training to reduce the loss, but it went from %f to %f", first.Loss, last.Loss)
+	}
+}
+
+// it should treat SiLU and Swish as the same layer
+func TestSwish(t *testing.T) {
+	if convnet.LayerSiLU != convnet.LayerSwish {
+		t.Error("expected LayerSiLU to be an alias of LayerSwish")
+	}
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 3},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerSiLU},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	if _, ok := net.Layers[2].(*convnet.SwishLayer); !ok {
+		t.Fatalf("expected a swish layer, but got %T", net.Layers[2])
+	}
+
+	out := net.Layers[2].Forward(convnet.NewVol1D([]float64{-1, 0, 1, 2}), false)
+	for i, x := range []float64{-1, 0, 1, 2} {
+		if want := x / (1 + math.Exp(-x)); math.Abs(out.W[i]-want) > 1e-12 {
+			t.Errorf("swish(%f): expected %f, but got %f", x, want, out.W[i])
+		}
+	}
+
+	x := convnet.NewVol1D([]float64{0.4, -1.2, 0.9})
+	if e := gradcheck.Check(net, x, convnet.LossData{Dim: 1}, gradcheck.DefaultOptions).Max(); e > 1e-4 {
+		t.Errorf("expected correct gradients, but worst relative error is %g", e)
+	}
+
+	var silu convnet.Net
+	if err := json.Unmarshal([]byte(`{"layers":[{"layer_type":"input","out_sx":1,"out_sy":1,"out_depth":2},{"layer_type":"silu","out_sx":1,"out_sy":1,"out_depth":2}]}`), &silu); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := silu.Layers[1].(*convnet.SwishLayer); !ok {
+		t.Errorf("expected silu to load as a swish layer, but got %T", silu.Layers[1])
+	}
+}
+
+// checks analytic gradients against central differences of cost
+func checkGradients(t *testing.T, name string, cost func() float64, params, grads []float64) {
+	t.Helper()
+
+	analytic := append([]float64(nil), grads...)
+
+	for j := range params {
+		const delta = 1e-6
+
+		old := params[j]
+		params[j] = old + delta
+		c0 := cost()
+		params[j] = old - delta
+		c1 := cost()
+		params[j] = old
+
+		numeric := (c0 - c1) / (2 * delta)
+		if relError := math.Abs(numeric-analytic[j]) / math.Max(math.Max(math.Abs(numeric), math.Abs(analytic[j])), 1e-6); relError > 1e-4 {
+			t.Errorf("%s %d: numeric %g, analytic %g", name, j, numeric, analytic[j])
+		}
+	}
+}
+
+// it should compute correct gradients through fan-out and merges
+func TestGraph(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	// a diamond: one trunk splits into two branches that are added back
+	// together, plus a second input that is concatenated on at the end
+	g := &convnet.Graph{Output: "loss"}
+	for _, err := range []error{
+		g.AddInput("x", 1, 1, 3),
+		g.AddInput("aux", 1, 1, 2),
+		g.AddLayer("trunk", convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh}, "x", r),
+		g.AddLayer("left", convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerSigmoid}, "trunk", r),
+		g.AddLayer("right", convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerTanh}, "trunk", r),
+		g.AddMerge("sum", convnet.MergeAdd, "left", "right"),
+		g.AddMerge("cat", convnet.MergeConcat, "sum", "trunk", "aux"),
+		g.AddLayer("loss", convnet.LayerDef{Type: convnet.LayerSoftmax, NumClasses: 3}, "cat", r),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string]*convnet.Vol{
+		"x":   convnet.NewVol1D([]float64{0.5, -0.3, 0.8}),
+		"aux": convnet.NewVol1D([]float64{-0.1, 0.6}),
+	}
+	y := convnet.LossData{Dim: 1}
+
+	cost := func() float64 {
+		g.Forward(inputs, false)
+		return g.Nodes[len(g.Nodes)-1].Layer.(convnet.LossLayer).BackwardLoss(y)
+	}
+
+	if out := g.Forward(inputs, true); out.Depth != 3 {
+		t.Fatalf("expected 3 outputs, but got %d", out.Depth)
+	}
+	g.Backward(y)
+
+	checkGradients(t, "input x", cost, inputs["x"].W, g.Input("x").Dw)
+	checkGradients(t, "input aux", cost, inputs["aux"].W, g.Input("aux").Dw)
+	for i, pg := range g.ParamsAndGrads() {
+		checkGradients(t, fmt.Sprintf("parameter group %d", i), cost, pg.Params, pg.Grads)
+	}
+
+	// the topology should survive serialization
+	b, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2 := &convnet.Graph{}
+	if err := json.Unmarshal(b, g2); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Forward(inputs, false).Equal(g2.Forward(inputs, false)) {
+		t.Error("expected the same output after serialization")
+	}
+
+	// and it should reject broken graphs
+	cyclic := &convnet.Graph{Output: "loss"}
+	if err := json.Unmarshal([]byte(`{"output":"loss","nodes":[{"name":"x","layer":{"layer_type":"input","out_sx":1,"out_sy":1,"out_depth":2}},{"name":"a","inputs":["x","b"],"merge":2},{"name":"b","inputs":["a"],"layer":{"layer_type":"relu","out_sx":1,"out_sy":1,"out_depth":2}},{"name":"loss","inputs":["b"],"layer":{"layer_type":"regression","num_inputs":2}}]}`), cyclic); err == nil {
+		t.Error("expected an error for a cycle")
+	}
+
+	dangling := &convnet.Graph{Output: "loss"}
+	dangling.AddInput("x", 1, 1, 2)
+	dangling.AddLayer("unused", convnet.LayerDef{Type: convnet.LayerRelu}, "x", r)
+	dangling.AddLayer("loss", convnet.LayerDef{Type: convnet.LayerRegression, NumNeurons: 2}, "x", r)
+	if err := dangling.Validate(); err == nil {
+		t.Error("expected an error for a node that is not connected to the output")
+	}
+
+	// nodes can be put in a graph directly and merged afterwards
+	// nodes: x, aux, trunk/0, trunk, left/0, left, right/0, right, ...
+	direct := &convnet.Graph{Nodes: append([]*convnet.GraphNode(nil), g.Nodes[:8]...)}
+	if err := direct.AddMerge("sum", convnet.MergeAdd, "left", "right"); err != nil {
+		t.Errorf("expected a merge of nodes added directly to work, but got %v", err)
+	}
+	if err := direct.AddMerge("bad", convnet.MergeAdd, "left", "trunk"); err == nil {
+		t.Error("expected an error for adding inputs of different shapes")
+	}
+}
+
+// it should update the batch norm statistics once per training step, even
+// when the forward pass is recomputed for checkpointing
+func TestBatchNormCheckpointing(t *testing.T) {
+	layerDefs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 2, OutSy: 2, OutDepth: 2},
+		{Type: convnet.LayerBatchNorm, Momentum: 0.5},
+		{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}
+
+	makeNet := func() *convnet.Net {
+		net := &convnet.Net{}
+		net.MakeLayers(layerDefs, rand.New(rand.NewSource(0)))
+		return net
+	}
+
+	plain, checkpointed := makeNet(), makeNet()
+	checkpointed.CheckpointEvery = 1
+
+	r := rand.New(rand.NewSource(1))
+	for _, net := range []*convnet.Net{plain, checkpointed} {
+		trainer := convnet.NewTrainer(net, convnet.DefaultTrainerOptions)
+		r.Seed(1)
+		for i := 0; i < 10; i++ {
+			trainer.Train(convnet.NewVolRand(2, 2, 2, r), convnet.LossData{Dim: i % 2})
+		}
+	}
+
+	bn1 := plain.Layers[1].(*convnet.BatchNormLayer)
+	bn2 := checkpointed.Layers[1].(*convnet.BatchNormLayer)
+	for d := 0; d < 2; d++ {
+		if m1, m2 := bn1.RunningMean().W[d], bn2.RunningMean().W[d]; m1 != m2 {
+			t.Errorf("expected running mean %d to match, but got %f and %f", d, m1, m2)
+		}
+		if v1, v2 := bn1.RunningVariance().W[d], bn2.RunningVariance().W[d]; v1 != v2 {
+			t.Errorf("expected running variance %d to match, but got %f and %f", d, v1, v2)
+		}
+	}
+}
+
+// it should track running statistics and optionally lock them
+func TestBatchNorm(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 2, OutSy: 2, OutDepth: 2},
+		{Type: convnet.LayerBatchNorm, Momentum: 0.5},
+		{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, r)
+
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bn := net.Layers[1].(*convnet.BatchNormLayer)
+
+	// every slice of every input has mean 3 (depth 0) or -1 (depth 1)
+	// and variance 1
+	for i := 0; i < 50; i++ {
+		x := convnet.NewVol(2, 2, 2, 0.0)
+		for d, mean := range []float64{3, -1} {
+			x.Set(0, 0, d, mean+1)
+			x.Set(1, 0, d, mean-1)
+			x.Set(0, 1, d, mean+1)
+			x.Set(1, 1, d, mean-1)
+		}
+		net.Forward(x, true)
+	}
+
+	for d, mean := range []float64{3, -1} {
+		if m := bn.RunningMean().W[d]; math.Abs(m-mean) > 1e-6 {
+			t.Errorf("expected running mean %d to be %f, but it is %f", d, mean, m)
+		}
+		if v := bn.RunningVariance().W[d]; math.Abs(v-1) > 1e-6 {
+			t.Errorf("expected running variance %d to be 1, but it is %f", d, v)
+		}
+	}
+
+	if report := gradcheck.Check(net, convnet.NewVolRand(2, 2, 2, r), convnet.LossData{Dim: 1}, gradcheck.DefaultOptions); report.Max() > 1e-4 {
+		t.Errorf("expected gradients to match, but report is %+v", report)
+	}
+
+	// fixed statistics are used even in training, and the running
+	// statistics are left alone
+	bn.SetFixedStats(convnet.NewVol1D([]float64{0, 0}), convnet.NewVol1D([]float64{4, 4}))
+	bn.FixedMode = true
+
+	x := convnet.NewVolRand(2, 2, 2, r)
+	net.Forward(x, true)
+	out := net.ActivationAt(1)
+	for i, f := range x.W {
+		if expected := f / math.Sqrt(4+1e-5); math.Abs(out.W[i]-expected) > 1e-9 {
+			t.Errorf("expected output %d to be %f, but it is %f", i, expected, out.W[i])
+		}
+	}
+	if m := bn.RunningMean().W[0]; math.Abs(m-3) > 1e-6 {
+		t.Errorf("expected running mean to be unchanged in fixed mode, but it is %f", m)
+	}
+
+	bn.FixedMode = false
+	bn.FreezeStats()
+	if !bn.FixedMode {
+		t.Error("expected FreezeStats to turn on fixed mode")
+	}
+
+	expected := net.Forward(x, false).Clone()
+
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var net2 convnet.Net
+	if err := json.Unmarshal(b, &net2); err != nil {
+		t.Fatal(err)
+	}
+	if !net2.Layers[1].(*convnet.BatchNormLayer).FixedMode {
+		t.Error("expected fixed mode to survive a JSON round trip")
+	}
+
+	// training must not move the frozen statistics
+	net2.Forward(convnet.NewVolRand(2, 2, 2, r), true)
+	actual := net2.Forward(x, false)
+	for i := range expected.W {
+		if math.Abs(expected.W[i]-actual.W[i]) > 1e-9 {
+			t.Errorf("expected output %d to be %f after round trip, but it is %f", i, expected.W[i], actual.W[i])
+		}
+	}
+}
+
+// it should read regression outputs and class predictions without
+// relying on the last Forward
+func TestRegressionAndPredictClass(t *testing.T) {
+	classifier, _, r := createTestNet()
+
+	x := convnet.NewVol1D([]float64{0.2, -0.3})
+	classifier.Forward(x, false)
+	expected := classifier.Prediction()
+
+	// a forward pass on some other input must not matter
+	classifier.Forward(convnet.NewVol1D([]float64{-0.9, 0.9}), false)
+
+	if c, err := classifier.PredictClass(x); err != nil {
+		t.Error(err)
+	} else if c != expected {
+		t.Errorf("expected class %d, but got %d", expected, c)
+	}
+	if _, err := classifier.Regression(x); err == nil {
+		t.Error("expected an error from Regression on a softmax net")
+	}
+
+	regressor := &convnet.Net{}
+	regressor.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerRegression, NumNeurons: 2},
+	}, r)
+
+	out, err := regressor.Regression(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regressor.Forward(x, false)
+	for i := range want.W {
+		if out[i] != want.W[i] {
+			t.Errorf("expected output %d to be %f, but it is %f", i, want.W[i], out[i])
+		}
+	}
+
+	// the result must be a copy
+	out[0] = math.NaN()
+	if math.IsNaN(regressor.Forward(x, false).W[0]) {
+		t.Error("expected Regression to return a copy")
+	}
+
+	if _, err := regressor.PredictClass(x); err == nil {
+		t.Error("expected an error from PredictClass on a regression net")
+	}
+}
+
+// it should predict classes for softmax and svm heads, and for regression
+// heads only when asked to
+func TestPredictionHeads(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	x := convnet.NewVol1D([]float64{0.2, -0.3})
+
+	for _, head := range []convnet.LayerDef{
+		{Type: convnet.LayerSoftmax, NumClasses: 4},
+		{Type: convnet.LayerSVM, NumClasses: 4},
+		{Type: convnet.LayerRegression, NumNeurons: 4},
+	} {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+			{Type: convnet.LayerFC, NumNeurons: 5, Activation: convnet.LayerTanh},
+			head,
+		}, r)
+
+		out := net.Forward(x, false)
+		expected := 0
+		for i, w := range out.W {
+			if w > out.W[expected] {
+				expected = i
+			}
+		}
+
+		// a forward pass on some other input must not matter
+		net.Forward(convnet.NewVol1D([]float64{-0.9, 0.9}), false)
+
+		if c, err := net.PredictArgmax(x); err != nil {
+			t.Errorf("%v: %v", head.Type, err)
+		} else if c != expected {
+			t.Errorf("%v: expected PredictArgmax to return %d, but got %d", head.Type, expected, c)
+		}
+
+		c, err := net.PredictClass(x)
+		if head.Type == convnet.LayerRegression {
+			if err == nil {
+				t.Errorf("%v: expected an error from PredictClass", head.Type)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", head.Type, err)
+		} else if c != expected {
+			t.Errorf("%v: expected PredictClass to return %d, but got %d", head.Type, expected, c)
+		}
+
+		net.Forward(x, false)
+		if p := net.Prediction(); p != expected {
+			t.Errorf("%v: expected Prediction to return %d, but got %d", head.Type, expected, p)
+		}
+	}
+}
+
+// it should swap the classifier for a new one and keep the trunk
+func TestReplaceHead(t *testing.T) {
+	net, _, r := createTestNet()
+
+	// layers: input, fc, tanh, fc, tanh, fc, softmax
+	// fine-tune only the new head
+	for i := 0; i < 5; i++ {
+		if _, ok := net.Layers[i].(convnet.TrainableLayer); ok {
+			if err := net.SetTrainable(i, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	trunk, _ := json.Marshal(net.Layers[:5])
+
+	if err := net.ReplaceHead([]convnet.LayerDef{
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 7},
+	}, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(net.Layers) != 9 {
+		t.Fatalf("expected 9 layers, but there are %d", len(net.Layers))
+	}
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if s := net.Layers[len(net.Layers)-1].OutDepth(); s != 7 {
+		t.Errorf("expected 7 classes, but there are %d", s)
+	}
+
+	opts := convnet.DefaultTrainerOptions
+	opts.LearningRate = 0.1
+	trainer := convnet.NewTrainer(net, opts)
+
+	x := convnet.NewVol1D([]float64{0.5, -0.5})
+	before := net.CostLoss(x, convnet.LossData{Dim: 6})
+	for i := 0; i < 20; i++ {
+		trainer.Train(x, convnet.LossData{Dim: 6})
+	}
+	if after := net.CostLoss(x, convnet.LossData{Dim: 6}); after >= before {
+		t.Errorf("expected new head to learn, but loss went from %f to %f", before, after)
+	}
+
+	if after, _ := json.Marshal(net.Layers[:5]); string(after) != string(trunk) {
+		t.Error("expected trunk weights to be unchanged")
+	}
+
+	// a head that does not fit leaves the net alone
+	err := net.ReplaceHead([]convnet.LayerDef{
+		{Type: convnet.LayerMaxout, GroupSize: 3},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, r)
+	if le, ok := err.(*convnet.LayerError); !ok || le.LayerIndex != 7 {
+		t.Errorf("expected a layer error at index 7, but got %v", err)
+	}
+	if len(net.Layers) != 9 {
+		t.Errorf("expected the net to be unchanged, but it has %d layers", len(net.Layers))
+	}
+
+	// there is no head to replace without a layer before the loss layer
+	for _, layers := range [][]convnet.Layer{nil, {&convnet.SoftmaxLayer{}}} {
+		short := &convnet.Net{Layers: layers}
+		if err := short.ReplaceHead([]convnet.LayerDef{{Type: convnet.LayerSoftmax, NumClasses: 2}}, r); err == nil {
+			t.Errorf("expected an error for a net with %d layers", len(layers))
+		}
+	}
+}
+
+// it should clear accumulated gradients
+func TestZeroGrads(t *testing.T) {
+	net, trainer, _ := createTestNet()
+
+	x := convnet.NewVol1D([]float64{0.2, -0.3})
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 1})
+
+	nonzero := func() int {
+		count := 0
+		for _, pg := range net.ParamsAndGrads() {
+			for _, g := range pg.Grads {
+				if g != 0 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	if nonzero() == 0 {
+		t.Fatal("expected Backward to accumulate gradients")
+	}
+
+	trainer.ZeroGrad()
+	if c := nonzero(); c != 0 {
+		t.Errorf("expected all gradients to be zero after ZeroGrad, but %d are not", c)
+	}
+
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 1})
+	net.ZeroGrads()
+	if c := nonzero(); c != 0 {
+		t.Errorf("expected all gradients to be zero after ZeroGrads, but %d are not", c)
+	}
+}
+
+// it should report norms and dead units
+func TestLayerStats(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	// layers: input, fc, relu, fc, softmax
+	// the first two units always have a negative input, so they are dead
+	pgs := net.Layers[1].ParamsAndGrads()
+	for i := 0; i < 4; i++ {
+		for j := range pgs[i].Params {
+			pgs[i].Params[j] = 0
+		}
+	}
+	copy(pgs[4].Params, []float64{-1, -1, 1, 1})
+
+	stats := net.LayerStats()
+	if stats[2].MeanAbsActivation != 0 || stats[2].ZeroFraction != 0 {
+		t.Errorf("expected no activation statistics before Forward, but got %+v", stats[2])
+	}
+
+	r := rand.New(rand.NewSource(1))
+	x := convnet.NewVol1D([]float64{r.Float64(), r.Float64()})
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 0})
+
+	stats = net.LayerStats()
+	if len(stats) != len(net.Layers) {
+		t.Fatalf("expected %d stats, but got %d", len(net.Layers), len(stats))
+	}
+
+	if s := stats[2]; s.Type != convnet.LayerRelu || s.ZeroFraction != 0.5 || s.MeanAbsActivation != 0.5 {
+		t.Errorf("expected half of the relu units to be dead, but got %+v", s)
+	}
+	if s := stats[1]; s.WeightNorm != 2 || s.GradNorm == 0 {
+		t.Errorf("expected fc weight norm 2 and some gradient, but got %+v", s)
+	}
+	if s := stats[2]; s.WeightNorm != 0 || s.GradNorm != 0 {
+		t.Errorf("expected relu to have no parameters, but got %+v", s)
+	}
+}
+
+// it should anneal the learning rate with restarts
+func TestSGDRScheduler(t *testing.T) {
+	s := &convnet.SGDRScheduler{T0: 10, TMult: 2, MaxLR: 0.1, MinLR: 0.001}
+
+	for _, c := range []struct {
+		step, cycle, inCycle, length int
+		lr                           float64
+	}{
+		{0, 0, 0, 10, 0.1},
+		{5, 0, 5, 10, 0.0505},
+		{9, 0, 9, 10, 0.001 + 0.0495*(1+math.Cos(math.Pi*0.9))},
+		{10, 1, 0, 20, 0.1},
+		{20, 1, 10, 20, 0.0505},
+		{30, 2, 0, 40, 0.1},
+		{50, 2, 20, 40, 0.0505},
+		{70, 3, 0, 80, 0.1},
+	} {
+		cycle, inCycle, length := s.CycleInfo(c.step)
+		if cycle != c.cycle || inCycle != c.inCycle || length != c.length {
+			t.Errorf("step %d: expected cycle info (%d, %d, %d), but got (%d, %d, %d)", c.step, c.cycle, c.inCycle, c.length, cycle, inCycle, length)
+		}
+
+		if lr := s.LearningRate(c.step); math.Abs(lr-c.lr) > 1e-12 {
+			t.Errorf("step %d: expected learning rate %g, but got %g", c.step, c.lr, lr)
+		}
+	}
+
+	// without a multiplier, every cycle is the same length
+	fixed := &convnet.SGDRScheduler{T0: 4, MaxLR: 1, MinLR: 0}
+	if cycle, inCycle, length := fixed.CycleInfo(9); cycle != 2 || inCycle != 1 || length != 4 {
+		t.Errorf("expected cycle info (2, 1, 4), but got (%d, %d, %d)", cycle, inCycle, length)
+	}
+
+	// the trainer asks the scheduler once per batch
+	_, trainer, r := createTestNet()
+	trainer.BatchSize = 2
+	trainer.Scheduler = s
+	for i := 0; i < 12; i++ {
+		trainer.Train(convnet.NewVol1D([]float64{r.Float64(), r.Float64()}), convnet.LossData{Dim: 0})
+	}
+	if lr := trainer.LearningRate; math.Abs(lr-s.LearningRate(5)) > 1e-12 {
+		t.Errorf("expected learning rate for update 5 (%g), but got %g", s.LearningRate(5), lr)
+	}
+}
+
+// it should splice layers in and out without disturbing the weights
+func TestInsertRemoveLayer(t *testing.T) {
+	net, trainer, r := createTestNet()
+
+	trainer.LearningRate = 0.1
+	for i := 0; i < 20; i++ {
+		trainer.Train(convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1}), convnet.LossData{Dim: r.Intn(3)})
+	}
+
+	x := convnet.NewVol1D([]float64{0.3, -0.7})
+	expected := net.Forward(x, false).Clone()
+
+	same := func(what string, tol float64) {
+		t.Helper()
+
+		actual := net.Forward(x, false)
+		for i := range expected.W {
+			if math.Abs(expected.W[i]-actual.W[i]) > tol {
+				t.Errorf("%s: expected output %d to be %f, but it is %f", what, i, expected.W[i], actual.W[i])
+			}
+		}
+	}
+
+	// layers: input, fc, tanh, fc, tanh, fc, softmax
+	// a fresh batch norm starts out as (nearly) the identity
+	if err := net.InsertLayer(3, convnet.LayerDef{Type: convnet.LayerBatchNorm}, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := net.Layers[3].(*convnet.BatchNormLayer); !ok || len(net.Layers) != 8 {
+		t.Fatalf("expected a batch norm layer at index 3 of 8, but got %T of %d", net.Layers[3], len(net.Layers))
+	}
+	same("after inserting batch norm", 1e-4)
+
+	// insert a dropout for training, then remove it for deployment
+	if err := net.InsertLayer(3, convnet.LayerDef{Type: convnet.LayerDropout, DropProb: 0.5}, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := net.Layers[3].(*convnet.DropoutLayer); !ok || len(net.Layers) != 9 {
+		t.Fatalf("expected a dropout layer at index 3 of 9, but got %T of %d", net.Layers[3], len(net.Layers))
+	}
+	trainer = convnet.NewTrainer(net, trainer.TrainerOptions)
+	trainer.LearningRate = 0
+	if res := trainer.Train(x, convnet.LossData{Dim: 0}); math.IsNaN(res.Loss) || math.IsInf(res.Loss, 0) {
+		t.Errorf("expected a finite loss with dropout, but got %f", res.Loss)
+	}
+	if err := net.RemoveLayer(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.RemoveLayer(3); err != nil {
+		t.Fatal(err)
+	}
+	same("after removing dropout and batch norm", 1e-12)
+
+	// removing a nonlinearity keeps the shapes, but not the function
+	if err := net.RemoveLayer(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if out := net.Forward(x, false); out.W[0] == expected.W[0] {
+		t.Error("expected output to change after removing tanh")
+	}
+
+	// layers: input, fc, fc, tanh, fc, softmax
+	// a maxout would shrink the input of the next fc layer
+	if err := net.InsertLayer(2, convnet.LayerDef{Type: convnet.LayerMaxout, GroupSize: 5}, r); err == nil {
+		t.Error("expected an error when the next layer no longer fits")
+	}
+	if err := net.RemoveLayer(len(net.Layers) - 1); err == nil {
+		t.Error("expected an error when removing the loss layer")
+	}
+	if err := net.InsertLayer(2, convnet.LayerDef{Type: convnet.LayerSoftmax, NumClasses: 2}, r); err == nil {
+		t.Error("expected an error when inserting a loss layer")
+	}
+	if len(net.Layers) != 6 {
+		t.Errorf("expected failed changes to leave 6 layers, but there are %d", len(net.Layers))
+	}
+
+	// a dropout between two fc layers trains along with the rest
+	if err := net.InsertLayer(2, convnet.LayerDef{Type: convnet.LayerDropout, DropProb: 0.2}, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := net.Layers[2].(*convnet.DropoutLayer); !ok || len(net.Layers) != 7 {
+		t.Fatalf("expected a dropout layer at index 2 of 7, but got %T of %d", net.Layers[2], len(net.Layers))
+	}
+
+	before := net.Forward(x, false).W[1]
+	trainer = convnet.NewTrainer(net, trainer.TrainerOptions)
+	trainer.LearningRate = 0.1
+	for i := 0; i < 50; i++ {
+		if res := trainer.Train(x, convnet.LossData{Dim: 1}); math.IsNaN(res.Loss) || math.IsInf(res.Loss, 0) {
+			t.Fatalf("expected a finite loss at step %d, but got %f", i, res.Loss)
+		}
+	}
+	if after := net.Forward(x, false).W[1]; after <= before {
+		t.Errorf("expected training to raise the probability of class 1 from %f, but it is %f", before, after)
+	}
+}
+
+// it should sort indices by descending value
+func TestArgSort(t *testing.T) {
+	v := convnet.NewVol(1, 2, 3, 0.0)
+	copy(v.W, []float64{0.1, 0.5, -1, 0.5, 2, 0})
+
+	expected := []int{4, 1, 3, 0, 5, 2}
+	if actual := v.ArgSort(); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, but got %v", expected, actual)
+	}
+
+	for n := 0; n <= 7; n++ {
+		want := expected
+		if n < len(want) {
+			want = want[:n]
+		}
+
+		if actual := v.ArgSortN(n); fmt.Sprint(actual) != fmt.Sprint(want) {
+			t.Errorf("n=%d: expected %v, but got %v", n, want, actual)
+		}
+	}
+
+	r := rand.New(rand.NewSource(0))
+	big := convnet.NewVolRand(1, 1, 1000, r)
+	full := big.ArgSort()
+	if top := big.ArgSortN(10); fmt.Sprint(top) != fmt.Sprint(full[:10]) {
+		t.Errorf("expected ArgSortN to match ArgSort, but got %v and %v", top, full[:10])
+	}
+}
+
+func BenchmarkArgSort(b *testing.B) {
+	v := convnet.NewVolRand(1, 1, 10000, rand.New(rand.NewSource(0)))
+
+	b.Run("Full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = v.ArgSort()[:10]
+		}
+	})
+	b.Run("N", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = v.ArgSortN(10)
+		}
+	})
+}
+
+// it should reject inputs that do not match the input layer
+func TestForwardChecked(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 3, OutSy: 2, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 2, Filters: 2, Stride: 1},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	r := rand.New(rand.NewSource(1))
+
+	if _, err := net.ForwardChecked(convnet.NewVolRand(3, 2, 2, r), false); err != nil {
+		t.Errorf("expected matching input to be accepted, but got %v", err)
+	}
+	wrongLength := convnet.NewVol(3, 2, 2, 0.0)
+	wrongLength.W = wrongLength.W[:11]
+
+	for name, v := range map[string]*convnet.Vol{
+		"wrong depth":        convnet.NewVolRand(3, 2, 3, r),
+		"wrong spatial size": convnet.NewVolRand(2, 3, 2, r),
+		"flattened":          convnet.NewVolRand(1, 1, 12, r),
+		"wrong 1D length":    convnet.NewVolRand(1, 1, 11, r),
+		"wrong total length": wrongLength,
+	} {
+		if _, err := net.ForwardChecked(v, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else {
+			t.Logf("%s: %v", name, err)
+		}
+	}
+}
+
+// it should mix in pretrained activations while training
+func TestMixout(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 100},
+		{Type: convnet.LayerMixout, MixProb: 0.3},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, r)
+
+	mixout := net.Layers[1].(*convnet.MixoutLayer)
+	mixout.SetPretrained(convnet.NewVol(1, 1, 100, 7.0))
+
+	x := convnet.NewVol(1, 1, 100, 1.0)
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 0})
+
+	mixed := 0
+	for i, w := range mixout.Output().W {
+		switch w {
+		case 7:
+			mixed++
+			if x.Dw[i] != 0 {
+				t.Errorf("expected no gradient for mixed activation %d, but it is %f", i, x.Dw[i])
+			}
+		case 1:
+			if x.Dw[i] == 0 {
+				t.Errorf("expected a gradient for activation %d", i)
+			}
+		default:
+			t.Fatalf("unexpected training activation %f", w)
+		}
+	}
+	if mixed < 15 || mixed > 45 {
+		t.Errorf("expected about 30 of 100 activations to be mixed, but %d were", mixed)
+	}
+
+	net.Forward(x, false)
+	for _, w := range mixout.Output().W {
+		if math.Abs(w-(0.7*1+0.3*7)) > 1e-12 {
+			t.Fatalf("expected prediction activation to be the expected value, but it is %f", w)
+		}
+	}
+
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var net2 convnet.Net
+	if err := json.Unmarshal(b, &net2); err != nil {
+		t.Fatal(err)
+	}
+	if !net2.Forward(x, false).ApproxEqual(net.Forward(x, false), 1e-12) {
+		t.Error("expected the same predictions after a JSON round trip")
+	}
+}
+
+// it should combine the outputs of its members
+func TestEnsemble(t *testing.T) {
+	a, _, r := createTestNet()
+	b := &convnet.Net{}
+	b.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	x := convnet.NewVol1D([]float64{0.4, -0.8})
+	pa := a.Forward(x, false).Clone()
+	pb := b.Forward(x, false).Clone()
+
+	single, err := convnet.NewEnsemble([]*convnet.Net{a}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := single.Forward(x); !out.ApproxEqual(pa, 1e-15) {
+		t.Errorf("expected an ensemble of one net to match it, but got %v and %v", out.W, pa.W)
+	}
+
+	e, err := convnet.NewEnsemble([]*convnet.Net{a, b}, []float64{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := e.Forward(x)
+	maxi := 0
+	for i := range out.W {
+		expected := 0.25*pa.W[i] + 0.75*pb.W[i]
+		if math.Abs(out.W[i]-expected) > 1e-12 {
+			t.Errorf("expected output %d to be %f, but it is %f", i, expected, out.W[i])
+		}
+		if out.W[i] > out.W[maxi] {
+			maxi = i
+		}
+	}
+	if p := e.Prediction(); p != maxi {
+		t.Errorf("expected prediction %d, but got %d", maxi, p)
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e2 convnet.Ensemble
+	if err := json.Unmarshal(buf, &e2); err != nil {
+		t.Fatal(err)
+	}
+	if !e2.Forward(x).ApproxEqual(out, 1e-12) {
+		t.Error("expected the same output after a JSON round trip")
+	}
+
+	wrong := &convnet.Net{}
+	wrong.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 4},
+	}, r)
+	if _, err := convnet.NewEnsemble([]*convnet.Net{a, wrong}, nil); err == nil {
+		t.Error("expected an error for members with different output sizes")
+	}
+	if _, err := convnet.NewEnsemble(nil, nil); err == nil {
+		t.Error("expected an error for an ensemble without nets")
+	}
+	if _, err := convnet.NewEnsemble([]*convnet.Net{a, b}, []float64{1, -1}); err == nil {
+		t.Error("expected an error for weights that sum to 0")
+	}
+	if _, err := convnet.NewEnsemble([]*convnet.Net{a, {}}, nil); err == nil {
+		t.Error("expected an error for a net without layers")
+	}
+
+	// an ensemble built by hand is checked before it is used
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Forward to panic for an empty ensemble")
+			}
+		}()
+
+		(&convnet.Ensemble{}).Forward(x)
+	}()
+}
+
+// it should report input and output sizes together
+func TestOutputInputSize(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 6, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 5, Stride: 1, Pad: 0},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerFC, NumNeurons: 7},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	type sizer interface {
+		OutputSize() (int, int, int)
+		InputSize() (int, int, int)
+	}
+
+	for i, expected := range [][6]int{
+		1: {6, 4, 5, 8, 6, 3},
+		2: {3, 2, 5, 6, 4, 5},
+		3: {1, 1, 7, 1, 1, 30},
+	} {
+		if i == 0 {
+			continue
+		}
+
+		l := net.Layers[i].(sizer)
+
+		var actual [6]int
+		actual[0], actual[1], actual[2] = l.OutputSize()
+		actual[3], actual[4], actual[5] = l.InputSize()
+		if actual != expected {
+			t.Errorf("layer %d: expected output and input sizes %v, but got %v", i, expected, actual)
+		}
+	}
+}
+
+// it should find the expected position of each depth slice
+func TestSpatialSoftmax(t *testing.T) {
+	v := convnet.NewVol(4, 3, 3, 0.0)
+
+	// depth 0 has a sharp peak at (3, 1)
+	v.Set(3, 1, 0, 100)
+
+	// depth 1 is uniform, so its expected position is the center
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 3; y++ {
+			v.Set(x, y, 1, 5)
+		}
+	}
+
+	// depth 2 splits evenly between (0, 0) and (2, 2)
+	v.Set(0, 0, 2, 50)
+	v.Set(2, 2, 2, 50)
+
+	expected := []float64{3, 1, 1.5, 1, 1, 1}
+
+	out := v.SpatialSoftmax()
+	if out.Sx != 1 || out.Sy != 1 || out.Depth != 6 {
+		t.Fatalf("expected a 1x1x6 output, but got %dx%dx%d", out.Sx, out.Sy, out.Depth)
+	}
+	for i, e := range expected {
+		if math.Abs(out.W[i]-e) > 1e-9 {
+			t.Errorf("expected output %d to be %f, but it is %f", i, e, out.W[i])
+		}
+	}
+}
+
+// slowRelu takes a noticeable amount of time in each direction.
+type slowRelu struct {
+	*convnet.ReluLayer
+}
+
+func (l slowRelu) Forward(v *convnet.Vol, isTraining bool) *convnet.Vol {
+	time.Sleep(5 * time.Millisecond)
+
+	return l.ReluLayer.Forward(v, isTraining)
+}
+
+func (l slowRelu) Backward() {
+	time.Sleep(5 * time.Millisecond)
+
+	l.ReluLayer.Backward()
+}
+
+// it should time each layer when profiling is enabled
+func TestProfiling(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerFC, NumNeurons: 8, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	// layers: input, fc, relu, fc, softmax
+	const slow = 2
+	net.Layers[slow] = slowRelu{net.Layers[slow].(*convnet.ReluLayer)}
+
+	x := convnet.NewVolRand(1, 1, 4, rand.New(rand.NewSource(1)))
+
+	if report := net.ProfileReport(); report != nil {
+		t.Errorf("expected no report before profiling is enabled, but got %v", report)
+	}
+
+	net.EnableProfiling(true)
+
+	const iterations = 4
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		net.Forward(x, true)
+		net.Backward(convnet.LossData{Dim: 1})
+	}
+	wall := time.Since(start)
+
+	report := net.ProfileReport()
+	if len(report) != len(net.Layers) {
+		t.Fatalf("expected %d entries, but got %d", len(net.Layers), len(report))
+	}
+
+	var total time.Duration
+	for i, lt := range report {
+		if lt.Index != i {
+			t.Errorf("expected entry %d to have index %d, but it is %d", i, i, lt.Index)
+		}
+		if lt.ForwardCalls != iterations {
+			t.Errorf("expected %d forward calls to layer %d, but got %d", iterations, i, lt.ForwardCalls)
+		}
+		if lt.BackwardCalls != iterations {
+			t.Errorf("expected %d backward calls to layer %d, but got %d", iterations, i, lt.BackwardCalls)
+		}
+
+		total += lt.Total()
+	}
+
+	if report[0].Type != convnet.LayerInput {
+		t.Errorf("expected the first layer to be reported as input, but it is %v", report[0].Type)
+	}
+	if min := 2 * iterations * 5 * time.Millisecond; report[slow].Total() < min {
+		t.Errorf("expected the slow layer to take at least %v, but it took %v", min, report[slow].Total())
+	}
+	if total > wall || total < wall*9/10 {
+		t.Errorf("expected the layer times to add up to about %v, but they add up to %v", wall, total)
+	}
+
+	net.ResetProfile()
+	for i, lt := range net.ProfileReport() {
+		if lt.ForwardCalls != 0 || lt.BackwardCalls != 0 || lt.Total() != 0 {
+			t.Errorf("expected layer %d to be reset, but got %+v", i, lt)
+		}
+	}
+
+	// changing the layers resets the timings to match the new layers
+	net.Layers[slow] = net.Layers[slow].(slowRelu).ReluLayer
+	r := rand.New(rand.NewSource(2))
+	for _, c := range []struct {
+		name   string
+		change func() error
+	}{
+		{"insert", func() error { return net.InsertLayer(1, convnet.LayerDef{Type: convnet.LayerTanh}, r) }},
+		{"remove", func() error { return net.RemoveLayer(1) }},
+		{"replace head", func() error {
+			return net.ReplaceHead([]convnet.LayerDef{{Type: convnet.LayerFC, NumNeurons: 5}, {Type: convnet.LayerSoftmax, NumClasses: 2}}, r)
+		}},
+		{"cold start", func() error { net.ColdStart(r); return nil }},
+	} {
+		name := c.name
+		if err := c.change(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		net.Forward(x, true)
+		net.Backward(convnet.LossData{Dim: 1})
+
+		report := net.ProfileReport()
+		if len(report) != len(net.Layers) {
+			t.Fatalf("%s: expected %d entries, but got %d", name, len(net.Layers), len(report))
+		}
+		for i, lt := range report {
+			if lt.Index != i || lt.ForwardCalls != 1 {
+				t.Errorf("%s: expected one forward call to layer %d, but got %+v", name, i, lt)
+			}
+		}
+	}
+
+	net.EnableProfiling(false)
+	net.Forward(x, true)
+	if report := net.ProfileReport(); report != nil {
+		t.Errorf("expected no report after profiling is disabled, but got %v", report)
+	}
+}
+
+// it should make a copy that predicts the same thing without copying
+// activations in its dropout layers
+func TestInferenceMode(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 3, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerDropout, DropProb: 0.25},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh, DropProb: 0.5},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	x := convnet.NewVolRand(4, 4, 2, r)
+	expected := append([]float64(nil), net.Forward(x, false).W...)
+	before, _ := json.Marshal(net)
+
+	inference := net.InferenceMode()
+
+	for i := 0; i < 2; i++ {
+		out := inference.Forward(x, i == 0)
+		for j := range expected {
+			if math.Abs(out.W[j]-expected[j]) > 1e-12 {
+				t.Errorf("expected output %d to be %f, but it is %f", j, expected[j], out.W[j])
+			}
+		}
+	}
+
+	for i, l := range inference.Layers {
+		if _, ok := l.(*convnet.DropoutLayer); !ok {
+			continue
+		}
+
+		in := inference.Layers[i-1].Output()
+		if allocs := testing.AllocsPerRun(10, func() { l.Forward(in, false) }); allocs != 0 {
+			t.Errorf("expected dropout layer %d not to allocate, but it made %v allocations", i, allocs)
+		}
+		if l.Output() != in {
+			t.Errorf("expected dropout layer %d to pass its input through", i)
+		}
+	}
+
+	if after, _ := json.Marshal(net); string(before) != string(after) {
+		t.Error("expected the original net to be unchanged")
+	}
+
+	// the folded weights must survive a round trip
+	b, err := json.Marshal(inference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded convnet.Net
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	out := loaded.Forward(x, false)
+	for j := range expected {
+		if math.Abs(out.W[j]-expected[j]) > 1e-12 {
+			t.Errorf("expected loaded output %d to be %f, but it is %f", j, expected[j], out.W[j])
+		}
+	}
+}
+
+// it should weight the feature maps by the average gradient of the class
+// score
+func TestGradCAM(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 3, OutSy: 2, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 1, Filters: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	// layers: input, conv, fc, softmax
+	const target = 1
+	fc := net.Layers[2].ParamsAndGrads()
+
+	before := make([][]float64, 0)
+	for _, pg := range net.ParamsAndGrads() {
+		for j := range pg.Grads {
+			pg.Grads[j] = r.Float64()
+		}
+		before = append(before, append([]float64(nil), pg.Grads...))
+	}
+
+	x := convnet.NewVolRand(3, 2, 1, r)
+
+	for class := 0; class < 3; class++ {
+		cam, err := convnet.GradCAM(net, x, class, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cam.Sx != 3 || cam.Sy != 2 || cam.Depth != 1 {
+			t.Fatalf("expected a 3x2x1 map, but got %dx%dx%d", cam.Sx, cam.Sy, cam.Depth)
+		}
+
+		// the score is linear in the conv output, so its gradient is
+		// the weights of the fc neuron for the class
+		act := net.ActivationAt(target)
+		w := fc[class].Params
+		alpha := make([]float64, 2)
+		for i, wi := range w {
+			alpha[i%2] += wi / 6
+		}
+
+		for px := 0; px < 3; px++ {
+			for py := 0; py < 2; py++ {
+				expected := math.Max(0, alpha[0]*act.Get(px, py, 0)+alpha[1]*act.Get(px, py, 1))
+				if actual := cam.Get(px, py, 0); math.Abs(actual-expected) > 1e-12 {
+					t.Errorf("class %d: expected (%d, %d) to be %f, but it is %f", class, px, py, expected, actual)
+				}
+			}
+		}
+	}
+
+	for i, pg := range net.ParamsAndGrads() {
+		for j := range pg.Grads {
+			if pg.Grads[j] != before[i][j] {
+				t.Fatalf("expected accumulated gradient %d/%d to be unchanged", i, j)
+			}
+		}
+	}
+
+	if _, err := convnet.GradCAM(net, x, 3, target); err == nil {
+		t.Error("expected an error for a class index out of range")
+	}
+	if _, err := convnet.GradCAM(net, x, 0, 3); err == nil {
+		t.Error("expected an error for the loss layer as the target")
+	}
+}
+
+func createConvNet(r *rand.Rand) *convnet.Net {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 16, OutSy: 16, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 8, Pad: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 8, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerSoftmax, NumClasses: 10},
+	}, r)
+
+	return net
+}
+
+// it should match Forward for every input and report bad inputs alone
+func TestForwardAll(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	net := createConvNet(r)
+
+	vols := make([]*convnet.Vol, 20)
+	for i := range vols {
+		vols[i] = convnet.NewVolRand(16, 16, 3, r)
+	}
+	vols[7] = convnet.NewVolRand(8, 8, 3, r)
+
+	outs, errs := net.ForwardAll(vols, 4)
+	if len(outs) != len(vols) || len(errs) != len(vols) {
+		t.Fatalf("expected %d results, but got %d outputs and %d errors", len(vols), len(outs), len(errs))
+	}
+
+	for i, v := range vols {
+		if i == 7 {
+			if errs[i] == nil || outs[i] != nil {
+				t.Errorf("expected input %d to fail, but got %v and %v", i, outs[i], errs[i])
+			}
+
+			continue
+		}
+
+		if errs[i] != nil {
+			t.Errorf("input %d: %v", i, errs[i])
+
+			continue
+		}
+
+		expected := net.Forward(v, false)
+		for j := range expected.W {
+			if outs[i].W[j] != expected.W[j] {
+				t.Errorf("input %d: expected output %d to be %f, but it is %f", i, j, expected.W[j], outs[i].W[j])
+			}
+		}
+	}
+
+	if _, errs := net.ForwardAll(vols[:7], 0); errs != nil {
+		t.Errorf("expected no errors, but got %v", errs)
+	}
+}
+
+func benchmarkForwardAll(b *testing.B, workers int) {
+	r := rand.New(rand.NewSource(0))
+	net := createConvNet(r)
+
+	vols := make([]*convnet.Vol, 64)
+	for i := range vols {
+		vols[i] = convnet.NewVolRand(16, 16, 3, r)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		net.ForwardAll(vols, workers)
+	}
+}
+
+func BenchmarkForwardAll1(b *testing.B) { benchmarkForwardAll(b, 1) }
+func BenchmarkForwardAll2(b *testing.B) { benchmarkForwardAll(b, 2) }
+func BenchmarkForwardAll4(b *testing.B) { benchmarkForwardAll(b, 4) }
+
+// it should round trip through float32 with only float32 rounding error
+func TestVolFloat32(t *testing.T) {
+	v := convnet.NewVolRand(3, 4, 5, rand.New(rand.NewSource(0)))
+
+	data := v.ToFloat32()
+	if len(data) != len(v.W) {
+		t.Fatalf("expected %d values, but got %d", len(v.W), len(data))
+	}
+
+	v2 := convnet.NewVolFromFloat32(3, 4, 5, data)
+	if !v.ApproxEqual(v2, 1e-6) {
+		t.Error("expected the values to survive conversion to float32")
+	}
+
+	var buf bytes.Buffer
+	if err := v.WriteFloat32(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := 12 + 4*len(v.W); buf.Len() != expected {
+		t.Errorf("expected %d bytes, but got %d", expected, buf.Len())
+	}
+
+	b := buf.Bytes()
+
+	v3, err := convnet.ReadVolFloat32(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v3.Equal(v2) {
+		t.Error("expected reading to give the same values as NewVolFromFloat32")
+	}
+
+	if _, err := convnet.ReadVolFloat32(bytes.NewReader(b[:len(b)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected a truncated vol to be an unexpected EOF, but got %v", err)
+	}
+}
+
+func BenchmarkVolFloat32(b *testing.B) {
+	// the weights of a large fc layer
+	v := convnet.NewVolRand(1, 1, 512*512, rand.New(rand.NewSource(0)))
+
+	var buf bytes.Buffer
+	maxErr := 0.0
+
+	b.SetBytes(int64(4 * len(v.W)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := v.WriteFloat32(&buf); err != nil {
+			b.Fatal(err)
+		}
+
+		v2, err := convnet.ReadVolFloat32(&buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		maxErr, _ = v.MaxAbsDiff(v2)
+	}
+
+	b.ReportMetric(maxErr, "max-error")
+}
+
+// it should remove dropout layers without changing predictions, and
+// refuse to train
+func TestCompile(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 3, Pad: 1, Activation: convnet.LayerRelu, DropProb: 0.5},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh, DropProb: 0.25},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	compiled := net.Compile()
+	if !compiled.Compiled() || net.Compiled() {
+		t.Error("expected only the copy to be compiled")
+	}
+
+	for _, l := range compiled.Layers {
+		if _, ok := l.(*convnet.DropoutLayer); ok {
+			t.Error("expected the dropout layers to be removed")
+		}
+	}
+	if expected := len(net.Layers) - 2; len(compiled.Layers) != expected {
+		t.Errorf("expected %d layers, but got %d", expected, len(compiled.Layers))
+	}
+
+	for i := 0; i < 5; i++ {
+		x := convnet.NewVolRand(4, 4, 2, r)
+
+		expected := net.Forward(x, false)
+		if out := compiled.Forward(x, false); !out.Equal(expected) {
+			t.Errorf("expected %v, but got %v", expected.W, out.W)
+		}
+		if out := compiled.Predict(x); !out.Equal(expected) {
+			t.Errorf("expected Predict to give %v, but got %v", expected.W, out.W)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected training a compiled net to panic")
+		} else if !strings.Contains(fmt.Sprint(r), "compiled") {
+			t.Errorf("expected a clear error, but got %v", r)
+		}
+	}()
+
+	trainer := convnet.NewTrainer(compiled, convnet.DefaultTrainerOptions)
+	trainer.Train(convnet.NewVolRand(4, 4, 2, r), convnet.LossData{Dim: 0})
+}
+
+// it should load the layers that still fit after the architecture changes
+func TestWarmStart(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	checkpoint := &convnet.Net{}
+	checkpoint.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerFC, NumNeurons: 8, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	// a new layer has been added before the classifier
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerFC, NumNeurons: 8, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerFC, NumNeurons: 5, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	before, _ := json.Marshal(net.Layers[5:])
+
+	loaded, skipped, err := net.WarmStart(checkpoint, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 || skipped != 2 {
+		t.Errorf("expected 2 layers loaded and 2 skipped, but got %d and %d", loaded, skipped)
+	}
+
+	// layers: input, fc, relu, fc, tanh, fc, tanh, fc, softmax
+	for _, i := range []int{1, 3} {
+		expected, _ := json.Marshal(checkpoint.Layers[i])
+		actual, _ := json.Marshal(net.Layers[i])
+		if string(expected) != string(actual) {
+			t.Errorf("expected layer %d to be loaded", i)
+		}
+	}
+	if after, _ := json.Marshal(net.Layers[5:]); string(before) != string(after) {
+		t.Error("expected the skipped layers to be unchanged")
+	}
+
+	// the first layer with parameters is now a different type
+	conv := &convnet.Net{}
+	conv.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerConv, Sx: 1, Filters: 8, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	before, _ = json.Marshal(conv)
+	if _, _, err := conv.WarmStart(checkpoint, false); err == nil {
+		t.Error("expected an error for a layer of a different type")
+	}
+	if after, _ := json.Marshal(conv); string(before) != string(after) {
+		t.Error("expected nothing to be loaded after an error")
+	}
+
+	loaded, skipped, err = conv.WarmStart(checkpoint, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 || skipped != 1 {
+		t.Errorf("expected 2 layers loaded and 1 skipped, but got %d and %d", loaded, skipped)
+	}
+
+	// filters with the same number of weights in a different shape
+	makeConv := func(sx, sy int) *convnet.Net {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 2},
+			{Type: convnet.LayerConv, Sx: sx, Sy: sy, Filters: 2},
+			{Type: convnet.LayerSoftmax, NumClasses: 3},
+		}, r)
+		return net
+	}
+
+	wide := makeConv(3, 1)
+	before, _ = json.Marshal(wide.Layers[1])
+	loaded, skipped, err = wide.WarmStart(makeConv(1, 3), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1 || skipped != 1 {
+		t.Errorf("expected 1 layer loaded and 1 skipped, but got %d and %d", loaded, skipped)
+	}
+	if after, _ := json.Marshal(wide.Layers[1]); string(before) != string(after) {
+		t.Error("expected the conv layer to be unchanged")
+	}
+}
+
+// it should merge each level with the upsampled level above it, and pass
+// the gradients back to every level
+func TestFPN(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	fpn, err := convnet.NewFPNLayer(convnet.LayerDef{
+		Type:        convnet.LayerFPN,
+		InSx:        5,
+		InSy:        4,
+		LevelDepths: []int{2, 3, 4},
+		Filters:     3,
+	}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fpn.Levels() != 3 {
+		t.Fatalf("expected 3 levels, but got %d", fpn.Levels())
+	}
+
+	var inputs []*convnet.Vol
+	for level, expected := range [][3]int{{5, 4, 2}, {3, 2, 3}, {2, 1, 4}} {
+		sx, sy, depth := fpn.InputSize(level)
+		if [3]int{sx, sy, depth} != expected {
+			t.Errorf("expected level %d to have size %v, but it is %dx%dx%d", level, expected, sx, sy, depth)
+		}
+
+		inputs = append(inputs, convnet.NewVolRand(sx, sy, depth, r))
+	}
+
+	// the loss is a fixed weighted sum of every output
+	weights := make([]*convnet.Vol, len(inputs))
+	loss := func() float64 {
+		sum := 0.0
+		for i, out := range fpn.ForwardMulti(inputs, false) {
+			if weights[i] == nil {
+				weights[i] = convnet.NewVolRand(out.Sx, out.Sy, out.Depth, r)
+			}
+
+			for j := range out.W {
+				sum += out.W[j] * weights[i].W[j]
+			}
+		}
+
+		return sum
+	}
+
+	loss()
+	outs := fpn.Outputs()
+
+	// the coarsest level is just its lateral convolution
+	lateral := fpn.Laterals()[2].Forward(inputs[2], false)
+	if !outs[2].Equal(lateral) {
+		t.Errorf("expected the coarsest output to be %v, but it is %v", lateral.W, outs[2].W)
+	}
+
+	// the next level adds the coarsest level at half resolution
+	lateral = fpn.Laterals()[1].Forward(inputs[1], false)
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 2; y++ {
+			for d := 0; d < 3; d++ {
+				expected := lateral.Get(x, y, d) + outs[2].Get(x/2, y/2, d)
+				if actual := outs[1].Get(x, y, d); math.Abs(actual-expected) > 1e-12 {
+					t.Errorf("expected (%d, %d, %d) to be %f, but it is %f", x, y, d, expected, actual)
+				}
+			}
+		}
+	}
+
+	loss()
+	for i, out := range fpn.Outputs() {
+		copy(out.Dw, weights[i].W)
+	}
+	fpn.Backward()
+
+	const delta = 1e-5
+	numeric := func(p []float64, j int) float64 {
+		old := p[j]
+		p[j] = old + delta
+		plus := loss()
+		p[j] = old - delta
+		minus := loss()
+		p[j] = old
+
+		return (plus - minus) / (2 * delta)
+	}
+
+	for level, in := range inputs {
+		for j := range in.W {
+			if n := numeric(in.W, j); math.Abs(n-in.Dw[j]) > 1e-6 {
+				t.Errorf("level %d: expected input gradient %d to be %f, but it is %f", level, j, n, in.Dw[j])
+			}
+		}
+	}
+	for i, pg := range fpn.ParamsAndGrads() {
+		for j := range pg.Params {
+			if n := numeric(pg.Params, j); math.Abs(n-pg.Grads[j]) > 1e-6 {
+				t.Errorf("parameter group %d: expected gradient %d to be %f, but it is %f", i, j, n, pg.Grads[j])
+			}
+		}
+	}
+
+	// a round trip keeps the pyramid intact
+	b, err := json.Marshal(fpn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded convnet.FPNLayer
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	for i, out := range loaded.ForwardMulti(inputs, false) {
+		if !out.Equal(outs[i]) {
+			t.Errorf("expected loaded output %d to match", i)
+		}
+	}
+
+	// a single level can be part of a net, but more cannot
+	defs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 2},
+		{Type: convnet.LayerFPN, Filters: 3},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}
+	if err := convnet.ValidateDefs(defs); err != nil {
+		t.Error(err)
+	}
+	net := &convnet.Net{}
+	net.MakeLayers(defs, r)
+	if err := net.Validate(); err != nil {
+		t.Error(err)
+	}
+	net.Forward(convnet.NewVolRand(4, 4, 2, r), true)
+	net.Backward(convnet.LossData{Dim: 1})
+
+	defs[1].LevelDepths = []int{2, 4}
+	if err := convnet.ValidateDefs(defs); err == nil {
+		t.Error("expected an error for a multi-level fpn layer in a net")
+	}
+}
+
+// it should crop at a reproducible random offset
+func TestRandomCrop(t *testing.T) {
+	v := convnet.NewVol(8, 6, 2, 0.0)
+	for i := range v.W {
+		v.W[i] = float64(i)
+	}
+
+	// finds the offset of a crop by looking for its corner
+	offset := func(c *convnet.Vol, flipped bool) (int, int) {
+		x := 0
+		if flipped {
+			x = c.Sx - 1
+		}
+
+		corner := int(c.Get(x, 0, 0)) / v.Depth
+
+		return corner % v.Sx, corner / v.Sx
+	}
+
+	seen := make(map[[2]int]bool)
+	flips := 0
+
+	for seed := int64(0); seed < 50; seed++ {
+		c := v.RandomCropWithFlip(4, 0.5, rand.New(rand.NewSource(seed)))
+		again := v.RandomCropWithFlip(4, 0.5, rand.New(rand.NewSource(seed)))
+		if !c.Equal(again) {
+			t.Fatal("expected the same seed to give the same crop")
+		}
+		if c.Sx != 4 || c.Sy != 4 || c.Depth != 2 {
+			t.Fatalf("expected a 4x4x2 crop, but got %dx%dx%d", c.Sx, c.Sy, c.Depth)
+		}
+
+		flipped := c.Get(0, 0, 0) > c.Get(3, 0, 0)
+		if flipped {
+			flips++
+		}
+
+		dx, dy := offset(c, flipped)
+		if dx < 0 || dx >= 4 || dy < 0 || dy >= 2 {
+			t.Errorf("expected an offset in [0, 4) x [0, 2), but got (%d, %d)", dx, dy)
+		}
+		if !c.Equal(v.Augment(4, dx, dy, flipped)) {
+			t.Errorf("expected the crop to match Augment at (%d, %d)", dx, dy)
+		}
+
+		seen[[2]int{dx, dy}] = true
+
+		if c := v.RandomCrop(4, rand.New(rand.NewSource(seed))); c.Get(0, 0, 0) > c.Get(3, 0, 0) {
+			t.Error("expected RandomCrop not to flip")
+		}
+	}
+
+	if len(seen) != 8 {
+		t.Errorf("expected all 8 offsets to be used, but got %v", seen)
+	}
+	if flips == 0 || flips == 50 {
+		t.Errorf("expected about half of the crops to be flipped, but %d were", flips)
+	}
+}
+
+// it should match finite differences of the class score and leave the
+// accumulated gradients alone
+func TestInputGradient(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 5, OutSy: 5, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerSigmoid},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	x := convnet.NewVolRand(5, 5, 2, r)
+	y := convnet.LossData{Dim: 1}
+
+	// leave a gradient behind, as a trainer in the middle of a batch would
+	net.Forward(x, true)
+	net.Backward(y)
+
+	var before [][]float64
+	for _, pg := range net.ParamsAndGrads() {
+		before = append(before, append([]float64(nil), pg.Grads...))
+	}
+	inputDw := append([]float64(nil), x.Dw...)
+
+	score := func(class int) float64 {
+		net.Forward(x, false)
+
+		return net.ActivationAt(len(net.Layers) - 2).W[class]
+	}
+
+	for class := 0; class < 3; class++ {
+		g := net.InputGradient(x, class)
+		if g.Sx != 5 || g.Sy != 5 || g.Depth != 2 {
+			t.Fatalf("expected a 5x5x2 gradient, but got %dx%dx%d", g.Sx, g.Sy, g.Depth)
+		}
+
+		for _, i := range []int{0, 7, 23, 49} {
+			const delta = 1e-5
+
+			old := x.W[i]
+			x.W[i] = old + delta
+			plus := score(class)
+			x.W[i] = old - delta
+			minus := score(class)
+			x.W[i] = old
+
+			if numeric := (plus - minus) / (2 * delta); math.Abs(numeric-g.W[i]) > 1e-6 {
+				t.Errorf("class %d: expected gradient %d to be %g, but it is %g", class, i, numeric, g.W[i])
+			}
+		}
+	}
+
+	for i, pg := range net.ParamsAndGrads() {
+		for j := range pg.Grads {
+			if pg.Grads[j] != before[i][j] {
+				t.Fatalf("expected accumulated gradient %d/%d to be unchanged", i, j)
+			}
+		}
+	}
+	for i := range inputDw {
+		if x.Dw[i] != inputDw[i] {
+			t.Fatalf("expected the gradient of the input to be unchanged")
+		}
+	}
+}
+
+// it should differentiate the prediction-time scaling of dropout layers
+func TestInputGradientDropout(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerFC, NumNeurons: 8, Activation: convnet.LayerTanh, DropProb: 0.3},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	x := convnet.NewVolRand(1, 1, 4, r)
+
+	// leave a dropout mask behind from a training pass
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 0})
+
+	score := func(class int) float64 {
+		net.Forward(x, false)
+
+		return net.ActivationAt(len(net.Layers) - 2).W[class]
+	}
+
+	for class := 0; class < 3; class++ {
+		g := net.InputGradient(x, class)
+
+		for i := range x.W {
+			const delta = 1e-5
+
+			old := x.W[i]
+			x.W[i] = old + delta
+			plus := score(class)
+			x.W[i] = old - delta
+			minus := score(class)
+			x.W[i] = old
+
+			if numeric := (plus - minus) / (2 * delta); math.Abs(numeric-g.W[i]) > 1e-6 {
+				t.Errorf("class %d: expected gradient %d to be %g, but it is %g", class, i, numeric, g.W[i])
+			}
+		}
+	}
+}
+
+// it should continue a training run exactly from a snapshot
+func TestRandState(t *testing.T) {
+	defs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+		{Type: convnet.LayerFC, NumNeurons: 10, Activation: convnet.LayerRelu, DropProb: 0.3},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}
+
+	example := func(i int) (*convnet.Vol, convnet.LossData) {
+		r := rand.New(rand.NewSource(int64(i)))
+
+		return convnet.NewVolRand(1, 1, 4, r), convnet.LossData{Dim: r.Intn(3)}
+	}
+
+	train := func(net *convnet.Net, from, to int) {
+		trainer := convnet.NewTrainer(net, convnet.TrainerOptions{LearningRate: 0.01, BatchSize: 1})
+		for i := from; i < to; i++ {
+			trainer.Train(example(i))
+		}
+	}
+
+	src := convnet.NewRandSource(7)
+	net := &convnet.Net{}
+	net.MakeLayers(defs, rand.New(src))
+	net.SetRandSource(src)
+
+	train(net, 0, 500)
+
+	snapshot, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := net.RandState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	train(net, 500, 1000)
+	expected, _ := json.Marshal(net)
+
+	// start over from the snapshot, as a new process would
+	restored := &convnet.Net{}
+	if err := json.Unmarshal(snapshot, restored); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.SetRandState(state); err == nil {
+		t.Error("expected an error before SetRandSource")
+	}
+	restored.SetRandSource(convnet.NewRandSource(0))
+	if err := restored.SetRandState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	train(restored, 500, 1000)
+	if actual, _ := json.Marshal(restored); string(actual) != string(expected) {
+		t.Error("expected the restored net to end up with the same weights")
+	}
+
+	// and a different state should not
+	diverged := &convnet.Net{}
+	if err := json.Unmarshal(snapshot, diverged); err != nil {
+		t.Fatal(err)
+	}
+	diverged.SetRandSource(convnet.NewRandSource(0))
+
+	train(diverged, 500, 1000)
+	if actual, _ := json.Marshal(diverged); string(actual) == string(expected) {
+		t.Error("expected a different random state to give different weights")
+	}
+}
+
+// it should find the layers of each type in order
+func TestGetLayer(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 6, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerFC, NumNeurons: 10, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	convs := convnet.GetConvLayers(net)
+	if len(convs) != 2 {
+		t.Fatalf("expected 2 conv layers, but got %d", len(convs))
+	}
+	if convs[0] != net.Layers[1] || convs[1] != net.Layers[4] {
+		t.Error("expected conv layers in order")
+	}
+	if convs[1].OutDepth() != 6 {
+		t.Errorf("expected second conv layer to have 6 filters, but it has %d", convs[1].OutDepth())
+	}
+
+	if n := len(convnet.GetReluLayers(net)); n != 2 {
+		t.Errorf("expected 2 relu layers, but got %d", n)
+	}
+	// the softmax layer brings its own fc layer
+	if n := len(convnet.GetFCLayers(net)); n != 2 {
+		t.Errorf("expected 2 fc layers, but got %d", n)
+	}
+	if n := len(convnet.GetDropoutLayers(net)); n != 0 {
+		t.Errorf("expected no dropout layers, but got %d", n)
+	}
+
+	generic := convnet.GetLayer[*convnet.ConvLayer](net)
+	if len(generic) != len(convs) || generic[0] != convs[0] || generic[1] != convs[1] {
+		t.Error("expected GetLayer to agree with GetConvLayers")
+	}
+
+	// interface types match every layer that implements them
+	if n := len(convnet.GetLayer[convnet.LossLayer](net)); n != 1 {
+		t.Errorf("expected 1 loss layer, but got %d", n)
+	}
+	if n := len(convnet.GetLayer[convnet.Layer](net)); n != len(net.Layers) {
+		t.Errorf("expected every layer to be a Layer, but got %d of %d", n, len(net.Layers))
+	}
+}
+
+// it should clip the gradient of each example and add noise to the batch
+func TestDPSGD(t *testing.T) {
+	params := func(net *convnet.Net) []float64 {
+		var p []float64
+		for _, pg := range net.ParamsAndGrads() {
+			p = append(p, pg.Params...)
+		}
+		return p
+	}
+	updateNorm := func(before, after []float64) float64 {
+		sumSq := 0.0
+		for i := range before {
+			d := after[i] - before[i]
+			sumSq += d * d
+		}
+		return math.Sqrt(sumSq)
+	}
+
+	opts := convnet.DefaultTrainerOptions
+	opts.Method = convnet.MethodDPSGD
+	opts.LearningRate = 1
+	opts.Momentum = 0
+	opts.ClipNorm = 0.01
+	opts.NoiseSigma = 0
+
+	// with a learning rate of 1 and no noise, each update is exactly the
+	// averaged clipped gradient
+	for _, batchSize := range []int{1, 4} {
+		net, _, r := createTestNet()
+		opts.BatchSize = batchSize
+		trainer := convnet.NewTrainer(net, opts)
+
+		for step := 0; step < 5; step++ {
+			before := params(net)
+			for i := 0; i < batchSize; i++ {
+				x := convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1})
+				trainer.Train(x, convnet.LossData{Dim: r.Intn(3)})
+			}
+
+			norm := updateNorm(before, params(net))
+			if norm > opts.ClipNorm+1e-12 {
+				t.Errorf("batch size %d step %d: expected gradient norm at most %g, but it is %g", batchSize, step, opts.ClipNorm, norm)
+			}
+			if batchSize == 1 && math.Abs(norm-opts.ClipNorm) > 1e-9 {
+				t.Errorf("step %d: expected a large gradient to be clipped to %g, but its norm is %g", step, opts.ClipNorm, norm)
+			}
+		}
+	}
+
+	// noise should make the update larger than the clipped gradient
+	net, _, r := createTestNet()
+	opts.BatchSize = 1
+	opts.NoiseSigma = 1
+	trainer := convnet.NewTrainer(net, opts)
+	trainer.Rand = rand.New(rand.NewSource(1))
+
+	if eps := trainer.DPEpsilon(); eps != 0 {
+		t.Errorf("expected epsilon of 0 before training, but got %g", eps)
+	}
+
+	before := params(net)
+	trainer.Train(convnet.NewVol1D([]float64{r.Float64(), r.Float64()}), convnet.LossData{Dim: 0})
+	if norm := updateNorm(before, params(net)); norm <= 2*opts.ClipNorm {
+		t.Errorf("expected noise to dominate the update, but its norm is only %g", norm)
+	}
+
+	eps1 := trainer.DPEpsilon()
+	trainer.Train(convnet.NewVol1D([]float64{r.Float64(), r.Float64()}), convnet.LossData{Dim: 1})
+	eps2 := trainer.DPEpsilon()
+	if !(eps1 > 0 && eps2 > eps1) || math.IsInf(eps2, 0) {
+		t.Errorf("expected epsilon to grow with each update, but got %g then %g", eps1, eps2)
+	}
+
+	// without a Rand, the noise must not be the same every time
+	var updates [2][]float64
+	for i := range updates {
+		net, _, _ := createTestNet()
+		trainer := convnet.NewTrainer(net, opts)
+		trainer.Train(convnet.NewVol1D([]float64{0.5, -0.5}), convnet.LossData{Dim: 0})
+		updates[i] = params(net)
+	}
+	if updateNorm(updates[0], updates[1]) == 0 {
+		t.Error("expected two trainers without a Rand to add different noise")
+	}
+}
+
+// it should initialize weights with the requested scale
+func TestInitMethod(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	stddev := func(w []float64) float64 {
+		sumSq := 0.0
+		for _, x := range w {
+			sumSq += x * x
+		}
+		return math.Sqrt(sumSq / float64(len(w)))
+	}
+
+	k := convnet.NewVolKaiming(10, 10, 100, 50, r)
+	if s, expected := stddev(k.W), math.Sqrt(2.0/50); math.Abs(s-expected) > 0.02*expected {
+		t.Errorf("expected kaiming weights to have standard deviation %g, but got %g", expected, s)
+	}
+
+	x := convnet.NewVolXavier(10, 10, 100, 40, 20, r)
+	limit := math.Sqrt(6.0 / 60)
+	for _, w := range x.W {
+		if w < -limit || w > limit {
+			t.Fatalf("expected xavier weights in [%g, %g], but got %g", -limit, limit, w)
+		}
+	}
+	if s, expected := stddev(x.W), limit/math.Sqrt(3); math.Abs(s-expected) > 0.02*expected {
+		t.Errorf("expected xavier weights to have standard deviation %g, but got %g", expected, s)
+	}
+
+	makeNet := func(method string) *convnet.Net {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 3},
+			{Type: convnet.LayerConv, Sx: 3, Filters: 16, Pad: 1, Activation: convnet.LayerRelu, InitMethod: method},
+			{Type: convnet.LayerFC, NumNeurons: 200, InitMethod: method},
+			{Type: convnet.LayerRegression, NumNeurons: 2, InitMethod: method},
+		}, rand.New(rand.NewSource(0)))
+		return net
+	}
+
+	// every filter, leaving out the biases at the end
+	allWeights := func(net *convnet.Net, i int) []float64 {
+		var w []float64
+		pgs := net.Layers[i].ParamsAndGrads()
+		for _, pg := range pgs[:len(pgs)-1] {
+			w = append(w, pg.Params...)
+		}
+		return w
+	}
+
+	// conv: fan in 3*3*3 = 27, fan out 3*3*16 = 144
+	// fc: fan in 8*8*16 = 1024, fan out 200
+	for _, c := range []struct {
+		method   string
+		conv, fc float64
+	}{
+		{convnet.InitDefault, math.Sqrt(1.0 / 27), math.Sqrt(1.0 / 1024)},
+		{"", math.Sqrt(1.0 / 27), math.Sqrt(1.0 / 1024)},
+		{convnet.InitKaiming, math.Sqrt(2.0 / 27), math.Sqrt(2.0 / 1024)},
+		{convnet.InitXavier, math.Sqrt(6.0/(27+144)) / math.Sqrt(3), math.Sqrt(6.0/(1024+200)) / math.Sqrt(3)},
+	} {
+		net := makeNet(c.method)
+		// layers: input, conv, relu, fc, fc, regression
+		if s := stddev(allWeights(net, 1)); math.Abs(s-c.conv) > 0.1*c.conv {
+			t.Errorf("%q: expected conv weights to have standard deviation %g, but got %g", c.method, c.conv, s)
+		}
+		if s := stddev(allWeights(net, 3)); math.Abs(s-c.fc) > 0.05*c.fc {
+			t.Errorf("%q: expected fc weights to have standard deviation %g, but got %g", c.method, c.fc, s)
+		}
+	}
+
+	// the other layers with weights
+	// deform conv: fan in 3*3*3 = 27, fan out 3*3*16 = 144
+	// causal conv: fan in 4*8 = 32, fan out 4*64 = 256
+	// embedding: each row is a neuron with 50 inputs and 50 outputs
+	firstWeights := func(net *convnet.Net, i, n int) []float64 {
+		var w []float64
+		for _, pg := range net.Layers[i].ParamsAndGrads()[:n] {
+			w = append(w, pg.Params...)
+		}
+		return w
+	}
+	for _, c := range []struct {
+		method                    string
+		deform, causal, embedding float64
+	}{
+		{"", math.Sqrt(1.0 / 27), math.Sqrt(1.0 / 32), math.Sqrt(1.0 / 50)},
+		{convnet.InitKaiming, math.Sqrt(2.0 / 27), math.Sqrt(2.0 / 32), math.Sqrt(2.0 / 50)},
+		{convnet.InitXavier, math.Sqrt(6.0/(27+144)) / math.Sqrt(3), math.Sqrt(6.0/(32+256)) / math.Sqrt(3), math.Sqrt(6.0/100) / math.Sqrt(3)},
+	} {
+		r := rand.New(rand.NewSource(0))
+
+		deform := &convnet.Net{}
+		deform.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 3},
+			{Type: convnet.LayerDeformConv, Sx: 3, Filters: 16, Pad: 1, InitMethod: c.method},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, r)
+		if s := stddev(firstWeights(deform, 1, 16)); math.Abs(s-c.deform) > 0.1*c.deform {
+			t.Errorf("%q: expected deform conv weights to have standard deviation %g, but got %g", c.method, c.deform, s)
+		}
+
+		causal := &convnet.Net{}
+		causal.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 16, OutSy: 1, OutDepth: 8},
+			{Type: convnet.LayerCausalConv, Sx: 4, Filters: 64, InitMethod: c.method},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, r)
+		if s := stddev(firstWeights(causal, 1, 64)); math.Abs(s-c.causal) > 0.1*c.causal {
+			t.Errorf("%q: expected causal conv weights to have standard deviation %g, but got %g", c.method, c.causal, s)
+		}
+
+		embedding := &convnet.Net{}
+		embedding.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 1},
+			{Type: convnet.LayerEmbedding, NumEmbeddings: 100, EmbeddingDim: 50, InitMethod: c.method},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, r)
+		if s := stddev(firstWeights(embedding, 1, 1)); math.Abs(s-c.embedding) > 0.05*c.embedding {
+			t.Errorf("%q: expected embedding weights to have standard deviation %g, but got %g", c.method, c.embedding, s)
+		}
+	}
+
+	err := convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 3, InitMethod: "he"},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	})
+	if layerErr, ok := err.(*convnet.LayerError); !ok || layerErr.LayerIndex != 1 {
+		t.Errorf("expected an error at layer 1 for an unknown init method, but got %v", err)
+	}
+}
+
+// it should learn targets far from unit scale by normalizing them
+func TestTargetNormalization(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 1},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	}, rand.New(rand.NewSource(0)))
+
+	reg := net.Layers[len(net.Layers)-1].(*convnet.RegressionLayer)
+	reg.EnableTargetNormalization(0.99)
+
+	if reg.TargetMean() != 0 || reg.TargetVariance() != 1 {
+		t.Errorf("expected stats to start at 0 and 1, but got %g and %g", reg.TargetMean(), reg.TargetVariance())
+	}
+
+	trainer := convnet.NewTrainer(net, convnet.TrainerOptions{LearningRate: 0.01, BatchSize: 1})
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		x := r.Float64()*2 - 1
+		res := trainer.Train(convnet.NewVol1D([]float64{x}), convnet.LossData{Dim: 0, Val: 1000 + 100*x})
+		if math.IsNaN(res.Loss) || math.IsInf(res.Loss, 0) {
+			t.Fatalf("loss diverged at step %d", i)
+		}
+	}
+
+	// targets are uniform in [900, 1100], with a variance of 100^2/3
+	mean, variance := reg.TargetMean(), reg.TargetVariance()
+	if math.Abs(mean-1000) > 20 {
+		t.Errorf("expected running mean near 1000, but got %g", mean)
+	}
+	if expected := 100.0 * 100 / 3; math.Abs(variance-expected) > 0.3*expected {
+		t.Errorf("expected running variance near %g, but got %g", expected, variance)
+	}
+
+	// evaluating the loss must not move the statistics
+	for _, y := range []float64{0, 1e6} {
+		net.CostLoss(convnet.NewVol1D([]float64{0}), convnet.LossData{Dim: 0, Val: y})
+	}
+	if reg.TargetMean() != mean || reg.TargetVariance() != variance {
+		t.Errorf("expected CostLoss to leave the stats at %g and %g, but got %g and %g", mean, variance, reg.TargetMean(), reg.TargetVariance())
+	}
+
+	for _, x := range []float64{-0.5, 0, 0.5} {
+		out := net.Forward(convnet.NewVol1D([]float64{x}), false).W[0]
+		pred := out*math.Sqrt(variance) + mean
+		if expected := 1000 + 100*x; math.Abs(pred-expected) > 10 {
+			t.Errorf("expected denormalized prediction near %g at %g, but got %g", expected, x, pred)
+		}
+	}
+
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded convnet.Net
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	lreg := loaded.Layers[len(loaded.Layers)-1].(*convnet.RegressionLayer)
+	if lreg.TargetMean() != mean || lreg.TargetVariance() != variance {
+		t.Error("expected target statistics to survive JSON")
+	}
+
+	// the loaded layer should keep normalizing
+	y := convnet.LossData{Dim: 0, Val: 1000}
+	x := convnet.NewVol1D([]float64{0})
+	net.Forward(x, true)
+	loaded.Forward(x, true)
+	if a, b := net.Backward(y), loaded.Backward(y); a != b {
+		t.Errorf("expected the loaded net to have the same loss, but got %g and %g", b, a)
+	}
+}
+
+// it should find orthogonal directions of decreasing variance
+func TestPCA(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	// samples spread mostly along (1, 1, 0, 0), less along (0, 0, 1, -1),
+	// and a little in every direction
+	samples := make([]*convnet.Vol, 500)
+	for i := range samples {
+		a, b := r.NormFloat64()*3, r.NormFloat64()
+		v := convnet.NewVol(1, 1, 4, 0.0)
+		v.W[0] = a + r.NormFloat64()*0.1 + 5
+		v.W[1] = a + r.NormFloat64()*0.1
+		v.W[2] = b + r.NormFloat64()*0.1
+		v.W[3] = -b + r.NormFloat64()*0.1 - 2
+		samples[i] = v
+	}
+
+	components, explained, err := convnet.PCA(samples, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if components.Sx != 4 || components.Sy != 4 || components.Depth != 1 {
+		t.Fatalf("expected 4x4x1 components, but got %dx%dx%d", components.Sx, components.Sy, components.Depth)
+	}
+
+	row := func(c int) []float64 { return components.W[c*4 : (c+1)*4] }
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			dot := 0.0
+			for k := 0; k < 4; k++ {
+				dot += row(i)[k] * row(j)[k]
+			}
+
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(dot-expected) > 1e-9 {
+				t.Errorf("expected components %d and %d to have a dot product of %g, but got %g", i, j, expected, dot)
+			}
+		}
+	}
+
+	sum := 0.0
+	for i, e := range explained {
+		sum += e
+		if i > 0 && e > explained[i-1] {
+			t.Errorf("expected explained variance to decrease, but got %v", explained)
+		}
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("expected explained variance to sum to 1, but it sums to %g", sum)
+	}
+	if explained[0] < 0.8 {
+		t.Errorf("expected the first component to explain most of the variance, but got %v", explained)
+	}
+
+	s := 1 / math.Sqrt2
+	for c, expected := range [][]float64{{s, s, 0, 0}, {0, 0, s, -s}} {
+		for k := range expected {
+			if got := row(c)[k]; math.Abs(math.Abs(got)-math.Abs(expected[k])) > 0.02 {
+				t.Errorf("expected component %d to be near %v, but got %v", c, expected, row(c))
+				break
+			}
+		}
+	}
+
+	// projecting onto every component keeps distances
+	proj := samples[0].ProjectPCA(components)
+	if proj.Depth != 4 {
+		t.Fatalf("expected 4 coordinates, but got %d", proj.Depth)
+	}
+	norm, projNorm := 0.0, 0.0
+	for k := range proj.W {
+		norm += samples[0].W[k] * samples[0].W[k]
+		projNorm += proj.W[k] * proj.W[k]
+	}
+	if math.Abs(norm-projNorm) > 1e-9 {
+		t.Errorf("expected projection to keep the squared norm %g, but got %g", norm, projNorm)
+	}
+
+	// fewer components than dimensions, with rank-deficient data
+	flat := make([]*convnet.Vol, 10)
+	for i := range flat {
+		flat[i] = convnet.NewVol1D([]float64{float64(i), 2 * float64(i), 0})
+	}
+	components, explained, err = convnet.PCA(flat, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(explained[0]-1) > 1e-9 || math.Abs(explained[1]) > 1e-9 || math.Abs(explained[2]) > 1e-9 {
+		t.Errorf("expected one component to explain everything, but got %v", explained)
+	}
+	if proj := flat[3].ProjectPCA(components); math.Abs(proj.W[0]-3*math.Sqrt(5)) > 1e-9 {
+		t.Errorf("expected projection %g on the first component, but got %g", 3*math.Sqrt(5), proj.W[0])
+	}
+
+	if _, _, err := convnet.PCA(flat, 4); err == nil {
+		t.Error("expected an error for too many components")
+	}
+	if _, _, err := convnet.PCA(nil, 1); err == nil {
+		t.Error("expected an error for no samples")
+	}
+}
+
+// it should compare Vols by angle and find the closest one
+func TestCosineSimilarity(t *testing.T) {
+	a := convnet.NewVol1D([]float64{1, 0, 0})
+	b := convnet.NewVol1D([]float64{2, 2, 0})
+
+	if sim, err := a.CosineSimilarity(b); err != nil || math.Abs(sim-1/math.Sqrt2) > 1e-12 {
+		t.Errorf("expected similarity %g, but got %g (%v)", 1/math.Sqrt2, sim, err)
+	}
+	if sim, _ := b.CosineSimilarity(b); math.Abs(sim-1) > 1e-12 {
+		t.Errorf("expected a Vol to have similarity 1 with itself, but got %g", sim)
+	}
+	if sim, _ := a.CosineSimilarity(convnet.NewVol1D([]float64{-3, 0, 0})); math.Abs(sim+1) > 1e-12 {
+		t.Errorf("expected opposite Vols to have similarity -1, but got %g", sim)
+	}
+	if _, err := a.CosineSimilarity(convnet.NewVol1D([]float64{1, 0})); err == nil {
+		t.Error("expected an error for different lengths")
+	}
+	if _, err := a.CosineSimilarity(convnet.NewVol(1, 1, 3, 0.0)); err == nil {
+		t.Error("expected an error for a zero vector")
+	}
+
+	gallery := []*convnet.Vol{
+		convnet.NewVol1D([]float64{0, 1, 0}),
+		convnet.NewVol(1, 1, 3, 0.0),
+		convnet.NewVol1D([]float64{5, 1, 0}),
+		convnet.NewVol1D([]float64{1, 1}),
+		b,
+	}
+	if i, sim := convnet.NearestNeighbor(a, gallery); i != 2 || math.Abs(sim-5/math.Sqrt(26)) > 1e-12 {
+		t.Errorf("expected gallery item 2 with similarity %g, but got %d with %g", 5/math.Sqrt(26), i, sim)
+	}
+	if i, _ := convnet.NearestNeighbor(a, gallery[1:2]); i != -1 {
+		t.Errorf("expected -1 when nothing can be compared, but got %d", i)
+	}
+}
+
+// it should threshold and clamp Vols
+func TestThreshold(t *testing.T) {
+	v := convnet.NewVol1D([]float64{-1, 0.5, 0.2, 3})
+
+	if w := v.Threshold(0.2); !reflect.DeepEqual(w.W, []float64{0, 1, 0, 1}) {
+		t.Errorf("expected mask [0 1 0 1], but got %v", w.W)
+	}
+	if !reflect.DeepEqual(v.W, []float64{-1, 0.5, 0.2, 3}) {
+		t.Errorf("expected Threshold to leave v alone, but got %v", v.W)
+	}
+
+	if w := v.Clamp(0, 1); !reflect.DeepEqual(w.W, []float64{0, 0.5, 0.2, 1}) {
+		t.Errorf("expected [0 0.5 0.2 1], but got %v", w.W)
+	}
+
+	v.ThresholdInPlace(0)
+	if !reflect.DeepEqual(v.W, []float64{0, 1, 1, 1}) {
+		t.Errorf("expected mask [0 1 1 1] in place, but got %v", v.W)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for min more than max")
+		}
+	}()
+	v.Clamp(1, 0)
+}
+
+// it should reorder the dimensions of a Vol
+func TestPermute(t *testing.T) {
+	v := convnet.NewVol(2, 3, 4, 0.0)
+	for i := range v.W {
+		v.W[i] = float64(i)
+	}
+
+	if w, err := v.Permute([3]int{0, 1, 2}); err != nil || !reflect.DeepEqual(w.W, v.W) {
+		t.Errorf("expected the identity permutation to copy v, but got %v (%v)", w, err)
+	}
+
+	// depth becomes y and y becomes x
+	w, err := v.Permute([3]int{1, 2, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Depth != 2 || w.Sx != 3 || w.Sy != 4 {
+		t.Fatalf("expected a 3x4x2 Vol, but got %dx%dx%d", w.Sx, w.Sy, w.Depth)
+	}
+	for y := 0; y < v.Sy; y++ {
+		for x := 0; x < v.Sx; x++ {
+			for d := 0; d < v.Depth; d++ {
+				if w.Get(y, d, x) != v.Get(x, y, d) {
+					t.Errorf("expected %g at (%d, %d, %d), but got %g", v.Get(x, y, d), y, d, x, w.Get(y, d, x))
+				}
+			}
+		}
+	}
+
+	// the inverse permutation undoes it
+	if u, err := w.Permute([3]int{2, 0, 1}); err != nil || u.Sx != 2 || u.Sy != 3 || u.Depth != 4 || !reflect.DeepEqual(u.W, v.W) {
+		t.Errorf("expected the inverse permutation to restore v, but got %v (%v)", u, err)
+	}
+
+	for _, order := range [][3]int{{0, 0, 1}, {0, 1, 3}, {-1, 1, 2}} {
+		if _, err := v.Permute(order); err == nil {
+			t.Errorf("expected an error for %v", order)
+		}
+	}
+}
+
+// it should make one-hot and label smoothed targets
+func TestOneHot(t *testing.T) {
+	v, err := convnet.OneHot(2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Sx != 1 || v.Sy != 1 || v.Depth != 4 || len(v.Dw) != 4 {
+		t.Errorf("expected a 1x1x4 Vol, but got %dx%dx%d", v.Sx, v.Sy, v.Depth)
+	}
+	if !v.Equal(convnet.NewVol1D([]float64{0, 0, 1, 0})) {
+		t.Errorf("unexpected one-hot values %v", v.W)
+	}
+
+	v, err = convnet.SoftOneHot(0, 4, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.ApproxEqual(convnet.NewVol1D([]float64{0.85, 0.05, 0.05, 0.05}), 1e-12) {
+		t.Errorf("unexpected smoothed values %v", v.W)
+	}
+
+	if _, err := convnet.OneHot(4, 4); err == nil {
+		t.Error("expected an error for class == numClasses")
+	}
+	if _, err := convnet.OneHot(-1, 4); err == nil {
+		t.Error("expected an error for a negative class")
+	}
+	if _, err := convnet.SoftOneHot(1, 4, 1.5); err == nil {
+		t.Error("expected an error for smoothing more than 1")
+	}
+}
+
+// it should carry on with the same momentum as the original
+func TestTrainerClone(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	}, rand.New(rand.NewSource(0)))
+
+	opts := convnet.DefaultTrainerOptions
+	opts.Method = convnet.MethodAdam
+	trainer := convnet.NewTrainer(net, opts)
+
+	x := convnet.NewVol1D([]float64{0.5, -1})
+	y := convnet.LossData{Dim: 0, Val: 1}
+	for i := 0; i < 5; i++ {
+		trainer.Train(x, y)
+	}
+
+	clone := trainer.Clone(net.Clone())
+	if clone.Net == net {
+		t.Fatal("expected the clone to train the given net")
+	}
+
+	for i := 0; i < 5; i++ {
+		trainer.Train(x, y)
+		clone.Train(x, y)
+	}
+
+	a, _ := json.Marshal(net)
+	b, _ := json.Marshal(clone.Net)
+	if string(a) != string(b) {
+		t.Error("expected the original and the clone to take the same steps")
+	}
+}
+
+// it should weight the gradients of examples within a batch
+func TestTrainWeighted(t *testing.T) {
+	newTrainer := func(method convnet.TrainerMethod, batchSize int) *convnet.Trainer {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+			{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, rand.New(rand.NewSource(0)))
+
+		opts := convnet.DefaultTrainerOptions
+		opts.Method = method
+		opts.Momentum = 0.9
+		opts.BatchSize = batchSize
+
+		return convnet.NewTrainer(net, opts)
+	}
+	same := func(a, b *convnet.Trainer) bool {
+		pa, pb := a.Net.ParamsAndGrads(), b.Net.ParamsAndGrads()
+		for i := range pa {
+			for j := range pa[i].Params {
+				if math.Abs(pa[i].Params[j]-pb[i].Params[j]) > 1e-12 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	x1, y1 := convnet.NewVol1D([]float64{0.5, -1}), convnet.LossData{Dim: 0, Val: 1}
+	x2, y2 := convnet.NewVol1D([]float64{-2, 0.25}), convnet.LossData{Dim: 0, Val: -1}
+
+	// a weight of 1 is the same as Train
+	a, b := newTrainer(convnet.MethodAdam, 2), newTrainer(convnet.MethodAdam, 2)
+	for i := 0; i < 6; i++ {
+		ra := a.Train(x1, y1)
+		rb := b.TrainWeighted(x1, y1, 1)
+		if ra != rb {
+			t.Errorf("expected the same result from Train and TrainWeighted, but got %+v and %+v", ra, rb)
+		}
+		a.Train(x2, y2)
+		b.Train(x2, y2)
+	}
+	if !same(a, b) {
+		t.Error("expected a weight of 1 to train the same as Train")
+	}
+
+	// an example with a weight of 0 does not count. (adam's bias
+	// correction depends on the number of examples, so use sgd here.)
+	a, b = newTrainer(convnet.MethodSGD, 1), newTrainer(convnet.MethodSGD, 2)
+	for i := 0; i < 6; i++ {
+		ra := a.Train(x1, y1)
+		if rb := b.TrainWeighted(x1, y1, 3); math.Abs(rb.CostLoss-3*ra.CostLoss) > 1e-9 {
+			t.Errorf("expected a weighted cost loss of %g, but got %g", 3*ra.CostLoss, rb.CostLoss)
+		}
+		b.TrainWeighted(x2, y2, 0)
+	}
+	if !same(a, b) {
+		t.Error("expected the weighted mean of the gradients to ignore an example with no weight")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a negative weight")
+		}
+	}()
+	a.TrainWeighted(x1, y1, -1)
+}
+
+// it should reinitialize the weights of a net the way MakeLayers did
+func TestColdStart(t *testing.T) {
+	defs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 16, Stride: 1, Pad: 1, Activation: convnet.LayerRelu, InitMethod: convnet.InitKaiming},
+		{Type: convnet.LayerFC, NumNeurons: 32, Activation: convnet.LayerTanh, InitMethod: convnet.InitXavier},
+		{Type: convnet.LayerSoftmax, NumClasses: 4},
+	}
+
+	net := &convnet.Net{}
+	net.MakeLayers(defs, rand.New(rand.NewSource(0)))
+
+	weights := func() [][]float64 {
+		var w [][]float64
+		for _, pg := range net.ParamsAndGrads() {
+			w = append(w, append([]float64(nil), pg.Params...))
+		}
+		return w
+	}
+	std := func(w []float64) float64 {
+		sum := 0.0
+		for _, x := range w {
+			sum += x * x
+		}
+		return math.Sqrt(sum / float64(len(w)))
+	}
+
+	initial := weights()
+
+	trainer := convnet.NewTrainer(net, convnet.TrainerOptions{LearningRate: 0.1, BatchSize: 1})
+	x := convnet.NewVol(8, 8, 3, 1.0)
+	for i := 0; i < 5; i++ {
+		trainer.Train(x, convnet.LossData{Dim: 2})
+	}
+	layers := len(net.Layers)
+
+	// the same random numbers give the same weights as MakeLayers
+	net.ColdStart(rand.New(rand.NewSource(0)))
+	if len(net.Layers) != layers {
+		t.Fatalf("expected %d layers, but there are %d", layers, len(net.Layers))
+	}
+	if !reflect.DeepEqual(weights(), initial) {
+		t.Error("expected ColdStart to draw the same weights as MakeLayers from the same random numbers")
+	}
+
+	// other random numbers give other weights from the same distributions
+	net.ColdStart(rand.New(rand.NewSource(1)))
+	after := weights()
+	// the first conv filter is kaiming with a fan-in of 27, and the first
+	// fc filter (after the 16 conv filters and their biases) is xavier
+	// with a fan-in of 1024 and a fan-out of 32
+	for _, c := range []struct {
+		i   int
+		std float64
+	}{{0, math.Sqrt(2.0 / 27)}, {17, math.Sqrt(2.0 / (1024 + 32))}} {
+		if reflect.DeepEqual(after[c.i], initial[c.i]) {
+			t.Errorf("expected parameter group %d to change", c.i)
+		}
+		if got := std(after[c.i]); math.Abs(got-c.std) > c.std/2 {
+			t.Errorf("expected parameter group %d to have a standard deviation near %g, but it has %g", c.i, c.std, got)
+		}
+	}
+
+	// a net loaded from JSON knows how its layers were made, including
+	// the bias the relu layer after the conv layer wants
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net = &convnet.Net{}
+	if err := json.Unmarshal(b, net); err != nil {
+		t.Fatal(err)
+	}
+	net.ColdStart(rand.New(rand.NewSource(0)))
+	if !reflect.DeepEqual(weights(), initial) {
+		t.Error("expected ColdStart to draw the same weights for a net loaded from JSON")
+	}
+
+	// every other kind of layer with parameters, loaded from JSON
+	for name, defs := range map[string][]convnet.LayerDef{
+		"deformconv": {
+			{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+			{Type: convnet.LayerDeformConv, Sx: 3, Filters: 2, Pad: 1, InitMethod: convnet.InitXavier},
+			{Type: convnet.LayerBatchNorm, Momentum: 0.5},
+			{Type: convnet.LayerFPN, Filters: 3, BiasPref: 0.2},
+			{Type: convnet.LayerSoftmax, NumClasses: 2},
+		},
+		"causalconv": {
+			{Type: convnet.LayerInput, OutSx: 8, OutSy: 1, OutDepth: 2},
+			{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 2, Filters: 3, InitMethod: convnet.InitKaiming},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		},
+		"embedding": {
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 1},
+			{Type: convnet.LayerEmbedding, NumEmbeddings: 5, EmbeddingDim: 4, InitMethod: convnet.InitKaiming},
+			{Type: convnet.LayerSVM, NumClasses: 3},
+		},
+	} {
+		net = &convnet.Net{}
+		net.MakeLayers(defs, rand.New(rand.NewSource(0)))
+		initial = weights()
+
+		b, err := json.Marshal(net)
+		if err != nil {
+			t.Fatal(err)
+		}
+		net = &convnet.Net{}
+		if err := json.Unmarshal(b, net); err != nil {
+			t.Fatal(err)
+		}
+		for _, pg := range net.ParamsAndGrads() {
+			for i := range pg.Params {
+				pg.Params[i] = 0
+			}
+		}
+
+		net.ColdStart(rand.New(rand.NewSource(0)))
+		if !reflect.DeepEqual(weights(), initial) {
+			t.Errorf("%s: expected ColdStart to draw the same weights for a net loaded from JSON", name)
+		}
+	}
+}
+
+// it should encode layer types by name
+func TestLayerTypeJSON(t *testing.T) {
+	def := convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: 3, Activation: convnet.LayerRelu}
+
+	b, err := json.Marshal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["type"] != "fc" || fields["activation"] != "relu" {
+		t.Errorf("expected type fc and activation relu, but got %v and %v", fields["type"], fields["activation"])
+	}
+
+	var decoded convnet.LayerDef
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != def.Type || decoded.Activation != def.Activation || decoded.NumNeurons != def.NumNeurons {
+		t.Errorf("expected %+v to survive JSON, but got %+v", def, decoded)
+	}
+
+	// no activation
+	b, _ = json.Marshal(convnet.LayerDef{Type: convnet.LayerSoftmax})
+	decoded = convnet.LayerDef{Activation: convnet.LayerTanh}
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.Activation != 0 {
+		t.Errorf("expected no activation, but got %v (%v)", decoded.Activation, err)
+	}
+
+	for _, c := range []struct {
+		in   string
+		want convnet.LayerType
+	}{
+		{`"causalconv"`, convnet.LayerCausalConv},
+		{`"silu"`, convnet.LayerSwish},
+		{`6`, convnet.LayerConv}, // written before layer types had names
+	} {
+		var lt convnet.LayerType
+		if err := json.Unmarshal([]byte(c.in), &lt); err != nil || lt != c.want {
+			t.Errorf("%s: expected %v, but got %v (%v)", c.in, c.want, lt, err)
+		}
+	}
+
+	var lt convnet.LayerType
+	if err := json.Unmarshal([]byte(`"nonsense"`), &lt); err == nil {
+		t.Error("expected an error for an unknown layer type")
+	}
+	// every layer type, up to the first one that can't be encoded, should
+	// decode to itself
+	last := convnet.LayerInput
+	for ; ; last++ {
+		b, err := json.Marshal(last)
+		if err != nil {
+			break
+		}
+
+		var lt convnet.LayerType
+		if err := json.Unmarshal(b, &lt); err != nil || lt != last {
+			t.Errorf("%v: expected the same layer type after a round trip, but got %v (%v)", last, lt, err)
+		}
+	}
+	if last <= convnet.LayerCausalConv {
+		t.Errorf("expected every layer type to be encoded, but %v was not", last)
+	}
+
+	if _, err := json.Marshal(convnet.LayerType(100)); err == nil {
+		t.Error("expected an error for encoding an unknown layer type")
+	}
+}
+
+// every layer type should survive a JSON round trip with its weights
+func TestJSONRoundTripAllLayers(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	nets := []struct {
+		defs []convnet.LayerDef
+		x    *convnet.Vol
+	}{
+		{[]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+			{Type: convnet.LayerConv, Sx: 3, Filters: 3, Pad: 1, Activation: convnet.LayerRelu},
+			{Type: convnet.LayerLRN, K: 1, N: 3, Alpha: 0.1, Beta: 0.75},
+			{Type: convnet.LayerPool, Sx: 2},
+			{Type: convnet.LayerDeformConv, Sx: 3, Filters: 2, Pad: 1},
+			{Type: convnet.LayerBatchNorm},
+			{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerSigmoid, DropProb: 0.5},
+			{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerMaxout, GroupSize: 2},
+			{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+			{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerSwish},
+			{Type: convnet.LayerMixout, MixProb: 0.3},
+			{Type: convnet.LayerSoftmax, NumClasses: 3},
+		}, convnet.NewVolRand(6, 6, 2, r)},
+		{[]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 2},
+			{Type: convnet.LayerLpPool, Sx: 2, P: 2},
+			{Type: convnet.LayerSPP, BinSizes: []int{1, 2}},
+			{Type: convnet.LayerSVM, NumClasses: 2},
+		}, convnet.NewVolRand(4, 4, 2, r)},
+		{[]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 1},
+			{Type: convnet.LayerEmbedding, NumEmbeddings: 5, EmbeddingDim: 4},
+			{Type: convnet.LayerRegression, NumNeurons: 2},
+		}, convnet.NewVol1D([]float64{3})},
+		{[]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 6, OutSy: 2, OutDepth: 2},
+			{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 2, Filters: 3},
+			{Type: convnet.LayerFPN, Filters: 2},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, convnet.NewVolRand(6, 2, 2, r)},
+	}
+
+	covered := make(map[convnet.LayerType]bool)
+
+	for i, c := range nets {
+		net := &convnet.Net{}
+		net.MakeLayers(c.defs, r)
+		if err := net.Validate(); err != nil {
+			t.Fatalf("net %d: %v", i, err)
+		}
+
+		// move the weights and statistics away from their defaults
+		trainer := convnet.NewTrainer(net, convnet.DefaultTrainerOptions)
+		for j := 0; j < 3; j++ {
+			trainer.Train(c.x, convnet.LossData{Dim: 0, Val: 1})
+		}
+
+		for _, info := range net.Describe() {
+			covered[info.Type] = true
+		}
+
+		b, err := json.Marshal(net)
+		if err != nil {
+			t.Fatalf("net %d: %v", i, err)
+		}
+
+		loaded := &convnet.Net{}
+		if err := json.Unmarshal(b, loaded); err != nil {
+			t.Fatalf("net %d: %v", i, err)
+		}
+
+		again, err := json.Marshal(loaded)
+		if err != nil {
+			t.Fatalf("net %d: %v", i, err)
+		}
+		if string(again) != string(b) {
+			t.Errorf("net %d: expected the same JSON after a round trip", i)
+		}
+
+		if !loaded.Forward(c.x, false).Equal(net.Forward(c.x, false)) {
+			t.Errorf("net %d: expected the same output after a round trip", i)
+		}
+	}
+
+	for lt := convnet.LayerInput; lt <= convnet.LayerCausalConv; lt++ {
+		if !covered[lt] {
+			t.Errorf("layer type %v is not covered", lt)
+		}
+	}
+}
+
+// it should beat sgd on a badly scaled linear regression
+func TestLion(t *testing.T) {
+	scales := []float64{1, 0.3, 0.1, 0.03}
+	weights := []float64{1, -2, 3, -1}
+
+	r := rand.New(rand.NewSource(0))
+	xs := make([]*convnet.Vol, 50)
+	ys := make([]float64, len(xs))
+	for i := range xs {
+		x := make([]float64, len(scales))
+		for k, s := range scales {
+			x[k] = (r.Float64()*2 - 1) * s
+			ys[i] += weights[k] * x[k]
+		}
+		xs[i] = convnet.NewVol1D(x)
+	}
+
+	// the number of epochs to get the mean loss below 1e-3, or -1
+	epochsToConverge := func(opts convnet.TrainerOptions) int {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: len(scales)},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, rand.New(rand.NewSource(1)))
+		trainer := convnet.NewTrainer(net, opts)
+
+		for epoch := 1; epoch <= 1000; epoch++ {
+			for i, x := range xs {
+				trainer.Train(x, convnet.LossData{Dim: 0, Val: ys[i]})
+			}
+
+			loss := 0.0
+			for i, x := range xs {
+				loss += net.CostLoss(x, convnet.LossData{Dim: 0, Val: ys[i]})
+			}
+			if loss/float64(len(xs)) < 1e-3 {
+				return epoch
+			}
+		}
+
+		return -1
+	}
+
+	sgd := convnet.DefaultTrainerOptions
+	sgd.Momentum = 0
+	sgd.BatchSize = 5
+
+	lion := sgd
+	lion.Method = convnet.MethodLion
+	lion.LearningRate = 0.01
+	lion.Beta1 = 0.9
+	lion.Beta2 = 0.99
+
+	// about the fastest sgd gets before it diverges
+	sgd.LearningRate = 1
+
+	sgdEpochs, lionEpochs := epochsToConverge(sgd), epochsToConverge(lion)
+	t.Logf("sgd: %d epochs, lion: %d epochs", sgdEpochs, lionEpochs)
+
+	if lionEpochs == -1 {
+		t.Fatal("expected lion to converge")
+	}
+	if sgdEpochs != -1 && lionEpochs >= sgdEpochs {
+		t.Errorf("expected lion to converge faster than sgd, but it took %d epochs to sgd's %d", lionEpochs, sgdEpochs)
+	}
+}
+
+// it should never move a parameter further than the clipped gradient
+func TestGradClipValue(t *testing.T) {
+	const clip = 0.01
+
+	// with plain sgd and a learning rate of 1, each parameter moves by
+	// exactly its (clipped) gradient
+	largestStep := func(clipValue float64) (largest float64, within bool) {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 4},
+			{Type: convnet.LayerFC, NumNeurons: 8, Activation: convnet.LayerTanh},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, rand.New(rand.NewSource(0)))
+
+		var before [][]float64
+		for _, pg := range net.ParamsAndGrads() {
+			before = append(before, append([]float64(nil), pg.Params...))
+		}
+
+		opts := convnet.DefaultTrainerOptions
+		opts.LearningRate = 1
+		opts.Momentum = 0
+		opts.L2Decay = 0.1
+		opts.GradClipValue = clipValue
+		trainer := convnet.NewTrainer(net, opts)
+		trainer.Train(convnet.NewVol1D([]float64{1, -2, 3, -4}), convnet.LossData{Dim: 0, Val: 100})
+
+		within = true
+		for i, pg := range net.ParamsAndGrads() {
+			for j, p := range pg.Params {
+				step := math.Abs(p - before[i][j])
+				largest = math.Max(largest, step)
+				if step > clipValue+1e-12 {
+					within = false
+				}
+			}
+		}
+
+		return largest, within
+	}
+
+	if largest, _ := largestStep(0); largest <= clip {
+		t.Fatalf("expected an unclipped step larger than %g, but the largest was %g", clip, largest)
+	}
+
+	largest, within := largestStep(clip)
+	if !within {
+		t.Errorf("expected every step to be at most %g, but the largest was %g", clip, largest)
+	}
+	if math.Abs(largest-clip) > 1e-12 {
+		t.Errorf("expected the largest step to be clipped to %g, but it was %g", clip, largest)
+	}
+}
+
+// it should pool windows by their Lp norm
+func TestLpPool(t *testing.T) {
+	// one 2x2 window: 3, -4, 0, 1
+	x := convnet.NewVol(2, 2, 1, 0.0)
+	x.Set(0, 0, 0, 3)
+	x.Set(1, 0, 0, -4)
+	x.Set(0, 1, 0, 0)
+	x.Set(1, 1, 0, 1)
+
+	pool := func(p float64) float64 {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 2, OutSy: 2, OutDepth: 1},
+			{Type: convnet.LayerLpPool, Sx: 2, P: p},
+			{Type: convnet.LayerRegression, NumNeurons: 1},
+		}, rand.New(rand.NewSource(0)))
+
+		return net.Layers[1].Forward(x, false).W[0]
+	}
+
+	if out := pool(1); math.Abs(out-8) > 1e-12 {
+		t.Errorf("expected p=1 to sum absolute values to 8, but got %g", out)
+	}
+	if out := pool(2); math.Abs(out-math.Sqrt(26)) > 1e-12 {
+		t.Errorf("expected p=2 to give %g, but got %g", math.Sqrt(26), out)
+	}
+	if out := pool(0); math.Abs(out-math.Sqrt(26)) > 1e-12 {
+		t.Errorf("expected p to default to 2, but got %g", out)
+	}
+	if out := pool(math.Inf(1)); out != 4 {
+		t.Errorf("expected p=inf to give the largest absolute value 4, but got %g", out)
+	}
+
+	prev := math.Inf(1)
+	for _, p := range []float64{4, 16, 64, 256} {
+		out := pool(p)
+		if out > prev || out < 4 {
+			t.Errorf("expected p=%g to be between 4 and %g, but got %g", p, prev, out)
+		}
+		prev = out
+	}
+	if prev-4 > 0.01 {
+		t.Errorf("expected large p to converge to the max, but got %g", prev)
+	}
+
+	// gradients
+	for _, p := range []float64{1, 2, 3.5, math.Inf(1)} {
+		net := &convnet.Net{}
+		net.MakeLayers([]convnet.LayerDef{
+			{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+			{Type: convnet.LayerConv, Sx: 3, Filters: 3, Pad: 1},
+			{Type: convnet.LayerLpPool, Sx: 3, Stride: 2, Pad: 1, P: p},
+			{Type: convnet.LayerSoftmax, NumClasses: 3},
+		}, rand.New(rand.NewSource(1)))
+
+		if err := net.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		in := convnet.NewVolRand(6, 6, 2, rand.New(rand.NewSource(2)))
+		report := gradcheck.Check(net, in, convnet.LossData{Dim: 1}, gradcheck.DefaultOptions)
+		if e := report.Max(); e > 1e-3 {
+			t.Errorf("p=%g: expected gradients to match, but worst relative error is %g", p, e)
+		}
+
+		b, err := json.Marshal(net)
+		if err != nil {
+			t.Fatalf("p=%g: %v", p, err)
+		}
+		var loaded convnet.Net
+		if err := json.Unmarshal(b, &loaded); err != nil {
+			t.Fatalf("p=%g: %v", p, err)
+		}
+		if lp := loaded.Layers[2].(*convnet.LpPoolLayer).P(); lp != p {
+			t.Errorf("expected p=%g to survive JSON, but got %g", p, lp)
+		}
+		if !net.Forward(in, false).ApproxEqual(loaded.Forward(in, false), 1e-12) {
+			t.Errorf("p=%g: expected the loaded net to give the same output", p)
+		}
+	}
+
+	err := convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 4, OutSy: 4, OutDepth: 1},
+		{Type: convnet.LayerLpPool, Sx: 2, P: 0.5},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	})
+	if err == nil {
+		t.Error("expected an error for p less than 1")
+	}
+}
+
+// it should only look at the present and the past, dilation steps apart
+func TestCausalConv(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 12, OutSy: 2, OutDepth: 2},
+		{Type: convnet.LayerCausalConv, Sx: 3, Dilation: 2, Filters: 3, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerCausalConv, Sx: 2, Dilation: 4, Filters: 2},
+		{Type: convnet.LayerRegression, NumNeurons: 2},
+	}, rand.New(rand.NewSource(0)))
+
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := convnet.GetCausalConvLayers(net)[0]
+	if c.Dilation() != 2 || c.Pad() != 4 {
+		t.Errorf("expected dilation 2 and pad 4, but got %d and %d", c.Dilation(), c.Pad())
+	}
+	if c.OutSx() != 12 || c.OutSy() != 2 || c.OutDepth() != 3 {
+		t.Errorf("expected output 12x2x3, but got %dx%dx%d", c.OutSx(), c.OutSy(), c.OutDepth())
+	}
+
+	x := convnet.NewVolRand(12, 2, 2, rand.New(rand.NewSource(1)))
+	before := net.Layers[1].Forward(x, false).Clone()
+
+	// changing time step 5 of row 1 should only change row 1 at times 5,
+	// 7, and 9
+	x2 := x.Clone()
+	x2.Set(5, 1, 0, x2.Get(5, 1, 0)+1)
+	after := net.Layers[1].Forward(x2, false)
+	for y := 0; y < 2; y++ {
+		for tt := 0; tt < 12; tt++ {
+			changed := before.Get(tt, y, 0) != after.Get(tt, y, 0)
+			if want := y == 1 && (tt == 5 || tt == 7 || tt == 9); changed != want {
+				t.Errorf("at time %d of row %d: expected changed=%v, but got %v", tt, y, want, changed)
+			}
+		}
+	}
+
+	report := gradcheck.Check(net, x, convnet.LossData{Dim: 1, Val: 0.5}, gradcheck.DefaultOptions)
+	if e := report.Max(); e > 1e-3 {
+		t.Errorf("expected gradients to match, but worst relative error is %g", e)
+	}
+
+	b, err := json.Marshal(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded convnet.Net
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d := convnet.GetCausalConvLayers(&loaded)[1].Dilation(); d != 4 {
+		t.Errorf("expected dilation 4 to survive JSON, but got %d", d)
+	}
+	if !net.Forward(x, false).ApproxEqual(loaded.Forward(x, false), 1e-12) {
+		t.Error("expected the loaded net to give the same output")
+	}
+
+	err = convnet.ValidateDefs([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 1, OutDepth: 1},
+		{Type: convnet.LayerCausalConv, Sx: 2, Dilation: -1, Filters: 1},
+		{Type: convnet.LayerRegression, NumNeurons: 1},
+	})
+	if err == nil {
+		t.Error("expected an error for a negative dilation")
+	}
+}
+
+// it should agree with Prediction and CostLoss
+func TestScore(t *testing.T) {
+	net, _, r := createTestNet()
+
+	before, _ := json.Marshal(net)
+
+	for i := 0; i < 20; i++ {
+		x := convnet.NewVol1D([]float64{r.Float64()*2 - 1, r.Float64()*2 - 1})
+		y := r.Intn(3)
+
+		prediction, loss := net.Score(x, y)
+
+		net.Forward(x, false)
+		if expected := net.Prediction(); prediction != expected {
+			t.Errorf("expected prediction %d, but got %d", expected, prediction)
+		}
+		if expected := net.CostLoss(x, convnet.LossData{Dim: y}); loss != expected {
+			t.Errorf("expected loss %g, but got %g", expected, loss)
+		}
+	}
+
+	if after, _ := json.Marshal(net); string(before) != string(after) {
+		t.Error("expected Score to leave the weights alone")
+	}
+	for _, pg := range net.ParamsAndGrads() {
+		for _, g := range pg.Grads {
+			if g != 0 {
+				t.Fatal("expected Score to leave the gradients alone")
+			}
+		}
+	}
+}
+
+// it should call hooks for the registered layers during each pass
+func TestHooks(t *testing.T) {
+	net, trainer, _ := createTestNet()
+	// layers: input, fc, tanh, fc, tanh, fc, softmax
+
+	var forwards, backwards []int
+	hookForward := func(i int) convnet.ForwardHook {
+		return func(l convnet.Layer, input, output *convnet.Vol) {
+			forwards = append(forwards, i)
+
+			if l != net.Layers[i] {
+				t.Errorf("forward hook %d: got the wrong layer", i)
+			}
+			if output != l.Output() {
+				t.Errorf("forward hook %d: expected the layer's output", i)
+			}
+			if input != net.Layers[i-1].Output() {
+				t.Errorf("forward hook %d: expected the previous layer's output as input", i)
+			}
+		}
+	}
+	hookBackward := func(i int) convnet.BackwardHook {
+		return func(l convnet.Layer) {
+			backwards = append(backwards, i)
+
+			if l != net.Layers[i] {
+				t.Errorf("backward hook %d: got the wrong layer", i)
+			}
+		}
+	}
+
+	for _, i := range []int{4, 2, 2} {
+		if err := net.RegisterForwardHook(i, hookForward(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range []int{1, 6} {
+		if err := net.RegisterBackwardHook(i, hookBackward(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := net.RegisterForwardHook(len(net.Layers), hookForward(0)); err == nil {
+		t.Error("expected an error for a layer index out of range")
+	}
+	if err := net.RegisterBackwardHook(-1, hookBackward(0)); err == nil {
+		t.Error("expected an error for a negative layer index")
+	}
+
+	x := convnet.NewVol1D([]float64{0.5, -1.3})
+	trainer.Train(x, convnet.LossData{Dim: 1})
+
+	if fmt.Sprint(forwards) != "[2 2 4]" {
+		t.Errorf("expected forward hooks [2 2 4], but got %v", forwards)
+	}
+	if fmt.Sprint(backwards) != "[6 1]" {
+		t.Errorf("expected backward hooks [6 1], but got %v", backwards)
+	}
+
+	net.RemoveAllHooks()
+	forwards, backwards = nil, nil
+	trainer.Train(x, convnet.LossData{Dim: 1})
+
+	if len(forwards) != 0 || len(backwards) != 0 {
+		t.Errorf("expected no hooks after RemoveAllHooks, but got %v and %v", forwards, backwards)
+	}
+}
+
+// it should make actions with a positive advantage more likely, and ones
+// with a negative advantage less likely
+func TestPolicyGradientTrain(t *testing.T) {
+	x := convnet.NewVol1D([]float64{0.3, -0.7})
+
+	for _, advantage := range []float64{2, -2, 0} {
+		net, trainer, _ := createTestNet()
+		trainer.LearningRate = 0.1
+
+		before := net.Forward(x, false).W[1]
+		result := trainer.PolicyGradientTrain(x, 1, advantage)
+		after := net.Forward(x, false).W[1]
+
+		if want := -advantage * math.Log(before); math.Abs(result.CostLoss-want) > 1e-12 {
+			t.Errorf("advantage %g: expected cost loss %g, but got %g", advantage, want, result.CostLoss)
+		}
+
+		switch {
+		case advantage > 0 && after <= before:
+			t.Errorf("advantage %g: expected the probability %g to go up, but it is %g", advantage, before, after)
+		case advantage < 0 && after >= before:
+			t.Errorf("advantage %g: expected the probability %g to go down, but it is %g", advantage, before, after)
+		case advantage == 0 && after != before:
+			t.Errorf("advantage %g: expected the probability %g to stay the same, but it is %g", advantage, before, after)
+		}
+	}
+}
+
+// it should backpropagate through the sampling positions as well as the
+// sampled values of a deformable convolution
+func TestDeformConv(t *testing.T) {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 5, OutSy: 5, OutDepth: 2},
+		{Type: convnet.LayerDeformConv, Sx: 3, Filters: 2, Pad: 1},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	deform, ok := net.Layers[1].(*convnet.DeformConvLayer)
+	if !ok {
+		t.Fatalf("expected a deformable convolution layer, but got %T", net.Layers[1])
+	}
+
+	x := convnet.NewVolRand(5, 5, 2, rand.New(rand.NewSource(1)))
+	before := deform.Forward(x, false).Clone()
+
+	// the offset layer starts at zero, so give it some weights of its own
+	// to move the samples off the grid, by fractions of a pixel. Its
+	// parameters come after the 2 filters and the biases.
+	r := rand.New(rand.NewSource(2))
+	for _, pg := range deform.ParamsAndGrads()[2+1:] {
+		for i := range pg.Params {
+			pg.Params[i] = r.Float64()*0.4 - 0.2
+		}
+	}
+
+	if deform.Forward(x, false).ApproxEqual(before, 1e-6) {
+		t.Fatal("expected nonzero offsets to change the output")
+	}
+
+	report := gradcheck.Check(net, x, convnet.LossData{Dim: 1}, gradcheck.DefaultOptions)
+	if report.Input > 1e-4 {
+		t.Errorf("expected the input gradient to match, but its relative error is %g", report.Input)
+	}
+	if report.Layers[1] > 1e-4 {
+		t.Errorf("expected the filter and offset gradients to match, but the worst relative error is %g", report.Layers[1])
+	}
+}
+
+// it should pool any input size to the same depth, and send each gradient
+// back only to the largest input of its bin
+func TestSPP(t *testing.T) {
+	binSizes := []int{1, 2, 3}
+	bins := 1 + 2*2 + 3*3
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 6, OutSy: 6, OutDepth: 2},
+		{Type: convnet.LayerSPP, BinSizes: binSizes},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	spp, ok := net.Layers[1].(*convnet.SPPLayer)
+	if !ok {
+		t.Fatalf("expected an spp layer, but got %T", net.Layers[1])
+	}
+	if spp.OutSx() != 1 || spp.OutSy() != 1 || spp.OutDepth() != 2*bins {
+		t.Errorf("expected output size 1x1x%d, but got %dx%dx%d", 2*bins, spp.OutSx(), spp.OutSy(), spp.OutDepth())
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, size := range [][2]int{{6, 6}, {7, 4}} {
+		// distinct values, so every bin has one largest input
+		x := convnet.NewVol(size[0], size[1], 2, 0.0)
+		for i, j := range r.Perm(len(x.W)) {
+			x.W[i] = float64(j)
+		}
+
+		out := spp.Forward(x, false)
+		if len(out.W) != 2*bins {
+			t.Fatalf("%dx%d: expected %d outputs, but got %d", size[0], size[1], 2*bins, len(out.W))
+		}
+
+		// bins are rounded outwards, and each output gets a gradient of
+		// its own that should end up at the input it came from
+		want := make([]float64, len(x.W))
+		n := 0
+		for _, s := range binSizes {
+			for by := 0; by < s; by++ {
+				for bx := 0; bx < s; bx++ {
+					for d := 0; d < 2; d++ {
+						max, maxX, maxY := math.Inf(-1), 0, 0
+						for y := by * size[1] / s; y < ((by+1)*size[1]+s-1)/s; y++ {
+							for xx := bx * size[0] / s; xx < ((bx+1)*size[0]+s-1)/s; xx++ {
+								if v := x.Get(xx, y, d); v > max {
+									max, maxX, maxY = v, xx, y
+								}
+							}
+						}
+
+						if out.W[n] != max {
+							t.Errorf("%dx%d: expected output %d (bin %d,%d of %dx%d, depth %d) to be %g, but got %g", size[0], size[1], n, bx, by, s, s, d, max, out.W[n])
+						}
+
+						out.Dw[n] = float64(n + 1)
+						want[(maxY*size[0]+maxX)*2+d] += out.Dw[n]
+						n++
+					}
+				}
+			}
+		}
+
+		spp.Backward()
+		if !reflect.DeepEqual(x.Dw, want) {
+			t.Errorf("%dx%d: expected input gradient %v, but got %v", size[0], size[1], want, x.Dw)
+		}
+	}
+}
+
+// it should add noise of the requested size to a copy of a volume
+func TestVolNoise(t *testing.T) {
+	v := convnet.NewVolRand(10, 10, 10, rand.New(rand.NewSource(0)))
+	orig := v.Clone()
+
+	for _, c := range []struct {
+		name  string
+		noisy *convnet.Vol
+		std   float64 // of the noise
+	}{
+		{"uniform", v.UniformNoise(0.5, rand.New(rand.NewSource(1))), 0.5 / math.Sqrt(3)},
+		{"gaussian", v.GaussianNoise(0.2, rand.New(rand.NewSource(1))), 0.2},
+	} {
+		if !v.Equal(orig) {
+			t.Fatalf("%s: expected the original volume to be unchanged", c.name)
+		}
+		if c.noisy.Sx != v.Sx || c.noisy.Sy != v.Sy || c.noisy.Depth != v.Depth || len(c.noisy.W) != len(v.W) {
+			t.Errorf("%s: expected a %dx%dx%d volume, but got %dx%dx%d", c.name, v.Sx, v.Sy, v.Depth, c.noisy.Sx, c.noisy.Sy, c.noisy.Depth)
+			continue
+		}
+
+		sum, sumSq := 0.0, 0.0
+		for i := range v.W {
+			d := c.noisy.W[i] - v.W[i]
+			if c.name == "uniform" && !(d > -0.5 && d < 0.5) {
+				t.Errorf("%s: expected noise within (-0.5, 0.5), but got %g", c.name, d)
+			}
+
+			sum += d
+			sumSq += d * d
+		}
+
+		n := float64(len(v.W))
+		mean := sum / n
+		std := math.Sqrt(sumSq/n - mean*mean)
+		if math.Abs(mean) > 3*c.std/math.Sqrt(n) {
+			t.Errorf("%s: expected noise with a mean near 0, but got %g", c.name, mean)
+		}
+		if math.Abs(std-c.std) > c.std/10 {
+			t.Errorf("%s: expected noise with a standard deviation near %g, but got %g", c.name, c.std, std)
+		}
+	}
+
+	// the same seed gives the same noise
+	if !v.GaussianNoise(0.2, rand.New(rand.NewSource(1))).Equal(v.GaussianNoise(0.2, rand.New(rand.NewSource(1)))) {
+		t.Error("expected the same noise from the same seed")
+	}
+}
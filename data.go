@@ -0,0 +1,22 @@
+package convnet
+
+// DataLoader provides indexed access to a set of examples.
+type DataLoader interface {
+	// Len returns the number of examples.
+	Len() int
+	// Example returns the input and expected output of example i,
+	// where 0 <= i < Len().
+	Example(i int) (*Vol, LossData)
+}
+
+// SliceLoader is a DataLoader for examples that are already in memory.
+// X and Y must have the same length.
+type SliceLoader struct {
+	X []*Vol
+	Y []LossData
+}
+
+func (s *SliceLoader) Len() int { return len(s.X) }
+func (s *SliceLoader) Example(i int) (*Vol, LossData) {
+	return s.X[i], s.Y[i]
+}
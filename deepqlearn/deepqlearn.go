@@ -1,402 +1,1628 @@
-package deepqlearn
-
-import (
-	"errors"
-	"fmt"
-	"math"
-	"math/rand"
-
-	"github.com/BenLubar/convnet"
-	"github.com/BenLubar/convnet/cnnutil"
-)
-
-// An agent is in state0 and does action0
-// environment then assigns reward0 and provides new state, state1
-// Experience nodes store all this information, which is used in the
-// Q-learning update step
-type Experience struct {
-	State0  []float64
-	Action0 int
-	Reward0 float64
-	State1  []float64
-}
-
-type BrainOptions struct {
-	// in number of time steps, of temporal memory
-	// the ACTUAL input to the net will be (x,a) temporal_window times, and followed by current x
-	// so to have no information from previous time step going into value function, set to 0.
-	TemporalWindow int
-	// size of experience replay memory
-	ExperienceSize int
-	// number of examples in experience replay memory before we begin learning
-	StartLearnThreshold int
-	// gamma is a crucial parameter that controls how much plan-ahead the agent does. In [0,1]
-	Gamma float64
-	// number of steps we will learn for
-	LearningStepsTotal int
-	// how many steps of the above to perform only random actions (in the beginning)?
-	LearningStepsBurnin int
-	// what epsilon value do we bottom out on? 0.0 => purely deterministic policy at end
-	EpsilonMin float64
-	// what epsilon to use at test time? (i.e. when learning is disabled)
-	EpsilonTestTime float64
-	// advanced feature. Sometimes a random action should be biased towards some values
-	// for example in flappy bird, we may want to choose to not flap more often
-	// this better sum to 1 by the way, and be of length this.num_actions
-	RandomActionDistribution []float64
-
-	LayerDefs        []convnet.LayerDef
-	HiddenLayerSizes []int
-	Rand             *rand.Rand
-
-	TDTrainerOptions convnet.TrainerOptions
-}
-
-var DefaultBrainOptions = BrainOptions{
-	TemporalWindow:           1,
-	ExperienceSize:           30000,
-	StartLearnThreshold:      int(math.Floor(math.Min(30000*0.1, 1000))),
-	Gamma:                    0.8,
-	LearningStepsTotal:       100000,
-	LearningStepsBurnin:      3000,
-	EpsilonMin:               0.05,
-	EpsilonTestTime:          0.01,
-	RandomActionDistribution: nil,
-	TDTrainerOptions: convnet.TrainerOptions{
-		LearningRate: 0.01,
-		Momentum:     0.0,
-		BatchSize:    64,
-		L2Decay:      0.01,
-	},
-}
-
-// A Brain object does all the magic.
-// over time it receives some inputs and some rewards
-// and its job is to set the outputs to maximize the expected reward
-type Brain struct {
-	TemporalWindow           int
-	ExperienceSize           int
-	StartLearnThreshold      int
-	Gamma                    float64
-	LearningStepsTotal       int
-	LearningStepsBurnin      int
-	EpsilonMin               float64
-	EpsilonTestTime          float64
-	RandomActionDistribution []float64
-
-	NetInputs  int
-	NumStates  int
-	NumActions int
-	WindowSize int
-
-	StateWindow  [][]float64
-	ActionWindow []int
-	RewardWindow []float64
-	NetWindow    [][]float64
-
-	Rand       *rand.Rand
-	ValueNet   convnet.Net
-	TDTrainer  *convnet.Trainer
-	Experience []Experience
-
-	Age                 int
-	ForwardPasses       int
-	Epsilon             float64
-	LatestReward        float64
-	LastInputArray      []float64
-	AverageRewardWindow *cnnutil.Window
-	AverageLossWindow   *cnnutil.Window
-	Learning            bool
-}
-
-func NewBrain(numStates, numActions int, opt BrainOptions) (*Brain, error) {
-	b := &Brain{
-		TemporalWindow:           opt.TemporalWindow,
-		ExperienceSize:           opt.ExperienceSize,
-		StartLearnThreshold:      opt.StartLearnThreshold,
-		Gamma:                    opt.Gamma,
-		LearningStepsTotal:       opt.LearningStepsTotal,
-		LearningStepsBurnin:      opt.LearningStepsBurnin,
-		EpsilonMin:               opt.EpsilonMin,
-		EpsilonTestTime:          opt.EpsilonTestTime,
-		RandomActionDistribution: opt.RandomActionDistribution,
-	}
-
-	if b.RandomActionDistribution != nil {
-		b.RandomActionDistribution = opt.RandomActionDistribution
-		if len(b.RandomActionDistribution) != numActions {
-			return nil, errors.New("deepqlearn: random_action_distribution should be same length as num_actions")
-		}
-
-		sum := 0.0
-		for _, a := range b.RandomActionDistribution {
-			sum += a
-		}
-
-		if math.Abs(sum-1.0) > 0.0001 {
-			return nil, errors.New("deepqlearn: random_action_distribution should sum to 1!")
-		}
-	}
-
-	// states that go into neural net to predict optimal action look as
-	// x0,a0,x1,a1,x2,a2,...xt
-	// this variable controls the size of that temporal window. Actions are
-	// encoded as 1-of-k hot vectors
-	b.NetInputs = numStates*b.TemporalWindow + numActions*b.TemporalWindow + numStates
-	b.NumStates = numStates
-	b.NumActions = numActions
-
-	b.WindowSize = b.TemporalWindow
-	if b.WindowSize < 2 {
-		// must be at least 2, but if we want more context even more
-		b.WindowSize = 2
-	}
-
-	b.StateWindow = make([][]float64, b.WindowSize)
-	b.ActionWindow = make([]int, b.WindowSize)
-	b.RewardWindow = make([]float64, b.WindowSize)
-	b.NetWindow = make([][]float64, b.WindowSize)
-
-	// create [state -> value of all possible actions] modeling net for the value function
-	layerDefs := opt.LayerDefs
-	if layerDefs != nil {
-		// this is an advanced usage feature, because size of the input to the network, and number of
-		// actions must check out. This is not very pretty Object Oriented programming but I can"t see
-		// a way out of it :(
-
-		if len(layerDefs) < 2 {
-			return nil, errors.New("deepqlearn: must have at least 2 layers")
-		}
-
-		if layerDefs[0].Type != convnet.LayerInput {
-			return nil, errors.New("deepqlearn: first layer must be input layer!")
-		}
-
-		if layerDefs[len(layerDefs)-1].Type != convnet.LayerRegression {
-			return nil, errors.New("deepqlearn: last layer must be input regression!")
-		}
-
-		if layerDefs[0].OutDepth*layerDefs[0].OutSx*layerDefs[0].OutSy != b.NetInputs {
-			return nil, errors.New("deepqlearn: Number of inputs must be num_states * temporal_window + num_actions * temporal_window + num_states!")
-		}
-
-		if layerDefs[len(layerDefs)-1].NumNeurons != b.NumActions {
-			return nil, errors.New("deepqlearn: Number of regression neurons should be num_actions!")
-		}
-	} else {
-		// create a very simple neural net by default
-		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: b.NetInputs})
-
-		for _, hl := range opt.HiddenLayerSizes {
-			// relu by default
-			layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: hl, Activation: convnet.LayerRelu})
-		}
-
-		// value function output
-		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerRegression, NumNeurons: numActions})
-	}
-
-	b.Rand = opt.Rand
-	if b.Rand == nil {
-		b.Rand = rand.New(rand.NewSource(0))
-	}
-
-	b.ValueNet.MakeLayers(layerDefs, b.Rand)
-
-	// and finally we need a Temporal Difference Learning trainer!
-	b.TDTrainer = convnet.NewTrainer(&b.ValueNet, opt.TDTrainerOptions)
-
-	// experience replay
-	b.Experience = make([]Experience, 0, b.ExperienceSize)
-
-	// various housekeeping variables
-	b.Age = 0           // incremented every backward()
-	b.ForwardPasses = 0 // incremented every forward()
-	b.Epsilon = 1.0     // controls exploration exploitation tradeoff. Should be annealed over time
-	b.LatestReward = 0
-	b.LastInputArray = nil
-	b.AverageRewardWindow = cnnutil.NewWindow(1000, 10)
-	b.AverageLossWindow = cnnutil.NewWindow(1000, 10)
-	b.Learning = true
-
-	return b, nil
-}
-
-// a bit of a helper function. It returns a random action
-// we are abstracting this away because in future we may want to
-// do more sophisticated things. For example some actions could be more
-// or less likely at "rest"/default state.
-func (b *Brain) RandomAction() int {
-	if b.RandomActionDistribution == nil {
-		return b.Rand.Intn(b.NumActions)
-	}
-
-	// okay, lets do some fancier sampling:
-	p := b.Rand.Float64()
-	cumprob := 0.0
-
-	for k := 0; k < b.NumActions; k++ {
-		cumprob += b.RandomActionDistribution[k]
-
-		if p < cumprob {
-			return k
-		}
-	}
-
-	// rounding error
-	return b.NumActions - 1
-}
-
-// compute the value of doing any action in this state
-// and return the argmax action and its value
-func (b *Brain) Policy(s []float64) (action int, value float64) {
-	svol := convnet.NewVol(1, 1, b.NetInputs, 0)
-	svol.W = s
-
-	actionValues := b.ValueNet.Forward(svol, false)
-
-	maxval, maxk := actionValues.W[0], 0
-
-	for k := 1; k < b.NumActions; k++ {
-		if actionValues.W[k] > maxval {
-			maxk, maxval = k, actionValues.W[k]
-		}
-	}
-
-	return maxk, maxval
-}
-
-// return s = (x,a,x,a,x,a,xt) state vector.
-// It"s a concatenation of last window_size (x,a) pairs and current state x
-func (b *Brain) NetInput(xt []float64) []float64 {
-	var w []float64
-	w = append(w, xt...) // start with current state
-
-	// and now go backwards and append states and actions from history temporal_window times
-	for k := 0; k < b.TemporalWindow; k++ {
-		// state
-		w = append(w, b.StateWindow[b.WindowSize-1-k]...)
-
-		// action, encoded as 1-of-k indicator vector. We scale it up a bit because
-		// we dont want weight regularization to undervalue this information, as it only exists once
-		action1ofk := make([]float64, b.NumActions)
-
-		action1ofk[b.ActionWindow[b.WindowSize-1-k]] = float64(b.NumStates)
-
-		w = append(w, action1ofk...)
-	}
-	return w
-}
-
-// compute forward (behavior) pass given the input neuron signals from body
-func (b *Brain) Forward(inputArray []float64) int {
-	b.ForwardPasses++
-	b.LastInputArray = inputArray // back this up
-
-	// create network input
-	var (
-		netInput []float64
-		action   int
-	)
-	if b.ForwardPasses > b.TemporalWindow {
-		// we have enough to actually do something reasonable
-		netInput = b.NetInput(inputArray)
-
-		if b.Learning {
-			// compute epsilon for the epsilon-greedy policy
-			b.Epsilon = math.Min(1.0, math.Max(b.EpsilonMin, 1.0-float64(b.Age-b.LearningStepsBurnin)/float64(b.LearningStepsTotal-b.LearningStepsBurnin)))
-		} else {
-			b.Epsilon = b.EpsilonTestTime // use test-time value
-		}
-
-		rf := b.Rand.Float64()
-		if rf < b.Epsilon {
-			// choose a random action with epsilon probability
-			action = b.RandomAction()
-		} else {
-			// otherwise use our policy to make decision
-			action, _ = b.Policy(netInput)
-		}
-	} else {
-		// pathological case that happens first few iterations
-		// before we accumulate window_size inputs
-		netInput = nil
-		action = b.RandomAction()
-	}
-
-	// remember the state and action we took for backward pass
-	copy(b.NetWindow, b.NetWindow[1:])
-	b.NetWindow[len(b.NetWindow)-1] = netInput
-	copy(b.StateWindow, b.StateWindow[1:])
-	b.StateWindow[len(b.StateWindow)-1] = inputArray
-	copy(b.ActionWindow, b.ActionWindow[1:])
-	b.ActionWindow[len(b.ActionWindow)-1] = action
-
-	return action
-}
-
-func (b *Brain) Backward(reward float64) {
-	b.LatestReward = reward
-	b.AverageRewardWindow.Add(reward)
-	copy(b.RewardWindow, b.RewardWindow[1:])
-	b.RewardWindow[len(b.RewardWindow)-1] = reward
-
-	if !b.Learning {
-		return
-	}
-
-	// various book-keeping
-	b.Age++
-
-	// it is time t+1 and we have to store (s_t, a_t, r_t, s_{t+1}) as new experience
-	// (given that an appropriate number of state measurements already exist, of course)
-	if b.ForwardPasses > b.TemporalWindow+1 {
-		n := b.WindowSize
-		e := Experience{
-			State0:  b.NetWindow[n-2],
-			Action0: b.ActionWindow[n-2],
-			Reward0: b.RewardWindow[n-2],
-			State1:  b.NetWindow[n-1],
-		}
-
-		if len(b.Experience) < b.ExperienceSize {
-			b.Experience = append(b.Experience, e)
-		} else {
-			// replace. finite memory!
-			ri := b.Rand.Intn(b.ExperienceSize)
-			b.Experience[ri] = e
-		}
-	}
-
-	// learn based on experience, once we have some samples to go on
-	// this is where the magic happens...
-	if len(b.Experience) > b.StartLearnThreshold {
-		avcost := 0.0
-
-		for k := 0; k < b.TDTrainer.BatchSize; k++ {
-			re := b.Rand.Intn(len(b.Experience))
-			e := b.Experience[re]
-
-			x := convnet.NewVol(1, 1, b.NetInputs, 0)
-			x.W = e.State0
-
-			_, maxact := b.Policy(e.State1)
-			r := e.Reward0 + b.Gamma*maxact
-
-			loss := b.TDTrainer.Train(x, convnet.LossData{Dim: e.Action0, Val: r})
-			avcost += loss.Loss
-		}
-
-		avcost /= float64(b.TDTrainer.BatchSize)
-		b.AverageLossWindow.Add(avcost)
-	}
-}
-
-func (b *Brain) String() string {
-	return fmt.Sprintf(`experience replay size: %d
-exploration epsilon: %f
-age: %d
-average Q-learning loss: %f
-smooth-ish reward: %f
-`, len(b.Experience), b.Epsilon, b.Age, b.AverageLossWindow.Average(), b.AverageRewardWindow.Average())
-}
+package deepqlearn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnutil"
+)
+
+// An agent is in state0 and does action0
+// environment then assigns reward0 and provides new state, state1
+// Experience nodes store all this information, which is used in the
+// Q-learning update step
+type Experience struct {
+	State0  []float64
+	Action0 int
+	Reward0 float64
+	State1  []float64
+	Action1 int    // the action taken in State1, for SARSA
+	Valid1  []bool // the actions that were valid in State1, or nil if all were
+
+	// with CompactExperience, State0 and State1 are nil and are rebuilt
+	// when they are needed from the TemporalWindow+2 raw states ending
+	// with that of State1, and the actions taken in all but the last of
+	// them, oldest first. see Brain.ExperienceInputs.
+	States  [][]float64 `json:",omitempty"`
+	Actions []int       `json:",omitempty"`
+
+	// with prioritized replay, the absolute TD error the last time this
+	// experience was learned from. new experiences start at the largest
+	// priority seen so far, so they are learned from at least once.
+	Priority float64
+}
+
+// Algorithm chooses how the value of the next state is estimated in the
+// TD target.
+type Algorithm int
+
+const (
+	// QLearning uses the value of the best action in the next state.
+	QLearning Algorithm = iota
+	// SARSA uses the value of the action that was actually taken in the
+	// next state, so the values learned are those of the policy being
+	// followed, exploration included. With a target net, the target net
+	// values that action, and DoubleDQN has no effect.
+	SARSA
+	// WatkinsQLambda is Watkins's Q(λ): it learns online from each step
+	// as it happens, like QLearning without experience replay, but also
+	// updates the values of earlier steps through eligibility traces
+	// over the parameters of the value net, which decay by Gamma*Lambda
+	// every step and are cut after an exploratory action. It needs a
+	// single action dimension, and cannot be used with prioritized
+	// replay or a target net.
+	WatkinsQLambda
+)
+
+// Exploration chooses how a learning brain picks actions other than the
+// best one.
+type Exploration int
+
+const (
+	// EpsilonGreedy takes a random action with probability epsilon, and
+	// the best action otherwise.
+	EpsilonGreedy Exploration = iota
+	// Boltzmann takes each action with probability proportional to
+	// exp(Q(s,a)/T) for a temperature T, so actions that look nearly as
+	// good as the best are tried more often than ones that look bad.
+	Boltzmann
+)
+
+// ReplacementPolicy chooses which experience is forgotten to make room
+// for a new one once the replay memory is full.
+type ReplacementPolicy int
+
+const (
+	// RandomReplacement overwrites an experience chosen uniformly at
+	// random.
+	RandomReplacement ReplacementPolicy = iota
+	// FIFOReplacement overwrites the oldest experience, so the memory
+	// always holds the most recent ExperienceSize experiences.
+	FIFOReplacement
+)
+
+type BrainOptions struct {
+	// in number of time steps, of temporal memory
+	// the ACTUAL input to the net will be (x,a) temporal_window times, and followed by current x
+	// so to have no information from previous time step going into value function, set to 0.
+	TemporalWindow int
+	// size of experience replay memory
+	ExperienceSize int
+	// if MaxExperienceBytes is more than 0, the replay memory holds fewer
+	// than ExperienceSize experiences if that many would take more bytes
+	// than this, going by Brain.ExperienceBytes.
+	MaxExperienceBytes int
+	// if CompactExperience is true, experiences keep the raw states and
+	// actions that their net inputs are made from rather than the net
+	// inputs themselves, which are rebuilt each time they are replayed.
+	// with a temporal window, this takes much less memory. as always,
+	// the states given to Forward must not be changed afterwards.
+	CompactExperience bool
+	// which experience to replace once the memory is full.
+	// RandomReplacement by default.
+	ReplacementPolicy ReplacementPolicy
+	// if SampleWithoutReplacement is true, no experience is used twice in
+	// the same batch, unless there are fewer experiences than the batch
+	// size, in which case every experience is used before any is used
+	// again. it does not affect prioritized replay.
+	SampleWithoutReplacement bool
+	// number of examples in experience replay memory before we begin learning
+	StartLearnThreshold int
+	// learn from a batch of experiences once every LearnEvery calls to
+	// Backward. 0 is the same as 1. experiences are stored every call
+	// either way.
+	LearnEvery int
+	// gamma is a crucial parameter that controls how much plan-ahead the agent does. In [0,1]
+	Gamma float64
+	// number of steps we will learn for
+	LearningStepsTotal int
+	// how many steps of the above to perform only random actions (in the beginning)?
+	LearningStepsBurnin int
+	// what epsilon value do we bottom out on? 0.0 => purely deterministic policy at end
+	EpsilonMin float64
+	// what epsilon to use at test time? (i.e. when learning is disabled)
+	EpsilonTestTime float64
+	// if EpsilonSchedule is not nil, it gives epsilon for each age while
+	// learning instead of the linear anneal from 1 to EpsilonMin over
+	// LearningStepsBurnin to LearningStepsTotal. epsilon never goes below
+	// EpsilonMin either way. see LinearEpsilon, ExponentialEpsilon, and
+	// PiecewiseEpsilon.
+	EpsilonSchedule func(age int) float64
+	// Exploration is EpsilonGreedy by default. with Boltzmann, the
+	// temperature while learning is TemperatureSchedule(age) if it is not
+	// nil, and Temperature otherwise; the epsilon schedule is not used.
+	// at test time, epsilon-greedy with EpsilonTestTime is used either way.
+	Exploration         Exploration
+	Temperature         float64
+	TemperatureSchedule func(age int) float64
+	// advanced feature. Sometimes a random action should be biased towards some values
+	// for example in flappy bird, we may want to choose to not flap more often
+	// this better sum to 1 by the way, and be of length this.num_actions
+	// with several action dimensions, each dimension's part must sum to 1
+	RandomActionDistribution []float64
+
+	LayerDefs        []convnet.LayerDef
+	HiddenLayerSizes []int
+	// if StateSx, StateSy, and StateDepth are set, each state is an image
+	// of that shape (so num_states must be their product), and the net
+	// input is the current state and the temporal_window states before it
+	// stacked along depth, most recent first, for conv layers to look at.
+	// The previous actions are not part of the input in this mode. The
+	// input layer of LayerDefs must be StateSx x StateSy x
+	// StateDepth*(temporal_window+1).
+	StateSx    int
+	StateSy    int
+	StateDepth int
+	Rand       *rand.Rand
+	// if Rand is nil and RandSource is not, the brain draws its random
+	// numbers from RandSource, so that RandState and SetRandState work.
+	// If both are nil, rand.NewSource(0) is used, as before RandSource
+	// existed, and the random state cannot be saved.
+	RandSource *convnet.RandSource
+
+	TDTrainerOptions convnet.TrainerOptions
+	// if LearningRateSchedule is not nil, the learning rate of the TD
+	// trainer is set to LearningRateSchedule(age) whenever the brain is
+	// about to learn: every LearnEvery calls to Backward, after the new
+	// experience is stored, and every call to Learn. GammaSchedule does
+	// the same for Gamma, for example to warm it up from a smaller value
+	// so that short term rewards are learned first. the epsilon schedules
+	// work for either.
+	LearningRateSchedule func(age int) float64
+	GammaSchedule        func(age int) float64
+
+	// if TargetSyncInterval is more than 0, the TD target is computed with
+	// a separate target net, which is a copy of the value net that is
+	// updated once every TargetSyncInterval calls to Backward.
+	TargetSyncInterval int
+	// if Tau is more than 0, there is a target net even if
+	// TargetSyncInterval is 0, and after every learning step its weights
+	// are moved towards those of the value net: target = Tau*value +
+	// (1-Tau)*target. it must be at most 1, which copies the weights.
+	Tau float64
+	// with a target net, DoubleDQN chooses the next action with the value
+	// net and evaluates it with the target net (van Hasselt et al. 2015).
+	// otherwise, the target net does both.
+	DoubleDQN bool
+
+	// if PrioritizedReplay is true, experiences are replayed in proportion
+	// to their priority raised to the power PriorityAlpha (Schaul et al.
+	// 2015), rather than uniformly. each update is weighted by the
+	// importance sampling weight (N*P(i))^-beta, normalized within the
+	// batch, where beta is annealed from PriorityBeta to 1 over
+	// LearningStepsTotal. PriorityEps is added to every priority so that
+	// no experience stops being replayed.
+	PrioritizedReplay bool
+	PriorityAlpha     float64
+	PriorityBeta      float64
+	PriorityEps       float64
+
+	// Algorithm is QLearning by default.
+	Algorithm Algorithm
+	// with WatkinsQLambda, Lambda is the decay of the eligibility traces
+	// on top of Gamma, in [0,1]. traces accumulate the gradient of the
+	// value of each action taken, unless ReplacingTraces is true, in
+	// which case each trace is replaced by the gradient wherever it is
+	// not zero. the value net is updated with plain sgd at the TD
+	// trainer's learning rate; the rest of its options are not used.
+	Lambda          float64
+	ReplacingTraces bool
+
+	// if ClipRewards is true, rewards are clipped into [RewardMin,
+	// RewardMax] before they are stored in experiences.
+	ClipRewards bool
+	RewardMin   float64
+	RewardMax   float64
+	// if NormalizeRewards is true, rewards are standardized with the
+	// running mean and standard deviation of every reward seen while
+	// learning (after clipping) before they are stored in experiences.
+	// AverageRewardWindow and LatestReward always get the raw reward.
+	NormalizeRewards bool
+
+	// if OnLearnStep is not nil, it is called with the statistics of
+	// every learning step. it is called synchronously by Backward or
+	// Learn, after the brain is unlocked, so it may use the brain, but
+	// Backward does not return until it does.
+	OnLearnStep func(LearnStats)
+}
+
+var DefaultBrainOptions = BrainOptions{
+	TemporalWindow:           1,
+	ExperienceSize:           30000,
+	StartLearnThreshold:      int(math.Floor(math.Min(30000*0.1, 1000))),
+	Gamma:                    0.8,
+	LearningStepsTotal:       100000,
+	LearningStepsBurnin:      3000,
+	EpsilonMin:               0.05,
+	EpsilonTestTime:          0.01,
+	Temperature:              1.0,
+	RandomActionDistribution: nil,
+	PriorityAlpha:            0.6,
+	PriorityBeta:             0.4,
+	PriorityEps:              1e-6,
+	TDTrainerOptions: convnet.TrainerOptions{
+		LearningRate: 0.01,
+		Momentum:     0.0,
+		BatchSize:    64,
+		L2Decay:      0.01,
+	},
+}
+
+// A Brain object does all the magic.
+// over time it receives some inputs and some rewards
+// and its job is to set the outputs to maximize the expected reward
+type Brain struct {
+	TemporalWindow           int
+	ExperienceSize           int // the capacity of the replay memory, after MaxExperienceBytes
+	MaxExperienceBytes       int
+	CompactExperience        bool
+	StartLearnThreshold      int
+	LearnEvery               int
+	Gamma                    float64
+	LearningStepsTotal       int
+	LearningStepsBurnin      int
+	EpsilonMin               float64
+	EpsilonTestTime          float64
+	EpsilonSchedule          func(age int) float64 `json:"-"`
+	LearningRateSchedule     func(age int) float64 `json:"-"`
+	GammaSchedule            func(age int) float64 `json:"-"`
+	Exploration              Exploration
+	Temperature              float64
+	TemperatureSchedule      func(age int) float64 `json:"-"`
+	RandomActionDistribution []float64
+
+	NetInputs int
+	NumStates int
+	// the shape of image states, or zero if states are flat; see
+	// BrainOptions
+	StateSx    int
+	StateSy    int
+	StateDepth int
+	NumActions int
+	WindowSize int
+	// the number of choices in each action dimension of a brain made by
+	// NewBrainMulti, or nil if there is only one. NumActions is their sum.
+	ActionDims []int
+
+	StateWindow  [][]float64
+	ActionWindow []int
+	RewardWindow []float64
+	NetWindow    [][]float64
+	MaskWindow   [][]bool // the valid actions given to ForwardMasked
+	WindowFill   int      // forward passes since the windows were reset
+
+	// the separate temporal context used by Act
+	EvalStateWindow  [][]float64
+	EvalActionWindow []int
+	EvalWindowFill   int
+
+	Rand       *rand.Rand
+	randSource *convnet.RandSource
+	ValueNet   convnet.Net
+	TDTrainer  *convnet.Trainer
+	Experience []Experience
+
+	ReplacementPolicy ReplacementPolicy
+	ExperienceCursor  int // with FIFOReplacement, the index of the oldest experience
+
+	SampleWithoutReplacement bool
+	sampleIndices            []int // a permutation of the experience indices, for sampleBatch
+
+	TargetSyncInterval int
+	DoubleDQN          bool
+	Tau                float64
+	TargetNet          *convnet.Net             // nil unless TargetSyncInterval or Tau > 0
+	valueParams        []convnet.ParamsAndGrads // cached for SoftUpdateTargetNet
+	targetParams       []convnet.ParamsAndGrads
+	paramsOf           *convnet.Net // the target net targetParams belong to
+
+	PrioritizedReplay bool
+	PriorityAlpha     float64
+	PriorityBeta      float64
+	PriorityEps       float64
+	priorities        *SumTree // priority^alpha of each experience
+	Algorithm         Algorithm
+	maxPriority       float64
+
+	Lambda          float64
+	ReplacingTraces bool
+	traces          [][]float64 // eligibility traces, indexed like ValueNet.ParamsAndGrads
+
+	ClipRewards      bool
+	RewardMin        float64
+	RewardMax        float64
+	NormalizeRewards bool
+	RewardCount      int     // number of rewards in the running stats
+	RewardMean       float64 // running mean of rewards
+	RewardM2         float64 // running sum of squared differences from the mean
+
+	Age                 int
+	ForwardPasses       int
+	Epsilon             float64
+	LatestReward        float64
+	LastInputArray      []float64
+	LastActionValues    []float64 // the value of every action at the last greedy choice in Forward
+	AverageRewardWindow *cnnutil.Window
+	AverageLossWindow   *cnnutil.Window
+	Learning            bool
+
+	OnLearnStep func(LearnStats) `json:"-"`
+	// the last learning step, if it has not been given to OnLearnStep
+	learnStats *LearnStats
+
+	// held by the methods that are safe to call concurrently
+	mu sync.Mutex
+}
+
+func NewBrain(numStates, numActions int, opt BrainOptions) (*Brain, error) {
+	return newBrain(numStates, []int{numActions}, opt)
+}
+
+func newBrain(numStates int, actionDims []int, opt BrainOptions) (*Brain, error) {
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
+	numActions := 0
+	for _, n := range actionDims {
+		numActions += n
+	}
+
+	b := &Brain{
+		TemporalWindow:           opt.TemporalWindow,
+		ExperienceSize:           opt.ExperienceSize,
+		MaxExperienceBytes:       opt.MaxExperienceBytes,
+		CompactExperience:        opt.CompactExperience,
+		ReplacementPolicy:        opt.ReplacementPolicy,
+		SampleWithoutReplacement: opt.SampleWithoutReplacement,
+		StartLearnThreshold:      opt.StartLearnThreshold,
+		LearnEvery:               opt.LearnEvery,
+		Gamma:                    opt.Gamma,
+		LearningStepsTotal:       opt.LearningStepsTotal,
+		LearningStepsBurnin:      opt.LearningStepsBurnin,
+		EpsilonMin:               opt.EpsilonMin,
+		EpsilonTestTime:          opt.EpsilonTestTime,
+		EpsilonSchedule:          opt.EpsilonSchedule,
+		LearningRateSchedule:     opt.LearningRateSchedule,
+		GammaSchedule:            opt.GammaSchedule,
+		Exploration:              opt.Exploration,
+		Temperature:              opt.Temperature,
+		TemperatureSchedule:      opt.TemperatureSchedule,
+		OnLearnStep:              opt.OnLearnStep,
+		RandomActionDistribution: opt.RandomActionDistribution,
+		TargetSyncInterval:       opt.TargetSyncInterval,
+		Tau:                      opt.Tau,
+		DoubleDQN:                opt.DoubleDQN,
+		PrioritizedReplay:        opt.PrioritizedReplay,
+		PriorityAlpha:            opt.PriorityAlpha,
+		PriorityBeta:             opt.PriorityBeta,
+		PriorityEps:              opt.PriorityEps,
+		Algorithm:                opt.Algorithm,
+		Lambda:                   opt.Lambda,
+		ReplacingTraces:          opt.ReplacingTraces,
+		ClipRewards:              opt.ClipRewards,
+		RewardMin:                opt.RewardMin,
+		RewardMax:                opt.RewardMax,
+		NormalizeRewards:         opt.NormalizeRewards,
+	}
+
+	if len(actionDims) > 1 {
+		if b.Algorithm == WatkinsQLambda {
+			return nil, errors.New("deepqlearn: watkins_q_lambda needs a single action dimension")
+		}
+
+		b.ActionDims = append([]int(nil), actionDims...)
+	}
+
+	if b.RandomActionDistribution != nil {
+		b.RandomActionDistribution = opt.RandomActionDistribution
+		if len(b.RandomActionDistribution) != numActions {
+			return nil, errors.New("deepqlearn: random_action_distribution should be same length as num_actions")
+		}
+
+		off := 0
+		for _, n := range actionDims {
+			sum := 0.0
+			for _, a := range b.RandomActionDistribution[off : off+n] {
+				sum += a
+			}
+
+			if math.Abs(sum-1.0) > 0.0001 {
+				return nil, errors.New("deepqlearn: random_action_distribution should sum to 1!")
+			}
+
+			off += n
+		}
+	}
+
+	// states that go into neural net to predict optimal action look as
+	// x0,a0,x1,a1,x2,a2,...xt
+	// this variable controls the size of that temporal window. Actions are
+	// encoded as 1-of-k hot vectors
+	b.NetInputs = numStates*b.TemporalWindow + numActions*b.TemporalWindow + numStates
+	if opt.StateSx != 0 || opt.StateSy != 0 || opt.StateDepth != 0 {
+		if opt.StateSx <= 0 || opt.StateSy <= 0 || opt.StateDepth <= 0 {
+			return nil, fmt.Errorf("deepqlearn: state shape %dx%dx%d must be positive", opt.StateSx, opt.StateSy, opt.StateDepth)
+		}
+
+		if opt.StateSx*opt.StateSy*opt.StateDepth != numStates {
+			return nil, fmt.Errorf("deepqlearn: state shape %dx%dx%d does not have num_states (%d) values", opt.StateSx, opt.StateSy, opt.StateDepth, numStates)
+		}
+
+		// frames stacked along depth, without actions
+		b.StateSx, b.StateSy, b.StateDepth = opt.StateSx, opt.StateSy, opt.StateDepth
+		b.NetInputs = numStates * (b.TemporalWindow + 1)
+	}
+	b.NumStates = numStates
+	b.NumActions = numActions
+
+	b.WindowSize = b.TemporalWindow
+	if b.WindowSize < 2 {
+		// must be at least 2, but if we want more context even more
+		b.WindowSize = 2
+	}
+	if b.CompactExperience {
+		// the whole history of both states of an experience
+		b.WindowSize = b.TemporalWindow + 2
+	}
+
+	if b.MaxExperienceBytes > 0 {
+		size := b.MaxExperienceBytes / b.ExperienceBytes()
+		if size < b.ExperienceSize {
+			b.ExperienceSize = size
+		}
+
+		if b.ExperienceSize <= b.StartLearnThreshold {
+			return nil, fmt.Errorf("deepqlearn: max_experience_bytes %d only has room for %d experiences of %d bytes, which is not more than start_learn_threshold %d", b.MaxExperienceBytes, b.ExperienceSize, b.ExperienceBytes(), b.StartLearnThreshold)
+		}
+	}
+
+	b.StateWindow = make([][]float64, b.WindowSize)
+	b.ActionWindow = make([]int, b.WindowSize)
+	b.RewardWindow = make([]float64, b.WindowSize)
+	b.NetWindow = make([][]float64, b.WindowSize)
+	b.MaskWindow = make([][]bool, b.WindowSize)
+
+	// create [state -> value of all possible actions] modeling net for the value function
+	layerDefs := opt.LayerDefs
+	if layerDefs != nil {
+		// this is an advanced usage feature, because size of the input to the network, and number of
+		// actions must check out. This is not very pretty Object Oriented programming but I can"t see
+		// a way out of it :(
+
+		if len(layerDefs) < 2 {
+			return nil, errors.New("deepqlearn: must have at least 2 layers")
+		}
+
+		if layerDefs[0].Type != convnet.LayerInput {
+			return nil, errors.New("deepqlearn: first layer must be input layer!")
+		}
+
+		if layerDefs[len(layerDefs)-1].Type != convnet.LayerRegression {
+			return nil, errors.New("deepqlearn: last layer must be input regression!")
+		}
+
+		if b.stacked() {
+			in := layerDefs[0]
+			if want := b.StateDepth * (b.TemporalWindow + 1); in.OutSx != b.StateSx || in.OutSy != b.StateSy || in.OutDepth != want {
+				return nil, fmt.Errorf("deepqlearn: input layer must be %dx%dx%d (state_sx x state_sy x state_depth*(temporal_window+1)), but it is %dx%dx%d", b.StateSx, b.StateSy, want, in.OutSx, in.OutSy, in.OutDepth)
+			}
+		} else if layerDefs[0].OutDepth*layerDefs[0].OutSx*layerDefs[0].OutSy != b.NetInputs {
+			return nil, errors.New("deepqlearn: Number of inputs must be num_states * temporal_window + num_actions * temporal_window + num_states!")
+		}
+
+		if layerDefs[len(layerDefs)-1].NumNeurons != b.NumActions {
+			return nil, errors.New("deepqlearn: Number of regression neurons should be num_actions!")
+		}
+	} else {
+		// create a very simple neural net by default
+		sx, sy, depth := b.inputShape()
+		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerInput, OutSx: sx, OutSy: sy, OutDepth: depth})
+
+		for _, hl := range opt.HiddenLayerSizes {
+			// relu by default
+			layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: hl, Activation: convnet.LayerRelu})
+		}
+
+		// value function output
+		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerRegression, NumNeurons: numActions})
+	}
+
+	b.Rand = opt.Rand
+	if b.Rand == nil {
+		if opt.RandSource != nil {
+			b.randSource = opt.RandSource
+			b.Rand = rand.New(b.randSource)
+		} else {
+			b.Rand = rand.New(rand.NewSource(0))
+		}
+	}
+
+	b.ValueNet.MakeLayers(layerDefs, b.Rand)
+	if b.randSource != nil {
+		b.ValueNet.SetRandSource(b.randSource)
+	}
+
+	if b.TargetSyncInterval > 0 || b.Tau > 0 {
+		b.SyncTargetNet()
+	}
+
+	// and finally we need a Temporal Difference Learning trainer!
+	b.TDTrainer = convnet.NewTrainer(&b.ValueNet, opt.TDTrainerOptions)
+
+	// experience replay
+	b.Experience = make([]Experience, 0, b.ExperienceSize)
+
+	// various housekeeping variables
+	b.Age = 0           // incremented every backward()
+	b.ForwardPasses = 0 // incremented every forward()
+	b.Epsilon = 1.0     // controls exploration exploitation tradeoff. Should be annealed over time
+	b.LatestReward = 0
+	b.LastInputArray = nil
+	b.AverageRewardWindow = cnnutil.NewWindow(1000, 10)
+	b.AverageLossWindow = cnnutil.NewWindow(1000, 10)
+	b.Learning = true
+
+	return b, nil
+}
+
+var errNoRandSource = errors.New("deepqlearn: brain was not given a RandSource")
+
+// RandState returns the state of the random numbers used for exploration,
+// experience replay, and the value net. It returns an error unless the
+// brain was created with a RandSource in its options.
+func (b *Brain) RandState() (uint64, error) {
+	if b.randSource == nil {
+		return 0, errNoRandSource
+	}
+
+	return b.randSource.State(), nil
+}
+
+// SetRandState restores a state returned by RandState.
+func (b *Brain) SetRandState(state uint64) error {
+	if b.randSource == nil {
+		return errNoRandSource
+	}
+
+	b.randSource.SetState(state)
+
+	return nil
+}
+
+// a bit of a helper function. It returns a random action
+// we are abstracting this away because in future we may want to
+// do more sophisticated things. For example some actions could be more
+// or less likely at "rest"/default state.
+func (b *Brain) RandomAction() int {
+	return b.RandomActionMasked(nil)
+}
+
+// RandomActionMasked is like RandomAction, but only picks actions that are
+// valid. RandomActionDistribution is renormalized over the valid actions,
+// or ignored if it gives them no probability at all. A nil mask means
+// every action is valid.
+func (b *Brain) RandomActionMasked(valid []bool) int {
+	if valid != nil {
+		b.checkMask(valid)
+	}
+
+	dims := b.actionDims()
+	actions := make([]int, len(dims))
+	off := 0
+
+	for d, n := range dims {
+		var dist []float64
+		if b.RandomActionDistribution != nil {
+			dist = b.RandomActionDistribution[off : off+n]
+		}
+
+		var v []bool
+		if valid != nil {
+			v = valid[off : off+n]
+		}
+
+		actions[d] = b.randomChoice(n, dist, v)
+		off += n
+	}
+
+	return b.encodeAction(actions)
+}
+
+// randomChoice picks one of n choices according to dist, or uniformly if
+// dist is nil, from the ones that are valid
+func (b *Brain) randomChoice(n int, dist []float64, valid []bool) int {
+	if valid == nil {
+		if dist == nil {
+			return b.Rand.Intn(n)
+		}
+
+		// okay, lets do some fancier sampling:
+		p := b.Rand.Float64()
+		cumprob := 0.0
+
+		for k := range dist {
+			cumprob += dist[k]
+
+			if p < cumprob {
+				return k
+			}
+		}
+
+		// rounding error
+		return n - 1
+	}
+
+	sum, count, last := 0.0, 0, 0
+	for k, ok := range valid {
+		if ok {
+			if dist != nil {
+				sum += dist[k]
+			}
+			count++
+			last = k
+		}
+	}
+
+	if sum <= 0 {
+		// uniform over the valid actions
+		n := b.Rand.Intn(count)
+		for k, ok := range valid {
+			if ok {
+				if n == 0 {
+					return k
+				}
+				n--
+			}
+		}
+	}
+
+	p := b.Rand.Float64() * sum
+	cumprob := 0.0
+
+	for k, ok := range valid {
+		if ok {
+			cumprob += dist[k]
+
+			if p < cumprob {
+				return k
+			}
+		}
+	}
+
+	// rounding error
+	return last
+}
+
+// panics unless valid has an entry for each action and allows at least
+// one of them in each action dimension
+func (b *Brain) checkMask(valid []bool) {
+	if len(valid) != b.NumActions {
+		panic(fmt.Sprintf("deepqlearn: mask has %d actions, but the brain has %d", len(valid), b.NumActions))
+	}
+
+	off := 0
+
+dims:
+	for _, n := range b.actionDims() {
+		for _, ok := range valid[off : off+n] {
+			if ok {
+				off += n
+				continue dims
+			}
+		}
+
+		panic("deepqlearn: mask does not allow any action")
+	}
+}
+
+// compute the value of doing any action in this state
+// and return the argmax action and its value
+func (b *Brain) Policy(s []float64) (action int, value float64) {
+	return b.PolicyMasked(s, nil)
+}
+
+// PolicyMasked is like Policy, but only considers the actions that are
+// valid. A nil mask means every action is valid.
+//
+// With more than one action dimension, the best action is chosen in each
+// dimension, and the value is the mean of their values.
+func (b *Brain) PolicyMasked(s []float64, valid []bool) (action int, value float64) {
+	values := b.actionValues(&b.ValueNet, s)
+	action = b.bestAction(values, valid)
+
+	chosen := b.chosenValues(values, action)
+	for _, v := range chosen {
+		value += v
+	}
+
+	return action, value / float64(len(chosen))
+}
+
+// PolicyValues is like Policy, but returns the value of every action in
+// this state. The slice belongs to the value net, and is only valid until
+// it is next used.
+func (b *Brain) PolicyValues(s []float64) (action int, values []float64) {
+	values = b.actionValues(&b.ValueNet, s)
+
+	return b.bestAction(values, nil), values
+}
+
+// the valid action with the largest value in each action dimension
+func (b *Brain) bestAction(values []float64, valid []bool) int {
+	dims := b.actionDims()
+	actions := make([]int, len(dims))
+	off := 0
+
+	for d, n := range dims {
+		var v []bool
+		if valid != nil {
+			v = valid[off : off+n]
+		}
+
+		actions[d] = bestChoice(values[off:off+n], v)
+		off += n
+	}
+
+	return b.encodeAction(actions)
+}
+
+// the valid choice with the largest value
+func bestChoice(values []float64, valid []bool) int {
+	maxk := -1
+
+	for k, v := range values {
+		if (valid == nil || valid[k]) && (maxk == -1 || v > values[maxk]) {
+			maxk = k
+		}
+	}
+
+	return maxk
+}
+
+// the value of action in each action dimension
+func (b *Brain) chosenValues(values []float64, action int) []float64 {
+	actions := b.decodeAction(action)
+	chosen := make([]float64, len(actions))
+	off := 0
+
+	for d, n := range b.actionDims() {
+		chosen[d] = values[off+actions[d]]
+		off += n
+	}
+
+	return chosen
+}
+
+// BoltzmannAction picks an action with probability proportional to
+// exp(values[a]/temperature). A temperature of zero or less always picks
+// the best action.
+func (b *Brain) BoltzmannAction(values []float64, temperature float64) int {
+	return b.boltzmannAction(values, temperature, nil)
+}
+
+// like BoltzmannAction, but invalid actions have no probability, and each
+// action dimension is sampled on its own
+func (b *Brain) boltzmannAction(values []float64, temperature float64, valid []bool) int {
+	dims := b.actionDims()
+	actions := make([]int, len(dims))
+	off := 0
+
+	for d, n := range dims {
+		var v []bool
+		if valid != nil {
+			v = valid[off : off+n]
+		}
+
+		actions[d] = b.boltzmannChoice(values[off:off+n], temperature, v)
+		off += n
+	}
+
+	return b.encodeAction(actions)
+}
+
+// boltzmannChoice samples one choice from the softmax of the valid values
+func (b *Brain) boltzmannChoice(values []float64, temperature float64, valid []bool) int {
+	best := bestChoice(values, valid)
+
+	if temperature <= 0 {
+		return best
+	}
+
+	// subtract the largest value so that exp cannot overflow
+	probs := make([]float64, len(values))
+	sum := 0.0
+	for k, v := range values {
+		if valid == nil || valid[k] {
+			probs[k] = math.Exp((v - values[best]) / temperature)
+			sum += probs[k]
+		}
+	}
+
+	p := b.Rand.Float64() * sum
+	cumprob := 0.0
+
+	for k, prob := range probs {
+		cumprob += prob
+
+		if p < cumprob {
+			return k
+		}
+	}
+
+	// rounding error
+	return best
+}
+
+// the temperature for Boltzmann exploration at the current age
+func (b *Brain) temperature() float64 {
+	if b.TemperatureSchedule != nil {
+		return b.TemperatureSchedule(b.Age)
+	}
+
+	return b.Temperature
+}
+
+func (b *Brain) actionValues(net *convnet.Net, s []float64) []float64 {
+	svol := b.inputVol(s)
+
+	return net.Forward(svol, false).W
+}
+
+// SyncTargetNet replaces the target net with a copy of the value net. It
+// is called every TargetSyncInterval calls to Backward.
+func (b *Brain) SyncTargetNet() {
+	b.TargetNet = b.ValueNet.Clone()
+}
+
+// SoftUpdateTargetNet moves each weight of the target net towards the
+// same weight of the value net: target = Tau*value + (1-Tau)*target. It is
+// called after every learning step if Tau is more than 0. Statistics that
+// are not trained, such as those of batch norm layers, are left alone.
+func (b *Brain) SoftUpdateTargetNet() {
+	if b.paramsOf != b.TargetNet {
+		b.valueParams = b.ValueNet.ParamsAndGrads()
+		b.targetParams = b.TargetNet.ParamsAndGrads()
+		b.paramsOf = b.TargetNet
+	}
+
+	tau := b.Tau
+	for i, pg := range b.targetParams {
+		value := b.valueParams[i].Params
+		for j, w := range pg.Params {
+			pg.Params[j] = tau*value[j] + (1-tau)*w
+		}
+	}
+}
+
+// ExportWeights returns a copy of every parameter of the value net as a
+// single slice, in the order of ValueNet.ParamsAndGrads. It is meant for
+// copying weights between brains with the same layers, and is much faster
+// than going through JSON.
+func (b *Brain) ExportWeights() []float64 {
+	var w []float64
+	for _, pg := range b.ValueNet.ParamsAndGrads() {
+		w = append(w, pg.Params...)
+	}
+
+	return w
+}
+
+// ImportWeights loads weights returned by ExportWeights into the value
+// net. It returns an error without changing anything if w is not the
+// right length. The target net, if any, is left alone; call SyncTargetNet
+// to copy the new weights to it as well.
+func (b *Brain) ImportWeights(w []float64) error {
+	pglist := b.ValueNet.ParamsAndGrads()
+
+	n := 0
+	for _, pg := range pglist {
+		n += len(pg.Params)
+	}
+	if len(w) != n {
+		return fmt.Errorf("deepqlearn: value net has %d weights, but %d were given", n, len(w))
+	}
+
+	for _, pg := range pglist {
+		w = w[copy(pg.Params, w):]
+	}
+
+	return nil
+}
+
+// Clone returns an independent copy of the brain, for example to explore
+// and mutate separately in an evolution strategy. The value net, target
+// net, trainer state, experience, and windows are all copied. The clone
+// gets its own RandSource, seeded from the brain's random numbers, so the
+// two explore differently. Schedules are shared, and the state vectors in
+// remembered experiences are shared because they are never modified.
+func (b *Brain) Clone() *Brain {
+	// a Net must not be copied, so every field is listed here
+	c := &Brain{
+		TemporalWindow:           b.TemporalWindow,
+		ExperienceSize:           b.ExperienceSize,
+		MaxExperienceBytes:       b.MaxExperienceBytes,
+		CompactExperience:        b.CompactExperience,
+		StartLearnThreshold:      b.StartLearnThreshold,
+		LearnEvery:               b.LearnEvery,
+		Gamma:                    b.Gamma,
+		LearningStepsTotal:       b.LearningStepsTotal,
+		LearningStepsBurnin:      b.LearningStepsBurnin,
+		EpsilonMin:               b.EpsilonMin,
+		EpsilonTestTime:          b.EpsilonTestTime,
+		EpsilonSchedule:          b.EpsilonSchedule,
+		LearningRateSchedule:     b.LearningRateSchedule,
+		GammaSchedule:            b.GammaSchedule,
+		Exploration:              b.Exploration,
+		Temperature:              b.Temperature,
+		TemperatureSchedule:      b.TemperatureSchedule,
+		RandomActionDistribution: append([]float64(nil), b.RandomActionDistribution...),
+
+		NetInputs:  b.NetInputs,
+		NumStates:  b.NumStates,
+		StateSx:    b.StateSx,
+		StateSy:    b.StateSy,
+		StateDepth: b.StateDepth,
+		NumActions: b.NumActions,
+		WindowSize: b.WindowSize,
+		ActionDims: append([]int(nil), b.ActionDims...),
+
+		StateWindow:  append([][]float64(nil), b.StateWindow...),
+		ActionWindow: append([]int(nil), b.ActionWindow...),
+		RewardWindow: append([]float64(nil), b.RewardWindow...),
+		NetWindow:    append([][]float64(nil), b.NetWindow...),
+		MaskWindow:   append([][]bool(nil), b.MaskWindow...),
+		WindowFill:   b.WindowFill,
+
+		EvalStateWindow:  append([][]float64(nil), b.EvalStateWindow...),
+		EvalActionWindow: append([]int(nil), b.EvalActionWindow...),
+		EvalWindowFill:   b.EvalWindowFill,
+
+		Experience: append(make([]Experience, 0, cap(b.Experience)), b.Experience...),
+
+		ReplacementPolicy: b.ReplacementPolicy,
+		ExperienceCursor:  b.ExperienceCursor,
+
+		SampleWithoutReplacement: b.SampleWithoutReplacement,
+		sampleIndices:            append([]int(nil), b.sampleIndices...),
+
+		TargetSyncInterval: b.TargetSyncInterval,
+		DoubleDQN:          b.DoubleDQN,
+		Tau:                b.Tau,
+
+		PrioritizedReplay: b.PrioritizedReplay,
+		PriorityAlpha:     b.PriorityAlpha,
+		PriorityBeta:      b.PriorityBeta,
+		PriorityEps:       b.PriorityEps,
+		Algorithm:         b.Algorithm,
+		maxPriority:       b.maxPriority,
+
+		Lambda:          b.Lambda,
+		ReplacingTraces: b.ReplacingTraces,
+		traces:          cloneTraces(b.traces),
+
+		ClipRewards:      b.ClipRewards,
+		RewardMin:        b.RewardMin,
+		RewardMax:        b.RewardMax,
+		NormalizeRewards: b.NormalizeRewards,
+		RewardCount:      b.RewardCount,
+		RewardMean:       b.RewardMean,
+		RewardM2:         b.RewardM2,
+
+		Age:                 b.Age,
+		ForwardPasses:       b.ForwardPasses,
+		Epsilon:             b.Epsilon,
+		LatestReward:        b.LatestReward,
+		LastInputArray:      b.LastInputArray,
+		LastActionValues:    append([]float64(nil), b.LastActionValues...),
+		AverageRewardWindow: cloneWindow(b.AverageRewardWindow),
+		AverageLossWindow:   cloneWindow(b.AverageLossWindow),
+		OnLearnStep:         b.OnLearnStep,
+		Learning:            b.Learning,
+	}
+
+	c.randSource = convnet.NewRandSource(b.Rand.Int63())
+	c.Rand = rand.New(c.randSource)
+
+	data, err := json.Marshal(&b.ValueNet)
+	if err != nil {
+		panic("deepqlearn: cannot clone value net: " + err.Error())
+	}
+	if err := c.ValueNet.UnmarshalJSON(data); err != nil {
+		panic("deepqlearn: cannot clone value net: " + err.Error())
+	}
+	c.ValueNet.CheckpointEvery = b.ValueNet.CheckpointEvery
+	c.ValueNet.SetRandSource(c.randSource)
+
+	c.TDTrainer = b.TDTrainer.Clone(&c.ValueNet)
+	if b.TargetNet != nil {
+		c.TargetNet = b.TargetNet.Clone()
+		c.TargetNet.SetRandSource(c.randSource)
+	}
+
+	if b.priorities != nil {
+		c.priorities = &SumTree{
+			leaves: b.priorities.leaves,
+			nodes:  append([]float64(nil), b.priorities.nodes...),
+		}
+	}
+
+	return c
+}
+
+func cloneWindow(w *cnnutil.Window) *cnnutil.Window {
+	clone := *w
+	clone.V = append(make([]float64, 0, cap(w.V)), w.V...)
+
+	return &clone
+}
+
+// the value of the next state of e used in the TD target, for each action
+// dimension
+func (b *Brain) nextValues(e *Experience) []float64 {
+	s1 := b.state1(e)
+
+	var (
+		action int
+		values []float64
+	)
+
+	switch {
+	case b.Algorithm == SARSA:
+		net := &b.ValueNet
+		if b.TargetNet != nil {
+			net = b.TargetNet
+		}
+
+		action = e.Action1
+		values = b.actionValues(net, s1)
+	case b.TargetNet == nil:
+		values = b.actionValues(&b.ValueNet, s1)
+		action = b.bestAction(values, e.Valid1)
+	case b.DoubleDQN:
+		action = b.bestAction(b.actionValues(&b.ValueNet, s1), e.Valid1)
+		values = b.actionValues(b.TargetNet, s1)
+	default:
+		values = b.actionValues(b.TargetNet, s1)
+		action = b.bestAction(values, e.Valid1)
+	}
+
+	return b.chosenValues(values, action)
+}
+
+// return s = (x,a,x,a,x,a,xt) state vector.
+// It"s a concatenation of last window_size (x,a) pairs and current state x
+func (b *Brain) NetInput(xt []float64) []float64 {
+	return b.netInput(xt, b.StateWindow, b.ActionWindow)
+}
+
+// like NetInput, but with the history taken from states and actions, which
+// have the most recent last
+func (b *Brain) netInput(xt []float64, states [][]float64, actions []int) []float64 {
+	if b.stacked() {
+		return b.stackFrames(xt, states)
+	}
+
+	var w []float64
+	w = append(w, xt...) // start with current state
+
+	// and now go backwards and append states and actions from history temporal_window times
+	for k := 0; k < b.TemporalWindow; k++ {
+		// state
+		w = append(w, states[len(states)-1-k]...)
+
+		// action, encoded as 1-of-k indicator vector. We scale it up a bit because
+		// we dont want weight regularization to undervalue this information, as it only exists once
+		// with several action dimensions, there is one block for each
+		action1ofk := make([]float64, b.NumActions)
+
+		off := 0
+		for d, a := range b.decodeAction(actions[len(actions)-1-k]) {
+			action1ofk[off+a] = float64(b.NumStates)
+			off += b.actionDims()[d]
+		}
+
+		w = append(w, action1ofk...)
+	}
+	return w
+}
+
+// epsilon for the current age while learning
+func (b *Brain) learningEpsilon() float64 {
+	schedule := b.EpsilonSchedule
+	if schedule == nil {
+		schedule = LinearEpsilon(1.0, 0.0, b.LearningStepsBurnin, b.LearningStepsTotal)
+	}
+
+	return math.Min(1.0, math.Max(b.EpsilonMin, schedule(b.Age)))
+}
+
+// LinearEpsilon returns an epsilon schedule that stays at start until
+// burnin and then moves linearly to end at total, where it stays.
+func LinearEpsilon(start, end float64, burnin, total int) func(age int) float64 {
+	return func(age int) float64 {
+		if age <= burnin {
+			return start
+		}
+		if age >= total {
+			return end
+		}
+
+		return start + (end-start)*float64(age-burnin)/float64(total-burnin)
+	}
+}
+
+// ExponentialEpsilon returns an epsilon schedule that starts at start and
+// is multiplied by decay at every step, but never goes below end.
+func ExponentialEpsilon(start, end, decay float64) func(age int) float64 {
+	return func(age int) float64 {
+		return math.Max(end, start*math.Pow(decay, float64(age)))
+	}
+}
+
+// PiecewiseEpsilon returns an epsilon schedule that is values[0] before
+// boundaries[0], values[i] from boundaries[i-1] up to boundaries[i], and
+// the last value after the last boundary. There must be one more value
+// than boundaries, and the boundaries must be in increasing order.
+func PiecewiseEpsilon(boundaries []int, values []float64) func(age int) float64 {
+	if len(values) != len(boundaries)+1 {
+		panic(fmt.Sprintf("deepqlearn: %d boundaries need %d values, but there are %d", len(boundaries), len(boundaries)+1, len(values)))
+	}
+
+	boundaries = append([]int(nil), boundaries...)
+	values = append([]float64(nil), values...)
+
+	return func(age int) float64 {
+		i := sort.SearchInts(boundaries, age+1)
+
+		return values[i]
+	}
+}
+
+// compute forward (behavior) pass given the input neuron signals from body
+func (b *Brain) Forward(inputArray []float64) int {
+	return b.ForwardMasked(inputArray, nil)
+}
+
+// ForwardMasked is like Forward, but only picks an action for which valid
+// is true, whether exploring or not. The mask is remembered, so that the
+// TD target for the previous action only considers the actions that were
+// valid in this state. A nil mask means every action is valid.
+func (b *Brain) ForwardMasked(inputArray []float64, valid []bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.forwardMasked(inputArray, valid)
+}
+
+func (b *Brain) forwardMasked(inputArray []float64, valid []bool) int {
+	if valid != nil {
+		b.checkMask(valid)
+		valid = append([]bool(nil), valid...) // it is kept for Backward
+	}
+
+	b.ForwardPasses++
+	b.WindowFill++
+	b.LastInputArray = inputArray // back this up
+
+	// create network input
+	var (
+		netInput []float64
+		action   int
+	)
+	if b.WindowFill > b.TemporalWindow {
+		// we have enough to actually do something reasonable
+		netInput = b.NetInput(inputArray)
+
+		if b.Learning && b.Exploration == Boltzmann {
+			// sample from the softmax of the action values
+			_, values := b.PolicyValues(netInput)
+			action = b.boltzmannAction(values, b.temperature(), valid)
+		} else {
+			if b.Learning {
+				// compute epsilon for the epsilon-greedy policy
+				b.Epsilon = b.learningEpsilon()
+			} else {
+				b.Epsilon = b.EpsilonTestTime // use test-time value
+			}
+
+			rf := b.Rand.Float64()
+			if rf < b.Epsilon {
+				// choose a random action with epsilon probability
+				action = b.RandomActionMasked(valid)
+			} else {
+				// otherwise use our policy to make decision
+				values := b.actionValues(&b.ValueNet, netInput)
+				action = b.bestAction(values, valid)
+				b.LastActionValues = append(b.LastActionValues[:0], values...)
+			}
+		}
+	} else {
+		// pathological case that happens first few iterations
+		// before we accumulate window_size inputs
+		netInput = nil
+		action = b.RandomActionMasked(valid)
+	}
+
+	// remember the state and action we took for backward pass
+	copy(b.NetWindow, b.NetWindow[1:])
+	b.NetWindow[len(b.NetWindow)-1] = netInput
+	copy(b.StateWindow, b.StateWindow[1:])
+	b.StateWindow[len(b.StateWindow)-1] = inputArray
+	copy(b.ActionWindow, b.ActionWindow[1:])
+	b.ActionWindow[len(b.ActionWindow)-1] = action
+	if len(b.MaskWindow) != b.WindowSize {
+		// loaded from before masks were remembered
+		b.MaskWindow = make([][]bool, b.WindowSize)
+	}
+	copy(b.MaskWindow, b.MaskWindow[1:])
+	b.MaskWindow[len(b.MaskWindow)-1] = valid
+
+	return action
+}
+
+func (b *Brain) Backward(reward float64) {
+	b.mu.Lock()
+	b.backward(reward)
+	report := b.takeLearnStats()
+	b.mu.Unlock()
+
+	report()
+}
+
+func (b *Brain) backward(reward float64) {
+	b.LatestReward = reward
+	b.AverageRewardWindow.Add(reward)
+	copy(b.RewardWindow, b.RewardWindow[1:])
+	b.RewardWindow[len(b.RewardWindow)-1] = b.transformReward(reward)
+
+	if !b.Learning {
+		return
+	}
+
+	// various book-keeping
+	b.Age++
+
+	if b.Algorithm == WatkinsQLambda {
+		// learn from the last step right away instead of remembering it
+		if b.WindowFill > b.TemporalWindow+1 {
+			b.learnTraces()
+		}
+
+		return
+	}
+
+	// it is time t+1 and we have to store (s_t, a_t, r_t, s_{t+1}) as new experience
+	// (given that an appropriate number of state measurements already exist, of course)
+	if b.WindowFill > b.TemporalWindow+1 {
+		n := b.WindowSize
+		e := Experience{
+			State0:  b.NetWindow[n-2],
+			Action0: b.ActionWindow[n-2],
+			Reward0: b.RewardWindow[n-2],
+			State1:  b.NetWindow[n-1],
+			Action1: b.ActionWindow[n-1],
+			Valid1:  b.MaskWindow[n-1],
+		}
+
+		if b.CompactExperience {
+			e.State0, e.State1 = nil, nil
+			e.States = append([][]float64(nil), b.StateWindow...)
+			e.Actions = append([]int(nil), b.ActionWindow[:n-1]...)
+		}
+
+		if b.PrioritizedReplay {
+			b.initPriorities()
+			e.Priority = b.maxPriority
+		}
+
+		ri := len(b.Experience)
+		if len(b.Experience) < b.ExperienceSize {
+			b.Experience = append(b.Experience, e)
+		} else if b.ReplacementPolicy == FIFOReplacement {
+			// replace the oldest, going around the memory like a ring
+			ri = b.ExperienceCursor
+			b.ExperienceCursor = (b.ExperienceCursor + 1) % b.ExperienceSize
+			b.Experience[ri] = e
+		} else {
+			// replace. finite memory!
+			ri = b.Rand.Intn(b.ExperienceSize)
+			b.Experience[ri] = e
+		}
+
+		if b.PrioritizedReplay {
+			b.priorities.Set(ri, math.Pow(e.Priority, b.PriorityAlpha))
+		}
+	}
+
+	if b.LearnEvery <= 1 || b.Age%b.LearnEvery == 0 {
+		b.learn()
+	}
+
+	if len(b.Experience) > b.StartLearnThreshold && b.TargetSyncInterval > 0 && b.Age%b.TargetSyncInterval == 0 {
+		b.SyncTargetNet()
+	}
+}
+
+// Learn trains the value net on one batch of experiences, if there are
+// more than StartLearnThreshold of them, and reports whether it did.
+// Backward calls it once every LearnEvery steps; callers that set
+// LearnEvery very high can call it themselves instead.
+func (b *Brain) Learn() bool {
+	b.mu.Lock()
+	learned := b.learn()
+	report := b.takeLearnStats()
+	b.mu.Unlock()
+
+	report()
+
+	return learned
+}
+
+func (b *Brain) learn() bool {
+	b.applySchedules()
+
+	if len(b.Experience) <= b.StartLearnThreshold {
+		return false
+	}
+
+	// learn based on experience, once we have some samples to go on
+	// this is where the magic happens...
+	var stats LearnStats
+	if b.PrioritizedReplay {
+		stats = b.learnPrioritized()
+	} else {
+		avcost, count := 0.0, 0
+		sumError, maxError := 0.0, 0.0
+
+		for _, re := range b.sampleBatch(b.TDTrainer.BatchSize) {
+			e := b.Experience[re]
+
+			x := b.inputVol(b.state0(&e))
+
+			next := b.nextValues(&e)
+
+			// each action dimension learns the value of its own
+			// action
+			off := 0
+			for d, a := range b.decodeAction(e.Action0) {
+				r := e.Reward0 + b.Gamma*next[d]
+
+				loss := b.TDTrainer.Train(x, convnet.LossData{Dim: off + a, Val: r})
+				avcost += loss.Loss
+				count++
+
+				// the regression loss is half the squared TD error
+				absError := math.Sqrt(2 * loss.CostLoss)
+				sumError += absError
+				maxError = math.Max(maxError, absError)
+
+				off += b.actionDims()[d]
+			}
+		}
+
+		stats = LearnStats{
+			Loss:           avcost / float64(count),
+			MeanAbsTDError: sumError / float64(count),
+			MaxAbsTDError:  maxError,
+		}
+	}
+
+	stats.Age = b.Age
+	stats.Epsilon = b.Epsilon
+	stats.LearningRate = b.TDTrainer.LearningRate
+	stats.Gamma = b.Gamma
+	b.AverageLossWindow.Add(stats.Loss)
+	b.learnStats = &stats
+
+	if b.Tau > 0 {
+		b.SoftUpdateTargetNet()
+	}
+
+	return true
+}
+
+// sets the learning rate and gamma for the current age, if they are
+// scheduled
+func (b *Brain) applySchedules() {
+	if b.LearningRateSchedule != nil {
+		b.TDTrainer.LearningRate = b.LearningRateSchedule(b.Age)
+	}
+	if b.GammaSchedule != nil {
+		b.Gamma = b.GammaSchedule(b.Age)
+	}
+}
+
+// transformReward clips and normalizes a reward as the options say, and
+// adds it to the running stats while learning
+func (b *Brain) transformReward(reward float64) float64 {
+	if b.ClipRewards {
+		reward = math.Max(b.RewardMin, math.Min(b.RewardMax, reward))
+	}
+
+	if !b.NormalizeRewards {
+		return reward
+	}
+
+	if b.Learning {
+		// Welford's algorithm
+		b.RewardCount++
+		delta := reward - b.RewardMean
+		b.RewardMean += delta / float64(b.RewardCount)
+		b.RewardM2 += delta * (reward - b.RewardMean)
+	}
+
+	mean, std := b.RewardStats()
+	if std < 1e-8 {
+		// not enough rewards to know the scale yet
+		std = 1
+	}
+
+	return (reward - mean) / std
+}
+
+// RewardStats returns the running mean and standard deviation of the
+// rewards used for NormalizeRewards.
+func (b *Brain) RewardStats() (mean, std float64) {
+	if b.RewardCount == 0 {
+		return 0, 0
+	}
+
+	return b.RewardMean, math.Sqrt(b.RewardM2 / float64(b.RewardCount))
+}
+
+// initPriorities builds the sum tree of priorities from the experiences,
+// if it has not been built yet, such as after the brain is loaded from
+// JSON
+func (b *Brain) initPriorities() {
+	if b.priorities != nil {
+		return
+	}
+
+	b.priorities = NewSumTree(b.ExperienceSize)
+	b.maxPriority = 1
+
+	for _, e := range b.Experience {
+		b.maxPriority = math.Max(b.maxPriority, e.Priority)
+	}
+
+	for i := range b.Experience {
+		e := &b.Experience[i]
+		if e.Priority == 0 {
+			// remembered without prioritized replay
+			e.Priority = b.maxPriority
+		}
+
+		b.priorities.Set(i, math.Pow(e.Priority, b.PriorityAlpha))
+	}
+}
+
+// sampleBatch chooses the indices of n experiences to learn from: in
+// proportion to their priorities with prioritized replay, and uniformly
+// otherwise, with or without replacement
+func (b *Brain) sampleBatch(n int) []int {
+	batch := make([]int, n)
+
+	if b.PrioritizedReplay {
+		total := b.priorities.Total()
+		for k := range batch {
+			batch[k] = b.priorities.Find(b.Rand.Float64() * total)
+		}
+
+		return batch
+	}
+
+	m := len(b.Experience)
+
+	if !b.SampleWithoutReplacement {
+		for k := range batch {
+			batch[k] = b.Rand.Intn(m)
+		}
+
+		return batch
+	}
+
+	// any permutation will do as a starting point, so only the indices
+	// of experiences added since the last batch need to be appended
+	if len(b.sampleIndices) > m {
+		b.sampleIndices = b.sampleIndices[:0]
+	}
+	for i := len(b.sampleIndices); i < m; i++ {
+		b.sampleIndices = append(b.sampleIndices, i)
+	}
+	idx := b.sampleIndices[:m]
+
+	// partial Fisher-Yates shuffle, starting over if the batch is bigger
+	// than the memory
+	for k := range batch {
+		j := k % m
+		r := j + b.Rand.Intn(m-j)
+		idx[j], idx[r] = idx[r], idx[j]
+		batch[k] = idx[j]
+	}
+
+	return batch
+}
+
+// learnPrioritized trains on one batch of experiences sampled by priority
+// and updates their priorities
+func (b *Brain) learnPrioritized() LearnStats {
+	b.initPriorities()
+
+	beta := b.PriorityBeta + (1-b.PriorityBeta)*math.Min(1, float64(b.Age)/float64(b.LearningStepsTotal))
+
+	batch := b.sampleBatch(b.TDTrainer.BatchSize)
+	weights := make([]float64, len(batch))
+	maxWeight := 0.0
+
+	total := b.priorities.Total()
+	n := float64(len(b.Experience))
+
+	for k := range batch {
+		p := b.priorities.Get(batch[k]) / total
+		weights[k] = math.Pow(n*p, -beta)
+		maxWeight = math.Max(maxWeight, weights[k])
+	}
+
+	avcost, count := 0.0, 0
+	sumError, maxError := 0.0, 0.0
+
+	for k, re := range batch {
+		e := &b.Experience[re]
+		w := weights[k] / maxWeight
+
+		s0 := b.state0(e)
+		x := b.inputVol(s0)
+
+		next := b.nextValues(e)
+		qs := b.chosenValues(b.actionValues(&b.ValueNet, s0), e.Action0)
+
+		// with several action dimensions, the priority is their mean
+		// absolute TD error
+		absError := 0.0
+
+		off := 0
+		for d, a := range b.decodeAction(e.Action0) {
+			r := e.Reward0 + b.Gamma*next[d]
+			tdError := r - qs[d]
+
+			// the gradient of the regression loss is q minus the
+			// target, so moving the target scales the gradient by w
+			loss := b.TDTrainer.Train(x, convnet.LossData{Dim: off + a, Val: qs[d] + w*tdError})
+			avcost += w*0.5*tdError*tdError + loss.L1DecayLoss + loss.L2DecayLoss
+			count++
+
+			absError += math.Abs(tdError)
+			sumError += math.Abs(tdError)
+			maxError = math.Max(maxError, math.Abs(tdError))
+			off += b.actionDims()[d]
+		}
+
+		e.Priority = absError/float64(len(qs)) + b.PriorityEps
+		b.priorities.Set(re, math.Pow(e.Priority, b.PriorityAlpha))
+		b.maxPriority = math.Max(b.maxPriority, e.Priority)
+	}
+
+	return LearnStats{
+		Loss:           avcost / float64(count),
+		MeanAbsTDError: sumError / float64(count),
+		MaxAbsTDError:  maxError,
+	}
+}
+
+func (b *Brain) String() string {
+	s := b.Stats()
+
+	return fmt.Sprintf(`experience replay size: %d
+exploration epsilon: %f
+age: %d
+average Q-learning loss: %f
+smooth-ish reward: %f
+`, s.ExperienceCount, s.Epsilon, s.Age, s.AverageLoss, s.AverageReward)
+}
@@ -0,0 +1,1958 @@
+package deepqlearn_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/deepqlearn"
+)
+
+// it should repeat its random choices after its state is restored
+func TestBrainRandState(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.RandSource = convnet.NewRandSource(42)
+
+	b, err := deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := b.RandState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make([]int, 50)
+	for i := range first {
+		first[i] = b.RandomAction()
+	}
+
+	if err := b.SetRandState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range first {
+		if a := b.RandomAction(); a != expected {
+			t.Fatalf("expected action %d to be %d after restoring, but it is %d", i, expected, a)
+		}
+	}
+
+	opt.Rand = rand.New(rand.NewSource(0))
+	b, err = deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RandState(); err == nil {
+		t.Error("expected an error from a brain with its own Rand")
+	}
+	explicit := b
+
+	// without either, the brain uses the same source as it always has
+	opt.Rand, opt.RandSource = nil, nil
+	b, err = deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RandState(); err == nil {
+		t.Error("expected an error from a brain without a RandSource")
+	}
+	for i := 0; i < 50; i++ {
+		if a, expected := b.RandomAction(), explicit.RandomAction(); a != expected {
+			t.Fatalf("expected default action %d to be %d, but it is %d", i, expected, a)
+		}
+	}
+}
+
+// meanMaxValue trains a brain on a deterministic MDP where every reward is
+// zero, so that the true value of every action is zero, and returns the
+// average over the states of the largest estimated action value.
+func meanMaxValue(t *testing.T, syncInterval int, double bool, seed int64) float64 {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.TemporalWindow = 0
+	opt.ExperienceSize = 1000
+	opt.StartLearnThreshold = 50
+	opt.Gamma = 0.95
+	opt.HiddenLayerSizes = []int{20}
+	opt.TDTrainerOptions.BatchSize = 8
+	opt.TDTrainerOptions.LearningRate = 0.01
+	opt.TDTrainerOptions.L2Decay = 0
+	opt.TargetSyncInterval = syncInterval
+	opt.DoubleDQN = double
+	opt.RandSource = convnet.NewRandSource(seed)
+
+	const numStates, numActions = 8, 10
+
+	b, err := deepqlearn.NewBrain(numStates, numActions, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(2))
+	states := make([][]float64, numStates)
+	for i := range states {
+		states[i] = make([]float64, numStates)
+		for j := range states[i] {
+			states[i][j] = r.Float64()*2 - 1
+		}
+	}
+
+	for step := 0; step < 3000; step++ {
+		b.Forward(states[step%numStates])
+		b.Backward(0)
+	}
+
+	total := 0.0
+	for _, s := range states {
+		_, v := b.Policy(s)
+		total += v
+	}
+
+	return total / numStates
+}
+
+// a target net, and even more so double DQN, should overestimate the
+// values less than bootstrapping from the value net itself
+func TestDoubleDQN(t *testing.T) {
+	plain := meanMaxValue(t, 0, false, 0)
+	target := meanMaxValue(t, 100, false, 0)
+	double := meanMaxValue(t, 100, true, 0)
+	t.Logf("plain: %g, target: %g, double: %g", plain, target, double)
+
+	if target >= plain {
+		t.Errorf("expected a target net to reduce overestimation, but got %g (plain) and %g (target)", plain, target)
+	}
+	if double >= plain || double > target {
+		t.Errorf("expected double DQN to reduce overestimation, but got %g (plain), %g (target), and %g (double)", plain, target, double)
+	}
+}
+
+// the target net should be saved with the brain
+func TestTargetNetJSON(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.TargetSyncInterval = 10
+	opt.DoubleDQN = true
+
+	b, err := deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded deepqlearn.Brain
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.TargetNet == nil {
+		t.Fatal("expected the target net to be loaded")
+	}
+	if loaded.TargetSyncInterval != 10 || !loaded.DoubleDQN {
+		t.Error("expected the target net options to be loaded")
+	}
+
+	expected, _ := json.Marshal(b.TargetNet)
+	if actual, _ := json.Marshal(loaded.TargetNet); string(actual) != string(expected) {
+		t.Error("expected the loaded target net to match")
+	}
+}
+
+// it should find slots in proportion to their weights
+func TestSumTree(t *testing.T) {
+	weights := []float64{1, 0, 3, 6, 2}
+	tree := deepqlearn.NewSumTree(len(weights))
+	for i, w := range weights {
+		tree.Set(i, w)
+	}
+
+	if total := tree.Total(); total != 12 {
+		t.Errorf("expected total of 12, but got %g", total)
+	}
+	if w := tree.Get(3); w != 6 {
+		t.Errorf("expected slot 3 to have weight 6, but got %g", w)
+	}
+
+	r := rand.New(rand.NewSource(0))
+	const samples = 120000
+	counts := make([]int, len(weights))
+	for i := 0; i < samples; i++ {
+		counts[tree.Find(r.Float64()*tree.Total())]++
+	}
+
+	for i, w := range weights {
+		expected := w / 12
+		actual := float64(counts[i]) / samples
+		if math.Abs(actual-expected) > 0.01 {
+			t.Errorf("expected slot %d to be found with probability %g, but got %g", i, expected, actual)
+		}
+	}
+	if counts[1] != 0 {
+		t.Errorf("expected empty slot to never be found, but it was found %d times", counts[1])
+	}
+
+	// the very end of the range belongs to the last non-empty slot
+	if i := tree.Find(math.Nextafter(12, 0)); i != 4 {
+		t.Errorf("expected the end of the range to be in slot 4, but got %d", i)
+	}
+
+	tree.Set(3, 0)
+	if total := tree.Total(); total != 6 {
+		t.Errorf("expected total of 6 after clearing slot 3, but got %g", total)
+	}
+	if i := tree.Find(4.5); i != 4 {
+		t.Errorf("expected 4.5 to be in slot 4, but got %d", i)
+	}
+}
+
+// it should update the priority of each experience it learns from
+func TestPrioritizedReplay(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.TemporalWindow = 0
+	opt.ExperienceSize = 100
+	opt.StartLearnThreshold = 20
+	opt.HiddenLayerSizes = []int{8}
+	opt.TDTrainerOptions.BatchSize = 4
+	opt.PrioritizedReplay = true
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for step := 0; step < 20; step++ {
+		b.Forward([]float64{r.Float64(), r.Float64()})
+		b.Backward(r.Float64())
+	}
+
+	for i, e := range b.Experience {
+		if e.Priority != 1 {
+			t.Fatalf("expected experience %d to start with priority 1 before learning, but it has %g", i, e.Priority)
+		}
+	}
+
+	for step := 0; step < 50; step++ {
+		b.Forward([]float64{r.Float64(), r.Float64()})
+		b.Backward(r.Float64())
+	}
+
+	updated := 0
+	for i, e := range b.Experience {
+		if e.Priority <= 0 {
+			t.Errorf("expected experience %d to have a positive priority, but it has %g", i, e.Priority)
+		}
+		if e.Priority != 1 {
+			updated++
+		}
+	}
+	if updated == 0 {
+		t.Error("expected some priorities to be updated to their TD error")
+	}
+	t.Logf("%d of %d priorities updated", updated, len(b.Experience))
+
+	if loss := b.AverageLossWindow.Average(); math.IsNaN(loss) || loss <= 0 {
+		t.Errorf("expected a positive average loss, but got %g", loss)
+	}
+}
+
+// cliffWalk trains a brain on a 4x3 grid. The agent starts in the bottom
+// left corner and is rewarded for reaching the bottom right corner, but
+// the two cells between them are a cliff. Either way, it goes back to the
+// start. It returns the rows visited by the greedy policy on the way to
+// the goal.
+func cliffWalk(t *testing.T, algorithm deepqlearn.Algorithm, seed int64) []int {
+	const width, height = 4, 3
+
+	opt := deepqlearn.DefaultBrainOptions
+	opt.TemporalWindow = 0
+	opt.ExperienceSize = 500
+	opt.StartLearnThreshold = 100
+	opt.Gamma = 0.9
+	opt.LearningStepsBurnin = 5000
+	opt.LearningStepsTotal = 15000
+	opt.EpsilonMin = 0.2
+	opt.EpsilonTestTime = 0
+	opt.TDTrainerOptions.BatchSize = 8
+	opt.TDTrainerOptions.LearningRate = 0.05
+	opt.TDTrainerOptions.L2Decay = 0
+	opt.Algorithm = algorithm
+	opt.RandSource = convnet.NewRandSource(seed)
+
+	b, err := deepqlearn.NewBrain(width*height, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y := 0, height-1
+	state := func() []float64 {
+		s := make([]float64, width*height)
+		s[y*width+x] = 1
+		return s
+	}
+	move := func(action int) float64 {
+		switch action {
+		case 0:
+			y = max(y-1, 0)
+		case 1:
+			y = min(y+1, height-1)
+		case 2:
+			x = max(x-1, 0)
+		case 3:
+			x = min(x+1, width-1)
+		}
+
+		if y == height-1 && x > 0 && x < width-1 {
+			x, y = 0, height-1
+			return -100
+		}
+		if y == height-1 && x == width-1 {
+			x, y = 0, height-1
+			return 20
+		}
+		return -1
+	}
+
+	for step := 0; step < 30000; step++ {
+		b.Backward(move(b.Forward(state())))
+	}
+
+	b.Learning = false
+	x, y = 0, height-1
+
+	var rows []int
+	for step := 0; step < 20; step++ {
+		action := b.Forward(state())
+		if move(action) != -1 {
+			return rows
+		}
+		rows = append(rows, y)
+	}
+
+	t.Errorf("greedy policy did not reach the goal: %v", rows)
+	return rows
+}
+
+// SARSA should learn to keep away from the cliff while exploring, but
+// Q-learning should learn the shortest path right next to it
+func TestSARSA(t *testing.T) {
+	minRow := func(rows []int) int {
+		m := len(rows)
+		for _, r := range rows {
+			m = min(m, r)
+		}
+		return m
+	}
+
+	q := cliffWalk(t, deepqlearn.QLearning, 0)
+	sarsa := cliffWalk(t, deepqlearn.SARSA, 0)
+	t.Logf("Q-learning: %v, SARSA: %v", q, sarsa)
+
+	if minRow(q) != 1 {
+		t.Errorf("expected Q-learning to walk along the cliff, but its path is in rows %v", q)
+	}
+	if minRow(sarsa) != 0 {
+		t.Errorf("expected SARSA to take the safe path, but its path is in rows %v", sarsa)
+	}
+
+	// the next action is saved with the experience
+	opt := deepqlearn.DefaultBrainOptions
+	opt.Algorithm = deepqlearn.SARSA
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []int
+	for step := 0; step < 10; step++ {
+		actions = append(actions, b.Forward([]float64{0, 1}))
+		b.Backward(0)
+	}
+	for i, e := range b.Experience {
+		if e.Action1 != actions[i+opt.TemporalWindow+1] {
+			t.Errorf("expected experience %d to have next action %d, but it has %d", i, actions[i+opt.TemporalWindow+1], e.Action1)
+		}
+	}
+
+	data, err := json.Marshal(b.Experience[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e deepqlearn.Experience
+	if err := json.Unmarshal(data, &e); err != nil || e.Action1 != b.Experience[0].Action1 {
+		t.Errorf("expected next action to survive JSON, but got %d (%v)", e.Action1, err)
+	}
+}
+
+// it should blend the value net into the target net
+func TestSoftTargetUpdate(t *testing.T) {
+	params := func(n *convnet.Net) []float64 {
+		var p []float64
+		for _, pg := range n.ParamsAndGrads() {
+			p = append(p, pg.Params...)
+		}
+		return p
+	}
+
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.Tau = 0.25
+
+	b, err := deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.TargetNet == nil {
+		t.Fatal("expected a target net when tau is set")
+	}
+
+	// make the two nets differ
+	for _, pg := range b.TargetNet.ParamsAndGrads() {
+		for j := range pg.Params {
+			pg.Params[j] = float64(j%5) - 2
+		}
+	}
+
+	value, target := params(&b.ValueNet), params(b.TargetNet)
+	b.SoftUpdateTargetNet()
+	for i, w := range params(b.TargetNet) {
+		if expected := 0.25*value[i] + 0.75*target[i]; math.Abs(w-expected) > 1e-12 {
+			t.Fatalf("expected blended weight %d to be %g, but it is %g", i, expected, w)
+		}
+	}
+
+	// tau of 1 is a hard copy
+	b.Tau = 1
+	b.SoftUpdateTargetNet()
+	expected, _ := json.Marshal(&b.ValueNet)
+	if actual, _ := json.Marshal(b.TargetNet); string(actual) != string(expected) {
+		t.Error("expected tau of 1 to copy the value net")
+	}
+
+	// every learning step should move the target net
+	opt.TemporalWindow = 0
+	opt.StartLearnThreshold = 5
+	opt.TDTrainerOptions.BatchSize = 1
+	b, err = deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(0))
+	for step := 0; step < 10; step++ {
+		before := params(b.TargetNet)
+		b.Forward([]float64{r.Float64(), r.Float64(), r.Float64()})
+		b.Backward(r.Float64())
+
+		moved := false
+		for i, w := range params(b.TargetNet) {
+			moved = moved || w != before[i]
+		}
+		if learning := len(b.Experience) > opt.StartLearnThreshold; moved != learning {
+			t.Errorf("step %d: expected target net to move only while learning, but moved is %v", step, moved)
+		}
+	}
+
+	for _, tau := range []float64{-0.1, 1.5} {
+		opt.Tau = tau
+		if _, err := deepqlearn.NewBrain(3, 4, opt); err == nil {
+			t.Errorf("expected an error for tau %g", tau)
+		}
+	}
+}
+
+// the built-in schedules should give the documented values, and the brain
+// should never explore less than EpsilonMin while learning
+func TestEpsilonSchedule(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		schedule func(int) float64
+		ages     []int
+		expected []float64
+	}{
+		{"linear", deepqlearn.LinearEpsilon(1, 0.1, 100, 1100), []int{0, 100, 600, 1100, 5000}, []float64{1, 1, 0.55, 0.1, 0.1}},
+		{"exponential", deepqlearn.ExponentialEpsilon(1, 0.05, 0.5), []int{0, 1, 2, 4, 5, 100}, []float64{1, 0.5, 0.25, 0.0625, 0.05, 0.05}},
+		{"piecewise", deepqlearn.PiecewiseEpsilon([]int{10, 20}, []float64{1, 0.5, 0.1}), []int{0, 9, 10, 19, 20, 1000}, []float64{1, 1, 0.5, 0.5, 0.1, 0.1}},
+	} {
+		for i, age := range c.ages {
+			if eps := c.schedule(age); math.Abs(eps-c.expected[i]) > 1e-12 {
+				t.Errorf("%s: expected epsilon %g at age %d, but got %g", c.name, c.expected[i], age, eps)
+			}
+		}
+	}
+
+	opt := deepqlearn.DefaultBrainOptions
+	opt.EpsilonMin = 0.2
+	opt.EpsilonTestTime = 0.01
+	opt.EpsilonSchedule = deepqlearn.PiecewiseEpsilon([]int{5}, []float64{0.9, 0})
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := 0; step < 10; step++ {
+		b.Forward([]float64{0, 1})
+
+		expected := 0.9
+		if b.Age >= 5 {
+			expected = 0.2 // the schedule says 0, but EpsilonMin is the floor
+		}
+		if step > 0 && b.Epsilon != expected {
+			t.Errorf("expected epsilon %g at age %d, but got %g", expected, b.Age, b.Epsilon)
+		}
+
+		b.Backward(0)
+	}
+
+	b.Learning = false
+	b.Forward([]float64{0, 1})
+	if b.Epsilon != 0.01 {
+		t.Errorf("expected test time epsilon 0.01, but got %g", b.Epsilon)
+	}
+
+	// the schedule is not saved, but the rest of the brain is
+	if _, err := json.Marshal(b); err != nil {
+		t.Errorf("expected a brain with a schedule to be saved, but got %v", err)
+	}
+}
+
+// it should sample actions from the softmax of their values
+func TestBoltzmannExploration(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.Exploration = deepqlearn.Boltzmann
+	opt.Temperature = 0.5
+	opt.RandSource = convnet.NewRandSource(3)
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// huge values must not overflow
+	values := []float64{1000, 1000.5, 999}
+	expected := make([]float64, len(values))
+	sum := 0.0
+	for k, v := range values {
+		expected[k] = math.Exp((v - 1000.5) / 0.5)
+		sum += expected[k]
+	}
+
+	const samples = 100000
+	counts := make([]int, len(values))
+	for i := 0; i < samples; i++ {
+		counts[b.BoltzmannAction(values, 0.5)]++
+	}
+	for k := range values {
+		p := expected[k] / sum
+		if actual := float64(counts[k]) / samples; math.Abs(actual-p) > 0.01 {
+			t.Errorf("expected action %d with probability %g, but got %g", k, p, actual)
+		}
+	}
+
+	if a := b.BoltzmannAction(values, 0); a != 1 {
+		t.Errorf("expected zero temperature to pick the best action 1, but got %d", a)
+	}
+
+	// Forward should use the value net's values and the temperature
+	// schedule
+	b.TemperatureSchedule = func(age int) float64 { return 0 }
+	state := []float64{0.3, -0.7}
+	b.Forward(state)
+	for i := 0; i < 20; i++ {
+		netInput := b.NetInput(state)
+		best, _ := b.Policy(netInput)
+		if a := b.Forward(state); a != best {
+			t.Fatalf("expected the best action %d at zero temperature, but got %d", best, a)
+		}
+	}
+
+	b.TemperatureSchedule = nil
+	b.Temperature = 1e6
+	counts = make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		counts[b.Forward(state)]++
+	}
+	for k, c := range counts {
+		if c < 800 {
+			t.Errorf("expected a high temperature to pick every action about as often, but action %d was picked %d times", k, c)
+		}
+	}
+}
+
+// it should copy the value net of one brain to another
+func TestExportImportWeights(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+
+	opt.RandSource = convnet.NewRandSource(1)
+	src, err := deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt.RandSource = convnet.NewRandSource(2)
+	dst, err := deepqlearn.NewBrain(3, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := src.ExportWeights()
+	if n := src.ValueNet.NumParameters(); len(w) != n {
+		t.Fatalf("expected %d weights, but got %d", n, len(w))
+	}
+
+	if err := dst.ImportWeights(w[1:]); err == nil {
+		t.Error("expected an error for the wrong number of weights")
+	}
+
+	if err := dst.ImportWeights(w); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 20; i++ {
+		s := make([]float64, src.NetInputs)
+		for j := range s {
+			s[j] = r.NormFloat64()
+		}
+
+		a1, v1 := src.Policy(s)
+		a2, v2 := dst.Policy(s)
+		if a1 != a2 || v1 != v2 {
+			t.Errorf("expected action %d with value %g, but got action %d with value %g", a1, v1, a2, v2)
+		}
+	}
+}
+
+// it should store clipped and standardized rewards, but report raw ones
+func TestRewardTransform(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.StartLearnThreshold = 1000 // only collect experiences
+	opt.ClipRewards = true
+	opt.RewardMin = -10
+	opt.RewardMax = 10
+	opt.NormalizeRewards = true
+
+	b, err := deepqlearn.NewBrain(2, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewards := []float64{1, -1e6, 3, 0.5, 1e9, -2, 7, 4}
+
+	var stored []float64
+	var sum, sumSq float64
+	for i, r := range rewards {
+		b.Forward([]float64{float64(i), 1})
+		b.Backward(r)
+
+		if b.LatestReward != r {
+			t.Errorf("expected latest reward to be raw reward %g, but it is %g", r, b.LatestReward)
+		}
+
+		// the same running stats, computed the slow way
+		c := math.Max(-10, math.Min(10, r))
+		sum += c
+		sumSq += c * c
+		n := float64(i + 1)
+		mean := sum / n
+		std := math.Sqrt(sumSq/n - mean*mean)
+
+		actualMean, actualStd := b.RewardStats()
+		if math.Abs(actualMean-mean) > 1e-9 || math.Abs(actualStd-std) > 1e-9 {
+			t.Errorf("after %d rewards, expected mean %g and std %g, but got %g and %g", i+1, mean, std, actualMean, actualStd)
+		}
+
+		if std < 1e-8 {
+			std = 1
+		}
+		stored = append(stored, (c-mean)/std)
+	}
+
+	// each experience holds the reward given on the step before it was
+	// stored, starting with the second
+	if len(b.Experience) != len(rewards)-2 {
+		t.Fatalf("expected %d experiences, but got %d", len(rewards)-2, len(b.Experience))
+	}
+	for k, e := range b.Experience {
+		if math.Abs(e.Reward0-stored[k+1]) > 1e-9 {
+			t.Errorf("expected experience %d to have reward %g, but it has %g", k, stored[k+1], e.Reward0)
+		}
+		if math.Abs(e.Reward0) > 3 {
+			t.Errorf("expected experience %d to have a standardized reward, but it has %g", k, e.Reward0)
+		}
+	}
+
+	for i, r := range b.AverageRewardWindow.V {
+		if r != rewards[i] {
+			t.Errorf("expected the average reward window to see raw reward %g, but it has %g", rewards[i], r)
+		}
+	}
+
+	opt.RewardMin, opt.RewardMax = 1, -1
+	if _, err := deepqlearn.NewBrain(2, 2, opt); err == nil {
+		t.Error("expected an error for an empty reward range")
+	}
+}
+
+// it should forget the oldest experiences first, even after being saved
+func TestFIFOReplacement(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.ExperienceSize = 10
+	opt.StartLearnThreshold = 9
+	opt.LearnEvery = 1000 // only collect experiences
+	opt.ReplacementPolicy = deepqlearn.FIFOReplacement
+
+	b, err := deepqlearn.NewBrain(1, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first input of State1 is the state given to Forward, so each
+	// experience can be traced back to its step
+	step := func(b *deepqlearn.Brain, i int) {
+		b.Forward([]float64{float64(i)})
+		b.Backward(0)
+	}
+	check := func(b *deepqlearn.Brain, last int) {
+		t.Helper()
+
+		seen := make(map[int]bool)
+		for _, e := range b.Experience {
+			seen[int(e.State1[0])] = true
+		}
+		for i := last - opt.ExperienceSize + 1; i <= last; i++ {
+			if !seen[i] {
+				t.Errorf("expected the experience from step %d to be remembered", i)
+			}
+		}
+		if len(seen) != opt.ExperienceSize {
+			t.Errorf("expected %d different experiences, but got %d", opt.ExperienceSize, len(seen))
+		}
+	}
+
+	// the first two steps do not make experiences, so this fills the
+	// memory twice and then some
+	for i := 0; i < 2+2*opt.ExperienceSize+3; i++ {
+		step(b, i)
+	}
+	check(b, 2+2*opt.ExperienceSize+2)
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded deepqlearn.Brain
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	loaded.Rand = rand.New(rand.NewSource(0))
+
+	if loaded.ReplacementPolicy != deepqlearn.FIFOReplacement || loaded.ExperienceCursor != b.ExperienceCursor {
+		t.Fatalf("expected FIFO replacement at cursor %d to be loaded, but got policy %d at cursor %d", b.ExperienceCursor, loaded.ReplacementPolicy, loaded.ExperienceCursor)
+	}
+
+	for i := 2 + 2*opt.ExperienceSize + 3; i < 3*opt.ExperienceSize; i++ {
+		step(&loaded, i)
+	}
+	check(&loaded, 3*opt.ExperienceSize-1)
+}
+
+// it should only train every LearnEvery steps, or when asked to
+func TestLearnEvery(t *testing.T) {
+	// makes a brain that has taken steps steps and records every example
+	// its trainer sees
+	run := func(learnEvery, steps int) (*deepqlearn.Brain, *convnet.History) {
+		opt := deepqlearn.DefaultBrainOptions
+		opt.HiddenLayerSizes = []int{4}
+		opt.StartLearnThreshold = 5
+		opt.LearnEvery = learnEvery
+		opt.TDTrainerOptions.BatchSize = 8
+
+		b, err := deepqlearn.NewBrain(2, 2, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history := b.TDTrainer.EnableHistory(0)
+
+		for i := 0; i < steps; i++ {
+			b.Forward([]float64{float64(i), 1})
+			b.Backward(1)
+		}
+
+		return b, history
+	}
+
+	// experiences are stored from step 3, and there are more than 5 of
+	// them from step 8
+	for _, c := range []struct{ learnEvery, batches int }{
+		{0, 23},
+		{1, 23},
+		{2, 12},
+		{5, 5},
+		{100, 0},
+	} {
+		b, history := run(c.learnEvery, 30)
+		if n := history.Len(); n != c.batches*8 {
+			t.Errorf("LearnEvery %d: expected %d examples in %d batches, but got %d", c.learnEvery, c.batches*8, c.batches, n)
+		}
+		if len(b.Experience) != 28 {
+			t.Errorf("LearnEvery %d: expected every step to store an experience, but got %d", c.learnEvery, len(b.Experience))
+		}
+	}
+
+	b, history := run(100, 30)
+	for i := 0; i < 3; i++ {
+		if !b.Learn() {
+			t.Error("expected Learn to train")
+		}
+	}
+	if n := history.Len(); n != 3*8 {
+		t.Errorf("expected 3 calls to Learn to train on %d examples, but got %d", 3*8, n)
+	}
+
+	b, history = run(100, 7)
+	if b.Learn() || history.Len() != 0 {
+		t.Error("expected Learn not to train with too few experiences")
+	}
+}
+
+// it should not repeat experiences within a batch unless it has to
+func TestSampleWithoutReplacement(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.StartLearnThreshold = 3
+	opt.LearnEvery = 1000 // learn only when asked
+	opt.SampleWithoutReplacement = true
+	opt.TDTrainerOptions.BatchSize = 8
+
+	b, err := deepqlearn.NewBrain(1, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first input of State0 is the step the experience was made on,
+	// and the input layer's output is what the trainer learned from
+	var batch []int
+	if err := b.ValueNet.RegisterBackwardHook(0, func(l convnet.Layer) {
+		batch = append(batch, int(l.Output().W[0]))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	step := 0
+	addExperiences := func(n int) {
+		for i := 0; i < n; i++ {
+			b.Forward([]float64{float64(step)})
+			b.Backward(0)
+			step++
+		}
+	}
+
+	// 6 steps make 4 experiences, so each must be used twice
+	addExperiences(6)
+	batch = nil
+	if !b.Learn() {
+		t.Fatal("expected Learn to train")
+	}
+	counts := make(map[int]int)
+	for _, s := range batch {
+		counts[s]++
+	}
+	if len(counts) != 4 {
+		t.Errorf("expected all 4 experiences in a batch of 8, but got %v", batch)
+	}
+	for s, c := range counts {
+		if c != 2 {
+			t.Errorf("expected experience from step %d to be used twice, but it was used %d times", s, c)
+		}
+	}
+
+	addExperiences(20)
+	for i := 0; i < 50; i++ {
+		batch = nil
+		b.Learn()
+
+		seen := make(map[int]bool)
+		for _, s := range batch {
+			if seen[s] {
+				t.Fatalf("expected no repeats, but got %v", batch)
+			}
+			seen[s] = true
+		}
+		if len(batch) != 8 {
+			t.Fatalf("expected a batch of 8, but got %d", len(batch))
+		}
+	}
+}
+
+// it should report its progress as a struct and as JSON
+func TestBrainStats(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.StartLearnThreshold = 5
+
+	b, err := deepqlearn.NewBrain(2, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 30; i++ {
+		b.Forward([]float64{float64(i), 1})
+		b.Backward(2)
+	}
+
+	stats := b.Stats()
+	if stats.Age != 30 || stats.ForwardPasses != 30 || stats.ExperienceCount != 28 || !stats.IsLearning {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Epsilon != b.Epsilon {
+		t.Errorf("expected epsilon %g, but got %g", b.Epsilon, stats.Epsilon)
+	}
+	if stats.AverageReward != 2 {
+		t.Errorf("expected average reward 2, but got %g", stats.AverageReward)
+	}
+	if stats.AverageLoss != b.AverageLossWindow.Average() || stats.AverageLoss < 0 {
+		t.Errorf("expected average loss %g, but got %g", b.AverageLossWindow.Average(), stats.AverageLoss)
+	}
+
+	data, err := b.MarshalStatsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"age", "epsilon", "experience_count", "experience_capacity", "average_loss", "average_reward", "forward_passes", "is_learning", "latest_reward", "learning_rate", "gamma", "action_values"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("expected field %q in %s", name, data)
+		}
+	}
+
+	var decoded deepqlearn.BrainStats
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, stats) {
+		t.Errorf("expected %+v to survive JSON, but got %+v", stats, decoded)
+	}
+
+	// String prints the same numbers
+	str := b.String()
+	for _, line := range []string{
+		fmt.Sprintf("experience replay size: %d\n", stats.ExperienceCount),
+		fmt.Sprintf("exploration epsilon: %f\n", stats.Epsilon),
+		fmt.Sprintf("age: %d\n", stats.Age),
+		fmt.Sprintf("average Q-learning loss: %f\n", stats.AverageLoss),
+		fmt.Sprintf("smooth-ish reward: %f\n", stats.AverageReward),
+	} {
+		if !strings.Contains(str, line) {
+			t.Errorf("expected %q in %q", line, str)
+		}
+	}
+
+	// the action values of a greedy choice are kept
+	b.Learning = false
+	b.EpsilonTestTime = 0
+	state := []float64{3, 1}
+	_, want := b.PolicyValues(b.NetInput(state))
+	want = append([]float64(nil), want...)
+	b.Forward(state)
+	b.Backward(-1)
+
+	stats = b.Stats()
+	if !reflect.DeepEqual(stats.ActionValues, want) {
+		t.Errorf("expected action values %v, but got %v", want, stats.ActionValues)
+	}
+	if stats.LatestReward != -1 || stats.IsLearning {
+		t.Errorf("expected latest reward -1 and not learning, but got %+v", stats)
+	}
+}
+
+// it should never choose an action the mask does not allow
+func TestActionMask(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.StartLearnThreshold = 5
+	// the only valid action is never chosen at random without a mask
+	opt.RandomActionDistribution = []float64{0.5, 0.5, 0}
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []bool{false, false, true}
+	r := rand.New(rand.NewSource(1))
+	state := func() []float64 { return []float64{r.NormFloat64(), r.NormFloat64()} }
+
+	// exploring: epsilon is 1 during burnin
+	for i := 0; i < 100; i++ {
+		if a := b.ForwardMasked(state(), valid); a != 2 {
+			t.Fatalf("step %d: expected action 2 while exploring, but got %d", i, a)
+		}
+		b.Backward(r.Float64())
+	}
+	if b.Epsilon != 1 {
+		t.Fatalf("expected to be exploring, but epsilon is %g", b.Epsilon)
+	}
+
+	for _, e := range b.Experience {
+		if len(e.Valid1) != 3 || e.Valid1[0] || e.Valid1[1] || !e.Valid1[2] {
+			t.Fatalf("expected experiences to remember the mask, but got %v", e.Valid1)
+		}
+	}
+
+	// exploiting, whichever action the net prefers
+	b.Learning = false
+	b.EpsilonTestTime = 0
+	for k := 0; k < 3; k++ {
+		valid := make([]bool, 3)
+		valid[k] = true
+
+		for i := 0; i < 50; i++ {
+			if a := b.ForwardMasked(state(), valid); a != k {
+				t.Fatalf("expected the only valid action %d while exploiting, but got %d", k, a)
+			}
+		}
+	}
+
+	// the mask is copied, so changing it later does not change history
+	valid = []bool{true, true, true}
+	b.Learning = true
+	b.ForwardMasked(state(), valid)
+	b.Backward(0)
+	b.ForwardMasked(state(), valid)
+	valid[0] = false
+	b.Backward(0)
+	if last := b.Experience[len(b.Experience)-1]; !last.Valid1[0] {
+		t.Error("expected the brain to keep its own copy of the mask")
+	}
+
+	// also when exploring with Boltzmann
+	b.Exploration = deepqlearn.Boltzmann
+	b.Temperature = 1e6
+	for i := 0; i < 50; i++ {
+		if a := b.ForwardMasked(state(), []bool{true, false, false}); a != 0 {
+			t.Fatalf("expected action 0 with Boltzmann exploration, but got %d", a)
+		}
+		b.Backward(0)
+	}
+}
+
+// it should choose and learn each action dimension separately
+func TestBrainMulti(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.TemporalWindow = 1
+	opt.StartLearnThreshold = 50
+	opt.LearningStepsBurnin = 200
+	opt.LearningStepsTotal = 1000
+
+	if _, err := deepqlearn.NewBrainMulti(2, []int{3, 0}, opt); err == nil {
+		t.Error("expected an error for an empty action dimension")
+	}
+
+	b, err := deepqlearn.NewBrainMulti(2, []int{3, 2}, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one output per choice, not per combination
+	if b.NumActions != 5 {
+		t.Errorf("expected 5 outputs, but got %d", b.NumActions)
+	}
+	if want := 2 + 5 + 2; b.NetInputs != want {
+		t.Errorf("expected %d inputs, but got %d", want, b.NetInputs)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	state := func() []float64 { return []float64{r.NormFloat64(), r.NormFloat64()} }
+
+	// the first dimension is rewarded for matching the sign of the first
+	// input, and the second for choosing 1
+	for i := 0; i < 2000; i++ {
+		s := state()
+
+		a := b.ForwardMulti(s)
+		if len(a) != 2 || a[0] < 0 || a[0] >= 3 || a[1] < 0 || a[1] >= 2 {
+			t.Fatalf("step %d: action %v out of range", i, a)
+		}
+
+		reward := 0.0
+		if (s[0] > 0) == (a[0] == 2) {
+			reward += 0.5
+		}
+		if a[1] == 1 {
+			reward += 0.5
+		}
+		b.Backward(reward)
+	}
+
+	b.Learning = false
+	b.EpsilonTestTime = 0
+	correct := 0
+	for i := 0; i < 100; i++ {
+		if a := b.ForwardMulti(state()); a[1] == 1 {
+			correct++
+		}
+	}
+	if correct < 90 {
+		t.Errorf("expected the second dimension to learn to choose 1, but it did %d times out of 100", correct)
+	}
+
+	// masks have a block for each dimension
+	valid := []bool{false, true, false, true, false}
+	for i := 0; i < 20; i++ {
+		if a := b.ForwardMasked(state(), valid); a != 1 {
+			t.Fatalf("expected the only valid combination, but got %d", a)
+		}
+	}
+
+	b.Learning = true
+	for i := 0; i < 20; i++ {
+		if a := b.RandomActionMasked(valid); a != 1 {
+			t.Fatalf("expected the only valid combination at random, but got %d", a)
+		}
+	}
+}
+
+// it should evaluate without disturbing learning
+func TestAct(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.TemporalWindow = 2
+	opt.StartLearnThreshold = 5
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	state := func() []float64 { return []float64{r.NormFloat64(), r.NormFloat64()} }
+
+	for i := 0; i < 20; i++ {
+		b.Forward(state())
+		b.Backward(r.Float64())
+	}
+
+	age, experience, epsilon := b.Age, len(b.Experience), b.Epsilon
+	stateWindow := append([][]float64(nil), b.StateWindow...)
+	actionWindow := append([]int(nil), b.ActionWindow...)
+	rewardWindow := append([]float64(nil), b.RewardWindow...)
+
+	b.EpsilonTestTime = 0
+	for i := 0; i < 50; i++ {
+		if a := b.Act(state()); a < 0 || a >= 3 {
+			t.Fatalf("action %d out of range", a)
+		}
+	}
+
+	if b.Age != age || len(b.Experience) != experience || b.Epsilon != epsilon {
+		t.Errorf("expected age %d, %d experiences, and epsilon %g, but got %d, %d, and %g", age, experience, epsilon, b.Age, len(b.Experience), b.Epsilon)
+	}
+	if !reflect.DeepEqual(b.StateWindow, stateWindow) || !reflect.DeepEqual(b.ActionWindow, actionWindow) || !reflect.DeepEqual(b.RewardWindow, rewardWindow) {
+		t.Error("expected the training windows to be untouched")
+	}
+
+	// with the training windows as history, it agrees with the policy
+	s := state()
+	want, _ := b.Policy(b.NetInput(s))
+	if a, err := b.ActFrom(b.StateWindow, b.ActionWindow, s); err != nil || a != want {
+		t.Errorf("expected action %d, but got %d (%v)", want, a, err)
+	}
+	if _, err := b.ActFrom(b.StateWindow[:1], b.ActionWindow[:1], s); err == nil {
+		t.Error("expected an error for too little history")
+	}
+
+	// after a reset, no experience spans the two episodes
+	b.ResetWindows()
+	if b.WindowFill != 0 || b.EvalWindowFill != 0 {
+		t.Errorf("expected empty windows, but they have %d and %d passes", b.WindowFill, b.EvalWindowFill)
+	}
+	experience = len(b.Experience)
+	for i := 0; i < opt.TemporalWindow+1; i++ {
+		b.Forward(state())
+		b.Backward(0)
+	}
+	if len(b.Experience) != experience {
+		t.Errorf("expected no new experience while the window refills, but got %d", len(b.Experience)-experience)
+	}
+	b.Forward(state())
+	b.Backward(0)
+	if len(b.Experience) != experience+1 {
+		t.Errorf("expected one new experience once the window is full, but got %d", len(b.Experience)-experience)
+	}
+}
+
+// it should make a copy that is unaffected by changes to the original
+func TestBrainClone(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.StartLearnThreshold = 5
+	opt.TargetSyncInterval = 10
+
+	b, err := deepqlearn.NewBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	state := func() []float64 { return []float64{r.NormFloat64(), r.NormFloat64()} }
+
+	for i := 0; i < 30; i++ {
+		b.Forward(state())
+		b.Backward(r.Float64())
+	}
+
+	c := b.Clone()
+
+	x := make([]float64, b.NetInputs)
+	for i := range x {
+		x[i] = r.NormFloat64()
+	}
+	_, before := c.PolicyValues(x)
+	before = append([]float64(nil), before...)
+
+	// mutate and train the original
+	w := b.ExportWeights()
+	for i := range w {
+		w[i] += r.NormFloat64()
+	}
+	if err := b.ImportWeights(w); err != nil {
+		t.Fatal(err)
+	}
+	b.SyncTargetNet()
+	for i := 0; i < 30; i++ {
+		b.Forward(state())
+		b.Backward(r.Float64())
+	}
+
+	if _, after := c.PolicyValues(x); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the clone's values %v to be unchanged, but got %v", before, after)
+	}
+	if c.Age != 30 || len(c.Experience) == len(b.Experience) {
+		t.Errorf("expected the clone to keep its own history, but it has age %d and %d experiences", c.Age, len(c.Experience))
+	}
+
+	// the clone explores with its own random numbers
+	d := b.Clone()
+	same := 0
+	for i := 0; i < 20; i++ {
+		if c.RandomAction() == d.RandomAction() {
+			same++
+		}
+	}
+	if same == 20 {
+		t.Error("expected clones to explore independently")
+	}
+
+	// and learns on its own
+	for i := 0; i < 30; i++ {
+		c.Forward(state())
+		c.Backward(r.Float64())
+	}
+	if c.Age != 60 || b.Age != 60 {
+		t.Errorf("expected both brains to be 60 steps old, but they are %d and %d", b.Age, c.Age)
+	}
+}
+
+// it should fit its replay memory into MaxExperienceBytes
+func TestMaxExperienceBytes(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.TemporalWindow = 3
+	opt.ExperienceSize = 1000
+	opt.StartLearnThreshold = 10
+
+	b, err := deepqlearn.NewBrain(50, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eager := b.ExperienceBytes()
+	if eager < 2*8*b.NetInputs {
+		t.Errorf("expected at least %d bytes for both net inputs, but got %d", 2*8*b.NetInputs, eager)
+	}
+	if capacity := b.Stats().ExperienceCapacity; capacity != 1000 {
+		t.Errorf("expected a capacity of 1000 without a limit, but got %d", capacity)
+	}
+
+	opt.MaxExperienceBytes = 40*eager + eager/2
+	if b, err = deepqlearn.NewBrain(50, 4, opt); err != nil {
+		t.Fatal(err)
+	}
+	if capacity := b.Stats().ExperienceCapacity; capacity != 40 {
+		t.Errorf("expected a capacity of 40, but got %d", capacity)
+	}
+	for i := 0; i < 100; i++ {
+		b.Forward(make([]float64, 50))
+		b.Backward(0)
+	}
+	if len(b.Experience) != 40 {
+		t.Errorf("expected 40 experiences, but there are %d", len(b.Experience))
+	}
+
+	opt.CompactExperience = true
+	if b, err = deepqlearn.NewBrain(50, 4, opt); err != nil {
+		t.Fatal(err)
+	}
+	compact := b.ExperienceBytes()
+	if compact >= eager {
+		t.Errorf("expected compact experiences to be smaller than %d bytes, but they are %d", eager, compact)
+	}
+	if capacity := b.Stats().ExperienceCapacity; capacity != opt.MaxExperienceBytes/compact {
+		t.Errorf("expected a capacity of %d, but got %d", opt.MaxExperienceBytes/compact, capacity)
+	}
+
+	// not even enough room to start learning
+	opt.MaxExperienceBytes = 10 * compact
+	if _, err := deepqlearn.NewBrain(50, 4, opt); err == nil {
+		t.Error("expected an error for a memory too small to learn from")
+	}
+}
+
+// compact experiences should replay exactly like full ones
+func TestCompactExperience(t *testing.T) {
+	for _, stacked := range []bool{false, true} {
+		brains := make([]*deepqlearn.Brain, 2)
+		for i := range brains {
+			opt := deepqlearn.DefaultBrainOptions
+			opt.HiddenLayerSizes = []int{4}
+			opt.TemporalWindow = 2
+			opt.StartLearnThreshold = 10
+			opt.TDTrainerOptions.BatchSize = 4
+			opt.CompactExperience = i == 1
+			if stacked {
+				opt.StateSx, opt.StateSy, opt.StateDepth = 2, 2, 1
+			}
+
+			b, err := deepqlearn.NewBrainMulti(4, []int{2, 3}, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			brains[i] = b
+		}
+
+		r := rand.New(rand.NewSource(0))
+		for step := 0; step < 200; step++ {
+			s := make([]float64, 4)
+			for i := range s {
+				s[i] = r.NormFloat64()
+			}
+			reward := r.Float64()
+
+			a0 := brains[0].Forward(s)
+			a1 := brains[1].Forward(s)
+			if a0 != a1 {
+				t.Fatalf("stacked=%v: the brains chose %d and %d at step %d", stacked, a0, a1, step)
+			}
+			brains[0].Backward(reward)
+			brains[1].Backward(reward)
+
+			if step == 100 {
+				brains[0].ResetWindows()
+				brains[1].ResetWindows()
+			}
+		}
+
+		full, compact := brains[0], brains[1]
+		if len(compact.Experience) != len(full.Experience) {
+			t.Fatalf("stacked=%v: expected %d experiences, but there are %d", stacked, len(full.Experience), len(compact.Experience))
+		}
+		for i := range full.Experience {
+			if compact.Experience[i].State0 != nil || compact.Experience[i].States == nil {
+				t.Fatalf("stacked=%v: expected experience %d to be compact", stacked, i)
+			}
+
+			s0, s1 := compact.ExperienceInputs(&compact.Experience[i])
+			if !reflect.DeepEqual(s0, full.Experience[i].State0) || !reflect.DeepEqual(s1, full.Experience[i].State1) {
+				t.Errorf("stacked=%v: experience %d was rebuilt differently", stacked, i)
+			}
+		}
+
+		if !reflect.DeepEqual(full.ExportWeights(), compact.ExportWeights()) {
+			t.Errorf("stacked=%v: expected both brains to learn the same weights", stacked)
+		}
+	}
+}
+
+// it should anneal the learning rate and gamma while learning
+func TestLearningSchedules(t *testing.T) {
+	var steps []deepqlearn.LearnStats
+
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{4}
+	opt.TemporalWindow = 0
+	opt.StartLearnThreshold = 5
+	opt.LearningRateSchedule = deepqlearn.PiecewiseEpsilon([]int{20, 40}, []float64{0.1, 0.01, 0.001})
+	opt.GammaSchedule = deepqlearn.LinearEpsilon(0.5, 0.9, 0, 50)
+	opt.OnLearnStep = func(s deepqlearn.LearnStats) { steps = append(steps, s) }
+
+	b, err := deepqlearn.NewBrain(1, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int][2]float64{
+		1:  {0.1, 0.508},
+		19: {0.1, 0.652},
+		20: {0.01, 0.66},
+		25: {0.01, 0.7},
+		40: {0.001, 0.82},
+		60: {0.001, 0.9},
+	}
+
+	for age := 1; age <= 60; age++ {
+		b.Forward([]float64{0})
+		b.Backward(1)
+
+		want, ok := expected[age]
+		if !ok {
+			continue
+		}
+
+		stats := b.Stats()
+		if stats.LearningRate != want[0] || math.Abs(stats.Gamma-want[1]) > 1e-12 {
+			t.Errorf("expected learning rate %g and gamma %g at age %d, but got %g and %g", want[0], want[1], age, stats.LearningRate, stats.Gamma)
+		}
+		if b.TDTrainer.LearningRate != stats.LearningRate || b.Gamma != stats.Gamma {
+			t.Errorf("expected the brain to use the scheduled values at age %d", age)
+		}
+	}
+
+	for _, s := range steps {
+		if s.LearningRate != opt.LearningRateSchedule(s.Age) || s.Gamma != opt.GammaSchedule(s.Age) {
+			t.Errorf("expected the learning step at age %d to use learning rate %g and gamma %g, but it used %g and %g", s.Age, opt.LearningRateSchedule(s.Age), opt.GammaSchedule(s.Age), s.LearningRate, s.Gamma)
+		}
+	}
+	if len(steps) == 0 {
+		t.Error("expected the brain to learn")
+	}
+}
+
+// it should report every learning step to OnLearnStep
+func TestOnLearnStep(t *testing.T) {
+	for _, prioritized := range []bool{false, true} {
+		var b *deepqlearn.Brain
+		var history []deepqlearn.LearnStats
+
+		opt := deepqlearn.DefaultBrainOptions
+		opt.HiddenLayerSizes = []int{4}
+		opt.TemporalWindow = 0
+		opt.StartLearnThreshold = 10
+		opt.TDTrainerOptions.BatchSize = 4
+		opt.PrioritizedReplay = prioritized
+		opt.OnLearnStep = func(s deepqlearn.LearnStats) {
+			// the brain is not locked
+			if age := b.Stats().Age; s.Age != age {
+				t.Errorf("expected age %d, but got %d", age, s.Age)
+			}
+
+			history = append(history, s)
+		}
+
+		b, err := deepqlearn.NewBrain(1, 2, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := rand.New(rand.NewSource(0))
+		for i := 0; i < 100; i++ {
+			b.Forward([]float64{r.Float64()})
+			b.Backward(r.Float64())
+		}
+
+		// one experience is stored on each call to Backward but the
+		// first, and learning starts when there are more than 10
+		if len(history) != 89 {
+			t.Errorf("prioritized=%v: expected 89 learning steps, but got %d", prioritized, len(history))
+		}
+
+		for i, s := range history {
+			if s.Age != 12+i {
+				t.Errorf("prioritized=%v: expected learning step %d at age %d, but it was at %d", prioritized, i, 12+i, s.Age)
+			}
+			if s.Epsilon != 1 {
+				t.Errorf("prioritized=%v: expected epsilon 1 during burnin, but got %g", prioritized, s.Epsilon)
+			}
+			if !(s.Loss > 0) || !(s.MeanAbsTDError > 0) || s.MaxAbsTDError < s.MeanAbsTDError {
+				t.Errorf("prioritized=%v: unexpected stats %+v", prioritized, s)
+			}
+		}
+
+		if last := history[len(history)-1]; last.Loss != b.AverageLossWindow.V[len(b.AverageLossWindow.V)-1] {
+			t.Errorf("prioritized=%v: expected the last loss %g to be in AverageLossWindow", prioritized, last.Loss)
+		}
+
+		if !b.Learn() || len(history) != 90 {
+			t.Errorf("prioritized=%v: expected Learn to report a learning step", prioritized)
+		}
+	}
+}
+
+// it should learn from several environments running at once
+func TestEnvironments(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.TemporalWindow = 0
+	opt.StartLearnThreshold = 50
+	opt.LearningStepsBurnin = 400
+	opt.LearningStepsTotal = 2400
+
+	b, err := deepqlearn.NewBrain(1, 2, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the right action is 0 for positive states and 1 for negative ones
+	reward := func(s []float64, a int) float64 {
+		if (s[0] > 0) == (a == 0) {
+			return 1
+		}
+		return 0
+	}
+
+	const envs, steps = 8, 400
+
+	var wg sync.WaitGroup
+	for i := 0; i < envs; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			env := b.NewEnvironment()
+			r := rand.New(rand.NewSource(seed))
+
+			for step := 0; step < steps; step++ {
+				s := []float64{r.Float64()*2 - 1}
+				env.Backward(reward(s, env.Forward(s)))
+
+				if step == steps/2 {
+					env.Reset()
+				}
+			}
+		}(int64(i))
+	}
+
+	// the brain's own methods can be used at the same time
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 50; i++ {
+			b.Stats()
+			b.Act([]float64{0.5})
+		}
+	}()
+
+	wg.Wait()
+
+	if b.Age != envs*steps {
+		t.Errorf("expected age %d, but got %d", envs*steps, b.Age)
+	}
+
+	b.Learning = false
+	b.EpsilonTestTime = 0
+	r := rand.New(rand.NewSource(100))
+	correct := 0
+	for i := 0; i < 100; i++ {
+		s := []float64{r.Float64()*2 - 1}
+		correct += int(reward(s, b.Forward(s)))
+		b.Backward(0)
+	}
+	if correct < 90 {
+		t.Errorf("expected the shared brain to learn, but it was right %d times out of 100", correct)
+	}
+}
+
+// it should stack image states along depth and learn with conv layers
+func TestStackedFrames(t *testing.T) {
+	opt := deepqlearn.DefaultBrainOptions
+	opt.TemporalWindow = 1
+	opt.StateSx, opt.StateSy, opt.StateDepth = 8, 8, 2
+	opt.Gamma = 0.5
+	opt.StartLearnThreshold = 100
+	opt.LearningStepsBurnin = 300
+	opt.LearningStepsTotal = 1500
+	opt.EpsilonMin = 0.1
+	opt.TDTrainerOptions.LearningRate = 0.01
+	opt.TDTrainerOptions.Momentum = 0.9
+	opt.TDTrainerOptions.BatchSize = 8
+	opt.LayerDefs = []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 8, OutSy: 8, OutDepth: 4},
+		{Type: convnet.LayerConv, Sx: 3, Pad: 1, Filters: 8, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerFC, NumNeurons: 32, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerRegression, NumNeurons: 4},
+	}
+
+	bad := opt
+	bad.LayerDefs = append([]convnet.LayerDef{{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 256}}, opt.LayerDefs[1:]...)
+	if _, err := deepqlearn.NewBrain(128, 4, bad); err == nil {
+		t.Error("expected an error for a flat input layer")
+	}
+	if _, err := deepqlearn.NewBrain(100, 4, opt); err == nil {
+		t.Error("expected an error when num_states does not match the state shape")
+	}
+
+	b, err := deepqlearn.NewBrain(128, 4, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the current frame comes first along depth, then the one before it
+	prev, cur := make([]float64, 128), make([]float64, 128)
+	for i := range cur {
+		prev[i], cur[i] = float64(i), float64(-i)
+	}
+	b.Forward(prev)
+	b.Backward(0)
+	in := b.NetInput(cur)
+	if len(in) != 256 || in[4*9+1] != cur[2*9+1] || in[4*9+3] != prev[2*9+1] {
+		t.Fatalf("unexpected stacked input %v", in[4*9:4*9+4])
+	}
+	b.ResetWindows()
+
+	// a gridworld: depth 0 shows the agent and depth 1 the goal, and the
+	// agent is rewarded for moving towards the goal
+	r := rand.New(rand.NewSource(1))
+	moves := [4][2]int{{0, -1}, {0, 1}, {-1, 0}, {1, 0}}
+	ax, ay, gx, gy := 0, 0, 5, 2
+	newEpisode := func() {
+		ax, ay = r.Intn(8), r.Intn(8)
+		for ax == gx && ay == gy {
+			ax, ay = r.Intn(8), r.Intn(8)
+		}
+	}
+	render := func() *convnet.Vol {
+		v := convnet.NewVol(8, 8, 2, 0)
+		v.Set(ax, ay, 0, 1)
+		v.Set(gx, gy, 1, 1)
+		return v
+	}
+	abs := func(x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	// moves the agent and reports whether it got closer to the goal
+	step := func(a int) bool {
+		before := abs(ax-gx) + abs(ay-gy)
+		if x, y := ax+moves[a][0], ay+moves[a][1]; x >= 0 && x < 8 && y >= 0 && y < 8 {
+			ax, ay = x, y
+		}
+		return abs(ax-gx)+abs(ay-gy) < before
+	}
+
+	newEpisode()
+	for i := 0; i < 2000; i++ {
+		reward := -1.0
+		if step(b.ForwardVol(render())) {
+			reward = 1
+		}
+		b.Backward(reward)
+
+		if ax == gx && ay == gy {
+			newEpisode()
+		}
+	}
+
+	b.Learning = false
+	b.EpsilonTestTime = 0
+	closer := 0
+	for i := 0; i < 200; i++ {
+		if step(b.ForwardVol(render())) {
+			closer++
+		}
+		b.Backward(0)
+
+		if ax == gx && ay == gy {
+			newEpisode()
+		}
+	}
+	if closer < 160 {
+		t.Errorf("expected to move towards the goal most of the time, but it did %d times out of 200", closer)
+	}
+}
+
+// it should reject options that cannot work, and fill in defaults
+func TestBrainOptionsValidate(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		change func(*deepqlearn.BrainOptions)
+	}{
+		{"negative temporal window", func(o *deepqlearn.BrainOptions) { o.TemporalWindow = -1 }},
+		{"no experience", func(o *deepqlearn.BrainOptions) { o.ExperienceSize = 0 }},
+		{"threshold too large", func(o *deepqlearn.BrainOptions) { o.StartLearnThreshold = o.ExperienceSize }},
+		{"negative learn every", func(o *deepqlearn.BrainOptions) { o.LearnEvery = -1 }},
+		{"gamma above 1", func(o *deepqlearn.BrainOptions) { o.Gamma = 1.5 }},
+		{"negative gamma", func(o *deepqlearn.BrainOptions) { o.Gamma = -0.1 }},
+		{"NaN gamma", func(o *deepqlearn.BrainOptions) { o.Gamma = math.NaN() }},
+		{"burnin after total", func(o *deepqlearn.BrainOptions) { o.LearningStepsBurnin = o.LearningStepsTotal }},
+		{"epsilon min above 1", func(o *deepqlearn.BrainOptions) { o.EpsilonMin = 2 }},
+		{"negative test epsilon", func(o *deepqlearn.BrainOptions) { o.EpsilonTestTime = -1 }},
+		{"unknown exploration", func(o *deepqlearn.BrainOptions) { o.Exploration = 5 }},
+		{"unknown algorithm", func(o *deepqlearn.BrainOptions) { o.Algorithm = 5 }},
+		{"unknown replacement", func(o *deepqlearn.BrainOptions) { o.ReplacementPolicy = 5 }},
+		{"zero batch size", func(o *deepqlearn.BrainOptions) { o.TDTrainerOptions.BatchSize = 0 }},
+		{"negative sync interval", func(o *deepqlearn.BrainOptions) { o.TargetSyncInterval = -1 }},
+		{"tau above 1", func(o *deepqlearn.BrainOptions) { o.Tau = 2 }},
+		{"reward range backwards", func(o *deepqlearn.BrainOptions) { o.ClipRewards, o.RewardMin, o.RewardMax = true, 1, -1 }},
+		{"negative priority alpha", func(o *deepqlearn.BrainOptions) { o.PrioritizedReplay, o.PriorityAlpha = true, -1 }},
+		{"priority beta above 1", func(o *deepqlearn.BrainOptions) { o.PrioritizedReplay, o.PriorityBeta = true, 2 }},
+	} {
+		opt := deepqlearn.DefaultBrainOptions
+		c.change(&opt)
+
+		if err := opt.Validate(); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		} else if _, err := deepqlearn.NewBrain(2, 2, opt); err == nil {
+			t.Errorf("%s: expected NewBrain to fail", c.name)
+		}
+	}
+
+	if err := deepqlearn.DefaultBrainOptions.Validate(); err != nil {
+		t.Errorf("expected the default options to be valid, but got %v", err)
+	}
+
+	// a schedule replaces the burnin and total
+	opt := deepqlearn.DefaultBrainOptions
+	opt.LearningStepsBurnin, opt.LearningStepsTotal = 10, 10
+	opt.EpsilonSchedule = deepqlearn.ExponentialEpsilon(1, 0.1, 0.99)
+	if err := opt.Validate(); err != nil {
+		t.Errorf("expected burnin to be ignored with a schedule, but got %v", err)
+	}
+
+	// only the options that matter need to be given
+	opt = deepqlearn.BrainOptions{
+		ExperienceSize:   500,
+		HiddenLayerSizes: []int{4},
+		TDTrainerOptions: convnet.TrainerOptions{Momentum: 0.9},
+	}.WithDefaults()
+
+	def := deepqlearn.DefaultBrainOptions
+	if opt.ExperienceSize != 500 || opt.StartLearnThreshold != 50 {
+		t.Errorf("expected experience size 500 and threshold 50, but got %d and %d", opt.ExperienceSize, opt.StartLearnThreshold)
+	}
+	if opt.Gamma != def.Gamma || opt.TemporalWindow != def.TemporalWindow || opt.LearningStepsTotal != def.LearningStepsTotal || opt.LearningStepsBurnin != def.LearningStepsBurnin {
+		t.Errorf("expected default gamma, window, and steps, but got %+v", opt)
+	}
+	if tdo := opt.TDTrainerOptions; tdo.Momentum != 0.9 || tdo.LearningRate != def.TDTrainerOptions.LearningRate || tdo.BatchSize != def.TDTrainerOptions.BatchSize {
+		t.Errorf("expected the given momentum with the default learning rate and batch size, but got %+v", tdo)
+	}
+	if _, err := deepqlearn.NewBrain(2, 2, opt); err != nil {
+		t.Error(err)
+	}
+
+	// the default burnin is dropped if it would not fit
+	opt = deepqlearn.BrainOptions{LearningStepsTotal: 1000}.WithDefaults()
+	if opt.LearningStepsBurnin != 0 || opt.TDTrainerOptions != def.TDTrainerOptions {
+		t.Errorf("expected no burnin and the default trainer, but got %d and %+v", opt.LearningStepsBurnin, opt.TDTrainerOptions)
+	}
+	if err := opt.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// eligibility traces should find a sparse reward at the end of a chain
+// sooner than one-step Q-learning from replay
+func TestWatkinsQLambda(t *testing.T) {
+	const length = 10
+
+	// the number of steps until the greedy action is to move right in
+	// every state of the chain, or -1 if that never happens
+	stepsToLearn := func(opt deepqlearn.BrainOptions, seed int64) int {
+		opt.HiddenLayerSizes = nil
+		opt.TemporalWindow = 0
+		opt.StartLearnThreshold = 0
+		opt.Gamma = 0.9
+		opt.LearningStepsBurnin = 200
+		opt.LearningStepsTotal = 1000
+		opt.EpsilonMin = 0.1
+		opt.TDTrainerOptions = convnet.TrainerOptions{LearningRate: 0.2, BatchSize: 1}
+		opt.RandSource = convnet.NewRandSource(seed)
+
+		b, err := deepqlearn.NewBrain(length, 2, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := func(pos int) []float64 {
+			s := make([]float64, length)
+			s[pos] = 1
+			return s
+		}
+
+		pos := 0
+		for step := 1; step <= 5000; step++ {
+			// 0 moves left and 1 moves right; the end of the chain gives
+			// a reward and starts over
+			if b.Forward(state(pos)) == 0 {
+				if pos > 0 {
+					pos--
+				}
+			} else {
+				pos++
+			}
+
+			reward := 0.0
+			if pos == length-1 {
+				reward, pos = 1, 0
+			}
+			b.Backward(reward)
+
+			// the values must also grow towards the reward, so that
+			// the policy isn't right by chance
+			learned, last := true, math.Inf(-1)
+			for p := 0; p < length-1; p++ {
+				a, value := b.Policy(state(p))
+				if a != 1 || value <= last {
+					learned = false
+					break
+				}
+				last = value
+			}
+			if learned {
+				return step
+			}
+		}
+
+		return -1
+	}
+
+	lambda := deepqlearn.DefaultBrainOptions
+	lambda.Algorithm = deepqlearn.WatkinsQLambda
+	lambda.Lambda = 0.9
+	replacing := lambda
+	replacing.ReplacingTraces = true
+
+	// a few runs each, since exploration is random
+	total := map[string]int{}
+	for seed := int64(1); seed <= 5; seed++ {
+		for name, opt := range map[string]deepqlearn.BrainOptions{
+			"replay":       deepqlearn.DefaultBrainOptions,
+			"accumulating": lambda,
+			"replacing":    replacing,
+		} {
+			steps := stepsToLearn(opt, seed)
+			if steps == -1 {
+				t.Errorf("%s: seed %d never learned the chain", name, seed)
+				steps = 5000
+			}
+			total[name] += steps
+		}
+	}
+
+	t.Logf("total steps to learn: %v", total)
+	for _, name := range []string{"accumulating", "replacing"} {
+		if total[name] >= total["replay"] {
+			t.Errorf("expected %s traces to take fewer than %d steps, but they took %d", name, total["replay"], total[name])
+		}
+	}
+
+	for _, c := range []func(*deepqlearn.BrainOptions){
+		func(opt *deepqlearn.BrainOptions) { opt.Lambda = 1.5 },
+		func(opt *deepqlearn.BrainOptions) { opt.PrioritizedReplay = true },
+		func(opt *deepqlearn.BrainOptions) { opt.TargetSyncInterval = 100 },
+	} {
+		opt := lambda
+		c(&opt)
+		if _, err := deepqlearn.NewBrain(2, 2, opt); err == nil {
+			t.Errorf("expected an error for options %+v", opt)
+		}
+	}
+	if _, err := deepqlearn.NewBrainMulti(2, []int{2, 2}, lambda); err == nil {
+		t.Error("expected an error for several action dimensions")
+	}
+}
+
+// it should learn a policy that gets more reward
+func TestPolicyBrain(t *testing.T) {
+	opt := deepqlearn.DefaultPolicyBrainOptions
+	opt.HiddenLayerSizes = []int{8}
+	opt.Rand = rand.New(rand.NewSource(1))
+	opt.TrainerOptions.LearningRate = 0.05
+
+	pb, err := deepqlearn.NewPolicyBrain(2, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the right action is the index of the hot input, and action 2 is
+	// never right
+	r := rand.New(rand.NewSource(2))
+	states := [][]float64{{1, 0}, {0, 1}}
+
+	for episode := 0; episode < 300; episode++ {
+		var rewards []float64
+		for step := 0; step < 10; step++ {
+			s := r.Intn(2)
+			action, logProb := pb.Forward(states[s])
+
+			if p := pb.Probabilities(states[s])[action]; math.Abs(math.Log(p)-logProb) > 1e-12 {
+				t.Fatalf("expected log-probability %g, but got %g", math.Log(p), logProb)
+			}
+			if got := pb.LogProbs[len(pb.LogProbs)-1]; got != logProb {
+				t.Fatalf("expected the trajectory to record log-probability %g, but it has %g", logProb, got)
+			}
+
+			reward := 0.0
+			if action == s {
+				reward = 1
+			}
+			rewards = append(rewards, reward)
+		}
+
+		if len(pb.States) != 10 || len(pb.Actions) != 10 || len(pb.LogProbs) != 10 {
+			t.Fatalf("expected a trajectory of 10 steps, but got %d states, %d actions, and %d log-probabilities", len(pb.States), len(pb.Actions), len(pb.LogProbs))
+		}
+		pb.EndEpisode(rewards)
+		if len(pb.States) != 0 || len(pb.Actions) != 0 || len(pb.LogProbs) != 0 {
+			t.Fatal("expected the trajectory to be cleared")
+		}
+	}
+
+	for s, state := range states {
+		if p := pb.Probabilities(state)[s]; p < 0.9 {
+			t.Errorf("expected the right action %d to have a probability of at least 0.9, but it is %g", s, p)
+		}
+	}
+	if avg := pb.AverageReturnWindow.Average(); avg < 8 {
+		t.Errorf("expected an average return of at least 8 out of 10, but got %g", avg)
+	}
+
+	pb.Forward(states[0])
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for the wrong number of rewards")
+			}
+		}()
+
+		pb.EndEpisode(nil)
+	}()
+
+	opt.LayerDefs = []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerRegression, NumNeurons: 3},
+	}
+	if _, err := deepqlearn.NewPolicyBrain(2, 3, opt); err == nil {
+		t.Error("expected an error for a policy net that does not end in softmax")
+	}
+}
@@ -0,0 +1,95 @@
+package deepqlearn
+
+// An Environment is the temporal context of one of several environments
+// that share a brain, such as simulations running in their own
+// goroutines. Forward and Backward on a brain keep a single history of
+// states and actions, so they only make sense for one environment at a
+// time. Each Environment keeps its own history instead, while its
+// experiences go into the brain's shared replay memory and train the
+// shared value net.
+//
+// Forward and Backward must still be called in matched pairs on each
+// Environment, and each Environment should only be used by one goroutine
+// at a time. Environments of the same brain may be used concurrently with
+// each other and with the brain's Forward, ForwardMasked, Backward, Learn,
+// Act, ActFrom, ResetWindows, and Stats, which all take the brain's lock.
+// Other methods of the brain, and its exported fields, are not guarded.
+type Environment struct {
+	brain *Brain
+
+	// swapped with the brain's own while it is used
+	stateWindow    [][]float64
+	actionWindow   []int
+	rewardWindow   []float64
+	netWindow      [][]float64
+	maskWindow     [][]bool
+	windowFill     int
+	lastInputArray []float64
+	traces         [][]float64
+}
+
+// NewEnvironment returns a new Environment for b with an empty history.
+func (b *Brain) NewEnvironment() *Environment {
+	return &Environment{
+		brain:        b,
+		stateWindow:  make([][]float64, b.WindowSize),
+		actionWindow: make([]int, b.WindowSize),
+		rewardWindow: make([]float64, b.WindowSize),
+		netWindow:    make([][]float64, b.WindowSize),
+		maskWindow:   make([][]bool, b.WindowSize),
+	}
+}
+
+// exchanges the history of the environment with the brain's; the brain's
+// lock must be held
+func (e *Environment) swap() {
+	b := e.brain
+
+	b.StateWindow, e.stateWindow = e.stateWindow, b.StateWindow
+	b.ActionWindow, e.actionWindow = e.actionWindow, b.ActionWindow
+	b.RewardWindow, e.rewardWindow = e.rewardWindow, b.RewardWindow
+	b.NetWindow, e.netWindow = e.netWindow, b.NetWindow
+	b.MaskWindow, e.maskWindow = e.maskWindow, b.MaskWindow
+	b.WindowFill, e.windowFill = e.windowFill, b.WindowFill
+	b.LastInputArray, e.lastInputArray = e.lastInputArray, b.LastInputArray
+	b.traces, e.traces = e.traces, b.traces
+}
+
+// Forward is like Brain.Forward, with the environment's history.
+func (e *Environment) Forward(inputArray []float64) int {
+	return e.ForwardMasked(inputArray, nil)
+}
+
+// ForwardMasked is like Brain.ForwardMasked, with the environment's
+// history.
+func (e *Environment) ForwardMasked(inputArray []float64, valid []bool) int {
+	e.brain.mu.Lock()
+	defer e.brain.mu.Unlock()
+
+	e.swap()
+	defer e.swap()
+
+	return e.brain.forwardMasked(inputArray, valid)
+}
+
+// Backward is like Brain.Backward, with the environment's history. The
+// brain's Age counts the calls to Backward of every environment.
+func (e *Environment) Backward(reward float64) {
+	e.brain.mu.Lock()
+	e.swap()
+	e.brain.backward(reward)
+	e.swap()
+	report := e.brain.takeLearnStats()
+	e.brain.mu.Unlock()
+
+	report()
+}
+
+// Reset clears the environment's history, like Brain.ResetWindows, for
+// the start of a new episode.
+func (e *Environment) Reset() {
+	e.brain.mu.Lock()
+	defer e.brain.mu.Unlock()
+
+	*e = *e.brain.NewEnvironment()
+}
@@ -0,0 +1,105 @@
+package deepqlearn
+
+import (
+	"fmt"
+)
+
+// Act picks an action for state the way Forward does at test time, with
+// EpsilonTestTime as epsilon, but without changing anything that learning
+// depends on: Age, Experience, the training windows, and Epsilon are left
+// alone. The temporal context comes from a separate evaluation window, so
+// a trained brain can be evaluated in another environment between (or
+// during) training episodes. Only the brain's random numbers are used up.
+func (b *Brain) Act(state []float64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.EvalStateWindow) != b.WindowSize || len(b.EvalActionWindow) != b.WindowSize {
+		b.EvalStateWindow = make([][]float64, b.WindowSize)
+		b.EvalActionWindow = make([]int, b.WindowSize)
+		b.EvalWindowFill = 0
+	}
+
+	var action int
+	if b.EvalWindowFill >= b.TemporalWindow {
+		action = b.act(b.netInput(state, b.EvalStateWindow, b.EvalActionWindow))
+	} else {
+		// not enough history yet, as in Forward
+		action = b.RandomAction()
+	}
+
+	b.EvalWindowFill++
+	copy(b.EvalStateWindow, b.EvalStateWindow[1:])
+	b.EvalStateWindow[len(b.EvalStateWindow)-1] = state
+	copy(b.EvalActionWindow, b.EvalActionWindow[1:])
+	b.EvalActionWindow[len(b.EvalActionWindow)-1] = action
+
+	return action
+}
+
+// ActFrom is like Act, but the temporal context is given by the caller
+// rather than kept by the brain: states and actions are the previous
+// states and the actions taken in them, with the most recent last. Only
+// the last TemporalWindow of each are used, and there must be at least
+// that many.
+func (b *Brain) ActFrom(states [][]float64, actions []int, state []float64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(states) < b.TemporalWindow || len(actions) < b.TemporalWindow {
+		return 0, fmt.Errorf("deepqlearn: a temporal window of %d needs that many states and actions, but there are %d and %d", b.TemporalWindow, len(states), len(actions))
+	}
+
+	for k := len(actions) - b.TemporalWindow; k < len(actions); k++ {
+		if actions[k] < 0 || actions[k] >= b.numCombinedActions() {
+			return 0, fmt.Errorf("deepqlearn: action %d is out of range", actions[k])
+		}
+	}
+
+	return b.act(b.netInput(state, states, actions)), nil
+}
+
+// epsilon-greedy with EpsilonTestTime
+func (b *Brain) act(netInput []float64) int {
+	if b.Rand.Float64() < b.EpsilonTestTime {
+		return b.RandomAction()
+	}
+
+	action, _ := b.Policy(netInput)
+
+	return action
+}
+
+// the number of distinct actions, counting every combination of choices
+// when there are several action dimensions
+func (b *Brain) numCombinedActions() int {
+	n := 1
+	for _, d := range b.actionDims() {
+		n *= d
+	}
+
+	return n
+}
+
+// ResetWindows clears the temporal context of both Forward and Act, as at
+// the start of an episode, so that nothing from the previous episode is
+// used as history and no experience joins the end of one episode to the
+// start of the next. The eligibility traces of WatkinsQLambda are cleared
+// too. As when the brain is new, the first TemporalWindow
+// actions after a reset are random.
+func (b *Brain) ResetWindows() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.StateWindow = make([][]float64, b.WindowSize)
+	b.ActionWindow = make([]int, b.WindowSize)
+	b.RewardWindow = make([]float64, b.WindowSize)
+	b.NetWindow = make([][]float64, b.WindowSize)
+	b.MaskWindow = make([][]bool, b.WindowSize)
+	b.WindowFill = 0
+	b.traces = nil
+
+	b.EvalStateWindow = make([][]float64, b.WindowSize)
+	b.EvalActionWindow = make([]int, b.WindowSize)
+	b.EvalWindowFill = 0
+}
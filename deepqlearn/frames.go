@@ -0,0 +1,69 @@
+package deepqlearn
+
+import (
+	"fmt"
+
+	"github.com/BenLubar/convnet"
+)
+
+// reports whether states are images that are stacked along depth rather
+// than flat vectors interleaved with actions
+func (b *Brain) stacked() bool {
+	return b.StateDepth != 0
+}
+
+// the shape of the value net's input
+func (b *Brain) inputShape() (sx, sy, depth int) {
+	if b.stacked() {
+		return b.StateSx, b.StateSy, b.StateDepth * (b.TemporalWindow + 1)
+	}
+
+	return 1, 1, b.NetInputs
+}
+
+// inputVol wraps a net input made by NetInput in a Vol of the right shape
+func (b *Brain) inputVol(w []float64) *convnet.Vol {
+	sx, sy, depth := b.inputShape()
+
+	v := convnet.NewVol(sx, sy, depth, 0)
+	v.W = w
+
+	return v
+}
+
+// stackFrames puts xt and the last TemporalWindow states before it
+// together along depth, so that frame f of the input (0 for xt, 1 for the
+// state before it, and so on) is depths f*StateDepth through
+// (f+1)*StateDepth-1
+func (b *Brain) stackFrames(xt []float64, states [][]float64) []float64 {
+	frames := b.TemporalWindow + 1
+	depth := b.StateDepth * frames
+	w := make([]float64, b.StateSx*b.StateSy*depth)
+
+	for f := 0; f < frames; f++ {
+		frame := xt
+		if f != 0 {
+			frame = states[len(states)-f]
+		}
+
+		for i := 0; i < b.StateSx*b.StateSy; i++ {
+			copy(w[i*depth+f*b.StateDepth:], frame[i*b.StateDepth:(i+1)*b.StateDepth])
+		}
+	}
+
+	return w
+}
+
+// ForwardVol is like Forward, for a brain with image states. state must
+// have the shape given by StateSx, StateSy, and StateDepth.
+func (b *Brain) ForwardVol(state *convnet.Vol) int {
+	if !b.stacked() {
+		panic("deepqlearn: ForwardVol needs a brain with a state shape")
+	}
+
+	if state.Sx != b.StateSx || state.Sy != b.StateSy || state.Depth != b.StateDepth {
+		panic(fmt.Sprintf("deepqlearn: state is %dx%dx%d, but the brain expects %dx%dx%d", state.Sx, state.Sy, state.Depth, b.StateSx, b.StateSy, b.StateDepth))
+	}
+
+	return b.Forward(state.W)
+}
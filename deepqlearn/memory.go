@@ -0,0 +1,54 @@
+package deepqlearn
+
+import "unsafe"
+
+// ExperienceBytes estimates the memory taken by one experience in the
+// replay memory, for MaxExperienceBytes. Every experience is counted as if
+// it had its own copy of each state, as it does once the brain has been
+// loaded from JSON; experiences made since then share some of their
+// states with their neighbours, so they take less.
+func (b *Brain) ExperienceBytes() int {
+	const (
+		floatBytes = int(unsafe.Sizeof(float64(0)))
+		intBytes   = int(unsafe.Sizeof(int(0)))
+		sliceBytes = int(unsafe.Sizeof([]float64(nil)))
+	)
+
+	n := int(unsafe.Sizeof(Experience{}))
+	n += b.NumActions // the valid actions
+
+	if b.CompactExperience {
+		n += (b.TemporalWindow + 2) * (sliceBytes + b.NumStates*floatBytes)
+		n += (b.TemporalWindow + 1) * intBytes
+	} else {
+		n += 2 * b.NetInputs * floatBytes
+	}
+
+	return n
+}
+
+// ExperienceInputs returns the net inputs of the states before and after
+// the action of e, rebuilding them if e was stored by CompactExperience.
+func (b *Brain) ExperienceInputs(e *Experience) (state0, state1 []float64) {
+	return b.state0(e), b.state1(e)
+}
+
+func (b *Brain) state0(e *Experience) []float64 {
+	if e.States == nil {
+		return e.State0
+	}
+
+	tw := b.TemporalWindow
+
+	return b.netInput(e.States[tw], e.States[:tw], e.Actions[:tw])
+}
+
+func (b *Brain) state1(e *Experience) []float64 {
+	if e.States == nil {
+		return e.State1
+	}
+
+	tw := b.TemporalWindow
+
+	return b.netInput(e.States[tw+1], e.States[1:tw+1], e.Actions[1:tw+1])
+}
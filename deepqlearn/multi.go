@@ -0,0 +1,72 @@
+package deepqlearn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NewBrainMulti creates a brain whose actions are made of one choice from
+// each of several action dimensions, with actionDims[d] choices in
+// dimension d. Rather than one output for every combination, the value
+// net has one block of outputs for each dimension, and each block learns
+// the value of its own choices (Tavakoli et al. 2018, "Action Branching
+// Architectures for Deep Reinforcement Learning"), so NumActions is the
+// sum of actionDims rather than their product.
+//
+// Actions are still passed around as a single int, in which the first
+// dimension varies fastest. ForwardMulti splits it into its parts. Masks
+// and RandomActionDistribution still have NumActions values, made of a
+// block for each dimension in order.
+func NewBrainMulti(numStates int, actionDims []int, opt BrainOptions) (*Brain, error) {
+	if len(actionDims) == 0 {
+		return nil, errors.New("deepqlearn: at least one action dimension is required")
+	}
+
+	for d, n := range actionDims {
+		if n < 1 {
+			return nil, fmt.Errorf("deepqlearn: action dimension %d has %d actions", d, n)
+		}
+	}
+
+	return newBrain(numStates, actionDims, opt)
+}
+
+// ForwardMulti is like Forward, but returns the chosen action in each
+// action dimension.
+func (b *Brain) ForwardMulti(inputArray []float64) []int {
+	return b.decodeAction(b.Forward(inputArray))
+}
+
+func (b *Brain) actionDims() []int {
+	if b.ActionDims == nil {
+		return []int{b.NumActions}
+	}
+
+	return b.ActionDims
+}
+
+// encodeAction combines the choice in each action dimension into a single
+// action, with the first dimension varying fastest
+func (b *Brain) encodeAction(actions []int) int {
+	dims := b.actionDims()
+
+	action := 0
+	for d := len(dims) - 1; d >= 0; d-- {
+		action = action*dims[d] + actions[d]
+	}
+
+	return action
+}
+
+// decodeAction is the inverse of encodeAction
+func (b *Brain) decodeAction(action int) []int {
+	dims := b.actionDims()
+
+	actions := make([]int, len(dims))
+	for d, n := range dims {
+		actions[d] = action % n
+		action /= n
+	}
+
+	return actions
+}
@@ -0,0 +1,135 @@
+package deepqlearn
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/BenLubar/convnet"
+)
+
+// between reports whether x is in [min, max]; NaN is not
+func between(x, min, max float64) bool {
+	return x >= min && x <= max
+}
+
+// Validate returns an error describing the first problem with the options
+// that would make a brain misbehave, such as a Gamma outside [0,1] or a
+// StartLearnThreshold so large that learning would never start. NewBrain
+// calls it.
+func (opt BrainOptions) Validate() error {
+	switch {
+	case opt.TemporalWindow < 0:
+		return fmt.Errorf("deepqlearn: temporal_window must not be negative, but it is %d", opt.TemporalWindow)
+	case opt.ExperienceSize <= 0:
+		return fmt.Errorf("deepqlearn: experience_size must be positive, but it is %d", opt.ExperienceSize)
+	case opt.MaxExperienceBytes < 0:
+		return fmt.Errorf("deepqlearn: max_experience_bytes must not be negative, but it is %d", opt.MaxExperienceBytes)
+	case opt.StartLearnThreshold < 0:
+		return fmt.Errorf("deepqlearn: start_learn_threshold must not be negative, but it is %d", opt.StartLearnThreshold)
+	case opt.StartLearnThreshold >= opt.ExperienceSize:
+		// learning starts once there are more experiences than this
+		return fmt.Errorf("deepqlearn: start_learn_threshold %d must be less than experience_size %d, or learning never starts", opt.StartLearnThreshold, opt.ExperienceSize)
+	case opt.LearnEvery < 0:
+		return fmt.Errorf("deepqlearn: learn_every must not be negative, but it is %d", opt.LearnEvery)
+	case !between(opt.Gamma, 0, 1):
+		return fmt.Errorf("deepqlearn: gamma must be between 0 and 1, but it is %g", opt.Gamma)
+	case opt.LearningStepsBurnin < 0:
+		return fmt.Errorf("deepqlearn: learning_steps_burnin must not be negative, but it is %d", opt.LearningStepsBurnin)
+	case opt.EpsilonSchedule == nil && opt.LearningStepsBurnin >= opt.LearningStepsTotal:
+		// the default epsilon schedule anneals between the two
+		return fmt.Errorf("deepqlearn: learning_steps_burnin %d must be less than learning_steps_total %d", opt.LearningStepsBurnin, opt.LearningStepsTotal)
+	case !between(opt.EpsilonMin, 0, 1):
+		return fmt.Errorf("deepqlearn: epsilon_min must be between 0 and 1, but it is %g", opt.EpsilonMin)
+	case !between(opt.EpsilonTestTime, 0, 1):
+		return fmt.Errorf("deepqlearn: epsilon_test_time must be between 0 and 1, but it is %g", opt.EpsilonTestTime)
+	case opt.Exploration != EpsilonGreedy && opt.Exploration != Boltzmann:
+		return fmt.Errorf("deepqlearn: unknown exploration %d", int(opt.Exploration))
+	case opt.Algorithm != QLearning && opt.Algorithm != SARSA && opt.Algorithm != WatkinsQLambda:
+		return fmt.Errorf("deepqlearn: unknown algorithm %d", int(opt.Algorithm))
+	case opt.ReplacementPolicy != RandomReplacement && opt.ReplacementPolicy != FIFOReplacement:
+		return fmt.Errorf("deepqlearn: unknown replacement policy %d", int(opt.ReplacementPolicy))
+	case opt.TDTrainerOptions.BatchSize <= 0:
+		return fmt.Errorf("deepqlearn: td_trainer_options batch_size must be positive, but it is %d", opt.TDTrainerOptions.BatchSize)
+	case opt.TargetSyncInterval < 0:
+		return fmt.Errorf("deepqlearn: target_sync_interval must not be negative, but it is %d", opt.TargetSyncInterval)
+	case !between(opt.Tau, 0, 1):
+		return fmt.Errorf("deepqlearn: tau must be between 0 and 1, but it is %g", opt.Tau)
+	case opt.ClipRewards && opt.RewardMin > opt.RewardMax:
+		return fmt.Errorf("deepqlearn: reward_min %g is more than reward_max %g", opt.RewardMin, opt.RewardMax)
+	}
+
+	if opt.Algorithm == WatkinsQLambda {
+		switch {
+		case !between(opt.Lambda, 0, 1):
+			return fmt.Errorf("deepqlearn: lambda must be between 0 and 1, but it is %g", opt.Lambda)
+		case opt.PrioritizedReplay:
+			return fmt.Errorf("deepqlearn: watkins_q_lambda does not use experience replay, so it cannot be prioritized")
+		case opt.TargetSyncInterval > 0 || opt.Tau > 0:
+			return fmt.Errorf("deepqlearn: watkins_q_lambda cannot be used with a target net")
+		}
+	}
+
+	if opt.PrioritizedReplay {
+		switch {
+		case !(opt.PriorityAlpha >= 0):
+			return fmt.Errorf("deepqlearn: priority_alpha must not be negative, but it is %g", opt.PriorityAlpha)
+		case !between(opt.PriorityBeta, 0, 1):
+			return fmt.Errorf("deepqlearn: priority_beta must be between 0 and 1, but it is %g", opt.PriorityBeta)
+		case !(opt.PriorityEps >= 0):
+			return fmt.Errorf("deepqlearn: priority_eps must not be negative, but it is %g", opt.PriorityEps)
+		}
+	}
+
+	return nil
+}
+
+// WithDefaults returns a copy of opt with every zero field that has a
+// non-zero value in DefaultBrainOptions set to that value, so that only
+// the options that matter to the caller need to be given. A zero
+// StartLearnThreshold is worked out from ExperienceSize the same way as
+// the default, and a zero LearningStepsBurnin stays zero if the default
+// would not be less than LearningStepsTotal. The TD trainer options are
+// the default ones if they are all zero; otherwise only a zero learning
+// rate or batch size is filled in.
+//
+// Fields whose zero value means something, such as a Gamma or
+// TemporalWindow of 0, cannot be asked for this way; start from
+// DefaultBrainOptions to set those to zero.
+func (opt BrainOptions) WithDefaults() BrainOptions {
+	def := DefaultBrainOptions
+
+	setInt := func(v *int, d int) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	setFloat := func(v *float64, d float64) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+
+	setInt(&opt.TemporalWindow, def.TemporalWindow)
+	setInt(&opt.ExperienceSize, def.ExperienceSize)
+	setInt(&opt.StartLearnThreshold, int(math.Floor(math.Min(float64(opt.ExperienceSize)*0.1, 1000))))
+	setFloat(&opt.Gamma, def.Gamma)
+	setInt(&opt.LearningStepsTotal, def.LearningStepsTotal)
+	if def.LearningStepsBurnin < opt.LearningStepsTotal {
+		setInt(&opt.LearningStepsBurnin, def.LearningStepsBurnin)
+	}
+	setFloat(&opt.EpsilonMin, def.EpsilonMin)
+	setFloat(&opt.EpsilonTestTime, def.EpsilonTestTime)
+	setFloat(&opt.Temperature, def.Temperature)
+	setFloat(&opt.PriorityAlpha, def.PriorityAlpha)
+	setFloat(&opt.PriorityBeta, def.PriorityBeta)
+	setFloat(&opt.PriorityEps, def.PriorityEps)
+
+	if opt.TDTrainerOptions == (convnet.TrainerOptions{}) {
+		opt.TDTrainerOptions = def.TDTrainerOptions
+	} else {
+		setFloat(&opt.TDTrainerOptions.LearningRate, def.TDTrainerOptions.LearningRate)
+		setInt(&opt.TDTrainerOptions.BatchSize, def.TDTrainerOptions.BatchSize)
+	}
+
+	return opt
+}
@@ -0,0 +1,220 @@
+package deepqlearn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnutil"
+)
+
+type PolicyBrainOptions struct {
+	// discount of future rewards in the returns. In [0,1]
+	Gamma float64
+	// if NormalizeReturns is true, the returns of each episode are
+	// standardized before they are used as advantages, which acts as a
+	// baseline and keeps the size of the updates steady
+	NormalizeReturns bool
+
+	// if LayerDefs is nil, the policy net is made of fully connected relu
+	// layers of HiddenLayerSizes followed by a softmax over the actions.
+	// otherwise it must start with an input layer of num_states values
+	// and end with a softmax layer of num_actions classes.
+	LayerDefs        []convnet.LayerDef
+	HiddenLayerSizes []int
+	Rand             *rand.Rand
+
+	TrainerOptions convnet.TrainerOptions
+}
+
+var DefaultPolicyBrainOptions = PolicyBrainOptions{
+	Gamma:            0.99,
+	NormalizeReturns: true,
+	TrainerOptions: convnet.TrainerOptions{
+		LearningRate: 0.01,
+		Momentum:     0.0,
+		BatchSize:    1,
+		L2Decay:      0.0,
+	},
+}
+
+// A PolicyBrain learns a stochastic policy directly with REINFORCE
+// (Williams 1992), rather than learning the values of actions like Brain.
+// It picks actions by sampling from the softmax output of its policy net,
+// remembers the episode as it goes, and learns from the whole episode when
+// it ends.
+type PolicyBrain struct {
+	NumStates  int
+	NumActions int
+
+	Gamma            float64
+	NormalizeReturns bool
+
+	Rand      *rand.Rand
+	PolicyNet convnet.Net
+	Trainer   *convnet.Trainer
+
+	// the trajectory of the current episode: the state given to each
+	// call to Forward, the action it chose, and the log-probability the
+	// action had when it was chosen. EndEpisode trains on States and
+	// Actions, and PolicyGradientTrain recomputes the log-probabilities
+	// from the policy net as it is updated, so LogProbs is only a record
+	// of how the episode was sampled.
+	States   [][]float64
+	Actions  []int
+	LogProbs []float64
+
+	AverageReturnWindow *cnnutil.Window // undiscounted total reward of each episode
+	AverageLossWindow   *cnnutil.Window
+}
+
+func NewPolicyBrain(numStates, numActions int, opt PolicyBrainOptions) (*PolicyBrain, error) {
+	pb := &PolicyBrain{
+		NumStates:        numStates,
+		NumActions:       numActions,
+		Gamma:            opt.Gamma,
+		NormalizeReturns: opt.NormalizeReturns,
+	}
+
+	layerDefs := opt.LayerDefs
+	if layerDefs != nil {
+		if len(layerDefs) < 2 {
+			return nil, errors.New("deepqlearn: must have at least 2 layers")
+		}
+
+		if layerDefs[0].Type != convnet.LayerInput {
+			return nil, errors.New("deepqlearn: first layer must be input layer!")
+		}
+
+		if layerDefs[len(layerDefs)-1].Type != convnet.LayerSoftmax {
+			return nil, errors.New("deepqlearn: last layer of a policy net must be softmax!")
+		}
+
+		if layerDefs[0].OutDepth*layerDefs[0].OutSx*layerDefs[0].OutSy != numStates {
+			return nil, errors.New("deepqlearn: Number of inputs must be num_states!")
+		}
+
+		if layerDefs[len(layerDefs)-1].NumClasses != numActions {
+			return nil, errors.New("deepqlearn: Number of softmax classes should be num_actions!")
+		}
+	} else {
+		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: numStates})
+
+		for _, hl := range opt.HiddenLayerSizes {
+			layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerFC, NumNeurons: hl, Activation: convnet.LayerRelu})
+		}
+
+		// probability of each action
+		layerDefs = append(layerDefs, convnet.LayerDef{Type: convnet.LayerSoftmax, NumClasses: numActions})
+	}
+
+	pb.Rand = opt.Rand
+	if pb.Rand == nil {
+		pb.Rand = rand.New(rand.NewSource(0))
+	}
+
+	pb.PolicyNet.MakeLayers(layerDefs, pb.Rand)
+	pb.Trainer = convnet.NewTrainer(&pb.PolicyNet, opt.TrainerOptions)
+
+	pb.AverageReturnWindow = cnnutil.NewWindow(1000, 10)
+	pb.AverageLossWindow = cnnutil.NewWindow(1000, 10)
+
+	return pb, nil
+}
+
+// Probabilities returns the probability of taking each action in state.
+func (pb *PolicyBrain) Probabilities(state []float64) []float64 {
+	svol := convnet.NewVol(1, 1, pb.NumStates, 0)
+	svol.W = state
+
+	return append([]float64(nil), pb.PolicyNet.Forward(svol, false).W...)
+}
+
+// Forward samples an action for state from the policy, and returns it
+// along with its log-probability. The state, action, and log-probability
+// are added to the trajectory of the current episode.
+func (pb *PolicyBrain) Forward(state []float64) (action int, logProb float64) {
+	probs := pb.Probabilities(state)
+
+	action = len(probs) - 1 // in case of rounding error
+	p := pb.Rand.Float64()
+	cumprob := 0.0
+
+	for k, prob := range probs {
+		cumprob += prob
+
+		if p < cumprob {
+			action = k
+			break
+		}
+	}
+
+	logProb = math.Log(probs[action])
+
+	pb.States = append(pb.States, state)
+	pb.Actions = append(pb.Actions, action)
+	pb.LogProbs = append(pb.LogProbs, logProb)
+
+	return action, logProb
+}
+
+// EndEpisode learns from the episode that just ended and starts a new one.
+// rewards[t] is the reward received after the action chosen by the t-th
+// call to Forward in the episode, so there must be one for each call; it
+// panics otherwise. The policy is trained on every step with the
+// discounted return from that step as its advantage.
+func (pb *PolicyBrain) EndEpisode(rewards []float64) {
+	if len(rewards) != len(pb.Actions) {
+		panic(fmt.Sprintf("deepqlearn: %d rewards given for an episode of %d steps", len(rewards), len(pb.Actions)))
+	}
+
+	// discounted returns, from the end backwards
+	returns := make([]float64, len(rewards))
+	ret, total := 0.0, 0.0
+	for t := len(rewards) - 1; t >= 0; t-- {
+		ret = rewards[t] + pb.Gamma*ret
+		returns[t] = ret
+		total += rewards[t]
+	}
+
+	if pb.NormalizeReturns && len(returns) > 1 {
+		mean := 0.0
+		for _, g := range returns {
+			mean += g
+		}
+		mean /= float64(len(returns))
+
+		variance := 0.0
+		for _, g := range returns {
+			variance += (g - mean) * (g - mean)
+		}
+		std := math.Sqrt(variance / float64(len(returns)))
+		if std < 1e-8 {
+			// every step was as good as the others
+			std = 1
+		}
+
+		for t := range returns {
+			returns[t] = (returns[t] - mean) / std
+		}
+	}
+
+	avcost := 0.0
+
+	for t, state := range pb.States {
+		x := convnet.NewVol(1, 1, pb.NumStates, 0)
+		x.W = state
+
+		loss := pb.Trainer.PolicyGradientTrain(x, pb.Actions[t], returns[t])
+		avcost += loss.Loss
+	}
+
+	if len(pb.States) != 0 {
+		pb.AverageLossWindow.Add(avcost / float64(len(pb.States)))
+	}
+	pb.AverageReturnWindow.Add(total)
+
+	pb.States, pb.Actions, pb.LogProbs = nil, nil, nil
+}
@@ -0,0 +1,83 @@
+package deepqlearn
+
+import "encoding/json"
+
+// BrainStats is a snapshot of a brain's training progress, for logging
+// and metrics.
+type BrainStats struct {
+	Age             int     `json:"age"`
+	Epsilon         float64 `json:"epsilon"`
+	ExperienceCount int     `json:"experience_count"`
+	// ExperienceCapacity is the most experiences the brain remembers,
+	// which MaxExperienceBytes can make less than ExperienceSize.
+	ExperienceCapacity int `json:"experience_capacity"`
+	// AverageLoss and AverageReward are averages over the most recent
+	// learning steps and rewards, or -1 if there have not been enough of
+	// them yet, like cnnutil.Window.Average.
+	AverageLoss   float64 `json:"average_loss"`
+	AverageReward float64 `json:"average_reward"`
+	ForwardPasses int     `json:"forward_passes"`
+	IsLearning    bool    `json:"is_learning"`
+	LatestReward  float64 `json:"latest_reward"`
+	// the learning rate of the TD trainer and the discount, which change
+	// over time with LearningRateSchedule and GammaSchedule
+	LearningRate float64 `json:"learning_rate"`
+	Gamma        float64 `json:"gamma"`
+	// ActionValues is the value of every action the last time Forward
+	// chose the best one rather than exploring, or nil if it has not yet.
+	ActionValues []float64 `json:"action_values"`
+}
+
+// Stats returns the current training progress of the brain.
+func (b *Brain) Stats() BrainStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BrainStats{
+		Age:                b.Age,
+		Epsilon:            b.Epsilon,
+		ExperienceCount:    len(b.Experience),
+		ExperienceCapacity: b.ExperienceSize,
+		AverageLoss:        b.AverageLossWindow.Average(),
+		AverageReward:      b.AverageRewardWindow.Average(),
+		ForwardPasses:      b.ForwardPasses,
+		IsLearning:         b.Learning,
+		LatestReward:       b.LatestReward,
+		LearningRate:       b.TDTrainer.LearningRate,
+		Gamma:              b.Gamma,
+		ActionValues:       append([]float64(nil), b.LastActionValues...),
+	}
+}
+
+// LearnStats describes one learning step, for BrainOptions.OnLearnStep.
+type LearnStats struct {
+	Age          int     `json:"age"`
+	Epsilon      float64 `json:"epsilon"`
+	LearningRate float64 `json:"learning_rate"`
+	Gamma        float64 `json:"gamma"`
+	// Loss is the mean TD loss of the batch, as added to
+	// AverageLossWindow. MeanAbsTDError and MaxAbsTDError are over every
+	// experience in the batch, and every action dimension of each.
+	Loss           float64 `json:"loss"`
+	MeanAbsTDError float64 `json:"mean_abs_td_error"`
+	MaxAbsTDError  float64 `json:"max_abs_td_error"`
+}
+
+// takeLearnStats returns a function that gives the stats of the learning
+// step since the last call, if there was one, to OnLearnStep. the brain
+// must be locked, and the function must be called after it is unlocked.
+func (b *Brain) takeLearnStats() func() {
+	stats, onLearnStep := b.learnStats, b.OnLearnStep
+	b.learnStats = nil
+
+	if stats == nil || onLearnStep == nil {
+		return func() {}
+	}
+
+	return func() { onLearnStep(*stats) }
+}
+
+// MarshalStatsJSON returns the result of Stats encoded as JSON.
+func (b *Brain) MarshalStatsJSON() ([]byte, error) {
+	return json.Marshal(b.Stats())
+}
@@ -0,0 +1,61 @@
+package deepqlearn
+
+// SumTree holds a non-negative weight for each of a fixed number of slots,
+// and can find the slot at any point of their running total in O(log n)
+// time. It is used to sample experiences in proportion to their priority.
+type SumTree struct {
+	leaves int       // a power of two, at least the number of slots
+	nodes  []float64 // nodes[1] is the root; the children of i are 2i and 2i+1
+}
+
+// NewSumTree returns a SumTree with n slots, all of weight zero.
+func NewSumTree(n int) *SumTree {
+	leaves := 1
+	for leaves < n {
+		leaves *= 2
+	}
+
+	return &SumTree{
+		leaves: leaves,
+		nodes:  make([]float64, 2*leaves),
+	}
+}
+
+// Set changes the weight of slot i.
+func (t *SumTree) Set(i int, w float64) {
+	i += t.leaves
+	t.nodes[i] = w
+
+	for i /= 2; i >= 1; i /= 2 {
+		t.nodes[i] = t.nodes[2*i] + t.nodes[2*i+1]
+	}
+}
+
+// Get returns the weight of slot i.
+func (t *SumTree) Get(i int) float64 {
+	return t.nodes[i+t.leaves]
+}
+
+// Total returns the sum of every weight.
+func (t *SumTree) Total() float64 {
+	return t.nodes[1]
+}
+
+// Find returns the slot whose range of the running total contains u,
+// where 0 <= u < Total(). Drawing u uniformly picks each slot with
+// probability proportional to its weight.
+func (t *SumTree) Find(u float64) int {
+	i := 1
+	for i < t.leaves {
+		if left := t.nodes[2*i]; u < left || t.nodes[2*i+1] == 0 {
+			// rounding can leave u just past the last non-zero
+			// weight, so never step into an empty subtree
+			i = 2 * i
+		} else {
+			u -= left
+			i = 2*i + 1
+		}
+	}
+
+	return i - t.leaves
+}
@@ -0,0 +1,88 @@
+package deepqlearn
+
+import (
+	"math"
+
+	"github.com/BenLubar/convnet"
+)
+
+// learnTraces makes the online Watkins's Q(λ) update for the last step:
+// the TD error of the action taken in the state before the last one is
+// applied to every parameter in proportion to its eligibility trace.
+func (b *Brain) learnTraces() {
+	b.applySchedules()
+
+	n := b.WindowSize
+	s0, a0, r0 := b.NetWindow[n-2], b.ActionWindow[n-2], b.RewardWindow[n-2]
+	s1, a1, valid1 := b.NetWindow[n-1], b.ActionWindow[n-1], b.MaskWindow[n-1]
+
+	values1 := b.actionValues(&b.ValueNet, s1)
+	best := b.bestAction(values1, valid1)
+
+	// the gradient of the value of a0 is the gradient of a regression
+	// loss with a target 1 below it
+	q := b.ValueNet.Forward(b.inputVol(s0), true).W[a0]
+	b.ValueNet.Backward(convnet.LossData{Dim: a0, Val: q - 1})
+
+	delta := r0 + b.Gamma*values1[best] - q
+
+	pglist := b.ValueNet.ParamsAndGrads()
+	if len(b.traces) != len(pglist) {
+		b.traces = make([][]float64, len(pglist))
+		for i, pg := range pglist {
+			b.traces[i] = make([]float64, len(pg.Params))
+		}
+	}
+
+	// traces carry on through greedy actions, but the values of earlier
+	// steps say nothing about an exploratory one
+	decay := 0.0
+	if a1 == best {
+		decay = b.Gamma * b.Lambda
+	}
+
+	lr := b.TDTrainer.LearningRate
+	for i, pg := range pglist {
+		trace := b.traces[i]
+
+		for j, g := range pg.Grads {
+			if b.ReplacingTraces && g != 0 {
+				trace[j] = g
+			} else {
+				trace[j] += g
+			}
+
+			if !pg.Frozen {
+				pg.Params[j] += lr * delta * trace[j]
+			}
+
+			pg.Grads[j] = 0
+			trace[j] *= decay
+		}
+	}
+
+	stats := LearnStats{
+		Age:            b.Age,
+		Epsilon:        b.Epsilon,
+		LearningRate:   lr,
+		Gamma:          b.Gamma,
+		Loss:           0.5 * delta * delta,
+		MeanAbsTDError: math.Abs(delta),
+		MaxAbsTDError:  math.Abs(delta),
+	}
+	b.AverageLossWindow.Add(stats.Loss)
+	b.learnStats = &stats
+}
+
+func cloneTraces(traces [][]float64) [][]float64 {
+	if traces == nil {
+		return nil
+	}
+
+	clone := make([][]float64, len(traces))
+	for i, t := range traces {
+		clone[i] = append([]float64(nil), t...)
+	}
+
+	return clone
+}
@@ -0,0 +1,49 @@
+package convnet
+
+// DistillTrain trains the net, whose last layer must be a softmax, to
+// match both a hard label and the soft outputs of a teacher network, as in
+// knowledge distillation (Hinton et al. 2015). Only the teacher's logits
+// (the inputs to its softmax) are needed, not the teacher itself.
+//
+// The cost is alpha times the cross-entropy between
+// softmax(teacherLogits / temperature) and the student's output at the
+// same temperature, plus (1 - alpha) times the usual cross-entropy with
+// hardLabel. The gradient of the soft term shrinks as 1/temperature^2, so
+// high temperatures usually need a larger alpha. It panics unless
+// temperature is positive and alpha is in [0, 1].
+func (t *Trainer) DistillTrain(x *Vol, hardLabel int, teacherLogits []float64, temperature, alpha float64) TrainingResult {
+	if !(temperature > 0) {
+		panic("convnet: DistillTrain requires a temperature greater than 0")
+	}
+	if !(alpha >= 0 && alpha <= 1) {
+		panic("convnet: DistillTrain requires an alpha between 0 and 1")
+	}
+
+	s, ok := t.Net.Layers[len(t.Net.Layers)-1].(*SoftmaxLayer)
+	if !ok {
+		panic("convnet: DistillTrain requires the last layer to be a softmax")
+	}
+	if len(teacherLogits) != s.outDepth {
+		panic("convnet: DistillTrain requires one teacher logit per class")
+	}
+
+	t.Net.Forward(x, true)
+
+	// gradient of the soft term
+	softTargets := softmax(teacherLogits, temperature)
+	softLoss := s.BackwardSoft(softTargets, temperature)
+	softGrad := s.inAct.Dw
+
+	// gradient of the hard term, blended with the soft term
+	hardLoss := s.BackwardLoss(LossData{Dim: hardLabel})
+	for i := range s.inAct.Dw {
+		s.inAct.Dw[i] = alpha*softGrad[i] + (1-alpha)*s.inAct.Dw[i]
+	}
+
+	t.Net.backwardHidden()
+
+	costLoss := alpha*softLoss + (1-alpha)*hardLoss
+	l1DecayLoss, l2DecayLoss := t.step(t.Net, 1)
+
+	return t.result(costLoss, l1DecayLoss, l2DecayLoss)
+}
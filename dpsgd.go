@@ -0,0 +1,110 @@
+package convnet
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"math"
+	"math/rand"
+)
+
+// dpAccumulate clips the gradient of the example that was just
+// backpropagated to an L2 norm of at most ClipNorm, adds it to t.dpsum,
+// and clears the net's gradients so that the next example's gradient can
+// be seen on its own. Frozen parameters are not counted or kept.
+func (t *Trainer) dpAccumulate(pglist []ParamsAndGrads) {
+	if len(t.dpsum) == 0 {
+		for _, pg := range pglist {
+			t.dpsum = append(t.dpsum, make([]float64, len(pg.Grads)))
+		}
+	}
+
+	sumSq := 0.0
+	for _, pg := range pglist {
+		if pg.Frozen {
+			continue
+		}
+
+		for _, g := range pg.Grads {
+			sumSq += g * g
+		}
+	}
+
+	scale := 1.0
+	if norm := math.Sqrt(sumSq); norm > t.ClipNorm {
+		scale = t.ClipNorm / norm
+	}
+
+	for i, pg := range pglist {
+		for j, g := range pg.Grads {
+			if !pg.Frozen {
+				t.dpsum[i][j] += g * scale
+			}
+			pg.Grads[j] = 0
+		}
+	}
+}
+
+// dpNoise puts the sum of the clipped gradients of the batch, plus
+// Gaussian noise with a standard deviation of NoiseSigma*ClipNorm, back
+// into the net's gradients so that step can average them and update
+// the parameters as sgd would.
+func (t *Trainer) dpNoise(pglist []ParamsAndGrads) {
+	r := t.Rand
+	if r == nil {
+		if t.dpRand == nil {
+			t.dpRand = rand.New(rand.NewSource(dpSeed()))
+		}
+		r = t.dpRand
+	}
+
+	stddev := t.NoiseSigma * t.ClipNorm
+
+	for i, pg := range pglist {
+		if pg.Frozen {
+			continue
+		}
+
+		for j := range pg.Grads {
+			pg.Grads[j] = t.dpsum[i][j] + r.NormFloat64()*stddev
+			t.dpsum[i][j] = 0
+		}
+	}
+}
+
+// dpSeed returns a seed for the noise of a trainer that was not given a
+// Rand. It must not be guessable: anyone who could regenerate the noise
+// could subtract it from the updates and undo the privacy it buys.
+func dpSeed() int64 {
+	var b [8]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		panic("convnet: could not seed DP-SGD noise: " + err.Error())
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// DPEpsilon returns an epsilon such that the updates made by a MethodDPSGD
+// trainer so far are (epsilon, DeltaDP)-differentially private with
+// respect to any one training example.
+//
+// The bound composes the Rényi differential privacy of the Gaussian
+// mechanism over every update, as if each example had been in every
+// batch. It ignores the amplification that comes from sampling batches,
+// so it is conservative: with a large dataset, the true epsilon is much
+// smaller.
+func (t *Trainer) DPEpsilon() float64 {
+	steps := float64(t.k / t.BatchSize)
+	if steps == 0 {
+		return 0
+	}
+	if t.NoiseSigma <= 0 || t.DeltaDP <= 0 {
+		return math.Inf(1)
+	}
+
+	// each update is (alpha, alpha/(2 sigma^2))-RDP; T updates compose to
+	// a*alpha with a = T/(2 sigma^2), which is (a*alpha + log(1/delta) /
+	// (alpha-1), delta)-DP for any alpha > 1. the best alpha gives:
+	a := steps / (2 * t.NoiseSigma * t.NoiseSigma)
+
+	return a + 2*math.Sqrt(a*math.Log(1/t.DeltaDP))
+}
@@ -0,0 +1,146 @@
+package convnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Ensemble combines the outputs of several nets, usually trained from
+// different random seeds. Softmax and regression outputs are averaged, and
+// svm scores are summed. Every member must end with the same kind of loss
+// layer and produce an output of the same size.
+type Ensemble struct {
+	Nets []*Net `json:"nets"`
+
+	// Weights scales the output of each net. If it is nil, every net has
+	// a weight of 1. Weights are normalized to sum to 1 when averaging.
+	Weights []float64 `json:"weights"`
+
+	out *Vol // output of the most recent Forward
+}
+
+// NewEnsemble creates an ensemble of nets, checking that they fit
+// together. weights may be nil.
+func NewEnsemble(nets []*Net, weights []float64) (*Ensemble, error) {
+	e := &Ensemble{Nets: nets, Weights: weights}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Validate checks that the ensemble has at least one net, that every net
+// has the same kind of loss layer and output size, and that there is one
+// weight per net if there are any weights at all, with a sum other than 0.
+func (e *Ensemble) Validate() error {
+	if len(e.Nets) == 0 {
+		return errors.New("convnet: an ensemble needs at least one net")
+	}
+	if e.Weights != nil {
+		if len(e.Weights) != len(e.Nets) {
+			return fmt.Errorf("convnet: ensemble has %d weights for %d nets", len(e.Weights), len(e.Nets))
+		}
+
+		total := 0.0
+		for _, w := range e.Weights {
+			total += w
+		}
+		if total == 0 {
+			return errors.New("convnet: ensemble weights sum to 0")
+		}
+	}
+	for i, n := range e.Nets {
+		if len(n.Layers) == 0 {
+			return fmt.Errorf("convnet: ensemble net %d has no layers", i)
+		}
+	}
+
+	first := e.Nets[0].Layers[len(e.Nets[0].Layers)-1]
+	for i, n := range e.Nets {
+		last := n.Layers[len(n.Layers)-1]
+
+		if _, ok := last.(LossLayer); !ok {
+			return fmt.Errorf("convnet: ensemble net %d does not end with a loss layer", i)
+		}
+		if layerTypeOf(last) != layerTypeOf(first) {
+			return fmt.Errorf("convnet: ensemble net %d ends with %v, but net 0 ends with %v", i, layerTypeOf(last), layerTypeOf(first))
+		}
+		if shapeOf(last) != shapeOf(first) {
+			s, f := shapeOf(last), shapeOf(first)
+			return fmt.Errorf("convnet: ensemble net %d has output %dx%dx%d, but net 0 has %dx%dx%d", i, s[0], s[1], s[2], f[0], f[1], f[2])
+		}
+	}
+
+	return nil
+}
+
+func (e *Ensemble) weight(i int) float64 {
+	if e.Weights == nil {
+		return 1
+	}
+
+	return e.Weights[i]
+}
+
+// Forward runs every net in prediction mode and returns their combined
+// output. It panics if the ensemble is not valid.
+func (e *Ensemble) Forward(v *Vol) *Vol {
+	if err := e.Validate(); err != nil {
+		panic(err.Error())
+	}
+
+	_, sum := e.Nets[0].Layers[len(e.Nets[0].Layers)-1].(*SVMLayer)
+
+	total := 0.0
+	for i := range e.Nets {
+		total += e.weight(i)
+	}
+
+	var out *Vol
+	for i, n := range e.Nets {
+		o := n.Forward(v, false)
+		if out == nil {
+			out = o.CloneAndZero()
+		}
+
+		w := e.weight(i)
+		if !sum {
+			w /= total
+		}
+
+		out.AddFromScaled(o, w)
+	}
+
+	e.out = out
+
+	return out
+}
+
+// Prediction returns the index of the highest combined output from the
+// most recent call to Forward.
+func (e *Ensemble) Prediction() int {
+	if e.out == nil {
+		panic("convnet: Ensemble.Prediction called before Forward")
+	}
+
+	return argmax(e.out.W)
+}
+
+func (e *Ensemble) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Nets    []*Net    `json:"nets"`
+		Weights []float64 `json:"weights"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	e.Nets = data.Nets
+	e.Weights = data.Weights
+	e.out = nil
+
+	return e.Validate()
+}
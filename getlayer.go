@@ -0,0 +1,85 @@
+package convnet
+
+// GetLayer returns every layer of n that has type T, in order. For example,
+// GetLayer[*ConvLayer](n) returns the conv layers of n. Activation layers
+// added by a LayerDef's Activation field are separate layers and are
+// returned by the getter for their own type.
+func GetLayer[T Layer](n *Net) []T {
+	var layers []T
+
+	for _, l := range n.Layers {
+		if t, ok := l.(T); ok {
+			layers = append(layers, t)
+		}
+	}
+
+	return layers
+}
+
+// GetInputLayers returns the input layers of n.
+func GetInputLayers(n *Net) []*InputLayer { return GetLayer[*InputLayer](n) }
+
+// GetFCLayers returns the fully connected layers of n.
+func GetFCLayers(n *Net) []*FullyConnLayer { return GetLayer[*FullyConnLayer](n) }
+
+// GetConvLayers returns the conv layers of n.
+func GetConvLayers(n *Net) []*ConvLayer { return GetLayer[*ConvLayer](n) }
+
+// GetDeformConvLayers returns the deformable conv layers of n.
+func GetDeformConvLayers(n *Net) []*DeformConvLayer { return GetLayer[*DeformConvLayer](n) }
+
+// GetCausalConvLayers returns the causal conv layers of n.
+func GetCausalConvLayers(n *Net) []*CausalConvLayer { return GetLayer[*CausalConvLayer](n) }
+
+// GetPoolLayers returns the pool layers of n.
+func GetPoolLayers(n *Net) []*PoolLayer { return GetLayer[*PoolLayer](n) }
+
+// GetLpPoolLayers returns the Lp pooling layers of n.
+func GetLpPoolLayers(n *Net) []*LpPoolLayer { return GetLayer[*LpPoolLayer](n) }
+
+// GetSPPLayers returns the spatial pyramid pooling layers of n.
+func GetSPPLayers(n *Net) []*SPPLayer { return GetLayer[*SPPLayer](n) }
+
+// GetLRNLayers returns the local response normalization layers of n.
+func GetLRNLayers(n *Net) []*LocalResponseNormalizationLayer {
+	return GetLayer[*LocalResponseNormalizationLayer](n)
+}
+
+// GetBatchNormLayers returns the batch normalization layers of n.
+func GetBatchNormLayers(n *Net) []*BatchNormLayer { return GetLayer[*BatchNormLayer](n) }
+
+// GetDropoutLayers returns the dropout layers of n.
+func GetDropoutLayers(n *Net) []*DropoutLayer { return GetLayer[*DropoutLayer](n) }
+
+// GetMixoutLayers returns the mixout layers of n.
+func GetMixoutLayers(n *Net) []*MixoutLayer { return GetLayer[*MixoutLayer](n) }
+
+// GetEmbeddingLayers returns the embedding layers of n.
+func GetEmbeddingLayers(n *Net) []*EmbeddingLayer { return GetLayer[*EmbeddingLayer](n) }
+
+// GetFPNLayers returns the feature pyramid layers of n.
+func GetFPNLayers(n *Net) []*FPNLayer { return GetLayer[*FPNLayer](n) }
+
+// GetReluLayers returns the relu layers of n.
+func GetReluLayers(n *Net) []*ReluLayer { return GetLayer[*ReluLayer](n) }
+
+// GetSigmoidLayers returns the sigmoid layers of n.
+func GetSigmoidLayers(n *Net) []*SigmoidLayer { return GetLayer[*SigmoidLayer](n) }
+
+// GetTanhLayers returns the tanh layers of n.
+func GetTanhLayers(n *Net) []*TanhLayer { return GetLayer[*TanhLayer](n) }
+
+// GetMaxoutLayers returns the maxout layers of n.
+func GetMaxoutLayers(n *Net) []*MaxoutLayer { return GetLayer[*MaxoutLayer](n) }
+
+// GetSwishLayers returns the swish layers of n.
+func GetSwishLayers(n *Net) []*SwishLayer { return GetLayer[*SwishLayer](n) }
+
+// GetSoftmaxLayers returns the softmax layers of n.
+func GetSoftmaxLayers(n *Net) []*SoftmaxLayer { return GetLayer[*SoftmaxLayer](n) }
+
+// GetSVMLayers returns the svm layers of n.
+func GetSVMLayers(n *Net) []*SVMLayer { return GetLayer[*SVMLayer](n) }
+
+// GetRegressionLayers returns the regression layers of n.
+func GetRegressionLayers(n *Net) []*RegressionLayer { return GetLayer[*RegressionLayer](n) }
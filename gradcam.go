@@ -0,0 +1,127 @@
+package convnet
+
+import (
+	"fmt"
+	"math"
+)
+
+// GradCAM computes a class activation map for input (Selvaraju et al.
+// 2017), showing which parts of the output of layer targetLayerIndex,
+// usually the last conv layer, were most important for the score of
+// classIndex. Each depth slice of the target layer's output is weighted by
+// the average gradient of the class score over that slice, and the
+// weighted sum is passed through a relu. The result has the width and
+// height of the target layer's output and a depth of 1.
+//
+// The class score is the input to the loss layer at the end of the net,
+// which for a softmax is the unnormalized log probability. The net is run
+// in prediction mode, and its accumulated gradients are left unchanged.
+func GradCAM(n *Net, input *Vol, classIndex int, targetLayerIndex int) (*Vol, error) {
+	if err := n.checkClassifier(true); err != nil {
+		return nil, err
+	}
+
+	last := len(n.Layers) - 1
+	if targetLayerIndex < 0 || targetLayerIndex >= last {
+		return nil, fmt.Errorf("convnet: target layer index %d out of range", targetLayerIndex)
+	}
+
+	if err := n.backwardScore(input, classIndex, targetLayerIndex); err != nil {
+		return nil, err
+	}
+
+	act := n.Layers[targetLayerIndex].Output()
+	grad := n.Layers[targetLayerIndex].GradOutput()
+
+	// average the gradient over each depth slice
+	alpha := make([]float64, act.Depth)
+	for x := 0; x < act.Sx; x++ {
+		for y := 0; y < act.Sy; y++ {
+			for d := 0; d < act.Depth; d++ {
+				alpha[d] += grad.Get(x, y, d)
+			}
+		}
+	}
+	for d := range alpha {
+		alpha[d] /= float64(act.Sx * act.Sy)
+	}
+
+	cam := NewVol(act.Sx, act.Sy, 1, 0.0)
+	for x := 0; x < act.Sx; x++ {
+		for y := 0; y < act.Sy; y++ {
+			sum := 0.0
+			for d := 0; d < act.Depth; d++ {
+				sum += alpha[d] * act.Get(x, y, d)
+			}
+
+			cam.Set(x, y, 0, math.Max(sum, 0))
+		}
+	}
+
+	return cam, nil
+}
+
+// InputGradient returns the gradient of the score of class with respect to
+// v, for a saliency map (Simonyan et al. 2014). As in GradCAM, the score is
+// the input to the loss layer, and the net is run in prediction mode. The
+// gradients the net has accumulated for its parameters and the gradient
+// stored in v are left unchanged, so InputGradient can be called in the
+// middle of a training batch. It panics if class is out of range or the
+// net does not end in a softmax, svm, or regression layer.
+func (n *Net) InputGradient(v *Vol, class int) *Vol {
+	if err := n.checkClassifier(true); err != nil {
+		panic(err.Error())
+	}
+
+	savedDw := v.Dw
+	v.Dw = make([]float64, len(v.W))
+	defer func() { v.Dw = savedDw }()
+
+	if err := n.backwardScore(v, class, 0); err != nil {
+		panic(err.Error())
+	}
+
+	g := NewVol(v.Sx, v.Sy, v.Depth, 0.0)
+	copy(g.W, v.Dw)
+
+	return g
+}
+
+// backwardScore runs input through the net in prediction mode and then
+// backpropagates the score of classIndex, rather than the loss, down to
+// the output of layer stop. The gradients of the parameters are restored
+// afterwards.
+func (n *Net) backwardScore(input *Vol, classIndex, stop int) error {
+	last := len(n.Layers) - 1
+
+	n.Forward(input, false)
+
+	scores := input
+	if last > 0 {
+		scores = n.Layers[last-1].Output()
+	}
+	if classIndex < 0 || classIndex >= len(scores.W) {
+		return fmt.Errorf("convnet: class index %d out of range", classIndex)
+	}
+
+	// keep whatever the trainer has accumulated so far
+	pgs := n.ParamsAndGrads()
+	saved := make([][]float64, len(pgs))
+	for i, pg := range pgs {
+		saved[i] = append([]float64(nil), pg.Grads...)
+	}
+	defer func() {
+		for i, pg := range pgs {
+			copy(pg.Grads, saved[i])
+		}
+	}()
+
+	scores.Dw = make([]float64, len(scores.W))
+	scores.Dw[classIndex] = 1
+
+	for i := last - 1; i > stop; i-- {
+		n.Layers[i].Backward()
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package gradcheck compares the gradients computed by a network's
+// Backward pass against numerical estimates from finite differences.
+// It is mainly useful when writing new layer types.
+package gradcheck
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/BenLubar/convnet"
+)
+
+// Options controls how gradients are checked.
+type Options struct {
+	// Delta is the step used for central differences.
+	Delta float64
+	// MaxSamples is the largest number of elements checked in any one
+	// parameter group or in the input. Larger groups are sub-sampled.
+	// Zero means every element is checked.
+	MaxSamples int
+	// MinDenom is the smallest denominator used when computing relative
+	// errors, so that two gradients that are both nearly zero are not
+	// reported as wildly different.
+	MinDenom float64
+	// Rand chooses which elements to sample. If it is nil, a fixed seed
+	// is used so that results are repeatable.
+	Rand *rand.Rand
+}
+
+var DefaultOptions = Options{
+	Delta:      1e-5,
+	MaxSamples: 50,
+	MinDenom:   1e-6,
+}
+
+// Report holds the worst relative error found for each part of the net.
+type Report struct {
+	// Input is the worst relative error of the gradient with respect
+	// to the input volume.
+	Input float64
+	// Layers holds the worst relative error of the gradient with
+	// respect to the parameters of each layer, indexed like net.Layers.
+	// Layers without parameters have an error of zero.
+	Layers []float64
+}
+
+// Max returns the worst relative error anywhere in the report.
+func (r Report) Max() float64 {
+	worst := r.Input
+	for _, e := range r.Layers {
+		worst = math.Max(worst, e)
+	}
+
+	return worst
+}
+
+// Check runs the net forward in prediction mode on x, computes the
+// gradients of the loss for y with Backward, and compares them to
+// central differences of the loss. The parameters, accumulated gradients,
+// and input of the net are restored exactly before Check returns.
+//
+// Layers that behave differently in training, such as dropout, and
+// inputs that sit on a kink of a non-smooth function, such as a relu at
+// zero or a tie in a max pool, will produce spurious errors.
+func Check(net *convnet.Net, x *convnet.Vol, y convnet.LossData, opts Options) Report {
+	r := opts.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(0))
+	}
+
+	// save the gradients the trainer may have accumulated so far, and
+	// start from zero so that Backward gives the gradient of this
+	// example alone
+	var saved [][]float64
+	for _, pg := range net.ParamsAndGrads() {
+		saved = append(saved, append([]float64(nil), pg.Grads...))
+
+		for j := range pg.Grads {
+			pg.Grads[j] = 0
+		}
+	}
+	savedInput := append([]float64(nil), x.Dw...)
+
+	net.Forward(x, false)
+	net.Backward(y)
+
+	report := Report{Layers: make([]float64, len(net.Layers))}
+
+	report.Input = check(x.W, append([]float64(nil), x.Dw...), net, x, y, opts, r)
+
+	for i, l := range net.Layers {
+		for _, pg := range l.ParamsAndGrads() {
+			e := check(pg.Params, append([]float64(nil), pg.Grads...), net, x, y, opts, r)
+			report.Layers[i] = math.Max(report.Layers[i], e)
+		}
+	}
+
+	for i, pg := range net.ParamsAndGrads() {
+		copy(pg.Grads, saved[i])
+	}
+	x.Dw = savedInput
+
+	return report
+}
+
+// check compares analytic, the gradient with respect to params, against
+// central differences, and returns the worst relative error
+func check(params, analytic []float64, net *convnet.Net, x *convnet.Vol, y convnet.LossData, opts Options, r *rand.Rand) float64 {
+	indices := r.Perm(len(params))
+	if opts.MaxSamples > 0 && len(indices) > opts.MaxSamples {
+		indices = indices[:opts.MaxSamples]
+	}
+
+	worst := 0.0
+
+	for _, j := range indices {
+		old := params[j]
+
+		params[j] = old + opts.Delta
+		lossPlus := net.CostLoss(x, y)
+		params[j] = old - opts.Delta
+		lossMinus := net.CostLoss(x, y)
+		params[j] = old // reset
+
+		numeric := (lossPlus - lossMinus) / (2 * opts.Delta)
+
+		denom := math.Max(math.Max(math.Abs(numeric), math.Abs(analytic[j])), opts.MinDenom)
+		relError := math.Abs(numeric-analytic[j]) / denom
+
+		if relError > worst || math.IsNaN(relError) {
+			worst = relError
+		}
+	}
+
+	return worst
+}
@@ -0,0 +1,108 @@
+package gradcheck_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/gradcheck"
+)
+
+func createTestNet() *convnet.Net {
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 5, OutSy: 5, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 4, Pad: 1, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerPool, Sx: 2},
+		{Type: convnet.LayerFC, NumNeurons: 6, Activation: convnet.LayerMaxout},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerSigmoid},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, rand.New(rand.NewSource(0)))
+
+	return net
+}
+
+// brokenFC has a subtly wrong backward pass: it doubles the gradient of
+// its weights.
+type brokenFC struct {
+	*convnet.FullyConnLayer
+}
+
+func (l brokenFC) Backward() {
+	before := make([][]float64, 0)
+	for _, pg := range l.ParamsAndGrads() {
+		before = append(before, append([]float64(nil), pg.Grads...))
+	}
+
+	l.FullyConnLayer.Backward()
+
+	for i, pg := range l.ParamsAndGrads() {
+		for j := range pg.Grads {
+			pg.Grads[j] += pg.Grads[j] - before[i][j]
+		}
+	}
+}
+
+// it should agree with correct gradients and leave the net unchanged
+func TestCheck(t *testing.T) {
+	net := createTestNet()
+	x := convnet.NewVolRand(5, 5, 2, rand.New(rand.NewSource(1)))
+	y := convnet.LossData{Dim: 2}
+
+	// leave some accumulated gradient behind, as a trainer in the
+	// middle of a batch would
+	net.Forward(x, true)
+	net.Backward(convnet.LossData{Dim: 1})
+
+	before, _ := json.Marshal(net)
+	var grads [][]float64
+	for _, pg := range net.ParamsAndGrads() {
+		grads = append(grads, append([]float64(nil), pg.Grads...))
+	}
+
+	report := gradcheck.Check(net, x, y, gradcheck.DefaultOptions)
+	t.Logf("%+v", report)
+
+	if e := report.Max(); e > 1e-3 {
+		t.Errorf("expected gradients to match, but worst relative error is %g", e)
+	}
+	if len(report.Layers) != len(net.Layers) {
+		t.Errorf("expected %d layer errors, but got %d", len(net.Layers), len(report.Layers))
+	}
+
+	after, _ := json.Marshal(net)
+	if string(before) != string(after) {
+		t.Error("expected parameters to be restored")
+	}
+	for i, pg := range net.ParamsAndGrads() {
+		for j := range pg.Grads {
+			if pg.Grads[j] != grads[i][j] {
+				t.Fatalf("expected accumulated gradient %d/%d to be restored", i, j)
+			}
+		}
+	}
+}
+
+// it should find a layer with a broken backward pass
+func TestCheckBroken(t *testing.T) {
+	net := createTestNet()
+
+	// layers: input, conv, tanh, pool, fc, maxout, fc, sigmoid, fc, softmax
+	const broken = 6
+	net.Layers[broken] = brokenFC{net.Layers[broken].(*convnet.FullyConnLayer)}
+
+	x := convnet.NewVolRand(5, 5, 2, rand.New(rand.NewSource(1)))
+	report := gradcheck.Check(net, x, convnet.LossData{Dim: 0}, gradcheck.DefaultOptions)
+	t.Logf("%+v", report)
+
+	for i, e := range report.Layers {
+		if i == broken {
+			if e < 0.1 {
+				t.Errorf("expected broken layer %d to have a large error, but it is %g", i, e)
+			}
+		} else if e > 1e-3 {
+			t.Errorf("expected layer %d to be correct, but its error is %g", i, e)
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package convnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// MergeType is the way a merge node in a Graph combines its inputs.
+type MergeType int
+
+const (
+	MergeNone   MergeType = iota // not a merge node
+	MergeConcat                  // concatenate along depth
+	MergeAdd                     // elementwise sum
+)
+
+// GraphNode is a node in a Graph. Input nodes have an *InputLayer and no
+// inputs, merge nodes have a Merge type and no Layer, and every other node
+// has a Layer and exactly one input.
+type GraphNode struct {
+	Name   string
+	Inputs []string
+	Layer  Layer
+	Merge  MergeType
+
+	shape [3]int    // output shape, filled in by Validate
+	out   *Vol      // output of the most recent Forward
+	grad  []float64 // gradient wrt out, accumulated over consumers
+}
+
+// Graph is a network whose layers form a directed acyclic graph rather
+// than a chain, so that activations can fan out to several layers and be
+// merged again, and so that there can be several inputs. Exactly one node,
+// named by Output, must be a loss layer.
+type Graph struct {
+	Nodes  []*GraphNode
+	Output string
+
+	order  []*GraphNode // topological order, filled in by Validate
+	byName map[string]*GraphNode
+}
+
+func (g *Graph) node(name string) *GraphNode {
+	for _, n := range g.Nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// fills in byName for nodes that were put in Nodes directly rather than
+// through the Add methods
+func (g *Graph) indexNodes() {
+	if g.byName != nil && len(g.byName) == len(g.Nodes) {
+		return
+	}
+
+	g.byName = make(map[string]*GraphNode, len(g.Nodes))
+	for _, n := range g.Nodes {
+		g.byName[n.Name] = n
+	}
+}
+
+func (g *Graph) add(n *GraphNode) error {
+	if n.Name == "" {
+		return errors.New("convnet: graph node name must not be empty")
+	}
+	if g.node(n.Name) != nil {
+		return fmt.Errorf("convnet: graph already has a node named %q", n.Name)
+	}
+
+	g.indexNodes()
+
+	g.Nodes = append(g.Nodes, n)
+	g.byName[n.Name] = n
+	g.order = nil
+
+	return nil
+}
+
+// AddInput adds an input node that accepts volumes of the given shape.
+func (g *Graph) AddInput(name string, sx, sy, depth int) error {
+	l := &InputLayer{}
+	l.fromDef(LayerDef{Type: LayerInput, OutSx: sx, OutSy: sy, OutDepth: depth}, nil)
+
+	return g.add(&GraphNode{Name: name, Layer: l, shape: shapeOf(l)})
+}
+
+// AddLayer adds the layer described by def, taking its input from the node
+// named input. Like Net.MakeLayers, definitions with an activation,
+// dropout, or an implied fully connected layer become several nodes; the
+// last of them is named name, and the others are named name/0, name/1,
+// and so on.
+func (g *Graph) AddLayer(name string, def LayerDef, input string, r *rand.Rand) error {
+	in := g.node(input)
+	if in == nil {
+		return fmt.Errorf("convnet: graph has no node named %q", input)
+	}
+	if def.Type == LayerInput {
+		return errors.New("convnet: use AddInput to add input nodes")
+	}
+
+	shape := &InputLayer{outSx: in.shape[0], outSy: in.shape[1], outDepth: in.shape[2]}
+	layers := makeLayers(desugar([]LayerDef{def}), shape, r)
+
+	for i, l := range layers {
+		n := &GraphNode{Name: name, Inputs: []string{input}, Layer: l, shape: shapeOf(l)}
+		if i != len(layers)-1 {
+			n.Name = fmt.Sprintf("%s/%d", name, i)
+		}
+
+		if err := g.add(n); err != nil {
+			return err
+		}
+
+		input = n.Name
+	}
+
+	return nil
+}
+
+// AddMerge adds a node that combines the outputs of two or more nodes.
+func (g *Graph) AddMerge(name string, merge MergeType, inputs ...string) error {
+	if merge != MergeConcat && merge != MergeAdd {
+		return fmt.Errorf("convnet: unknown merge type %d", merge)
+	}
+	if len(inputs) < 2 {
+		return errors.New("convnet: merge nodes must have at least two inputs")
+	}
+	for _, in := range inputs {
+		if g.node(in) == nil {
+			return fmt.Errorf("convnet: graph has no node named %q", in)
+		}
+	}
+
+	g.indexNodes()
+
+	n := &GraphNode{Name: name, Inputs: inputs, Merge: merge}
+	if err := g.inferShape(n); err != nil {
+		return err
+	}
+
+	return g.add(n)
+}
+
+// Validate checks that the graph is well formed: node names are unique,
+// every input refers to an existing node, there are no cycles, every node
+// contributes to the output, the output is the only loss layer, and the
+// shapes of connected nodes agree.
+func (g *Graph) Validate() error {
+	g.order = nil
+	g.byName = make(map[string]*GraphNode, len(g.Nodes))
+
+	for _, n := range g.Nodes {
+		if _, ok := g.byName[n.Name]; ok || n.Name == "" {
+			return fmt.Errorf("convnet: graph node name %q is empty or not unique", n.Name)
+		}
+
+		g.byName[n.Name] = n
+	}
+
+	// count the inputs of each node that are not yet in order (Kahn's algorithm)
+	consumers := make(map[string][]*GraphNode)
+	pending := make(map[*GraphNode]int)
+	var order, ready []*GraphNode
+
+	for _, n := range g.Nodes {
+		_, isInput := n.Layer.(*InputLayer)
+
+		switch {
+		case isInput:
+			if len(n.Inputs) != 0 {
+				return fmt.Errorf("convnet: graph input node %q must not have inputs", n.Name)
+			}
+		case n.Merge != MergeNone:
+			if n.Layer != nil {
+				return fmt.Errorf("convnet: graph merge node %q must not have a layer", n.Name)
+			}
+			if n.Merge != MergeConcat && n.Merge != MergeAdd {
+				return fmt.Errorf("convnet: graph merge node %q has unknown merge type %d", n.Name, n.Merge)
+			}
+			if len(n.Inputs) < 2 {
+				return fmt.Errorf("convnet: graph merge node %q must have at least two inputs", n.Name)
+			}
+		case n.Layer == nil:
+			return fmt.Errorf("convnet: graph node %q has neither a layer nor a merge type", n.Name)
+		default:
+			if len(n.Inputs) != 1 {
+				return fmt.Errorf("convnet: graph node %q must have exactly one input", n.Name)
+			}
+		}
+
+		if _, ok := n.Layer.(LossLayer); ok && n.Name != g.Output {
+			return fmt.Errorf("convnet: graph node %q is a loss layer but not the output", n.Name)
+		}
+
+		for _, in := range n.Inputs {
+			if _, ok := g.byName[in]; !ok {
+				return fmt.Errorf("convnet: graph node %q has unknown input %q", n.Name, in)
+			}
+
+			consumers[in] = append(consumers[in], n)
+		}
+
+		pending[n] = len(n.Inputs)
+		if len(n.Inputs) == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	for len(ready) != 0 {
+		n := ready[0]
+		ready = ready[1:]
+		order = append(order, n)
+
+		if err := g.inferShape(n); err != nil {
+			return err
+		}
+
+		for _, c := range consumers[n.Name] {
+			if pending[c]--; pending[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+
+	if len(order) != len(g.Nodes) {
+		return errors.New("convnet: graph has a cycle")
+	}
+
+	out, ok := g.byName[g.Output]
+	if !ok {
+		return fmt.Errorf("convnet: graph has no output node named %q", g.Output)
+	}
+	if _, ok := out.Layer.(LossLayer); !ok {
+		return fmt.Errorf("convnet: graph output node %q must be a loss layer", g.Output)
+	}
+
+	// every node must be able to reach the output
+	reached := map[string]bool{out.Name: true}
+	for i := len(order) - 1; i >= 0; i-- {
+		if reached[order[i].Name] {
+			for _, in := range order[i].Inputs {
+				reached[in] = true
+			}
+		}
+	}
+	for _, n := range g.Nodes {
+		if !reached[n.Name] {
+			return fmt.Errorf("convnet: graph node %q is not connected to the output", n.Name)
+		}
+	}
+
+	g.order = order
+
+	return nil
+}
+
+// fills in the output shape of n from the shapes of its inputs
+func (g *Graph) inferShape(n *GraphNode) error {
+	switch n.Merge {
+	case MergeConcat:
+		first := g.byName[n.Inputs[0]].shape
+		n.shape = [3]int{first[0], first[1], 0}
+
+		for _, name := range n.Inputs {
+			in := g.byName[name].shape
+			if in[0] != first[0] || in[1] != first[1] {
+				return fmt.Errorf("convnet: graph node %q cannot concatenate %dx%d and %dx%d inputs", n.Name, first[0], first[1], in[0], in[1])
+			}
+
+			n.shape[2] += in[2]
+		}
+	case MergeAdd:
+		n.shape = g.byName[n.Inputs[0]].shape
+
+		for _, name := range n.Inputs {
+			if in := g.byName[name].shape; in != n.shape {
+				return fmt.Errorf("convnet: graph node %q cannot add %dx%dx%d and %dx%dx%d inputs", n.Name, n.shape[0], n.shape[1], n.shape[2], in[0], in[1], in[2])
+			}
+		}
+	default:
+		n.shape = shapeOf(n.Layer)
+
+		if len(n.Inputs) == 1 {
+			if err := checkInputShape(1, n.Layer, g.byName[n.Inputs[0]].shape); err != nil {
+				return fmt.Errorf("convnet: graph node %q: %w", n.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Forward runs the graph on the given inputs, keyed by the name of the
+// input node, and returns the output of the output node.
+func (g *Graph) Forward(inputs map[string]*Vol, isTraining bool) *Vol {
+	if g.order == nil {
+		if err := g.Validate(); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, n := range g.order {
+		var act *Vol
+
+		switch {
+		case n.Merge == MergeConcat:
+			act = NewVol(n.shape[0], n.shape[1], n.shape[2], 0.0)
+
+			d0 := 0
+			for _, name := range n.Inputs {
+				in := g.byName[name].out
+
+				for i := 0; i < n.shape[0]*n.shape[1]; i++ {
+					copy(act.W[i*act.Depth+d0:i*act.Depth+d0+in.Depth], in.W[i*in.Depth:(i+1)*in.Depth])
+				}
+
+				d0 += in.Depth
+			}
+		case n.Merge == MergeAdd:
+			act = NewVol(n.shape[0], n.shape[1], n.shape[2], 0.0)
+
+			for _, name := range n.Inputs {
+				act.AddFrom(g.byName[name].out)
+			}
+		case len(n.Inputs) == 0:
+			v, ok := inputs[n.Name]
+			if !ok {
+				panic(fmt.Sprintf("convnet: no value given for graph input %q", n.Name))
+			}
+
+			act = n.Layer.Forward(v, isTraining)
+		default:
+			act = n.Layer.Forward(g.byName[n.Inputs[0]].out, isTraining)
+		}
+
+		n.out = act
+		n.grad = nil
+	}
+
+	return g.byName[g.Output].out
+}
+
+// Backward computes the gradients of the loss for y with respect to every
+// parameter and input of the graph, adding together the gradients that
+// reach a node from each of its consumers. It returns the loss.
+func (g *Graph) Backward(y LossData) float64 {
+	out := g.byName[g.Output]
+	loss := out.Layer.(LossLayer).BackwardLoss(y)
+	g.collect(g.byName[out.Inputs[0]])
+
+	for i := len(g.order) - 2; i >= 0; i-- {
+		n := g.order[i]
+
+		n.out.Dw = g.grad(n)
+
+		switch n.Merge {
+		case MergeConcat:
+			d0 := 0
+			for _, name := range n.Inputs {
+				in := g.byName[name]
+				grad := g.grad(in)
+				depth := in.shape[2]
+
+				for j := 0; j < n.shape[0]*n.shape[1]; j++ {
+					for d := 0; d < depth; d++ {
+						grad[j*depth+d] += n.out.Dw[j*n.shape[2]+d0+d]
+					}
+				}
+
+				d0 += depth
+			}
+		case MergeAdd:
+			for _, name := range n.Inputs {
+				grad := g.grad(g.byName[name])
+
+				for j, d := range n.out.Dw {
+					grad[j] += d
+				}
+			}
+		default:
+			n.Layer.Backward()
+
+			if len(n.Inputs) == 1 {
+				g.collect(g.byName[n.Inputs[0]])
+			}
+		}
+	}
+
+	return loss
+}
+
+// returns the gradient accumulator for the output of n
+func (g *Graph) grad(n *GraphNode) []float64 {
+	if n.grad == nil {
+		n.grad = make([]float64, len(n.out.W))
+	}
+
+	return n.grad
+}
+
+// adds the gradient that a consumer has just written into the output of n
+// to its accumulator. This has to happen right away, because the next
+// consumer of the same node will overwrite it.
+func (g *Graph) collect(n *GraphNode) {
+	grad := g.grad(n)
+
+	for j, d := range n.out.Dw {
+		grad[j] += d
+	}
+}
+
+// ParamsAndGrads returns the parameters of every layer in the graph.
+func (g *Graph) ParamsAndGrads() []ParamsAndGrads {
+	var response []ParamsAndGrads
+
+	for _, n := range g.Nodes {
+		if n.Layer != nil {
+			response = append(response, n.Layer.ParamsAndGrads()...)
+		}
+	}
+
+	return response
+}
+
+// Input returns the gradient-carrying volume that was given to the input
+// node named name in the most recent Forward.
+func (g *Graph) Input(name string) *Vol {
+	n := g.node(name)
+	if n == nil {
+		return nil
+	}
+
+	return n.out
+}
+
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	type node struct {
+		Name   string    `json:"name"`
+		Inputs []string  `json:"inputs,omitempty"`
+		Layer  Layer     `json:"layer,omitempty"`
+		Merge  MergeType `json:"merge,omitempty"`
+	}
+
+	nodes := make([]node, len(g.Nodes))
+	for i, n := range g.Nodes {
+		nodes[i] = node{Name: n.Name, Inputs: n.Inputs, Layer: n.Layer, Merge: n.Merge}
+	}
+
+	return json.Marshal(&struct {
+		Nodes  []node `json:"nodes"`
+		Output string `json:"output"`
+	}{
+		Nodes:  nodes,
+		Output: g.Output,
+	})
+}
+
+func (g *Graph) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Nodes []struct {
+			Name   string          `json:"name"`
+			Inputs []string        `json:"inputs"`
+			Layer  json.RawMessage `json:"layer"`
+			Merge  MergeType       `json:"merge"`
+		} `json:"nodes"`
+		Output string `json:"output"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	nodes := make([]*GraphNode, len(data.Nodes))
+	for i, n := range data.Nodes {
+		nodes[i] = &GraphNode{Name: n.Name, Inputs: n.Inputs, Merge: n.Merge}
+
+		if len(n.Layer) != 0 {
+			l, err := unmarshalLayer(n.Layer)
+			if err != nil {
+				return err
+			}
+
+			nodes[i].Layer = l
+		}
+	}
+
+	g.Nodes = nodes
+	g.Output = data.Output
+
+	return g.Validate()
+}
+
+// TrainGraph is like Train, but for a Graph. The trainer's Net is not used.
+func (t *Trainer) TrainGraph(g *Graph, inputs map[string]*Vol, y LossData) TrainingResult {
+	g.Forward(inputs, true)
+
+	costLoss := g.Backward(y)
+	l1DecayLoss, l2DecayLoss := t.step(g, 1)
+
+	return t.result(costLoss, l1DecayLoss, l2DecayLoss)
+}
@@ -0,0 +1,70 @@
+package convnet
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// History records the results of training steps, for plotting loss
+// curves. The slices are parallel: entry i of each describes the same step.
+type History struct {
+	Steps      []int
+	Losses     []float64
+	CostLosses []float64
+	L1Losses   []float64
+	L2Losses   []float64
+
+	// MaxLen is the maximum number of entries to keep. When it is
+	// exceeded, the oldest entries are dropped. Zero means no limit.
+	MaxLen int
+}
+
+// Record appends the result of a training step.
+func (h *History) Record(step int, r TrainingResult) {
+	h.Steps = append(h.Steps, step)
+	h.Losses = append(h.Losses, r.Loss)
+	h.CostLosses = append(h.CostLosses, r.CostLoss)
+	h.L1Losses = append(h.L1Losses, r.L1DecayLoss)
+	h.L2Losses = append(h.L2Losses, r.L2DecayLoss)
+
+	if h.MaxLen > 0 && len(h.Steps) > h.MaxLen {
+		drop := len(h.Steps) - h.MaxLen
+
+		h.Steps = append(h.Steps[:0], h.Steps[drop:]...)
+		h.Losses = append(h.Losses[:0], h.Losses[drop:]...)
+		h.CostLosses = append(h.CostLosses[:0], h.CostLosses[drop:]...)
+		h.L1Losses = append(h.L1Losses[:0], h.L1Losses[drop:]...)
+		h.L2Losses = append(h.L2Losses[:0], h.L2Losses[drop:]...)
+	}
+}
+
+// Len returns the number of recorded steps.
+func (h *History) Len() int { return len(h.Steps) }
+
+// ExportCSV writes the history to w as CSV with a header row.
+func (h *History) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"step", "loss", "cost_loss", "l1_decay_loss", "l2_decay_loss"}); err != nil {
+		return err
+	}
+
+	ff := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+	for i, step := range h.Steps {
+		if err := cw.Write([]string{
+			strconv.Itoa(step),
+			ff(h.Losses[i]),
+			ff(h.CostLosses[i]),
+			ff(h.L1Losses[i]),
+			ff(h.L2Losses[i]),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
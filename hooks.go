@@ -0,0 +1,68 @@
+package convnet
+
+import "fmt"
+
+// ForwardHook is called after a layer's Forward with the layer, the volume
+// it was given, and the volume it returned.
+type ForwardHook func(layer Layer, input, output *Vol)
+
+// BackwardHook is called after a layer's Backward, when the gradients of
+// its parameters and of its input have been filled in.
+type BackwardHook func(layer Layer)
+
+// RegisterForwardHook arranges for fn to be called every time layer
+// layerIndex of n is run forward by Forward. Hooks belong to the index,
+// so adding or removing layers in front of it moves the hook to another
+// layer. Hooks are not copied by Clone or ShareWeightsClone, so Predict
+// does not call them. In a checkpointed training pass, the hooks of
+// layers that are recomputed may be called again during Backward.
+func (n *Net) RegisterForwardHook(layerIndex int, fn ForwardHook) error {
+	if layerIndex < 0 || layerIndex >= len(n.Layers) {
+		return fmt.Errorf("convnet: layer index %d out of range", layerIndex)
+	}
+
+	for len(n.forwardHooks) <= layerIndex {
+		n.forwardHooks = append(n.forwardHooks, nil)
+	}
+	n.forwardHooks[layerIndex] = append(n.forwardHooks[layerIndex], fn)
+
+	return nil
+}
+
+// RegisterBackwardHook arranges for fn to be called every time layer
+// layerIndex of n is run backward by Backward, including the loss layer.
+// Like forward hooks, backward hooks belong to the index.
+func (n *Net) RegisterBackwardHook(layerIndex int, fn BackwardHook) error {
+	if layerIndex < 0 || layerIndex >= len(n.Layers) {
+		return fmt.Errorf("convnet: layer index %d out of range", layerIndex)
+	}
+
+	for len(n.backwardHooks) <= layerIndex {
+		n.backwardHooks = append(n.backwardHooks, nil)
+	}
+	n.backwardHooks[layerIndex] = append(n.backwardHooks[layerIndex], fn)
+
+	return nil
+}
+
+// RemoveAllHooks removes every forward and backward hook from n.
+func (n *Net) RemoveAllHooks() {
+	n.forwardHooks = nil
+	n.backwardHooks = nil
+}
+
+func (n *Net) runForwardHooks(i int, input, output *Vol) {
+	if i < len(n.forwardHooks) {
+		for _, fn := range n.forwardHooks[i] {
+			fn(n.Layers[i], input, output)
+		}
+	}
+}
+
+func (n *Net) runBackwardHooks(i int) {
+	if i < len(n.backwardHooks) {
+		for _, fn := range n.backwardHooks[i] {
+			fn(n.Layers[i])
+		}
+	}
+}
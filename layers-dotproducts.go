@@ -2,6 +2,7 @@ package convnet
 
 import (
 	"encoding/json"
+	"errors"
 	"math"
 	"math/rand"
 )
@@ -11,8 +12,26 @@ import (
 // schemes:
 // - FullyConn is fully connected dot products
 // - ConvLayer does convolutions (so weight sharing spatially)
+// - DeformConvLayer does convolutions at learned, shifted positions
+// - CausalConvLayer does dilated convolutions over time that only look back
 // putting them together in one file because they are very similar
 
+// newFilter makes the weights of one filter with the given size, which
+// takes sx*sy*depth inputs and whose weights each feed fanOut outputs,
+// using the initialization named by method.
+func newFilter(method string, sx, sy, depth, fanOut int, r *rand.Rand) *Vol {
+	switch method {
+	case "", InitDefault:
+		return NewVolRand(sx, sy, depth, r)
+	case InitKaiming:
+		return NewVolKaiming(sx, sy, depth, sx*sy*depth, r)
+	case InitXavier:
+		return NewVolXavier(sx, sy, depth, sx*sy*depth, fanOut, r)
+	default:
+		panic("convnet: unrecognized init method: " + method)
+	}
+}
+
 type ConvLayer struct {
 	sx         int
 	sy         int
@@ -26,6 +45,9 @@ type ConvLayer struct {
 	pad        int
 	l1DecayMul float64
 	l2DecayMul float64
+	frozen     bool
+	initMethod string  // how the filters were initialized, for ColdStart
+	biasPref   float64 // what the biases were initialized to, for ColdStart
 	filters    []*Vol
 	biases     *Vol
 	inAct      *Vol
@@ -35,6 +57,24 @@ type ConvLayer struct {
 func (l *ConvLayer) OutDepth() int { return l.outDepth }
 func (l *ConvLayer) OutSx() int    { return l.outSx }
 func (l *ConvLayer) OutSy() int    { return l.outSy }
+
+// OutputSize returns the width, height, and depth of the output.
+func (l *ConvLayer) OutputSize() (sx, sy, depth int) { return l.outSx, l.outSy, l.outDepth }
+
+// InputSize returns the width, height, and depth of the input.
+func (l *ConvLayer) InputSize() (sx, sy, depth int) { return l.inSx, l.inSy, l.inDepth }
+
+// ReceptiveField returns the width of each filter in the input, the
+// stride between filters, and the padding added to each side of the
+// input. Filters that are not square are described by their width.
+func (l *ConvLayer) ReceptiveField() (size, stride, pad int) { return l.sx, l.stride, l.pad }
+
+// Filters returns the filters of the layer, one sx by sy by in_depth Vol
+// for each output depth. They are the layer's own weights, not a copy.
+func (l *ConvLayer) Filters() []*Vol { return l.filters }
+
+func (l *ConvLayer) Trainable() bool     { return !l.frozen }
+func (l *ConvLayer) SetTrainable(t bool) { l.frozen = !t }
 func (l *ConvLayer) fromDef(def LayerDef, r *rand.Rand) {
 	// required
 	l.outDepth = def.Filters
@@ -62,6 +102,8 @@ func (l *ConvLayer) fromDef(def LayerDef, r *rand.Rand) {
 		l.l2DecayMul = 1.0
 	}
 
+	l.frozen = !def.Trainable && def.TrainableZero
+
 	// computed
 	// note we are doing floor, so if the strided convolution of the filter doesnt fit into the input
 	// volume exactly, the output volume will be trimmed and not contain the (incomplete) computed
@@ -70,14 +112,36 @@ func (l *ConvLayer) fromDef(def LayerDef, r *rand.Rand) {
 	l.outSy = (l.inSy+l.pad*2-l.sy)/l.stride + 1
 
 	// initializations
+	l.initMethod = def.InitMethod
+	l.biasPref = def.BiasPref
 	l.filters = make([]*Vol, l.outDepth)
 
 	for i := range l.filters {
-		l.filters[i] = NewVolRand(l.sx, l.sy, l.inDepth, r)
+		l.filters[i] = newFilter(def.InitMethod, l.sx, l.sy, l.inDepth, l.sx*l.sy*l.outDepth, r)
 	}
 
 	l.biases = NewVol(1, 1, l.outDepth, def.BiasPref)
 }
+func (l *ConvLayer) def() LayerDef {
+	return LayerDef{
+		Type:           LayerConv,
+		Filters:        l.outDepth,
+		Sx:             l.sx,
+		Sy:             l.sy,
+		SyZero:         true,
+		Stride:         l.stride,
+		StrideZero:     true,
+		Pad:            l.pad,
+		L1DecayMul:     l.l1DecayMul,
+		L2DecayMul:     l.l2DecayMul,
+		L2DecayMulZero: true,
+		Trainable:      !l.frozen,
+		TrainableZero:  true,
+		BiasPref:       l.biasPref,
+		BiasPrefZero:   true,
+		InitMethod:     l.initMethod,
+	}
+}
 func (l *ConvLayer) ParamsAndGrads() []ParamsAndGrads {
 	response := make([]ParamsAndGrads, 0, l.outDepth+1)
 
@@ -87,6 +151,7 @@ func (l *ConvLayer) ParamsAndGrads() []ParamsAndGrads {
 			Grads:      l.filters[i].Dw,
 			L2DecayMul: l.l2DecayMul,
 			L1DecayMul: l.l1DecayMul,
+			Frozen:     l.frozen,
 		})
 	}
 
@@ -95,6 +160,7 @@ func (l *ConvLayer) ParamsAndGrads() []ParamsAndGrads {
 		Grads:      l.biases.Dw,
 		L1DecayMul: 0.0,
 		L2DecayMul: 0.0,
+		Frozen:     l.frozen,
 	})
 
 	return response
@@ -141,6 +207,15 @@ func (l *ConvLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *ConvLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *ConvLayer) Output() *Vol     { return l.outAct }
+func (l *ConvLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *ConvLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *ConvLayer) Backward() {
 	var V = l.inAct
 	V.Dw = make([]float64, len(V.W)) // zero out gradient wrt bottom data, we're about to fill it
@@ -191,6 +266,9 @@ func (l *ConvLayer) MarshalJSON() ([]byte, error) {
 		LayerType  string  `json:"layer_type"`
 		L1DecayMul float64 `json:"l1_decay_mul"`
 		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
 		Pad        int     `json:"pad"`
 		Filters    []*Vol  `json:"filters"`
 		Biases     *Vol    `json:"biases"`
@@ -205,6 +283,9 @@ func (l *ConvLayer) MarshalJSON() ([]byte, error) {
 		LayerType:  LayerConv.String(),
 		L1DecayMul: l.l1DecayMul,
 		L2DecayMul: l.l2DecayMul,
+		Trainable:  !l.frozen,
+		InitMethod: l.initMethod,
+		BiasPref:   l.biasPref,
 		Pad:        l.pad,
 		Filters:    l.filters,
 		Biases:     l.biases,
@@ -222,6 +303,9 @@ func (l *ConvLayer) UnmarshalJSON(b []byte) error {
 		LayerType  string  `json:"layer_type"`
 		L1DecayMul float64 `json:"l1_decay_mul"`
 		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
 		Pad        int     `json:"pad"`
 		Filters    []*Vol  `json:"filters"`
 		Biases     *Vol    `json:"biases"`
@@ -229,6 +313,7 @@ func (l *ConvLayer) UnmarshalJSON(b []byte) error {
 
 	data.L1DecayMul = 1.0
 	data.L2DecayMul = 1.0
+	data.Trainable = true
 
 	if err := json.Unmarshal(b, &data); err != nil {
 		return err
@@ -243,6 +328,9 @@ func (l *ConvLayer) UnmarshalJSON(b []byte) error {
 	l.inDepth = data.InDepth // depth of input volume
 	l.l1DecayMul = data.L1DecayMul
 	l.l2DecayMul = data.L2DecayMul
+	l.frozen = !data.Trainable
+	l.initMethod = data.InitMethod
+	l.biasPref = data.BiasPref
 	l.pad = data.Pad
 	l.filters = data.Filters
 	l.biases = data.Biases
@@ -254,6 +342,9 @@ type FullyConnLayer struct {
 	outDepth   int
 	l1DecayMul float64
 	l2DecayMul float64
+	frozen     bool
+	initMethod string  // how the filters were initialized, for ColdStart
+	biasPref   float64 // what the biases were initialized to, for ColdStart
 	numInputs  int
 	filters    []*Vol
 	biases     *Vol
@@ -265,6 +356,17 @@ func (l *FullyConnLayer) OutSx() int    { return 1 }
 func (l *FullyConnLayer) OutSy() int    { return 1 }
 func (l *FullyConnLayer) OutDepth() int { return l.outDepth }
 
+// OutputSize returns the width, height, and depth of the output, which is
+// always 1x1xNumNeurons.
+func (l *FullyConnLayer) OutputSize() (sx, sy, depth int) { return 1, 1, l.outDepth }
+
+// InputSize returns the size of the input as 1x1xN, where N is the total
+// number of inputs. Fully connected layers do not keep track of the shape
+// of their input, only its size.
+func (l *FullyConnLayer) InputSize() (sx, sy, depth int) { return 1, 1, l.numInputs }
+
+func (l *FullyConnLayer) Trainable() bool     { return !l.frozen }
+func (l *FullyConnLayer) SetTrainable(t bool) { l.frozen = !t }
 func (l *FullyConnLayer) fromDef(def LayerDef, r *rand.Rand) {
 	// required
 	l.outDepth = def.NumNeurons
@@ -277,18 +379,35 @@ func (l *FullyConnLayer) fromDef(def LayerDef, r *rand.Rand) {
 		l.l2DecayMul = 1.0
 	}
 
+	l.frozen = !def.Trainable && def.TrainableZero
+
 	// computed
 	l.numInputs = def.InSx * def.InSy * def.InDepth
 
 	// initializations
-	bias := def.BiasPref
+	l.initMethod = def.InitMethod
+	l.biasPref = def.BiasPref
 	l.filters = make([]*Vol, l.outDepth)
 
 	for i := 0; i < l.outDepth; i++ {
-		l.filters[i] = NewVolRand(1, 1, l.numInputs, r)
+		l.filters[i] = newFilter(def.InitMethod, 1, 1, l.numInputs, l.outDepth, r)
 	}
 
-	l.biases = NewVol(1, 1, l.outDepth, bias)
+	l.biases = NewVol(1, 1, l.outDepth, l.biasPref)
+}
+func (l *FullyConnLayer) def() LayerDef {
+	return LayerDef{
+		Type:           LayerFC,
+		NumNeurons:     l.outDepth,
+		L1DecayMul:     l.l1DecayMul,
+		L2DecayMul:     l.l2DecayMul,
+		L2DecayMulZero: true,
+		Trainable:      !l.frozen,
+		TrainableZero:  true,
+		BiasPref:       l.biasPref,
+		BiasPrefZero:   true,
+		InitMethod:     l.initMethod,
+	}
 }
 func (l *FullyConnLayer) Forward(v *Vol, isTraining bool) *Vol {
 	l.inAct = v
@@ -321,6 +440,15 @@ func (l *FullyConnLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *FullyConnLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *FullyConnLayer) Output() *Vol     { return l.outAct }
+func (l *FullyConnLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *FullyConnLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *FullyConnLayer) Backward() {
 	v := l.inAct
 	v.Dw = make([]float64, len(v.W)) // zero out the gradient in input Vol
@@ -346,6 +474,7 @@ func (l *FullyConnLayer) ParamsAndGrads() []ParamsAndGrads {
 			Grads:      f.Dw,
 			L1DecayMul: l.l1DecayMul,
 			L2DecayMul: l.l2DecayMul,
+			Frozen:     l.frozen,
 		})
 	}
 
@@ -354,6 +483,7 @@ func (l *FullyConnLayer) ParamsAndGrads() []ParamsAndGrads {
 		Grads:      l.biases.Dw,
 		L1DecayMul: 0.0,
 		L2DecayMul: 0.0,
+		Frozen:     l.frozen,
 	})
 
 	return response
@@ -367,6 +497,9 @@ func (l *FullyConnLayer) MarshalJSON() ([]byte, error) {
 		NumInputs  int     `json:"num_inputs"`
 		L1DecayMul float64 `json:"l1_decay_mul"`
 		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
 		Filters    []*Vol  `json:"filters"`
 		Biases     *Vol    `json:"biases"`
 	}{
@@ -377,6 +510,9 @@ func (l *FullyConnLayer) MarshalJSON() ([]byte, error) {
 		NumInputs:  l.numInputs,
 		L1DecayMul: l.l1DecayMul,
 		L2DecayMul: l.l2DecayMul,
+		Trainable:  !l.frozen,
+		InitMethod: l.initMethod,
+		BiasPref:   l.biasPref,
 		Filters:    l.filters,
 		Biases:     l.biases,
 	})
@@ -390,12 +526,16 @@ func (l *FullyConnLayer) UnmarshalJSON(b []byte) error {
 		NumInputs  int     `json:"num_inputs"`
 		L1DecayMul float64 `json:"l1_decay_mul"`
 		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
 		Filters    []*Vol  `json:"filters"`
 		Biases     *Vol    `json:"biases"`
 	}
 
 	data.L1DecayMul = 1.0
 	data.L2DecayMul = 1.0
+	data.Trainable = true
 
 	if err := json.Unmarshal(b, &data); err != nil {
 		return err
@@ -405,6 +545,625 @@ func (l *FullyConnLayer) UnmarshalJSON(b []byte) error {
 	l.numInputs = data.NumInputs
 	l.l1DecayMul = data.L1DecayMul
 	l.l2DecayMul = data.L2DecayMul
+	l.frozen = !data.Trainable
+	l.initMethod = data.InitMethod
+	l.biasPref = data.BiasPref
+	l.filters = data.Filters
+	l.biases = data.Biases
+
+	return nil
+}
+
+// DeformConvLayer is a deformable convolution (Dai et al. 2017). A
+// regular convolution (the offset layer) predicts a 2D displacement for
+// every element of the filter at every output position, and the input is
+// sampled at the displaced positions using bilinear interpolation.
+type DeformConvLayer struct {
+	sx         int
+	sy         int
+	inSx       int
+	inSy       int
+	inDepth    int
+	outSx      int
+	outSy      int
+	outDepth   int
+	stride     int
+	pad        int
+	l1DecayMul float64
+	l2DecayMul float64
+	frozen     bool
+	initMethod string  // how the filters were initialized, for ColdStart
+	biasPref   float64 // what the biases were initialized to, for ColdStart
+	filters    []*Vol
+	biases     *Vol
+	offset     *ConvLayer // produces 2*sx*sy channels: x and y offsets
+	offAct     *Vol
+	inAct      *Vol
+	outAct     *Vol
+}
+
+func (l *DeformConvLayer) OutDepth() int { return l.outDepth }
+func (l *DeformConvLayer) OutSx() int    { return l.outSx }
+func (l *DeformConvLayer) OutSy() int    { return l.outSy }
+
+func (l *DeformConvLayer) Trainable() bool { return !l.frozen }
+func (l *DeformConvLayer) SetTrainable(t bool) {
+	l.frozen = !t
+	l.offset.frozen = !t
+}
+func (l *DeformConvLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// the main convolution is set up exactly like a ConvLayer
+	var c ConvLayer
+	c.fromDef(def, r)
+
+	l.sx, l.sy = c.sx, c.sy
+	l.inSx, l.inSy, l.inDepth = c.inSx, c.inSy, c.inDepth
+	l.outSx, l.outSy, l.outDepth = c.outSx, c.outSy, c.outDepth
+	l.stride, l.pad = c.stride, c.pad
+	l.l1DecayMul, l.l2DecayMul = c.l1DecayMul, c.l2DecayMul
+	l.frozen = c.frozen
+	l.initMethod, l.biasPref = c.initMethod, c.biasPref
+	l.filters, l.biases = c.filters, c.biases
+
+	// the offset convolution has the same geometry, so its output lines
+	// up with ours. it starts at zero so the layer initially behaves like
+	// a regular convolution.
+	offDef := def
+	offDef.Type = LayerConv
+	offDef.Sy, offDef.SyZero = l.sy, true
+	offDef.Stride, offDef.StrideZero = l.stride, true
+	offDef.Filters = 2 * l.sx * l.sy
+	offDef.BiasPref = 0
+
+	l.offset = &ConvLayer{}
+	l.offset.fromDef(offDef, r)
+
+	for _, f := range l.offset.filters {
+		f.SetConst(0.0)
+	}
+}
+func (l *DeformConvLayer) def() LayerDef {
+	c := ConvLayer{
+		outDepth:   l.outDepth,
+		sx:         l.sx,
+		sy:         l.sy,
+		stride:     l.stride,
+		pad:        l.pad,
+		l1DecayMul: l.l1DecayMul,
+		l2DecayMul: l.l2DecayMul,
+		frozen:     l.frozen,
+		initMethod: l.initMethod,
+		biasPref:   l.biasPref,
+	}
+
+	def := c.def()
+	def.Type = LayerDeformConv
+
+	return def
+}
+func (l *DeformConvLayer) ParamsAndGrads() []ParamsAndGrads {
+	response := make([]ParamsAndGrads, 0, l.outDepth+1+len(l.offset.filters)+1)
+
+	for _, f := range l.filters {
+		response = append(response, ParamsAndGrads{
+			Params:     f.W,
+			Grads:      f.Dw,
+			L1DecayMul: l.l1DecayMul,
+			L2DecayMul: l.l2DecayMul,
+			Frozen:     l.frozen,
+		})
+	}
+
+	response = append(response, ParamsAndGrads{
+		Params:     l.biases.W,
+		Grads:      l.biases.Dw,
+		L1DecayMul: 0.0,
+		L2DecayMul: 0.0,
+		Frozen:     l.frozen,
+	})
+
+	return append(response, l.offset.ParamsAndGrads()...)
+}
+
+// bilinear returns the value of depth slice d of v at the fractional
+// position (px, py), treating everything outside of v as zero.
+func bilinear(v *Vol, px, py float64, d int) float64 {
+	x0, y0 := int(math.Floor(px)), int(math.Floor(py))
+	ax, ay := px-float64(x0), py-float64(y0)
+
+	return (1-ax)*(1-ay)*volAt(v, x0, y0, d) +
+		ax*(1-ay)*volAt(v, x0+1, y0, d) +
+		(1-ax)*ay*volAt(v, x0, y0+1, d) +
+		ax*ay*volAt(v, x0+1, y0+1, d)
+}
+
+func volAt(v *Vol, x, y, d int) float64 {
+	if x < 0 || x >= v.Sx || y < 0 || y >= v.Sy {
+		return 0
+	}
+
+	return v.Get(x, y, d)
+}
+
+func (l *DeformConvLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+	l.offAct = l.offset.Forward(v, isTraining)
+	a := NewVol(l.outSx, l.outSy, l.outDepth, 0.0)
+
+	for d := 0; d < l.outDepth; d++ {
+		f := l.filters[d]
+		y := -l.pad
+
+		for ay := 0; ay < l.outSy; y, ay = y+l.stride, ay+1 {
+			x := -l.pad
+
+			for ax := 0; ax < l.outSx; x, ax = x+l.stride, ax+1 {
+				sum := 0.0
+
+				for fy := 0; fy < f.Sy; fy++ {
+					for fx := 0; fx < f.Sx; fx++ {
+						k := fy*f.Sx + fx
+						px := float64(x+fx) + l.offAct.Get(ax, ay, 2*k)
+						py := float64(y+fy) + l.offAct.Get(ax, ay, 2*k+1)
+
+						for fd := 0; fd < f.Depth; fd++ {
+							sum += f.Get(fx, fy, fd) * bilinear(v, px, py, fd)
+						}
+					}
+				}
+
+				sum += l.biases.W[d]
+
+				a.Set(ax, ay, d, sum)
+			}
+		}
+	}
+
+	l.outAct = a
+
+	return l.outAct
+}
+func (l *DeformConvLayer) forget() {
+	l.offset.forget()
+	l.inAct, l.offAct, l.outAct = nil, nil, nil
+}
+func (l *DeformConvLayer) Output() *Vol     { return l.outAct }
+func (l *DeformConvLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *DeformConvLayer) shareWeights() Layer {
+	c := *l
+	c.offset = l.offset.shareWeights().(*ConvLayer)
+	c.forget()
+
+	return &c
+}
+func (l *DeformConvLayer) Backward() {
+	V := l.inAct
+	dv := make([]float64, len(V.W))                // gradient wrt data through the sampling
+	l.offAct.Dw = make([]float64, len(l.offAct.W)) // gradient wrt offsets
+
+	// distributes g over the four pixels around (px, py), and returns the
+	// derivative of the sampled value wrt px and py
+	scatter := func(px, py float64, d int, g float64) (float64, float64) {
+		x0, y0 := int(math.Floor(px)), int(math.Floor(py))
+		ax, ay := px-float64(x0), py-float64(y0)
+
+		v00, v10 := volAt(V, x0, y0, d), volAt(V, x0+1, y0, d)
+		v01, v11 := volAt(V, x0, y0+1, d), volAt(V, x0+1, y0+1, d)
+
+		corners := [4]struct {
+			x, y int
+			w    float64
+		}{
+			{x0, y0, (1 - ax) * (1 - ay)},
+			{x0 + 1, y0, ax * (1 - ay)},
+			{x0, y0 + 1, (1 - ax) * ay},
+			{x0 + 1, y0 + 1, ax * ay},
+		}
+		for _, c := range corners {
+			if c.x >= 0 && c.x < V.Sx && c.y >= 0 && c.y < V.Sy {
+				dv[V.index(c.x, c.y, d)] += c.w * g
+			}
+		}
+
+		return (1-ay)*(v10-v00) + ay*(v11-v01), (1-ax)*(v01-v00) + ax*(v11-v10)
+	}
+
+	for d := 0; d < l.outDepth; d++ {
+		f := l.filters[d]
+		y := -l.pad
+
+		for ay := 0; ay < l.outSy; y, ay = y+l.stride, ay+1 {
+			x := -l.pad
+
+			for ax := 0; ax < l.outSx; x, ax = x+l.stride, ax+1 {
+				chainGrad := l.outAct.GetGrad(ax, ay, d) // gradient from above, from chain rule
+
+				for fy := 0; fy < f.Sy; fy++ {
+					for fx := 0; fx < f.Sx; fx++ {
+						k := fy*f.Sx + fx
+						px := float64(x+fx) + l.offAct.Get(ax, ay, 2*k)
+						py := float64(y+fy) + l.offAct.Get(ax, ay, 2*k+1)
+
+						for fd := 0; fd < f.Depth; fd++ {
+							ix := f.index(fx, fy, fd)
+
+							f.Dw[ix] += bilinear(V, px, py, fd) * chainGrad
+
+							gx, gy := scatter(px, py, fd, f.W[ix]*chainGrad)
+							l.offAct.AddGrad(ax, ay, 2*k, gx*f.W[ix]*chainGrad)
+							l.offAct.AddGrad(ax, ay, 2*k+1, gy*f.W[ix]*chainGrad)
+						}
+					}
+				}
+
+				l.biases.Dw[d] += chainGrad
+			}
+		}
+	}
+
+	// the offset layer resets the gradient wrt data, so it goes first
+	l.offset.Backward()
+
+	for i := range V.Dw {
+		V.Dw[i] += dv[i]
+	}
+}
+func (l *DeformConvLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Sx         int        `json:"sx"`
+		Sy         int        `json:"sy"`
+		Stride     int        `json:"stride"`
+		InSx       int        `json:"in_sx"`
+		InSy       int        `json:"in_sy"`
+		InDepth    int        `json:"in_depth"`
+		OutDepth   int        `json:"out_depth"`
+		OutSx      int        `json:"out_sx"`
+		OutSy      int        `json:"out_sy"`
+		LayerType  string     `json:"layer_type"`
+		L1DecayMul float64    `json:"l1_decay_mul"`
+		L2DecayMul float64    `json:"l2_decay_mul"`
+		Trainable  bool       `json:"trainable"`
+		InitMethod string     `json:"init_method"`
+		BiasPref   float64    `json:"bias_pref"`
+		Pad        int        `json:"pad"`
+		Filters    []*Vol     `json:"filters"`
+		Biases     *Vol       `json:"biases"`
+		Offset     *ConvLayer `json:"offset"`
+	}{
+		Sx:         l.sx,
+		Sy:         l.sy,
+		Stride:     l.stride,
+		InSx:       l.inSx,
+		InSy:       l.inSy,
+		InDepth:    l.inDepth,
+		OutDepth:   l.outDepth,
+		OutSx:      l.outSx,
+		OutSy:      l.outSy,
+		LayerType:  LayerDeformConv.String(),
+		L1DecayMul: l.l1DecayMul,
+		L2DecayMul: l.l2DecayMul,
+		Trainable:  !l.frozen,
+		InitMethod: l.initMethod,
+		BiasPref:   l.biasPref,
+		Pad:        l.pad,
+		Filters:    l.filters,
+		Biases:     l.biases,
+		Offset:     l.offset,
+	})
+}
+func (l *DeformConvLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Sx         int        `json:"sx"`
+		Sy         int        `json:"sy"`
+		Stride     int        `json:"stride"`
+		InSx       int        `json:"in_sx"`
+		InSy       int        `json:"in_sy"`
+		InDepth    int        `json:"in_depth"`
+		OutDepth   int        `json:"out_depth"`
+		OutSx      int        `json:"out_sx"`
+		OutSy      int        `json:"out_sy"`
+		LayerType  string     `json:"layer_type"`
+		L1DecayMul float64    `json:"l1_decay_mul"`
+		L2DecayMul float64    `json:"l2_decay_mul"`
+		Trainable  bool       `json:"trainable"`
+		InitMethod string     `json:"init_method"`
+		BiasPref   float64    `json:"bias_pref"`
+		Pad        int        `json:"pad"`
+		Filters    []*Vol     `json:"filters"`
+		Biases     *Vol       `json:"biases"`
+		Offset     *ConvLayer `json:"offset"`
+	}
+
+	data.L1DecayMul = 1.0
+	data.L2DecayMul = 1.0
+	data.Trainable = true
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.outDepth = data.OutDepth
+	l.outSx = data.OutSx
+	l.outSy = data.OutSy
+	l.sx = data.Sx
+	l.sy = data.Sy
+	l.stride = data.Stride
+	l.inSx = data.InSx
+	l.inSy = data.InSy
+	l.inDepth = data.InDepth
+	l.l1DecayMul = data.L1DecayMul
+	l.l2DecayMul = data.L2DecayMul
+	l.frozen = !data.Trainable
+	l.initMethod = data.InitMethod
+	l.biasPref = data.BiasPref
+	l.pad = data.Pad
+	l.filters = data.Filters
+	l.biases = data.Biases
+	l.offset = data.Offset
+
+	if l.offset == nil {
+		return errors.New("convnet: deformable convolution layer is missing its offset layer")
+	}
+
+	return nil
+}
+
+// CausalConvLayer is a 1D dilated convolution over sequences, as used in
+// WaveNet and temporal convolutional networks. The x axis of the input is
+// time, and each row is a separate sequence. The output at time t only
+// depends on the inputs at times t, t-dilation, ..., t-(sx-1)*dilation,
+// as if the input were padded with (sx-1)*dilation zeros on the left (the
+// past) and none on the right, so the output is as long as the input and
+// no output ever sees the future.
+type CausalConvLayer struct {
+	sx         int
+	dilation   int
+	inSx       int
+	inSy       int
+	inDepth    int
+	outDepth   int
+	l1DecayMul float64
+	l2DecayMul float64
+	frozen     bool
+	initMethod string  // how the filters were initialized, for ColdStart
+	biasPref   float64 // what the biases were initialized to, for ColdStart
+	filters    []*Vol  // sx by 1 by in_depth
+	biases     *Vol
+	inAct      *Vol
+	outAct     *Vol
+}
+
+func (l *CausalConvLayer) OutDepth() int { return l.outDepth }
+func (l *CausalConvLayer) OutSx() int    { return l.inSx }
+func (l *CausalConvLayer) OutSy() int    { return l.inSy }
+
+// Dilation returns the spacing in time between the inputs of a filter.
+func (l *CausalConvLayer) Dilation() int { return l.dilation }
+
+// Pad returns the number of zeros the input is padded with on the left.
+func (l *CausalConvLayer) Pad() int { return (l.sx - 1) * l.dilation }
+
+// Filters returns the filters of the layer, one sx by 1 by in_depth Vol
+// for each output depth. The weight at x = sx-1 multiplies the current
+// time step. They are the layer's own weights, not a copy.
+func (l *CausalConvLayer) Filters() []*Vol { return l.filters }
+
+func (l *CausalConvLayer) Trainable() bool     { return !l.frozen }
+func (l *CausalConvLayer) SetTrainable(t bool) { l.frozen = !t }
+func (l *CausalConvLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// the filters are set up like those of a ConvLayer that is one row tall
+	cdef := def
+	cdef.Sy, cdef.SyZero = 1, true
+	cdef.Pad = 0
+	cdef.Stride, cdef.StrideZero = 1, true
+
+	var c ConvLayer
+	c.fromDef(cdef, r)
+
+	l.sx = c.sx
+	l.inSx, l.inSy, l.inDepth = c.inSx, c.inSy, c.inDepth
+	l.outDepth = c.outDepth
+	l.l1DecayMul, l.l2DecayMul = c.l1DecayMul, c.l2DecayMul
+	l.frozen = c.frozen
+	l.initMethod, l.biasPref = c.initMethod, c.biasPref
+	l.filters, l.biases = c.filters, c.biases
+
+	// optional
+	l.dilation = def.Dilation
+	if l.dilation == 0 {
+		l.dilation = 1
+	}
+}
+func (l *CausalConvLayer) def() LayerDef {
+	c := ConvLayer{
+		outDepth:   l.outDepth,
+		sx:         l.sx,
+		sy:         1,
+		stride:     1,
+		l1DecayMul: l.l1DecayMul,
+		l2DecayMul: l.l2DecayMul,
+		frozen:     l.frozen,
+		initMethod: l.initMethod,
+		biasPref:   l.biasPref,
+	}
+
+	def := c.def()
+	def.Type = LayerCausalConv
+	def.Dilation = l.dilation
+
+	return def
+}
+func (l *CausalConvLayer) ParamsAndGrads() []ParamsAndGrads {
+	response := make([]ParamsAndGrads, 0, l.outDepth+1)
+
+	for _, f := range l.filters {
+		response = append(response, ParamsAndGrads{
+			Params:     f.W,
+			Grads:      f.Dw,
+			L1DecayMul: l.l1DecayMul,
+			L2DecayMul: l.l2DecayMul,
+			Frozen:     l.frozen,
+		})
+	}
+
+	response = append(response, ParamsAndGrads{
+		Params:     l.biases.W,
+		Grads:      l.biases.Dw,
+		L1DecayMul: 0.0,
+		L2DecayMul: 0.0,
+		Frozen:     l.frozen,
+	})
+
+	return response
+}
+func (l *CausalConvLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+	a := NewVol(l.inSx, l.inSy, l.outDepth, 0.0)
+	pad := l.Pad()
+
+	for d := 0; d < l.outDepth; d++ {
+		f := l.filters[d]
+
+		for y := 0; y < l.inSy; y++ {
+			for t := 0; t < l.inSx; t++ {
+				sum := l.biases.W[d]
+
+				for fx := 0; fx < f.Sx; fx++ {
+					ox := t - pad + fx*l.dilation // never more than t
+
+					if ox >= 0 {
+						for fd := 0; fd < f.Depth; fd++ {
+							sum += f.Get(fx, 0, fd) * v.Get(ox, y, fd)
+						}
+					}
+				}
+
+				a.Set(t, y, d, sum)
+			}
+		}
+	}
+
+	l.outAct = a
+
+	return l.outAct
+}
+func (l *CausalConvLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *CausalConvLayer) Output() *Vol     { return l.outAct }
+func (l *CausalConvLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *CausalConvLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
+func (l *CausalConvLayer) Backward() {
+	V := l.inAct
+	V.Dw = make([]float64, len(V.W)) // zero out gradient wrt bottom data, we're about to fill it
+	pad := l.Pad()
+
+	for d := 0; d < l.outDepth; d++ {
+		f := l.filters[d]
+
+		for y := 0; y < l.inSy; y++ {
+			for t := 0; t < l.inSx; t++ {
+				chainGrad := l.outAct.GetGrad(t, y, d) // gradient from above, from chain rule
+
+				for fx := 0; fx < f.Sx; fx++ {
+					ox := t - pad + fx*l.dilation
+
+					if ox >= 0 {
+						for fd := 0; fd < f.Depth; fd++ {
+							ix1 := V.index(ox, y, fd)
+							ix2 := f.index(fx, 0, fd)
+
+							f.Dw[ix2] += V.W[ix1] * chainGrad
+							V.Dw[ix1] += f.W[ix2] * chainGrad
+						}
+					}
+				}
+
+				l.biases.Dw[d] += chainGrad
+			}
+		}
+	}
+}
+func (l *CausalConvLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Sx         int     `json:"sx"`
+		Dilation   int     `json:"dilation"`
+		InSx       int     `json:"in_sx"`
+		InSy       int     `json:"in_sy"`
+		InDepth    int     `json:"in_depth"`
+		OutDepth   int     `json:"out_depth"`
+		OutSx      int     `json:"out_sx"`
+		OutSy      int     `json:"out_sy"`
+		LayerType  string  `json:"layer_type"`
+		L1DecayMul float64 `json:"l1_decay_mul"`
+		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
+		Filters    []*Vol  `json:"filters"`
+		Biases     *Vol    `json:"biases"`
+	}{
+		Sx:         l.sx,
+		Dilation:   l.dilation,
+		InSx:       l.inSx,
+		InSy:       l.inSy,
+		InDepth:    l.inDepth,
+		OutDepth:   l.outDepth,
+		OutSx:      l.inSx,
+		OutSy:      l.inSy,
+		LayerType:  LayerCausalConv.String(),
+		L1DecayMul: l.l1DecayMul,
+		L2DecayMul: l.l2DecayMul,
+		Trainable:  !l.frozen,
+		InitMethod: l.initMethod,
+		BiasPref:   l.biasPref,
+		Filters:    l.filters,
+		Biases:     l.biases,
+	})
+}
+func (l *CausalConvLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Sx         int     `json:"sx"`
+		Dilation   int     `json:"dilation"`
+		InSx       int     `json:"in_sx"`
+		InSy       int     `json:"in_sy"`
+		InDepth    int     `json:"in_depth"`
+		OutDepth   int     `json:"out_depth"`
+		OutSx      int     `json:"out_sx"`
+		OutSy      int     `json:"out_sy"`
+		LayerType  string  `json:"layer_type"`
+		L1DecayMul float64 `json:"l1_decay_mul"`
+		L2DecayMul float64 `json:"l2_decay_mul"`
+		Trainable  bool    `json:"trainable"`
+		InitMethod string  `json:"init_method"`
+		BiasPref   float64 `json:"bias_pref"`
+		Filters    []*Vol  `json:"filters"`
+		Biases     *Vol    `json:"biases"`
+	}
+
+	data.Dilation = 1
+	data.L1DecayMul = 1.0
+	data.L2DecayMul = 1.0
+	data.Trainable = true
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.sx = data.Sx
+	l.dilation = data.Dilation
+	l.inSx = data.InSx
+	l.inSy = data.InSy
+	l.inDepth = data.InDepth
+	l.outDepth = data.OutDepth
+	l.l1DecayMul = data.L1DecayMul
+	l.l2DecayMul = data.L2DecayMul
+	l.frozen = !data.Trainable
+	l.initMethod = data.InitMethod
+	l.biasPref = data.BiasPref
 	l.filters = data.Filters
 	l.biases = data.Biases
 
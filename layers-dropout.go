@@ -2,6 +2,7 @@ package convnet
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/rand"
 )
 
@@ -19,6 +20,11 @@ type DropoutLayer struct {
 	rand     *rand.Rand
 	inAct    *Vol
 	outAct   *Vol
+	training bool // whether the most recent Forward dropped activations
+
+	// set by Net.InferenceMode once the prediction-time scaling has been
+	// folded into a later layer; the input is then passed through as is
+	inference bool
 }
 
 func (l *DropoutLayer) OutDepth() int { return l.outDepth }
@@ -40,9 +46,20 @@ func (l *DropoutLayer) fromDef(def LayerDef, r *rand.Rand) {
 	l.rand = r
 }
 func (l *DropoutLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
+
+// SetRand sets the random source used to choose which activations are
+// dropped. Layers loaded from JSON have no random source until one is set.
+func (l *DropoutLayer) SetRand(r *rand.Rand) { l.rand = r }
 func (l *DropoutLayer) Forward(v *Vol, isTraining bool) *Vol {
+	if l.inference {
+		l.inAct, l.outAct = v, v
+
+		return v
+	}
+
 	l.inAct = v
 	v2 := v.Clone()
+	l.training = isTraining
 
 	if isTraining {
 		// do dropout
@@ -66,11 +83,56 @@ func (l *DropoutLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+
+// re-applies the most recent dropout mask to v, so that a checkpointed
+// training pass can be recomputed exactly
+func (l *DropoutLayer) replay(v *Vol) *Vol {
+	if l.inference {
+		return l.Forward(v, true)
+	}
+
+	l.inAct = v
+	v2 := v.Clone()
+	l.training = true
+
+	for i := range v2.W {
+		if l.dropped[i] {
+			v2.W[i] = 0
+		}
+	}
+
+	l.outAct = v2
+
+	return l.outAct
+}
+func (l *DropoutLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *DropoutLayer) Output() *Vol     { return l.outAct }
+func (l *DropoutLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *DropoutLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.dropped = make([]bool, len(l.dropped))
+
+	return &c
+}
 func (l *DropoutLayer) Backward() {
+	if l.inference {
+		// the output is the input, so its gradient is already there
+		return
+	}
+
 	v := l.inAct // we need to set dw of this
 	chainGrad := l.outAct
 
 	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+	if !l.training {
+		// the activations were scaled rather than dropped
+		for i := range v.Dw {
+			v.Dw[i] = chainGrad.Dw[i] * l.dropProb
+		}
+
+		return
+	}
 	for i := range v.Dw {
 		if !l.dropped[i] {
 			v.Dw[i] = chainGrad.Dw[i] // copy over the gradient
@@ -84,12 +146,14 @@ func (l *DropoutLayer) MarshalJSON() ([]byte, error) {
 		OutSy     int     `json:"out_sy"`
 		LayerType string  `json:"layer_type"`
 		DropProb  float64 `json:"drop_prob"`
+		Inference bool    `json:"inference,omitempty"`
 	}{
 		OutDepth:  l.outDepth,
 		OutSx:     l.outSx,
 		OutSy:     l.outSy,
 		LayerType: LayerDropout.String(),
 		DropProb:  l.dropProb,
+		Inference: l.inference,
 	})
 }
 func (l *DropoutLayer) UnmarshalJSON(b []byte) error {
@@ -99,6 +163,7 @@ func (l *DropoutLayer) UnmarshalJSON(b []byte) error {
 		OutSy     int     `json:"out_sy"`
 		LayerType string  `json:"layer_type"`
 		DropProb  float64 `json:"drop_prob"`
+		Inference bool    `json:"inference"`
 	}
 
 	if err := json.Unmarshal(b, &data); err != nil {
@@ -109,6 +174,169 @@ func (l *DropoutLayer) UnmarshalJSON(b []byte) error {
 	l.outSx = data.OutSx
 	l.outSy = data.OutSy
 	l.dropProb = data.DropProb
+	l.inference = data.Inference
+	l.dropped = make([]bool, l.outSx*l.outSy*l.outDepth)
+
+	return nil
+}
+
+// MixoutLayer is a regularizer for fine-tuning (Lee et al. 2020). During
+// training, each activation is replaced by the matching value of a
+// pretrained reference volume with probability mixProb, so that the net
+// cannot stray too far from what it computed before fine-tuning. Replaced
+// activations pass no gradient back. During prediction, the output is the
+// expected value, (1-mixProb)*x + mixProb*pretrained.
+//
+// The reference starts out as all zeros, which makes mixout act like
+// dropout; use SetPretrained to give it real values.
+type MixoutLayer struct {
+	outSx      int
+	outSy      int
+	outDepth   int
+	mixProb    float64
+	pretrained *Vol
+	mixed      []bool
+	rand       *rand.Rand
+	inAct      *Vol
+	outAct     *Vol
+}
+
+func (l *MixoutLayer) OutDepth() int { return l.outDepth }
+func (l *MixoutLayer) OutSx() int    { return l.outSx }
+func (l *MixoutLayer) OutSy() int    { return l.outSy }
+func (l *MixoutLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// computed
+	l.outSx = def.InSx
+	l.outSy = def.InSy
+	l.outDepth = def.InDepth
+
+	l.mixProb = def.MixProb
+	if l.mixProb == 0.0 && !def.MixProbZero {
+		l.mixProb = 0.5
+	}
+
+	l.pretrained = NewVol(l.outSx, l.outSy, l.outDepth, 0.0)
+	l.mixed = make([]bool, l.outSx*l.outSy*l.outDepth)
+
+	l.rand = r
+}
+func (l *MixoutLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
+
+// SetRand sets the random source used to choose which activations are
+// replaced. Layers loaded from JSON have no random source until one is set.
+func (l *MixoutLayer) SetRand(r *rand.Rand) { l.rand = r }
+
+// SetPretrained sets the reference activations, which are copied. v must
+// have the same dimensions as the output of the layer.
+func (l *MixoutLayer) SetPretrained(v *Vol) {
+	if v.Sx != l.outSx || v.Sy != l.outSy || v.Depth != l.outDepth {
+		panic(fmt.Sprintf("convnet: mixout reference is %dx%dx%d, but the layer is %dx%dx%d", v.Sx, v.Sy, v.Depth, l.outSx, l.outSy, l.outDepth))
+	}
+
+	l.pretrained = v.Clone()
+}
+
+// Pretrained returns the reference activations.
+func (l *MixoutLayer) Pretrained() *Vol { return l.pretrained }
+func (l *MixoutLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+	v2 := v.Clone()
+
+	if isTraining {
+		for i := range v2.W {
+			l.mixed[i] = l.rand.Float64() < l.mixProb
+			if l.mixed[i] {
+				v2.W[i] = l.pretrained.W[i]
+			}
+		}
+	} else {
+		for i := range v2.W {
+			v2.W[i] = (1-l.mixProb)*v2.W[i] + l.mixProb*l.pretrained.W[i]
+		}
+	}
+
+	l.outAct = v2
+
+	return l.outAct
+}
+
+// re-applies the most recent mixing mask to v
+func (l *MixoutLayer) replay(v *Vol) *Vol {
+	l.inAct = v
+	v2 := v.Clone()
+
+	for i := range v2.W {
+		if l.mixed[i] {
+			v2.W[i] = l.pretrained.W[i]
+		}
+	}
+
+	l.outAct = v2
+
+	return l.outAct
+}
+func (l *MixoutLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *MixoutLayer) Output() *Vol     { return l.outAct }
+func (l *MixoutLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *MixoutLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.mixed = make([]bool, len(l.mixed))
+
+	return &c
+}
+func (l *MixoutLayer) Backward() {
+	v := l.inAct // we need to set dw of this
+	chainGrad := l.outAct
+
+	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+	for i := range v.Dw {
+		if !l.mixed[i] {
+			v.Dw[i] = chainGrad.Dw[i] // copy over the gradient
+		}
+	}
+}
+func (l *MixoutLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		OutDepth   int     `json:"out_depth"`
+		OutSx      int     `json:"out_sx"`
+		OutSy      int     `json:"out_sy"`
+		LayerType  string  `json:"layer_type"`
+		MixProb    float64 `json:"mix_prob"`
+		Pretrained *Vol    `json:"pretrained"`
+	}{
+		OutDepth:   l.outDepth,
+		OutSx:      l.outSx,
+		OutSy:      l.outSy,
+		LayerType:  LayerMixout.String(),
+		MixProb:    l.mixProb,
+		Pretrained: l.pretrained,
+	})
+}
+func (l *MixoutLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		OutDepth   int     `json:"out_depth"`
+		OutSx      int     `json:"out_sx"`
+		OutSy      int     `json:"out_sy"`
+		LayerType  string  `json:"layer_type"`
+		MixProb    float64 `json:"mix_prob"`
+		Pretrained *Vol    `json:"pretrained"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.outDepth = data.OutDepth
+	l.outSx = data.OutSx
+	l.outSy = data.OutSy
+	l.mixProb = data.MixProb
+	l.pretrained = data.Pretrained
+	l.mixed = make([]bool, l.outSx*l.outSy*l.outDepth)
+
+	if l.pretrained == nil {
+		l.pretrained = NewVol(l.outSx, l.outSy, l.outDepth, 0.0)
+	}
 
 	return nil
 }
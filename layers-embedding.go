@@ -0,0 +1,175 @@
+package convnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+// EmbeddingLayer is a trainable lookup table. The input is a single
+// integer index stored in W[0], and the output is the matching row of the
+// table as a 1x1xEmbeddingDim volume.
+type EmbeddingLayer struct {
+	numEmbeddings int
+	embeddingDim  int
+	l1DecayMul    float64
+	l2DecayMul    float64
+	frozen        bool
+	initMethod    string // how the table was initialized, for ColdStart
+	table         *Vol   // 1 x numEmbeddings x embeddingDim
+	index         int
+	inAct         *Vol
+	outAct        *Vol
+}
+
+func (l *EmbeddingLayer) OutSx() int    { return 1 }
+func (l *EmbeddingLayer) OutSy() int    { return 1 }
+func (l *EmbeddingLayer) OutDepth() int { return l.embeddingDim }
+
+func (l *EmbeddingLayer) NumEmbeddings() int  { return l.numEmbeddings }
+func (l *EmbeddingLayer) EmbeddingDim() int   { return l.embeddingDim }
+func (l *EmbeddingLayer) Trainable() bool     { return !l.frozen }
+func (l *EmbeddingLayer) SetTrainable(t bool) { l.frozen = !t }
+func (l *EmbeddingLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// required
+	l.numEmbeddings = def.NumEmbeddings
+	l.embeddingDim = def.EmbeddingDim
+
+	// optional
+	l.l1DecayMul = def.L1DecayMul
+	l.l2DecayMul = def.L2DecayMul
+
+	if l.l2DecayMul == 0 && !def.L2DecayMulZero {
+		l.l2DecayMul = 1.0
+	}
+
+	l.frozen = !def.Trainable && def.TrainableZero
+
+	// initializations
+	// each row is initialized like the weights of a fully connected
+	// neuron with embeddingDim inputs and outputs, rather than by the size
+	// of the whole table
+	l.initMethod = def.InitMethod
+	l.table = NewVol(1, l.numEmbeddings, l.embeddingDim, 0.0)
+	for i := 0; i < l.numEmbeddings; i++ {
+		row := newFilter(def.InitMethod, 1, 1, l.embeddingDim, l.embeddingDim, r)
+		copy(l.table.W[i*l.embeddingDim:], row.W)
+	}
+}
+func (l *EmbeddingLayer) def() LayerDef {
+	return LayerDef{
+		Type:           LayerEmbedding,
+		NumEmbeddings:  l.numEmbeddings,
+		EmbeddingDim:   l.embeddingDim,
+		L1DecayMul:     l.l1DecayMul,
+		L2DecayMul:     l.l2DecayMul,
+		L2DecayMulZero: true,
+		Trainable:      !l.frozen,
+		TrainableZero:  true,
+		InitMethod:     l.initMethod,
+	}
+}
+func (l *EmbeddingLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+
+	l.index = int(v.W[0])
+	if l.index < 0 || l.index >= l.numEmbeddings || float64(l.index) != v.W[0] {
+		panic(fmt.Sprintf("convnet: embedding index %v out of range [0, %d)", v.W[0], l.numEmbeddings))
+	}
+
+	a := NewVol(1, 1, l.embeddingDim, 0.0)
+	copy(a.W, l.table.W[l.index*l.embeddingDim:(l.index+1)*l.embeddingDim])
+
+	l.outAct = a
+
+	return l.outAct
+}
+func (l *EmbeddingLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *EmbeddingLayer) Output() *Vol     { return l.outAct }
+func (l *EmbeddingLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *EmbeddingLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
+func (l *EmbeddingLayer) Backward() {
+	v := l.inAct
+	v.Dw = make([]float64, len(v.W)) // the index is not differentiable
+
+	row := l.table.Dw[l.index*l.embeddingDim : (l.index+1)*l.embeddingDim]
+	for i, g := range l.outAct.Dw {
+		row[i] += g
+	}
+}
+func (l *EmbeddingLayer) ParamsAndGrads() []ParamsAndGrads {
+	return []ParamsAndGrads{
+		{
+			Params:     l.table.W,
+			Grads:      l.table.Dw,
+			L1DecayMul: l.l1DecayMul,
+			L2DecayMul: l.l2DecayMul,
+			Frozen:     l.frozen,
+		},
+	}
+}
+func (l *EmbeddingLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		OutDepth      int     `json:"out_depth"`
+		OutSx         int     `json:"out_sx"`
+		OutSy         int     `json:"out_sy"`
+		LayerType     string  `json:"layer_type"`
+		NumEmbeddings int     `json:"num_embeddings"`
+		EmbeddingDim  int     `json:"embedding_dim"`
+		L1DecayMul    float64 `json:"l1_decay_mul"`
+		L2DecayMul    float64 `json:"l2_decay_mul"`
+		Trainable     bool    `json:"trainable"`
+		InitMethod    string  `json:"init_method"`
+		Table         *Vol    `json:"table"`
+	}{
+		OutDepth:      l.embeddingDim,
+		OutSx:         1,
+		OutSy:         1,
+		LayerType:     LayerEmbedding.String(),
+		NumEmbeddings: l.numEmbeddings,
+		EmbeddingDim:  l.embeddingDim,
+		L1DecayMul:    l.l1DecayMul,
+		L2DecayMul:    l.l2DecayMul,
+		Trainable:     !l.frozen,
+		InitMethod:    l.initMethod,
+		Table:         l.table,
+	})
+}
+func (l *EmbeddingLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		OutDepth      int     `json:"out_depth"`
+		OutSx         int     `json:"out_sx"`
+		OutSy         int     `json:"out_sy"`
+		LayerType     string  `json:"layer_type"`
+		NumEmbeddings int     `json:"num_embeddings"`
+		EmbeddingDim  int     `json:"embedding_dim"`
+		L1DecayMul    float64 `json:"l1_decay_mul"`
+		L2DecayMul    float64 `json:"l2_decay_mul"`
+		Trainable     bool    `json:"trainable"`
+		InitMethod    string  `json:"init_method"`
+		Table         *Vol    `json:"table"`
+	}
+
+	data.L1DecayMul = 1.0
+	data.L2DecayMul = 1.0
+	data.Trainable = true
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.numEmbeddings = data.NumEmbeddings
+	l.embeddingDim = data.EmbeddingDim
+	l.l1DecayMul = data.L1DecayMul
+	l.l2DecayMul = data.L2DecayMul
+	l.frozen = !data.Trainable
+	l.initMethod = data.InitMethod
+	l.table = data.Table
+
+	return nil
+}
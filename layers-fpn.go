@@ -0,0 +1,313 @@
+package convnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// FPNLayer is the top-down half of a Feature Pyramid Network (Lin et al.
+// 2017). It takes feature maps from several levels of a backbone, finest
+// first, with each level half the width and height of the one before it
+// (rounded up). A 1x1 lateral convolution brings every level to the same
+// depth, and then, starting from the coarsest level, each level is
+// upsampled by 2 (nearest neighbor) and added to the level below it. The
+// result is one feature map per level, all with the same depth.
+//
+// Since it has several inputs and outputs, an FPNLayer is normally used on
+// its own through ForwardMulti, with Backward reading the gradients that
+// were written into each of the Outputs. It can only be part of a Net if
+// it has a single level, in which case it is just a 1x1 convolution.
+//
+// The definition gives the width and height of the finest level as in_sx
+// and in_sy, as for any other layer, the depth of every level, finest
+// first, as LevelDepths, and the depth of the outputs as Filters. If
+// LevelDepths is empty, there is a single level with a depth of in_depth.
+type FPNLayer struct {
+	sizes    [][3]int // input shape of each level
+	outDepth int
+	laterals []*ConvLayer
+	inActs   []*Vol
+	latActs  []*Vol
+	outActs  []*Vol
+}
+
+// NewFPNLayer creates an FPNLayer from def, whose type must be LayerFPN and
+// whose in_sx and in_sy must be set.
+func NewFPNLayer(def LayerDef, r *rand.Rand) (*FPNLayer, error) {
+	if def.Type != LayerFPN {
+		return nil, fmt.Errorf("convnet: cannot create an fpn layer from a %v definition", def.Type)
+	}
+	if def.InDepth == 0 && len(def.LevelDepths) != 0 {
+		def.InDepth = def.LevelDepths[0]
+	}
+	if reason := fpnDefProblem(def); reason != "" {
+		return nil, &LayerError{Type: LayerFPN, Reason: reason}
+	}
+
+	l := &FPNLayer{}
+	l.fromDef(def, r)
+
+	return l, nil
+}
+
+// returns why def is not a valid fpn layer, or "" if it is
+func fpnDefProblem(def LayerDef) string {
+	if def.Filters <= 0 {
+		return "number of filters must be positive"
+	}
+	if def.InSx <= 0 || def.InSy <= 0 || def.InDepth <= 0 {
+		return fmt.Sprintf("input size %dx%dx%d is not positive", def.InSx, def.InSy, def.InDepth)
+	}
+
+	for _, d := range def.LevelDepths {
+		if d <= 0 {
+			return "level depths must be positive"
+		}
+	}
+	if len(def.LevelDepths) != 0 && def.LevelDepths[0] != def.InDepth {
+		return fmt.Sprintf("finest level has depth %d, but the input has depth %d", def.LevelDepths[0], def.InDepth)
+	}
+
+	return ""
+}
+
+func (l *FPNLayer) OutDepth() int { return l.outDepth }
+func (l *FPNLayer) OutSx() int    { return l.sizes[0][0] }
+func (l *FPNLayer) OutSy() int    { return l.sizes[0][1] }
+
+// Levels returns the number of levels of the pyramid.
+func (l *FPNLayer) Levels() int { return len(l.sizes) }
+
+// InputSize returns the width, height, and depth of the input of a level.
+// The output of the level has the same width and height.
+func (l *FPNLayer) InputSize(level int) (sx, sy, depth int) {
+	s := l.sizes[level]
+
+	return s[0], s[1], s[2]
+}
+
+// Laterals returns the 1x1 lateral convolution of each level.
+func (l *FPNLayer) Laterals() []*ConvLayer { return l.laterals }
+
+func (l *FPNLayer) Trainable() bool { return !l.laterals[0].frozen }
+func (l *FPNLayer) SetTrainable(t bool) {
+	for _, c := range l.laterals {
+		c.frozen = !t
+	}
+}
+func (l *FPNLayer) fromDef(def LayerDef, r *rand.Rand) {
+	l.outDepth = def.Filters
+
+	depths := def.LevelDepths
+	if len(depths) == 0 {
+		depths = []int{def.InDepth}
+	}
+
+	l.sizes = fpnSizes(def.InSx, def.InSy, depths)
+
+	l.laterals = make([]*ConvLayer, len(l.sizes))
+	for i, s := range l.sizes {
+		latDef := def
+		latDef.Type = LayerConv
+		latDef.InSx, latDef.InSy, latDef.InDepth = s[0], s[1], s[2]
+		latDef.Sx, latDef.Sy, latDef.SyZero = 1, 1, true
+		latDef.Stride, latDef.StrideZero = 1, true
+		latDef.Pad = 0
+
+		l.laterals[i] = &ConvLayer{}
+		l.laterals[i].fromDef(latDef, r)
+	}
+}
+func (l *FPNLayer) def() LayerDef {
+	// every lateral convolution is made from the same definition
+	def := l.laterals[0].def()
+	def.Type = LayerFPN
+	def.InSx, def.InSy, def.InDepth = l.InputSize(0)
+	def.LevelDepths = make([]int, len(l.sizes))
+	for i, s := range l.sizes {
+		def.LevelDepths[i] = s[2]
+	}
+
+	return def
+}
+func (l *FPNLayer) ParamsAndGrads() []ParamsAndGrads {
+	var response []ParamsAndGrads
+
+	for _, c := range l.laterals {
+		response = append(response, c.ParamsAndGrads()...)
+	}
+
+	return response
+}
+
+// Forward runs a single-level pyramid. Use ForwardMulti for more levels.
+func (l *FPNLayer) Forward(v *Vol, isTraining bool) *Vol {
+	if len(l.sizes) != 1 {
+		panic(fmt.Sprintf("convnet: fpn layer has %d levels; use ForwardMulti", len(l.sizes)))
+	}
+
+	return l.ForwardMulti([]*Vol{v}, isTraining)[0]
+}
+
+// ForwardMulti takes one feature map for each level, finest first, and
+// returns the output of each level in the same order.
+func (l *FPNLayer) ForwardMulti(vs []*Vol, isTraining bool) []*Vol {
+	if len(vs) != len(l.sizes) {
+		panic(fmt.Sprintf("convnet: fpn layer has %d levels, but got %d inputs", len(l.sizes), len(vs)))
+	}
+
+	l.inActs = vs
+	l.latActs = make([]*Vol, len(vs))
+	l.outActs = make([]*Vol, len(vs))
+
+	for i := len(vs) - 1; i >= 0; i-- {
+		l.latActs[i] = l.laterals[i].Forward(vs[i], isTraining)
+
+		a := l.latActs[i].Clone()
+		if i+1 < len(vs) {
+			// add the upsampled level above
+			up := l.outActs[i+1]
+			for x := 0; x < a.Sx; x++ {
+				for y := 0; y < a.Sy; y++ {
+					for d := 0; d < a.Depth; d++ {
+						a.Add(x, y, d, up.Get(x/2, y/2, d))
+					}
+				}
+			}
+		}
+
+		l.outActs[i] = a
+	}
+
+	return l.outActs
+}
+
+// Output returns the output of the finest level.
+func (l *FPNLayer) Output() *Vol {
+	if l.outActs == nil {
+		return nil
+	}
+
+	return l.outActs[0]
+}
+
+// GradOutput returns the gradient with respect to the output of the finest
+// level.
+func (l *FPNLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+
+// Outputs returns the output of each level from the most recent call to
+// Forward or ForwardMulti.
+func (l *FPNLayer) Outputs() []*Vol { return l.outActs }
+func (l *FPNLayer) forget() {
+	for _, c := range l.laterals {
+		c.forget()
+	}
+
+	l.inActs, l.latActs, l.outActs = nil, nil, nil
+}
+func (l *FPNLayer) shareWeights() Layer {
+	c := *l
+	c.laterals = make([]*ConvLayer, len(l.laterals))
+	for i, lat := range l.laterals {
+		c.laterals[i] = lat.shareWeights().(*ConvLayer)
+	}
+	c.forget()
+
+	return &c
+}
+
+// Backward computes the gradients wrt the parameters and the input of
+// every level from the gradients in the Dw of every output.
+func (l *FPNLayer) Backward() {
+	// each level's total gradient includes what flowed down from the
+	// finer levels it was upsampled into
+	var below []float64
+
+	for i, out := range l.outActs {
+		g := append([]float64(nil), out.Dw...)
+
+		if below != nil {
+			fine := l.outActs[i-1]
+			for x := 0; x < fine.Sx; x++ {
+				for y := 0; y < fine.Sy; y++ {
+					for d := 0; d < fine.Depth; d++ {
+						g[out.index(x/2, y/2, d)] += below[fine.index(x, y, d)]
+					}
+				}
+			}
+		}
+
+		l.latActs[i].Dw = g
+		l.laterals[i].Backward()
+
+		below = g
+	}
+}
+func (l *FPNLayer) MarshalJSON() ([]byte, error) {
+	depths := make([]int, len(l.sizes))
+	for i, s := range l.sizes {
+		depths[i] = s[2]
+	}
+
+	return json.Marshal(&struct {
+		InSx        int          `json:"in_sx"`
+		InSy        int          `json:"in_sy"`
+		LevelDepths []int        `json:"level_depths"`
+		OutDepth    int          `json:"out_depth"`
+		OutSx       int          `json:"out_sx"`
+		OutSy       int          `json:"out_sy"`
+		LayerType   string       `json:"layer_type"`
+		Laterals    []*ConvLayer `json:"laterals"`
+	}{
+		InSx:        l.sizes[0][0],
+		InSy:        l.sizes[0][1],
+		LevelDepths: depths,
+		OutDepth:    l.outDepth,
+		OutSx:       l.OutSx(),
+		OutSy:       l.OutSy(),
+		LayerType:   LayerFPN.String(),
+		Laterals:    l.laterals,
+	})
+}
+func (l *FPNLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		InSx        int          `json:"in_sx"`
+		InSy        int          `json:"in_sy"`
+		LevelDepths []int        `json:"level_depths"`
+		OutDepth    int          `json:"out_depth"`
+		OutSx       int          `json:"out_sx"`
+		OutSy       int          `json:"out_sy"`
+		LayerType   string       `json:"layer_type"`
+		Laterals    []*ConvLayer `json:"laterals"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	if len(data.LevelDepths) == 0 || len(data.Laterals) != len(data.LevelDepths) {
+		return errors.New("convnet: fpn layer needs one lateral convolution for each level")
+	}
+
+	l.outDepth = data.OutDepth
+	l.laterals = data.Laterals
+
+	l.sizes = fpnSizes(data.InSx, data.InSy, data.LevelDepths)
+
+	return nil
+}
+
+// the input shape of each level of a pyramid, halving the width and height
+// of the finest level, sx by sy, at each step
+func fpnSizes(sx, sy int, depths []int) [][3]int {
+	sizes := make([][3]int, len(depths))
+
+	for i, d := range depths {
+		sizes[i] = [3]int{sx, sy, d}
+		sx, sy = (sx+1)/2, (sy+1)/2
+	}
+
+	return sizes
+}
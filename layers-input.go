@@ -2,6 +2,7 @@ package convnet
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/rand"
 )
 
@@ -34,6 +35,20 @@ func (l *InputLayer) fromDef(def LayerDef, r *rand.Rand) {
 	}
 }
 
+// checkInput returns an error if v does not have exactly the shape declared
+// by the input layer.
+func (l *InputLayer) checkInput(v *Vol) error {
+	if n := v.Sx * v.Sy * v.Depth; len(v.W) != n {
+		return fmt.Errorf("convnet: input has %d values, but its shape %dx%dx%d needs %d", len(v.W), v.Sx, v.Sy, v.Depth, n)
+	}
+
+	if v.Sx == l.outSx && v.Sy == l.outSy && v.Depth == l.outDepth {
+		return nil
+	}
+
+	return fmt.Errorf("convnet: input has shape %dx%dx%d, but the input layer expects %dx%dx%d", v.Sx, v.Sy, v.Depth, l.outSx, l.outSy, l.outDepth)
+}
+
 func (l *InputLayer) Forward(v *Vol, isTraining bool) *Vol {
 	l.act = v
 
@@ -41,8 +56,18 @@ func (l *InputLayer) Forward(v *Vol, isTraining bool) *Vol {
 }
 
 func (l *InputLayer) Backward()                        {}
+func (l *InputLayer) forget()                          { l.act = nil }
+func (l *InputLayer) Output() *Vol                     { return l.act }
+func (l *InputLayer) GradOutput() *Vol                 { return gradOutput(l.Output()) }
 func (l *InputLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
 
+func (l *InputLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
+
 func (l *InputLayer) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		OutDepth  int    `json:"out_depth"`
@@ -7,10 +7,9 @@ import (
 )
 
 // Layers that implement a loss. Currently these are the layers that
-// can initiate a backward() pass. In future we probably want a more
-// flexible system that can accomodate multiple losses to do multi-task
-// learning, and stuff like that. But for now, one of the layers in this
-// file must be the final layer in a Net.
+// can initiate a backward() pass. One of the layers in this file must be
+// the final layer in a Net, or in each head of a MultiHeadNet for
+// multi-task learning.
 
 // This is a classifier, with N discrete classes from 0 to N-1
 // it gets a stream of N incoming numbers and computes the softmax
@@ -33,6 +32,8 @@ func (l *SoftmaxLayer) fromDef(def LayerDef, r *rand.Rand) {
 }
 
 func (l *SoftmaxLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+
 	a := NewVol(1, 1, l.outDepth, 0.0)
 
 	// compute max activation
@@ -64,6 +65,14 @@ func (l *SoftmaxLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *SoftmaxLayer) Output() *Vol     { return l.outAct }
+func (l *SoftmaxLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *SoftmaxLayer) shareWeights() Layer {
+	c := *l
+	c.inAct, c.outAct, c.es = nil, nil, nil
+
+	return &c
+}
 func (l *SoftmaxLayer) Backward() {}
 func (l *SoftmaxLayer) BackwardLoss(y LossData) float64 {
 	// compute and accumulate gradient wrt weights and bias of this layer
@@ -84,6 +93,53 @@ func (l *SoftmaxLayer) BackwardLoss(y LossData) float64 {
 	// loss is the class negative log likelihood
 	return -math.Log(l.es[y.Dim])
 }
+
+// BackwardSoft is like BackwardLoss, but the target is a probability
+// distribution over the classes rather than a single class. The inputs of
+// the layer are divided by temperature before the softmax is applied, as
+// in knowledge distillation. It returns the cross-entropy between targets
+// and the softened output.
+func (l *SoftmaxLayer) BackwardSoft(targets []float64, temperature float64) float64 {
+	x := l.inAct
+	// zero out the gradient of input Vol
+	x.Dw = make([]float64, len(x.W))
+
+	ps := softmax(x.W[:l.outDepth], temperature)
+
+	loss := 0.0
+	for i, p := range ps {
+		x.Dw[i] = (p - targets[i]) / temperature
+
+		if targets[i] != 0 {
+			loss -= targets[i] * math.Log(p)
+		}
+	}
+
+	return loss
+}
+
+// computes softmax(as / temperature) without overflowing
+func softmax(as []float64, temperature float64) []float64 {
+	amax := as[0]
+	for _, a := range as[1:] {
+		if a > amax {
+			amax = a
+		}
+	}
+
+	es := make([]float64, len(as))
+	esum := 0.0
+	for i, a := range as {
+		es[i] = math.Exp((a - amax) / temperature)
+		esum += es[i]
+	}
+
+	for i := range es {
+		es[i] /= esum
+	}
+
+	return es
+}
 func (l *SoftmaxLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
 func (l *SoftmaxLayer) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
@@ -124,6 +180,13 @@ func (l *SoftmaxLayer) UnmarshalJSON(b []byte) error {
 type RegressionLayer struct {
 	numInputs int
 	act       *Vol
+	training  bool // whether the most recent Forward was a training pass
+
+	// target normalization
+	normalizeTargets bool
+	targetMomentum   float64
+	targetMean       float64
+	targetVar        float64
 }
 
 var _ LossLayer = (*RegressionLayer)(nil)
@@ -139,9 +202,20 @@ func (l *RegressionLayer) fromDef(def LayerDef, r *rand.Rand) {
 
 func (l *RegressionLayer) Forward(v *Vol, isTraining bool) *Vol {
 	l.act = v
+	l.training = isTraining
 	return v // identity function
 }
 
+func (l *RegressionLayer) Output() *Vol     { return l.act }
+func (l *RegressionLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+
+func (l *RegressionLayer) shareWeights() Layer {
+	c := *l
+	c.act = nil
+
+	return &c
+}
+
 func (l *RegressionLayer) Backward() {}
 
 func (l *RegressionLayer) BackwardLoss(y LossData) float64 {
@@ -150,6 +224,13 @@ func (l *RegressionLayer) BackwardLoss(y LossData) float64 {
 	x.Dw = make([]float64, len(x.W)) // zero out the gradient of input Vol
 
 	i, yi := y.Dim, y.Val
+	if l.normalizeTargets {
+		if l.training {
+			l.updateTargetStats(yi)
+		}
+		yi = (yi - l.targetMean) / math.Sqrt(l.targetVar+1e-5)
+	}
+
 	dy := x.W[i] - yi
 	x.Dw[i] = dy
 
@@ -157,28 +238,75 @@ func (l *RegressionLayer) BackwardLoss(y LossData) float64 {
 }
 func (l *RegressionLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
 
+// EnableTargetNormalization makes BackwardLoss keep a running mean and
+// variance of the targets it is given after a training Forward, with the
+// old statistics weighted by momentum, and train the net to predict
+// targets normalized by them. Losses computed in prediction mode, such as
+// by Net.CostLoss, use the statistics without changing them.
+// The outputs of the net are then normalized too; multiply them by the
+// square root of TargetVariance and add TargetMean to get predictions in
+// the original scale. The statistics start at a mean of 0 and a variance
+// of 1, like those of a batch norm layer.
+func (l *RegressionLayer) EnableTargetNormalization(momentum float64) {
+	if !l.normalizeTargets {
+		l.targetMean, l.targetVar = 0, 1
+	}
+
+	l.normalizeTargets = true
+	l.targetMomentum = momentum
+}
+
+// TargetMean returns the running mean of the targets seen in training.
+func (l *RegressionLayer) TargetMean() float64 { return l.targetMean }
+
+// TargetVariance returns the running variance of the targets seen in
+// training.
+func (l *RegressionLayer) TargetVariance() float64 { return l.targetVar }
+
+// folds one target into the running statistics, the same way a batch norm
+// layer folds in a volume with a single value
+func (l *RegressionLayer) updateTargetStats(y float64) {
+	m := l.targetMomentum
+
+	delta := y - l.targetMean
+	l.targetVar = m*l.targetVar + m*(1-m)*delta*delta
+	l.targetMean += (1 - m) * delta
+}
+
 func (l *RegressionLayer) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		OutDepth  int    `json:"out_depth"`
-		OutSx     int    `json:"out_sx"`
-		OutSy     int    `json:"out_sy"`
-		LayerType string `json:"layer_type"`
-		NumInputs int    `json:"num_inputs"`
+		OutDepth         int     `json:"out_depth"`
+		OutSx            int     `json:"out_sx"`
+		OutSy            int     `json:"out_sy"`
+		LayerType        string  `json:"layer_type"`
+		NumInputs        int     `json:"num_inputs"`
+		NormalizeTargets bool    `json:"normalize_targets,omitempty"`
+		TargetMomentum   float64 `json:"target_momentum,omitempty"`
+		TargetMean       float64 `json:"target_mean,omitempty"`
+		TargetVariance   float64 `json:"target_variance,omitempty"`
 	}{
-		OutDepth:  l.numInputs,
-		OutSx:     1,
-		OutSy:     1,
-		LayerType: LayerRegression.String(),
-		NumInputs: l.numInputs,
+		OutDepth:         l.numInputs,
+		OutSx:            1,
+		OutSy:            1,
+		LayerType:        LayerRegression.String(),
+		NumInputs:        l.numInputs,
+		NormalizeTargets: l.normalizeTargets,
+		TargetMomentum:   l.targetMomentum,
+		TargetMean:       l.targetMean,
+		TargetVariance:   l.targetVar,
 	})
 }
 func (l *RegressionLayer) UnmarshalJSON(b []byte) error {
 	var data struct {
-		OutDepth  int    `json:"out_depth"`
-		OutSx     int    `json:"out_sx"`
-		OutSy     int    `json:"out_sy"`
-		LayerType string `json:"layer_type"`
-		NumInputs int    `json:"num_inputs"`
+		OutDepth         int     `json:"out_depth"`
+		OutSx            int     `json:"out_sx"`
+		OutSy            int     `json:"out_sy"`
+		LayerType        string  `json:"layer_type"`
+		NumInputs        int     `json:"num_inputs"`
+		NormalizeTargets bool    `json:"normalize_targets"`
+		TargetMomentum   float64 `json:"target_momentum"`
+		TargetMean       float64 `json:"target_mean"`
+		TargetVariance   float64 `json:"target_variance"`
 	}
 
 	if err := json.Unmarshal(b, &data); err != nil {
@@ -186,6 +314,10 @@ func (l *RegressionLayer) UnmarshalJSON(b []byte) error {
 	}
 
 	l.numInputs = data.NumInputs
+	l.normalizeTargets = data.NormalizeTargets
+	l.targetMomentum = data.TargetMomentum
+	l.targetMean = data.TargetMean
+	l.targetVar = data.TargetVariance
 
 	return nil
 }
@@ -211,6 +343,16 @@ func (l *SVMLayer) Forward(v *Vol, isTraining bool) *Vol {
 	return v
 }
 
+func (l *SVMLayer) Output() *Vol     { return l.act }
+func (l *SVMLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+
+func (l *SVMLayer) shareWeights() Layer {
+	c := *l
+	c.act = nil
+
+	return &c
+}
+
 func (l *SVMLayer) Backward() {}
 
 func (l *SVMLayer) BackwardLoss(y LossData) float64 {
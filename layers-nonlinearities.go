@@ -41,6 +41,15 @@ func (l *ReluLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *ReluLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *ReluLayer) Output() *Vol     { return l.outAct }
+func (l *ReluLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *ReluLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *ReluLayer) Backward() {
 	v := l.inAct // we need to set dw of this
 	v2 := l.outAct
@@ -106,7 +115,7 @@ func (l *SigmoidLayer) fromDef(def LayerDef, r *rand.Rand) {
 	l.outSy = def.InSy
 	l.outDepth = def.InDepth
 }
-func (l *SigmoidLayer) ParamsAndGrads() []ParamsAndGrads { panic("TODO") }
+func (l *SigmoidLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
 func (l *SigmoidLayer) Forward(v *Vol, isTraining bool) *Vol {
 	l.inAct = v
 	v2 := v.CloneAndZero()
@@ -119,6 +128,15 @@ func (l *SigmoidLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *SigmoidLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *SigmoidLayer) Output() *Vol     { return l.outAct }
+func (l *SigmoidLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *SigmoidLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *SigmoidLayer) Backward() {
 	v := l.inAct // we need to set dw of this
 	v2 := l.outAct
@@ -175,9 +193,9 @@ type MaxoutLayer struct {
 	outAct    *Vol
 }
 
-func (l *MaxoutLayer) OutDepth() int { panic("TODO") }
-func (l *MaxoutLayer) OutSx() int    { panic("TODO") }
-func (l *MaxoutLayer) OutSy() int    { panic("TODO") }
+func (l *MaxoutLayer) OutDepth() int { return l.outDepth }
+func (l *MaxoutLayer) OutSx() int    { return l.outSx }
+func (l *MaxoutLayer) OutSy() int    { return l.outSy }
 func (l *MaxoutLayer) fromDef(def LayerDef, r *rand.Rand) {
 	// required
 	l.groupSize = def.GroupSize
@@ -192,7 +210,7 @@ func (l *MaxoutLayer) fromDef(def LayerDef, r *rand.Rand) {
 
 	l.switches = make([]int, l.outSx*l.outSy*l.outDepth) // useful for backprop
 }
-func (l *MaxoutLayer) ParamsAndGrads() []ParamsAndGrads { panic("TODO") }
+func (l *MaxoutLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
 func (l *MaxoutLayer) Forward(v *Vol, isTraining bool) *Vol {
 	l.inAct = v
 	v2 := NewVol(l.outSx, l.outSy, l.outDepth, 0.0)
@@ -249,6 +267,16 @@ func (l *MaxoutLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *MaxoutLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *MaxoutLayer) Output() *Vol     { return l.outAct }
+func (l *MaxoutLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *MaxoutLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.switches = make([]int, len(l.switches))
+
+	return &c
+}
 func (l *MaxoutLayer) Backward() {
 	v := l.inAct // we need to set dw of this
 	v2 := l.outAct
@@ -256,7 +284,7 @@ func (l *MaxoutLayer) Backward() {
 
 	// pass the gradient through the appropriate switch
 	if l.outSx == 1 && l.outSy == 1 {
-		for i := range v.Dw {
+		for i := range v2.Dw {
 			chainGrad := v2.Dw[i]
 
 			v.Dw[l.switches[i]] = chainGrad
@@ -348,6 +376,15 @@ func (l *TanhLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *TanhLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *TanhLayer) Output() *Vol     { return l.outAct }
+func (l *TanhLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *TanhLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *TanhLayer) Backward() {
 	v := l.inAct // we need to set dw of this
 	v2 := l.outAct
@@ -392,3 +429,88 @@ func (l *TanhLayer) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// Implements Swish nonlinearity elementwise, also known as SiLU
+// x -> x/(1+e^(-x))
+type SwishLayer struct {
+	outDepth int
+	outSx    int
+	outSy    int
+	inAct    *Vol
+	outAct   *Vol
+}
+
+func (l *SwishLayer) OutDepth() int { return l.outDepth }
+func (l *SwishLayer) OutSx() int    { return l.outSx }
+func (l *SwishLayer) OutSy() int    { return l.outSy }
+func (l *SwishLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// computed
+	l.outSx = def.InSx
+	l.outSy = def.InSy
+	l.outDepth = def.InDepth
+}
+func (l *SwishLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
+func (l *SwishLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+	v2 := v.CloneAndZero()
+
+	for i := range v2.W {
+		v2.W[i] = v.W[i] / (1.0 + math.Exp(-v.W[i]))
+	}
+
+	l.outAct = v2
+
+	return l.outAct
+}
+func (l *SwishLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *SwishLayer) Output() *Vol     { return l.outAct }
+func (l *SwishLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *SwishLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
+func (l *SwishLayer) Backward() {
+	v := l.inAct // we need to set dw of this
+	v2 := l.outAct
+
+	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+
+	for i := range v.Dw {
+		// d/dx x*sigmoid(x) = swish(x) + sigmoid(x)*(1-swish(x))
+		s := 1.0 / (1.0 + math.Exp(-v.W[i]))
+		v.Dw[i] = (v2.W[i] + s*(1.0-v2.W[i])) * v2.Dw[i]
+	}
+}
+func (l *SwishLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		OutDepth  int    `json:"out_depth"`
+		OutSx     int    `json:"out_sx"`
+		OutSy     int    `json:"out_sy"`
+		LayerType string `json:"layer_type"`
+	}{
+		OutDepth:  l.outDepth,
+		OutSx:     l.outSx,
+		OutSy:     l.outSy,
+		LayerType: LayerSwish.String(),
+	})
+}
+func (l *SwishLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		OutDepth  int    `json:"out_depth"`
+		OutSx     int    `json:"out_sx"`
+		OutSy     int    `json:"out_sy"`
+		LayerType string `json:"layer_type"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.outDepth = data.OutDepth
+	l.outSx = data.OutSx
+	l.outSy = data.OutSy
+
+	return nil
+}
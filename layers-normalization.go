@@ -2,6 +2,7 @@ package convnet
 
 import (
 	"encoding/json"
+	"fmt"
 	"math"
 	"math/rand"
 )
@@ -79,6 +80,15 @@ func (l *LocalResponseNormalizationLayer) Forward(v *Vol, isTraining bool) *Vol
 	l.outAct = a
 	return l.outAct
 }
+func (l *LocalResponseNormalizationLayer) forget()          { l.inAct, l.outAct, l.s = nil, nil, nil }
+func (l *LocalResponseNormalizationLayer) Output() *Vol     { return l.outAct }
+func (l *LocalResponseNormalizationLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *LocalResponseNormalizationLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
 func (l *LocalResponseNormalizationLayer) Backward() {
 	// evaluate gradient wrt data
 	v := l.inAct                     // we need to set dw of this
@@ -169,3 +179,301 @@ func (l *LocalResponseNormalizationLayer) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// BatchNormLayer normalizes each depth slice of its input using a running
+// average of the mean and variance seen during training, then applies a
+// learned scale (gamma) and shift (beta) per depth slice.
+//
+// Nets here see one example at a time, so there is no batch to take
+// statistics over. Instead, every training Forward folds the statistics of
+// its input into the running averages, with the old averages weighted by
+// momentum, and the normalization always uses the running averages. The
+// statistics are treated as constants by Backward.
+//
+// When FixedMode is set, the layer normalizes with the statistics given to
+// SetFixedStats instead, in both training and prediction, and leaves the
+// running averages alone. This matches runtimes that cannot update
+// normalization statistics.
+type BatchNormLayer struct {
+	outSx       int
+	outSy       int
+	outDepth    int
+	momentum    float64
+	eps         float64
+	frozen      bool
+	gamma       *Vol // 1 x 1 x outDepth
+	beta        *Vol // 1 x 1 x outDepth
+	runningMean *Vol
+	runningVar  *Vol
+	fixedMean   *Vol
+	fixedVar    *Vol
+	inAct       *Vol
+	outAct      *Vol
+
+	FixedMode bool
+}
+
+func (l *BatchNormLayer) OutDepth() int { return l.outDepth }
+func (l *BatchNormLayer) OutSx() int    { return l.outSx }
+func (l *BatchNormLayer) OutSy() int    { return l.outSy }
+
+// RunningMean returns the running average of the mean of each depth slice.
+func (l *BatchNormLayer) RunningMean() *Vol { return l.runningMean }
+
+// RunningVariance returns the running average of the variance of each
+// depth slice.
+func (l *BatchNormLayer) RunningVariance() *Vol { return l.runningVar }
+
+func (l *BatchNormLayer) Trainable() bool     { return !l.frozen }
+func (l *BatchNormLayer) SetTrainable(t bool) { l.frozen = !t }
+
+// SetFixedStats sets the statistics used when FixedMode is true. mean and
+// variance must each hold one value per depth slice, and are copied.
+func (l *BatchNormLayer) SetFixedStats(mean, variance *Vol) {
+	if len(mean.W) != l.outDepth || len(variance.W) != l.outDepth {
+		panic(fmt.Sprintf("convnet: batch norm statistics must have %d values, but have %d and %d", l.outDepth, len(mean.W), len(variance.W)))
+	}
+
+	l.fixedMean = NewVol(1, 1, l.outDepth, 0.0)
+	l.fixedVar = NewVol(1, 1, l.outDepth, 0.0)
+	copy(l.fixedMean.W, mean.W)
+	copy(l.fixedVar.W, variance.W)
+}
+
+// FreezeStats copies the current running statistics into the fixed
+// statistics and turns on FixedMode.
+func (l *BatchNormLayer) FreezeStats() {
+	l.SetFixedStats(l.runningMean, l.runningVar)
+	l.FixedMode = true
+}
+
+// copies the running and fixed statistics of c, which has the same depth
+func (l *BatchNormLayer) copyStats(c *BatchNormLayer) {
+	copy(l.runningMean.W, c.runningMean.W)
+	copy(l.runningVar.W, c.runningVar.W)
+
+	l.fixedMean, l.fixedVar = nil, nil
+	if c.fixedMean != nil {
+		l.fixedMean, l.fixedVar = c.fixedMean.Clone(), c.fixedVar.Clone()
+	}
+}
+func (l *BatchNormLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// computed
+	l.outSx = def.InSx
+	l.outSy = def.InSy
+	l.outDepth = def.InDepth
+
+	// optional
+	l.momentum = def.Momentum
+	if l.momentum == 0 && !def.MomentumZero {
+		l.momentum = 0.9
+	}
+	l.eps = 1e-5
+
+	l.frozen = !def.Trainable && def.TrainableZero
+
+	// initializations
+	l.gamma = NewVol(1, 1, l.outDepth, 1.0)
+	l.beta = NewVol(1, 1, l.outDepth, 0.0)
+	l.runningMean = NewVol(1, 1, l.outDepth, 0.0)
+	l.runningVar = NewVol(1, 1, l.outDepth, 1.0)
+}
+func (l *BatchNormLayer) def() LayerDef {
+	return LayerDef{
+		Type:          LayerBatchNorm,
+		Momentum:      l.momentum,
+		MomentumZero:  true,
+		Trainable:     !l.frozen,
+		TrainableZero: true,
+	}
+}
+func (l *BatchNormLayer) stats() (mean, variance []float64) {
+	if l.FixedMode {
+		if l.fixedMean == nil {
+			panic("convnet: batch norm layer is in fixed mode, but no fixed statistics were set")
+		}
+
+		return l.fixedMean.W, l.fixedVar.W
+	}
+
+	return l.runningMean.W, l.runningVar.W
+}
+func (l *BatchNormLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+
+	if isTraining && !l.FixedMode {
+		l.updateRunningStats(v)
+	}
+
+	mean, variance := l.stats()
+
+	a := v.CloneAndZero()
+	for d := 0; d < l.outDepth; d++ {
+		scale := l.gamma.W[d] / math.Sqrt(variance[d]+l.eps)
+
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				a.Set(x, y, d, (v.Get(x, y, d)-mean[d])*scale+l.beta.W[d])
+			}
+		}
+	}
+
+	l.outAct = a
+
+	return l.outAct
+}
+
+// normalizes v without updating the running statistics, so that a
+// checkpointed training pass can be recomputed without counting v twice
+func (l *BatchNormLayer) replay(v *Vol) *Vol {
+	return l.Forward(v, false)
+}
+
+// folds the mean and variance of each depth slice of v into the running
+// statistics, as a mixture of the old distribution (weighted by momentum)
+// and the new one
+func (l *BatchNormLayer) updateRunningStats(v *Vol) {
+	n := float64(v.Sx * v.Sy)
+	m := l.momentum
+
+	for d := 0; d < l.outDepth; d++ {
+		sum, sumSq := 0.0, 0.0
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				f := v.Get(x, y, d)
+				sum += f
+				sumSq += f * f
+			}
+		}
+
+		mean := sum / n
+		variance := math.Max(sumSq/n-mean*mean, 0)
+
+		delta := mean - l.runningMean.W[d]
+		l.runningVar.W[d] = m*l.runningVar.W[d] + (1-m)*variance + m*(1-m)*delta*delta
+		l.runningMean.W[d] += (1 - m) * delta
+	}
+}
+func (l *BatchNormLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *BatchNormLayer) Output() *Vol     { return l.outAct }
+func (l *BatchNormLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *BatchNormLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+
+	return &c
+}
+func (l *BatchNormLayer) Backward() {
+	v := l.inAct                     // we need to set dw of this
+	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+	a := l.outAct
+
+	mean, variance := l.stats()
+
+	for d := 0; d < l.outDepth; d++ {
+		invStd := 1 / math.Sqrt(variance[d]+l.eps)
+
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				chainGrad := a.GetGrad(x, y, d)
+
+				v.SetGrad(x, y, d, chainGrad*l.gamma.W[d]*invStd)
+				l.gamma.Dw[d] += chainGrad * (v.Get(x, y, d) - mean[d]) * invStd
+				l.beta.Dw[d] += chainGrad
+			}
+		}
+	}
+}
+func (l *BatchNormLayer) ParamsAndGrads() []ParamsAndGrads {
+	return []ParamsAndGrads{
+		{
+			Params:     l.gamma.W,
+			Grads:      l.gamma.Dw,
+			L1DecayMul: 0.0,
+			L2DecayMul: 0.0,
+			Frozen:     l.frozen,
+		},
+		{
+			Params:     l.beta.W,
+			Grads:      l.beta.Dw,
+			L1DecayMul: 0.0,
+			L2DecayMul: 0.0,
+			Frozen:     l.frozen,
+		},
+	}
+}
+func (l *BatchNormLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		OutDepth    int     `json:"out_depth"`
+		OutSx       int     `json:"out_sx"`
+		OutSy       int     `json:"out_sy"`
+		LayerType   string  `json:"layer_type"`
+		Momentum    float64 `json:"momentum"`
+		Eps         float64 `json:"eps"`
+		Trainable   bool    `json:"trainable"`
+		Gamma       *Vol    `json:"gamma"`
+		Beta        *Vol    `json:"beta"`
+		RunningMean *Vol    `json:"running_mean"`
+		RunningVar  *Vol    `json:"running_var"`
+		FixedMean   *Vol    `json:"fixed_mean,omitempty"`
+		FixedVar    *Vol    `json:"fixed_var,omitempty"`
+		FixedMode   bool    `json:"fixed_mode"`
+	}{
+		OutDepth:    l.outDepth,
+		OutSx:       l.outSx,
+		OutSy:       l.outSy,
+		LayerType:   LayerBatchNorm.String(),
+		Momentum:    l.momentum,
+		Eps:         l.eps,
+		Trainable:   !l.frozen,
+		Gamma:       l.gamma,
+		Beta:        l.beta,
+		RunningMean: l.runningMean,
+		RunningVar:  l.runningVar,
+		FixedMean:   l.fixedMean,
+		FixedVar:    l.fixedVar,
+		FixedMode:   l.FixedMode,
+	})
+}
+func (l *BatchNormLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		OutDepth    int     `json:"out_depth"`
+		OutSx       int     `json:"out_sx"`
+		OutSy       int     `json:"out_sy"`
+		LayerType   string  `json:"layer_type"`
+		Momentum    float64 `json:"momentum"`
+		Eps         float64 `json:"eps"`
+		Trainable   bool    `json:"trainable"`
+		Gamma       *Vol    `json:"gamma"`
+		Beta        *Vol    `json:"beta"`
+		RunningMean *Vol    `json:"running_mean"`
+		RunningVar  *Vol    `json:"running_var"`
+		FixedMean   *Vol    `json:"fixed_mean"`
+		FixedVar    *Vol    `json:"fixed_var"`
+		FixedMode   bool    `json:"fixed_mode"`
+	}
+
+	data.Momentum = 0.9
+	data.Eps = 1e-5
+	data.Trainable = true
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.outDepth = data.OutDepth
+	l.outSx = data.OutSx
+	l.outSy = data.OutSy
+	l.momentum = data.Momentum
+	l.eps = data.Eps
+	l.frozen = !data.Trainable
+	l.gamma = data.Gamma
+	l.beta = data.Beta
+	l.runningMean = data.RunningMean
+	l.runningVar = data.RunningVar
+	l.fixedMean = data.FixedMean
+	l.fixedVar = data.FixedVar
+	l.FixedMode = data.FixedMode
+
+	return nil
+}
@@ -2,6 +2,7 @@ package convnet
 
 import (
 	"encoding/json"
+	"math"
 	"math/rand"
 )
 
@@ -25,6 +26,12 @@ func (l *PoolLayer) OutDepth() int { return l.inDepth }
 func (l *PoolLayer) OutSx() int    { return l.outSx }
 func (l *PoolLayer) OutSy() int    { return l.outSy }
 
+// OutputSize returns the width, height, and depth of the output.
+func (l *PoolLayer) OutputSize() (sx, sy, depth int) { return l.outSx, l.outSy, l.inDepth }
+
+// InputSize returns the width, height, and depth of the input.
+func (l *PoolLayer) InputSize() (sx, sy, depth int) { return l.inSx, l.inSy, l.inDepth }
+
 func (l *PoolLayer) fromDef(def LayerDef, r *rand.Rand) {
 	// required
 	l.sx = def.Sx // filter size
@@ -103,6 +110,16 @@ func (l *PoolLayer) Forward(v *Vol, isTraining bool) *Vol {
 
 	return l.outAct
 }
+func (l *PoolLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *PoolLayer) Output() *Vol     { return l.outAct }
+func (l *PoolLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *PoolLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.switchx, c.switchy = make([]int, len(l.switchx)), make([]int, len(l.switchy))
+
+	return &c
+}
 func (l *PoolLayer) Backward() {
 	// pooling layers have no parameters, so simply compute
 	// gradient wrt data here
@@ -181,3 +198,385 @@ func (l *PoolLayer) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// LpPoolLayer pools each window of its input by taking the Lp norm of the
+// values in it: (sum |x|^p)^(1/p). A p of 1 sums the absolute values, a
+// p of 2 is L2 pooling, and as p grows it approaches max pooling of the
+// absolute values, which is what an infinite p does. p must be at least
+// 1. The windows are laid out like those of a PoolLayer, and padding
+// contributes nothing to the norm.
+type LpPoolLayer struct {
+	sx      int
+	sy      int
+	inDepth int
+	inSx    int
+	inSy    int
+	outSx   int
+	outSy   int
+	stride  int
+	pad     int
+	p       float64
+	switchx []int // for an infinite p, like PoolLayer
+	switchy []int
+	inAct   *Vol
+	outAct  *Vol
+}
+
+func (l *LpPoolLayer) OutDepth() int { return l.inDepth }
+func (l *LpPoolLayer) OutSx() int    { return l.outSx }
+func (l *LpPoolLayer) OutSy() int    { return l.outSy }
+
+// P returns the order of the norm.
+func (l *LpPoolLayer) P() float64 { return l.p }
+
+func (l *LpPoolLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// the window is set up exactly like a PoolLayer
+	var pool PoolLayer
+	pool.fromDef(def, r)
+
+	l.sx, l.sy = pool.sx, pool.sy
+	l.inSx, l.inSy, l.inDepth = pool.inSx, pool.inSy, pool.inDepth
+	l.outSx, l.outSy = pool.outSx, pool.outSy
+	l.stride, l.pad = pool.stride, pool.pad
+	l.switchx, l.switchy = pool.switchx, pool.switchy
+
+	// optional
+	l.p = def.P
+	if l.p == 0 {
+		l.p = 2
+	}
+}
+func (l *LpPoolLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+
+	a := NewVol(l.outSx, l.outSy, l.inDepth, 0.0)
+	inf := math.IsInf(l.p, 1)
+
+	n := 0 // a counter for switches
+
+	for d := 0; d < l.inDepth; d++ {
+		x := -l.pad
+
+		for ax := 0; ax < l.outSx; x, ax = x+l.stride, ax+1 {
+			y := -l.pad
+
+			for ay := 0; ay < l.outSy; y, ay = y+l.stride, ay+1 {
+				sum := 0.0
+				winx, winy := -1, -1
+
+				for fx := 0; fx < l.sx; fx++ {
+					for fy := 0; fy < l.sy; fy++ {
+						ox, oy := x+fx, y+fy
+
+						if oy >= 0 && oy < v.Sy && ox >= 0 && ox < v.Sx {
+							value := math.Abs(v.Get(ox, oy, d))
+
+							if !inf {
+								sum += math.Pow(value, l.p)
+							} else if winx == -1 || value > sum {
+								sum = value
+								winx, winy = ox, oy
+							}
+						}
+					}
+				}
+
+				if !inf {
+					sum = math.Pow(sum, 1/l.p)
+				}
+
+				l.switchx[n] = winx
+				l.switchy[n] = winy
+				n++
+
+				a.Set(ax, ay, d, sum)
+			}
+		}
+	}
+
+	l.outAct = a
+
+	return l.outAct
+}
+func (l *LpPoolLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *LpPoolLayer) Output() *Vol     { return l.outAct }
+func (l *LpPoolLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *LpPoolLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.switchx, c.switchy = make([]int, len(l.switchx)), make([]int, len(l.switchy))
+
+	return &c
+}
+func (l *LpPoolLayer) Backward() {
+	// d out / d x = sign(x) * (|x| / out)^(p-1)
+	v := l.inAct
+	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+
+	inf := math.IsInf(l.p, 1)
+
+	n := 0
+	for d := 0; d < l.inDepth; d++ {
+		x := -l.pad
+
+		for ax := 0; ax < l.outSx; x, ax = x+l.stride, ax+1 {
+			y := -l.pad
+
+			for ay := 0; ay < l.outSy; y, ay = y+l.stride, ay+1 {
+				chainGrad := l.outAct.GetGrad(ax, ay, d)
+				out := l.outAct.Get(ax, ay, d)
+
+				if inf {
+					if winx, winy := l.switchx[n], l.switchy[n]; winx != -1 {
+						v.AddGrad(winx, winy, d, chainGrad*math.Copysign(1, v.Get(winx, winy, d)))
+					}
+				} else if out != 0 {
+					for fx := 0; fx < l.sx; fx++ {
+						for fy := 0; fy < l.sy; fy++ {
+							ox, oy := x+fx, y+fy
+
+							if oy >= 0 && oy < v.Sy && ox >= 0 && ox < v.Sx {
+								value := v.Get(ox, oy, d)
+								if value == 0 {
+									continue
+								}
+
+								g := math.Pow(math.Abs(value)/out, l.p-1)
+								v.AddGrad(ox, oy, d, chainGrad*math.Copysign(g, value))
+							}
+						}
+					}
+				}
+
+				n++
+			}
+		}
+	}
+}
+func (l *LpPoolLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
+
+// an infinite p is written to JSON as the string "inf"
+func (l *LpPoolLayer) MarshalJSON() ([]byte, error) {
+	var p interface{} = l.p
+	if math.IsInf(l.p, 1) {
+		p = "inf"
+	}
+
+	return json.Marshal(&struct {
+		Sx        int         `json:"sx"`
+		Sy        int         `json:"sy"`
+		Stride    int         `json:"stride"`
+		InDepth   int         `json:"in_depth"`
+		OutDepth  int         `json:"out_depth"`
+		OutSx     int         `json:"out_sx"`
+		OutSy     int         `json:"out_sy"`
+		LayerType string      `json:"layer_type"`
+		Pad       int         `json:"pad"`
+		P         interface{} `json:"p"`
+	}{
+		Sx:        l.sx,
+		Sy:        l.sy,
+		Stride:    l.stride,
+		InDepth:   l.inDepth,
+		OutDepth:  l.inDepth,
+		OutSx:     l.outSx,
+		OutSy:     l.outSy,
+		LayerType: LayerLpPool.String(),
+		Pad:       l.pad,
+		P:         p,
+	})
+}
+func (l *LpPoolLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Sx        int             `json:"sx"`
+		Sy        int             `json:"sy"`
+		Stride    int             `json:"stride"`
+		InDepth   int             `json:"in_depth"`
+		OutDepth  int             `json:"out_depth"`
+		OutSx     int             `json:"out_sx"`
+		OutSy     int             `json:"out_sy"`
+		LayerType string          `json:"layer_type"`
+		Pad       int             `json:"pad"`
+		P         json.RawMessage `json:"p"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.outSx = data.OutSx
+	l.outSy = data.OutSy
+	l.sx = data.Sx
+	l.sy = data.Sy
+	l.stride = data.Stride
+	l.inDepth = data.InDepth
+	l.pad = data.Pad
+
+	l.p = 2
+	if string(data.P) == `"inf"` {
+		l.p = math.Inf(1)
+	} else if len(data.P) != 0 {
+		if err := json.Unmarshal(data.P, &l.p); err != nil {
+			return err
+		}
+	}
+
+	// need to re-init these appropriately
+	l.switchx = make([]int, l.outSx*l.outSy*l.inDepth)
+	l.switchy = make([]int, l.outSx*l.outSy*l.inDepth)
+
+	return nil
+}
+
+// Spatial pyramid pooling layer. Max pools the input at several scales
+// (for example 1x1, 2x2, and 4x4 bins) and concatenates the results along
+// the depth axis, so the output size does not depend on the spatial size
+// of the input.
+type SPPLayer struct {
+	binSizes []int
+	inDepth  int
+	outDepth int
+	switchx  []int
+	switchy  []int
+	inAct    *Vol
+	outAct   *Vol
+}
+
+func (l *SPPLayer) OutDepth() int { return l.outDepth }
+func (l *SPPLayer) OutSx() int    { return 1 }
+func (l *SPPLayer) OutSy() int    { return 1 }
+
+func (l *SPPLayer) fromDef(def LayerDef, r *rand.Rand) {
+	// optional
+	l.binSizes = append([]int(nil), def.BinSizes...)
+	if len(l.binSizes) == 0 {
+		l.binSizes = []int{1, 2, 4}
+	}
+
+	// computed
+	l.inDepth = def.InDepth
+	l.init()
+}
+func (l *SPPLayer) init() {
+	bins := 0
+	for _, s := range l.binSizes {
+		bins += s * s
+	}
+
+	l.outDepth = l.inDepth * bins
+
+	// store switches for x,y coordinates for where the max comes from, for each output neuron
+	l.switchx = make([]int, l.outDepth)
+	l.switchy = make([]int, l.outDepth)
+}
+func (l *SPPLayer) Forward(v *Vol, isTraining bool) *Vol {
+	l.inAct = v
+
+	a := NewVol(1, 1, l.outDepth, 0.0)
+
+	n := 0 // a counter for outputs and switches
+
+	for _, s := range l.binSizes {
+		for by := 0; by < s; by++ {
+			// bin boundaries, rounded outwards so every input is covered
+			y0, y1 := by*v.Sy/s, ((by+1)*v.Sy+s-1)/s
+
+			for bx := 0; bx < s; bx++ {
+				x0, x1 := bx*v.Sx/s, ((bx+1)*v.Sx+s-1)/s
+
+				for d := 0; d < l.inDepth; d++ {
+					bestValue := -99999.0 // hopefully small enough ;\
+					winx, winy := -1, -1
+
+					for y := y0; y < y1; y++ {
+						for x := x0; x < x1; x++ {
+							if value := v.Get(x, y, d); value > bestValue {
+								bestValue = value
+								winx = x
+								winy = y
+							}
+						}
+					}
+
+					l.switchx[n] = winx
+					l.switchy[n] = winy
+					a.W[n] = bestValue
+					n++
+				}
+			}
+		}
+	}
+
+	l.outAct = a
+
+	return l.outAct
+}
+func (l *SPPLayer) forget()          { l.inAct, l.outAct = nil, nil }
+func (l *SPPLayer) Output() *Vol     { return l.outAct }
+func (l *SPPLayer) GradOutput() *Vol { return gradOutput(l.Output()) }
+func (l *SPPLayer) shareWeights() Layer {
+	c := *l
+	c.forget()
+	c.switchx, c.switchy = make([]int, len(l.switchx)), make([]int, len(l.switchy))
+
+	return &c
+}
+func (l *SPPLayer) Backward() {
+	// no parameters, so simply route the gradient back to
+	// wherever the max came from at each pyramid level
+	v := l.inAct
+	v.Dw = make([]float64, len(v.W)) // zero out gradient wrt data
+
+	n := 0
+	for _, s := range l.binSizes {
+		for i := 0; i < s*s; i++ {
+			for d := 0; d < l.inDepth; d++ {
+				if l.switchx[n] >= 0 {
+					v.AddGrad(l.switchx[n], l.switchy[n], d, l.outAct.Dw[n])
+				}
+
+				n++
+			}
+		}
+	}
+}
+func (l *SPPLayer) ParamsAndGrads() []ParamsAndGrads { return nil }
+func (l *SPPLayer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		BinSizes  []int  `json:"bin_sizes"`
+		InDepth   int    `json:"in_depth"`
+		OutDepth  int    `json:"out_depth"`
+		OutSx     int    `json:"out_sx"`
+		OutSy     int    `json:"out_sy"`
+		LayerType string `json:"layer_type"`
+	}{
+		BinSizes:  l.binSizes,
+		InDepth:   l.inDepth,
+		OutDepth:  l.outDepth,
+		OutSx:     1,
+		OutSy:     1,
+		LayerType: LayerSPP.String(),
+	})
+}
+func (l *SPPLayer) UnmarshalJSON(b []byte) error {
+	var data struct {
+		BinSizes  []int  `json:"bin_sizes"`
+		InDepth   int    `json:"in_depth"`
+		OutDepth  int    `json:"out_depth"`
+		OutSx     int    `json:"out_sx"`
+		OutSy     int    `json:"out_sy"`
+		LayerType string `json:"layer_type"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	l.binSizes = data.BinSizes
+	l.inDepth = data.InDepth
+
+	// need to re-init these appropriately
+	l.init()
+
+	return nil
+}
@@ -21,11 +21,21 @@ func _() {
 	_ = x[LayerFC-11]
 	_ = x[LayerMaxout-12]
 	_ = x[LayerSVM-13]
+	_ = x[LayerSPP-14]
+	_ = x[LayerDeformConv-15]
+	_ = x[LayerEmbedding-16]
+	_ = x[LayerSwish-17]
+	_ = x[LayerBatchNorm-18]
+	_ = x[LayerMixout-19]
+	_ = x[LayerFPN-20]
+	_ = x[LayerLpPool-21]
+	_ = x[LayerCausalConv-22]
+	_ = x[layerTypeEnd-23]
 }
 
-const _LayerType_name = "inputrelusigmoidtanhdropoutconvpoollrnsoftmaxregressionfcmaxoutsvm"
+const _LayerType_name = "inputrelusigmoidtanhdropoutconvpoollrnsoftmaxregressionfcmaxoutsvmsppdeformconvembeddingswishbatchnormmixoutfpnlppoolcausalconvlayerTypeEnd"
 
-var _LayerType_index = [...]uint8{0, 5, 9, 16, 20, 27, 31, 35, 38, 45, 55, 57, 63, 66}
+var _LayerType_index = [...]uint8{0, 5, 9, 16, 20, 27, 31, 35, 38, 45, 55, 57, 63, 66, 69, 79, 88, 93, 102, 108, 111, 117, 127, 139}
 
 func (i LayerType) String() string {
 	i -= 1
@@ -0,0 +1,61 @@
+package convnet
+
+import (
+	"errors"
+	"math"
+)
+
+// LearningRateFinder runs the learning rate range test (Smith 2017) on a
+// copy of net: numSteps steps of vanilla SGD on the examples from loader,
+// with the learning rate increasing exponentially from minLR to maxLR. It
+// returns the learning rate used at every step and the corresponding
+// training loss, smoothed over the last 10 steps. net itself is not modified.
+//
+// A good learning rate is usually a bit below the point where the smoothed
+// loss stops decreasing.
+func LearningRateFinder(net *Net, loader DataLoader, minLR, maxLR float64, numSteps int) ([]float64, []float64, error) {
+	if numSteps <= 0 {
+		return nil, nil, errors.New("convnet: LearningRateFinder requires a positive number of steps")
+	}
+	if minLR <= 0 || maxLR <= minLR {
+		return nil, nil, errors.New("convnet: LearningRateFinder requires 0 < minLR < maxLR")
+	}
+	if loader.Len() == 0 {
+		return nil, nil, errors.New("convnet: LearningRateFinder requires at least one example")
+	}
+
+	trainer := NewTrainer(net.Clone(), TrainerOptions{
+		LearningRate: minLR,
+		BatchSize:    1,
+		Method:       MethodSGD,
+	})
+
+	const window = 10
+
+	lrs := make([]float64, numSteps)
+	losses := make([]float64, numSteps)
+	recent := make([]float64, 0, window)
+	sum := 0.0
+
+	for step := 0; step < numSteps; step++ {
+		lr := minLR * math.Pow(maxLR/minLR, float64(step)/float64(numSteps))
+		trainer.LearningRate = lr
+
+		x, y := loader.Example(step % loader.Len())
+		loss := trainer.Train(x, y).Loss
+
+		// moving average over the last few steps
+		if len(recent) < window {
+			recent = append(recent, loss)
+		} else {
+			sum -= recent[step%window]
+			recent[step%window] = loss
+		}
+		sum += loss
+
+		lrs[step] = lr
+		losses[step] = sum / float64(len(recent))
+	}
+
+	return lrs, losses, nil
+}
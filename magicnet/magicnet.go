@@ -0,0 +1,442 @@
+// Package magicnet searches for a good network for a classification
+// problem automatically. Given data, a list of Vols, and labels, which are
+// class indices 0..K-1, a MagicNet:
+//   - creates data folds for cross-validation
+//   - samples candidate networks and training hyperparameters
+//   - evaluates the candidates on all data folds
+//   - produces predictions by model-averaging the best networks
+package magicnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/cnnutil"
+)
+
+// Options controls the search. Decay and learning rate ranges are given as
+// powers of ten.
+type Options struct {
+	TrainRatio    float64 // fraction of the data used for training in each fold
+	NumFolds      int
+	NumCandidates int // number of candidates evaluated at the same time
+	// NumEpochs is the number of passes over the training data of each
+	// fold. Higher values mean more accurate results, but take longer.
+	NumEpochs int
+	// EnsembleSize is the number of best candidates averaged together
+	// for predictions. More is usually better.
+	EnsembleSize int
+
+	// candidate parameters
+	BatchSizeMin    int
+	BatchSizeMax    int
+	L2DecayMin      float64
+	L2DecayMax      float64
+	LearningRateMin float64
+	LearningRateMax float64
+	MomentumMin     float64
+	MomentumMax     float64
+	NeuronsMin      int
+	NeuronsMax      int
+
+	// Rand is used for every random choice, including the initial
+	// weights of the candidates. If it is nil, a fixed seed is used.
+	Rand *rand.Rand
+}
+
+var DefaultOptions = Options{
+	TrainRatio:    0.7,
+	NumFolds:      10,
+	NumCandidates: 50,
+	NumEpochs:     50,
+	EnsembleSize:  10,
+
+	BatchSizeMin:    10,
+	BatchSizeMax:    300,
+	L2DecayMin:      -4,
+	L2DecayMax:      2,
+	LearningRateMin: -4,
+	LearningRateMax: 0,
+	MomentumMin:     0.9,
+	MomentumMax:     0.9,
+	NeuronsMin:      5,
+	NeuronsMax:      30,
+}
+
+// Candidate is a network architecture and set of training hyperparameters
+// being evaluated.
+type Candidate struct {
+	LayerDefs      []convnet.LayerDef
+	TrainerOptions convnet.TrainerOptions
+
+	// Net is the network trained on the most recent fold.
+	Net     *convnet.Net
+	trainer *convnet.Trainer
+
+	// Accuracy holds the validation accuracy of each finished fold.
+	Accuracy *cnnutil.Window
+}
+
+// MeanAccuracy returns the average validation accuracy over the finished
+// folds, or -1 if no folds have finished.
+func (c *Candidate) MeanAccuracy() float64 {
+	if c.Accuracy == nil {
+		return -1
+	}
+
+	return c.Accuracy.Average()
+}
+
+type fold struct {
+	train []int
+	test  []int
+}
+
+type MagicNet struct {
+	Options
+
+	data       []*convnet.Vol
+	labels     []int
+	numClasses int
+
+	folds      []fold
+	candidates []*Candidate
+	evaluated  []*Candidate // sorted by accuracy, best first
+
+	iter    int // iteration counter, goes from 0 to NumEpochs * len(training data)
+	foldIdx int // index of the active fold
+
+	// OnFinishFold is called when a fold is finished, while evaluating a
+	// batch of candidates.
+	OnFinishFold func()
+	// OnFinishBatch is called when a batch of candidates has finished
+	// evaluating on every fold.
+	OnFinishBatch func()
+}
+
+// New creates a MagicNet for data, where labels[i] is the class of
+// data[i]. Every Vol in data must have the same size.
+func New(data []*convnet.Vol, labels []int, opts Options) (*MagicNet, error) {
+	if len(data) != len(labels) {
+		return nil, errors.New("magicnet: needs one label for each example")
+	}
+	if len(data) < 2 {
+		return nil, errors.New("magicnet: needs at least two examples")
+	}
+	if opts.NumFolds <= 0 || opts.NumCandidates <= 0 || opts.NumEpochs <= 0 || opts.EnsembleSize <= 0 {
+		return nil, errors.New("magicnet: needs a positive number of folds, candidates, epochs, and ensemble members")
+	}
+
+	if opts.Rand == nil {
+		opts.Rand = rand.New(rand.NewSource(0))
+	}
+
+	m := &MagicNet{
+		Options: opts,
+		data:    data,
+		labels:  labels,
+	}
+
+	for _, l := range labels {
+		if l < 0 {
+			return nil, errors.New("magicnet: labels must not be negative")
+		}
+		if l >= m.numClasses {
+			m.numClasses = l + 1
+		}
+	}
+
+	m.sampleFolds()
+	m.sampleCandidates()
+
+	return m, nil
+}
+
+// sets m.folds to a sampling of NumFolds folds
+func (m *MagicNet) sampleFolds() {
+	n := len(m.data)
+
+	numTrain := int(math.Floor(m.TrainRatio * float64(n)))
+	if numTrain < 1 {
+		numTrain = 1
+	}
+	if numTrain >= n {
+		numTrain = n - 1
+	}
+
+	m.folds = make([]fold, m.NumFolds)
+	for i := range m.folds {
+		p := m.Rand.Perm(n)
+		m.folds[i] = fold{train: p[:numTrain], test: p[numTrain:]}
+	}
+}
+
+// returns a random integer in [min, max)
+func (m *MagicNet) randi(min, max int) int {
+	if max <= min {
+		return min
+	}
+
+	return min + m.Rand.Intn(max-min)
+}
+
+// returns a random number in [min, max)
+func (m *MagicNet) randf(min, max float64) float64 {
+	return min + m.Rand.Float64()*(max-min)
+}
+
+// returns a random candidate network
+func (m *MagicNet) sampleCandidate() *Candidate {
+	in := m.data[0]
+
+	// sample network topology
+	defs := []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: in.Sx, OutSy: in.Sy, OutDepth: in.Depth},
+	}
+
+	// prefer nets with 1 or 2 hidden layers
+	numHidden := 0
+	for p, cum := m.Rand.Float64(), 0.0; numHidden < 3; numHidden++ {
+		cum += []float64{0.2, 0.3, 0.3, 0.2}[numHidden]
+		if p < cum {
+			break
+		}
+	}
+
+	for i := 0; i < numHidden; i++ {
+		def := convnet.LayerDef{
+			Type:       convnet.LayerFC,
+			NumNeurons: m.randi(m.NeuronsMin, m.NeuronsMax),
+			Activation: []convnet.LayerType{convnet.LayerTanh, convnet.LayerMaxout, convnet.LayerRelu}[m.randi(0, 3)],
+		}
+
+		if def.Activation == convnet.LayerMaxout && def.NumNeurons%2 != 0 {
+			// maxout combines pairs of neurons
+			def.NumNeurons++
+		}
+
+		if m.Rand.Float64() < 0.5 {
+			def.DropProb = m.Rand.Float64()
+		}
+
+		defs = append(defs, def)
+	}
+
+	defs = append(defs, convnet.LayerDef{Type: convnet.LayerSoftmax, NumClasses: m.numClasses})
+
+	// sample training hyperparameters
+	opts := convnet.DefaultTrainerOptions
+	opts.BatchSize = m.randi(m.BatchSizeMin, m.BatchSizeMax)
+	opts.L2Decay = math.Pow(10, m.randf(m.L2DecayMin, m.L2DecayMax))
+
+	switch tp := m.Rand.Float64(); {
+	case tp < 0.33:
+		opts.Method = convnet.MethodADADelta
+	case tp < 0.66:
+		opts.Method = convnet.MethodADAGrad
+		opts.LearningRate = math.Pow(10, m.randf(m.LearningRateMin, m.LearningRateMax))
+	default:
+		opts.Method = convnet.MethodSGD
+		opts.LearningRate = math.Pow(10, m.randf(m.LearningRateMin, m.LearningRateMax))
+		opts.Momentum = m.randf(m.MomentumMin, m.MomentumMax)
+	}
+
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+
+	c := &Candidate{
+		LayerDefs:      defs,
+		TrainerOptions: opts,
+		Accuracy:       cnnutil.NewWindow(m.NumFolds, 1),
+	}
+	m.resetCandidate(c)
+
+	return c
+}
+
+// gives c a freshly initialized net and trainer
+func (m *MagicNet) resetCandidate(c *Candidate) {
+	c.Net = &convnet.Net{}
+	c.Net.MakeLayers(c.LayerDefs, m.Rand)
+	c.trainer = convnet.NewTrainer(c.Net, c.TrainerOptions)
+}
+
+// sets m.candidates to NumCandidates new candidate nets
+func (m *MagicNet) sampleCandidates() {
+	m.candidates = make([]*Candidate, m.NumCandidates)
+	for i := range m.candidates {
+		m.candidates[i] = m.sampleCandidate()
+	}
+}
+
+// Step trains every current candidate on one random example from the
+// training data of the active fold. At the end of a fold, the candidates
+// are evaluated on its validation data and start over on the next fold.
+// After the last fold, they are ranked with the previously evaluated
+// candidates and a new batch of candidates is sampled.
+func (m *MagicNet) Step() {
+	m.iter++
+
+	// step all candidates on a random data point
+	f := m.folds[m.foldIdx]
+	i := f.train[m.randi(0, len(f.train))]
+	for _, c := range m.candidates {
+		c.trainer.Train(m.data[i], convnet.LossData{Dim: m.labels[i]})
+	}
+
+	// process consequences: sample new folds, or candidates
+	if m.iter < m.NumEpochs*len(f.train) {
+		return
+	}
+
+	// finished evaluation of this fold. Get final validation accuracies,
+	// record them, and go on to the next fold.
+	for _, c := range m.candidates {
+		c.Accuracy.Add(m.validationAccuracy(c.Net, f))
+	}
+
+	m.iter = 0
+	m.foldIdx++
+
+	if m.OnFinishFold != nil {
+		m.OnFinishFold()
+	}
+
+	if m.foldIdx < len(m.folds) {
+		// we will go on to another fold, so start the candidates over
+		for _, c := range m.candidates {
+			m.resetCandidate(c)
+		}
+
+		return
+	}
+
+	// we finished all folds as well! Record these candidates and sample
+	// new ones to evaluate.
+	m.evaluated = append(m.evaluated, m.candidates...)
+	sort.SliceStable(m.evaluated, func(i, j int) bool {
+		return m.evaluated[i].MeanAccuracy() > m.evaluated[j].MeanAccuracy()
+	})
+
+	// only keep the top few, so that memory use doesn't grow without
+	// bound if the search runs for a long time
+	if len(m.evaluated) > 3*m.EnsembleSize {
+		m.evaluated = m.evaluated[:3*m.EnsembleSize]
+	}
+
+	if m.OnFinishBatch != nil {
+		m.OnFinishBatch()
+	}
+
+	m.sampleCandidates()
+	m.foldIdx = 0
+}
+
+// Train calls Step until ctx is done, and then returns ctx.Err().
+func (m *MagicNet) Train(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			m.Step()
+		}
+	}
+}
+
+// returns the 0-1 accuracy of net on the validation data of f
+func (m *MagicNet) validationAccuracy(net *convnet.Net, f fold) float64 {
+	correct := 0
+	for _, i := range f.test {
+		net.Forward(m.data[i], false)
+		if net.Prediction() == m.labels[i] {
+			correct++
+		}
+	}
+
+	return float64(correct) / float64(len(f.test))
+}
+
+// Candidates returns the candidates currently being evaluated.
+func (m *MagicNet) Candidates() []*Candidate { return m.candidates }
+
+// Evaluated returns the candidates that have been evaluated on every
+// fold, best first.
+func (m *MagicNet) Evaluated() []*Candidate { return m.evaluated }
+
+// Ensemble returns an ensemble of the best EnsembleSize evaluated
+// candidates. If no candidates have been evaluated yet, the current
+// candidates are used instead.
+func (m *MagicNet) Ensemble() *convnet.Ensemble {
+	candidates := m.evaluated
+	if len(candidates) == 0 {
+		// the first batch of nets hasn't been evaluated yet, so just
+		// predict with the current candidates
+		candidates = m.candidates
+	} else if len(candidates) > m.EnsembleSize {
+		candidates = candidates[:m.EnsembleSize]
+	}
+
+	nets := make([]*convnet.Net, len(candidates))
+	for i, c := range candidates {
+		nets[i] = c.Net
+	}
+
+	return &convnet.Ensemble{Nets: nets}
+}
+
+// PredictSoftly returns the class probabilities for v, averaged over the
+// nets returned by Ensemble.
+func (m *MagicNet) PredictSoftly(v *convnet.Vol) *convnet.Vol {
+	return m.Ensemble().Forward(v)
+}
+
+// Prediction returns the most likely class for v according to
+// PredictSoftly.
+func (m *MagicNet) Prediction(v *convnet.Vol) int {
+	e := m.Ensemble()
+	e.Forward(v)
+
+	return e.Prediction()
+}
+
+// MarshalJSON encodes the nets of the best EnsembleSize evaluated
+// candidates.
+func (m *MagicNet) MarshalJSON() ([]byte, error) {
+	nets := make([]*convnet.Net, 0, m.EnsembleSize)
+	for i := 0; i < len(m.evaluated) && i < m.EnsembleSize; i++ {
+		nets = append(nets, m.evaluated[i].Net)
+	}
+
+	return json.Marshal(&struct {
+		Nets []*convnet.Net `json:"nets"`
+	}{
+		Nets: nets,
+	})
+}
+
+// UnmarshalJSON loads nets saved by MarshalJSON as the evaluated
+// candidates, so that the MagicNet can be used for predictions.
+func (m *MagicNet) UnmarshalJSON(b []byte) error {
+	var data struct {
+		Nets []*convnet.Net `json:"nets"`
+	}
+
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	m.EnsembleSize = len(data.Nets)
+	m.evaluated = make([]*Candidate, len(data.Nets))
+	for i, n := range data.Nets {
+		m.evaluated[i] = &Candidate{Net: n}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package magicnet_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/magicnet"
+)
+
+// two noisy clusters in 2D, one per class
+func syntheticData(n int, r *rand.Rand) ([]*convnet.Vol, []int) {
+	data := make([]*convnet.Vol, n)
+	labels := make([]int, n)
+	for i := range data {
+		labels[i] = i % 2
+
+		cx := -1.0
+		if labels[i] == 1 {
+			cx = 1.0
+		}
+
+		data[i] = convnet.NewVol1D([]float64{cx + r.NormFloat64()*0.3, r.NormFloat64() * 0.3})
+	}
+
+	return data, labels
+}
+
+func accuracy(m *magicnet.MagicNet, data []*convnet.Vol, labels []int) float64 {
+	correct := 0
+	for i, v := range data {
+		if m.Prediction(v) == labels[i] {
+			correct++
+		}
+	}
+
+	return float64(correct) / float64(len(data))
+}
+
+func TestMagicNet(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	data, labels := syntheticData(60, r)
+
+	opts := magicnet.DefaultOptions
+	opts.NumFolds = 2
+	opts.NumCandidates = 4
+	opts.NumEpochs = 5
+	opts.EnsembleSize = 3
+	opts.BatchSizeMin = 1
+	opts.BatchSizeMax = 5
+	opts.L2DecayMin = -5
+	opts.L2DecayMax = -3
+	opts.LearningRateMin = -2
+	opts.LearningRateMax = -1
+	opts.Rand = r
+
+	m, err := magicnet.New(data, labels, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folds, batches := 0, 0
+	m.OnFinishFold = func() { folds++ }
+	m.OnFinishBatch = func() { batches++ }
+
+	for batches == 0 {
+		m.Step()
+	}
+
+	if folds != 2 {
+		t.Errorf("expected 2 folds to finish, but %d did", folds)
+	}
+	if len(m.Evaluated()) != 4 {
+		t.Fatalf("expected 4 evaluated candidates, but there are %d", len(m.Evaluated()))
+	}
+	for i, c := range m.Evaluated()[1:] {
+		if c.MeanAccuracy() > m.Evaluated()[i].MeanAccuracy() {
+			t.Error("expected evaluated candidates to be sorted by accuracy")
+		}
+	}
+
+	test, testLabels := syntheticData(100, r)
+	acc := accuracy(m, test, testLabels)
+	if acc < 0.9 {
+		t.Errorf("expected at least 90%% accuracy, but got %f", acc)
+	}
+
+	if p := m.PredictSoftly(test[0]); len(p.W) != 2 {
+		t.Errorf("expected 2 class probabilities, but got %d", len(p.W))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Train(ctx); err != context.Canceled {
+		t.Errorf("expected Train to stop with context.Canceled, but got %v", err)
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded magicnet.MagicNet
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loadedAcc := accuracy(&loaded, test, testLabels); loadedAcc != acc {
+		t.Errorf("expected the same accuracy after a JSON round trip, but got %f and %f", acc, loadedAcc)
+	}
+}
@@ -0,0 +1,67 @@
+package convnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// MCDropoutForward runs numSamples forward passes with dropout enabled and
+// returns the output of each pass, for estimating the uncertainty of a
+// prediction (Gal and Ghahramani 2016). Only the dropout layers run in
+// training mode; the others, such as batch norm and mixout, run in
+// prediction mode and are left unchanged. If r is not nil, it is used as
+// the random source of every dropout layer for the duration of the call.
+func (n *Net) MCDropoutForward(v *Vol, numSamples int, r *rand.Rand) ([]*Vol, error) {
+	if numSamples <= 0 {
+		return nil, errors.New("convnet: number of samples must be positive")
+	}
+
+	var dropouts []*DropoutLayer
+	for i, l := range n.Layers {
+		if d, ok := l.(*DropoutLayer); ok {
+			if r == nil && d.rand == nil {
+				return nil, fmt.Errorf("convnet: dropout layer %d has no random source", i)
+			}
+
+			dropouts = append(dropouts, d)
+		}
+	}
+
+	if len(dropouts) == 0 {
+		return nil, errors.New("convnet: net has no dropout layers")
+	}
+
+	if r != nil {
+		old := make([]*rand.Rand, len(dropouts))
+		for i, d := range dropouts {
+			old[i] = d.rand
+			d.SetRand(r)
+		}
+
+		defer func() {
+			for i, d := range dropouts {
+				d.SetRand(old[i])
+			}
+		}()
+	}
+
+	n.checkpoints = nil
+
+	samples := make([]*Vol, numSamples)
+	for s := range samples {
+		act := v
+		for i, l := range n.Layers {
+			if i == 0 && n.compiled {
+				continue
+			}
+
+			_, isDropout := l.(*DropoutLayer)
+			act = n.forward(i, act, isDropout)
+		}
+
+		samples[s] = act
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,56 @@
+// Package models contains ready-made network architectures that are
+// useful as baselines for benchmarking, or as a starting point to copy and
+// adapt.
+package models
+
+import (
+	"math/rand"
+
+	"github.com/BenLubar/convnet"
+)
+
+// CIFARLayerDefs returns the layer definitions used by NewCIFARNet.
+func CIFARLayerDefs() []convnet.LayerDef {
+	return []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 32, OutSy: 32, OutDepth: 3},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 16, Stride: 1, Pad: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 20, Stride: 1, Pad: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerFC, NumNeurons: 64, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 10},
+	}
+}
+
+// NewCIFARNet creates a LeNet-like network for 32x32x3 images in 10
+// classes, such as CIFAR-10: two convolution and pooling blocks followed
+// by a hidden fully connected layer and a softmax classifier (which adds
+// a second fully connected layer of its own).
+func NewCIFARNet(r *rand.Rand) *convnet.Net {
+	net := &convnet.Net{}
+	net.MakeLayers(CIFARLayerDefs(), r)
+
+	return net
+}
+
+// MNISTLayerDefs returns the layer definitions used by NewMNISTNet.
+func MNISTLayerDefs() []convnet.LayerDef {
+	return []convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 28, OutSy: 28, OutDepth: 1},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 8, Stride: 1, Pad: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerConv, Sx: 5, Filters: 16, Stride: 1, Pad: 2, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 3, Stride: 3},
+		{Type: convnet.LayerFC, NumNeurons: 64, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerSoftmax, NumClasses: 10},
+	}
+}
+
+// NewMNISTNet creates a LeNet-like network for 28x28x1 images of digits,
+// such as MNIST, with the same structure as NewCIFARNet.
+func NewMNISTNet(r *rand.Rand) *convnet.Net {
+	net := &convnet.Net{}
+	net.MakeLayers(MNISTLayerDefs(), r)
+
+	return net
+}
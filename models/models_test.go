@@ -0,0 +1,45 @@
+package models_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+	"github.com/BenLubar/convnet/models"
+)
+
+func testModel(t *testing.T, defs []convnet.LayerDef, net *convnet.Net, sx, sy, depth int) {
+	t.Helper()
+
+	if err := convnet.ValidateDefs(defs); err != nil {
+		t.Fatal(err)
+	}
+	if err := net.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	x := convnet.NewVolRand(sx, sy, depth, r)
+
+	if out := net.Forward(x, false); len(out.W) != 10 {
+		t.Fatalf("expected 10 class probabilities, but got %d", len(out.W))
+	}
+
+	// a few steps on a single example should make it more likely
+	trainer := convnet.NewTrainer(net, convnet.DefaultTrainerOptions)
+	before := net.CostLoss(x, convnet.LossData{Dim: 3})
+	for i := 0; i < 5; i++ {
+		trainer.Train(x, convnet.LossData{Dim: 3})
+	}
+	if after := net.CostLoss(x, convnet.LossData{Dim: 3}); after >= before {
+		t.Errorf("expected loss to decrease, but it went from %f to %f", before, after)
+	}
+}
+
+func TestCIFARNet(t *testing.T) {
+	testModel(t, models.CIFARLayerDefs(), models.NewCIFARNet(rand.New(rand.NewSource(0))), 32, 32, 3)
+}
+
+func TestMNISTNet(t *testing.T) {
+	testModel(t, models.MNISTLayerDefs(), models.NewMNISTNet(rand.New(rand.NewSource(0))), 28, 28, 1)
+}
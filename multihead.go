@@ -0,0 +1,186 @@
+package convnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+// MultiHeadNet is a network with a shared trunk and several heads, each
+// of which ends in its own loss layer, for multi-task learning. Every
+// head takes the output of the last trunk layer as its input.
+type MultiHeadNet struct {
+	Trunk []Layer   `json:"trunk"` // starts with an input layer
+	Heads [][]Layer `json:"heads"` // each ends with a loss layer
+
+	// Weights scales the loss of each head. If it is nil, every head has
+	// a weight of 1.
+	Weights []float64 `json:"weights"`
+}
+
+// MakeLayers creates the trunk from trunk, which must start with an input
+// layer, and one head from each element of heads, which must each end
+// with a loss layer.
+func (m *MultiHeadNet) MakeLayers(trunk []LayerDef, heads [][]LayerDef, r *rand.Rand) {
+	if len(trunk) < 1 || trunk[0].Type != LayerInput {
+		panic("convnet: first layer must be the input layer, to declare size of inputs")
+	}
+	if len(heads) < 1 {
+		panic("convnet: at least one head is required")
+	}
+
+	m.Trunk = makeLayers(desugar(trunk), nil, r)
+	last := m.Trunk[len(m.Trunk)-1]
+
+	m.Heads = make([][]Layer, len(heads))
+	for i, defs := range heads {
+		m.Heads[i] = makeLayers(desugar(defs), last, r)
+
+		if len(m.Heads[i]) == 0 {
+			panic(fmt.Sprintf("convnet: head %d is empty", i))
+		}
+		if _, ok := m.Heads[i][len(m.Heads[i])-1].(LossLayer); !ok {
+			panic(fmt.Sprintf("convnet: last layer of head %d must be a loss layer", i))
+		}
+	}
+}
+
+func (m *MultiHeadNet) weight(i int) float64 {
+	if m.Weights == nil {
+		return 1
+	}
+
+	return m.Weights[i]
+}
+
+// Forward runs the trunk and then every head, and returns the output of
+// each head.
+func (m *MultiHeadNet) Forward(v *Vol, isTraining bool) []*Vol {
+	act := v
+	for _, l := range m.Trunk {
+		act = l.Forward(act, isTraining)
+	}
+
+	outs := make([]*Vol, len(m.Heads))
+	for i, head := range m.Heads {
+		hact := act
+		for _, l := range head {
+			hact = l.Forward(hact, isTraining)
+		}
+
+		outs[i] = hact
+	}
+
+	return outs
+}
+
+// Backward computes the gradient of the weighted sum of the head losses,
+// with ys[i] as the target of head i. The gradients from every head are
+// added together where they reach the trunk. It returns the weighted total
+// loss and the unweighted loss of each head.
+func (m *MultiHeadNet) Backward(ys []LossData) (float64, []float64) {
+	if len(ys) != len(m.Heads) {
+		panic(fmt.Sprintf("convnet: %d targets given for %d heads", len(ys), len(m.Heads)))
+	}
+
+	trunkOut := m.Trunk[len(m.Trunk)-1].Output()
+	trunkGrad := make([]float64, len(trunkOut.W))
+
+	total := 0.0
+	losses := make([]float64, len(m.Heads))
+
+	for i, head := range m.Heads {
+		w := m.weight(i)
+
+		losses[i] = head[len(head)-1].(LossLayer).BackwardLoss(ys[i])
+		total += w * losses[i]
+
+		// scale the gradient at the input of the loss layer, so that the
+		// head's own parameters see the weighted loss too
+		lossIn := trunkOut
+		if len(head) > 1 {
+			lossIn = head[len(head)-2].Output()
+		}
+		for j := range lossIn.Dw {
+			lossIn.Dw[j] *= w
+		}
+
+		for j := len(head) - 2; j >= 0; j-- {
+			head[j].Backward()
+		}
+
+		// each head overwrites the gradient of the trunk output, so
+		// collect them as we go
+		for j, g := range trunkOut.Dw {
+			trunkGrad[j] += g
+		}
+	}
+
+	trunkOut.Dw = trunkGrad
+
+	for i := len(m.Trunk) - 1; i >= 0; i-- {
+		m.Trunk[i].Backward()
+	}
+
+	return total, losses
+}
+
+// ParamsAndGrads returns the parameters of the trunk followed by those of
+// each head in order.
+func (m *MultiHeadNet) ParamsAndGrads() []ParamsAndGrads {
+	var response []ParamsAndGrads
+
+	for _, l := range m.Trunk {
+		response = append(response, l.ParamsAndGrads()...)
+	}
+
+	for _, head := range m.Heads {
+		for _, l := range head {
+			response = append(response, l.ParamsAndGrads()...)
+		}
+	}
+
+	return response
+}
+
+func (m *MultiHeadNet) UnmarshalJSON(b []byte) error {
+	var rawData struct {
+		Trunk   []json.RawMessage   `json:"trunk"`
+		Heads   [][]json.RawMessage `json:"heads"`
+		Weights []float64           `json:"weights"`
+	}
+
+	if err := json.Unmarshal(b, &rawData); err != nil {
+		return err
+	}
+
+	trunk, err := unmarshalLayers(rawData.Trunk)
+	if err != nil {
+		return err
+	}
+
+	heads := make([][]Layer, len(rawData.Heads))
+	for i, raw := range rawData.Heads {
+		if heads[i], err = unmarshalLayers(raw); err != nil {
+			return err
+		}
+	}
+
+	m.Trunk = trunk
+	m.Heads = heads
+	m.Weights = rawData.Weights
+
+	return nil
+}
+
+// TrainMulti is like Train, but for a MultiHeadNet with a target for each
+// head. The trainer's Net is not used. CostLoss in the result is the
+// weighted sum of the head losses, which are also returned individually.
+func (t *Trainer) TrainMulti(m *MultiHeadNet, x *Vol, ys []LossData) (TrainingResult, []float64) {
+	m.Forward(x, true)
+
+	costLoss, losses := m.Backward(ys)
+	l1DecayLoss, l2DecayLoss := t.step(m, 1)
+
+	return t.result(costLoss, l1DecayLoss, l2DecayLoss), losses
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sync"
 )
 
 type LayerType int
@@ -24,8 +25,83 @@ const (
 	LayerFC                              // fc
 	LayerMaxout                          // maxout
 	LayerSVM                             // svm
+	LayerSPP                             // spp
+	LayerDeformConv                      // deformconv
+	LayerEmbedding                       // embedding
+	LayerSwish                           // swish
+	LayerBatchNorm                       // batchnorm
+	LayerMixout                          // mixout
+	LayerFPN                             // fpn
+	LayerLpPool                          // lppool
+	LayerCausalConv                      // causalconv
+
+	// layerTypeEnd is not a layer type. New layer types go before it, so
+	// that every layer type is less than it.
+	layerTypeEnd
 )
 
+// LayerSiLU is another name for LayerSwish. SiLU (sigmoid linear unit)
+// and Swish are the same function.
+const LayerSiLU = LayerSwish
+
+// MarshalJSON encodes a layer type as its name, such as "fc". The zero
+// LayerType, which means no layer (for example, no Activation), is encoded
+// as an empty string.
+func (t LayerType) MarshalJSON() ([]byte, error) {
+	if t == 0 {
+		return json.Marshal("")
+	}
+
+	if t < LayerInput || t >= layerTypeEnd {
+		return nil, fmt.Errorf("convnet: cannot encode unknown layer type %d", int(t))
+	}
+
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON decodes a layer type from its name, as written by
+// MarshalJSON. "silu" is accepted for LayerSwish, and a number is accepted
+// for JSON written before layer types were encoded as names.
+func (t *LayerType) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var n int
+		if json.Unmarshal(b, &n) != nil {
+			return err
+		}
+
+		*t = LayerType(n)
+
+		return nil
+	}
+
+	if name == "" {
+		*t = 0
+
+		return nil
+	}
+
+	if name == "silu" {
+		*t = LayerSwish
+
+		return nil
+	}
+
+	for lt := LayerInput; lt < layerTypeEnd; lt++ {
+		if lt.String() == name {
+			*t = lt
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("convnet: unknown layer type %q", name)
+}
+
 type LayerDef struct {
 	Type           LayerType `json:"type"`
 	NumNeurons     int       `json:"num_neurons"`
@@ -60,8 +136,32 @@ type LayerDef struct {
 	N              int       `json:"n"`
 	Alpha          float64   `json:"alpha"`
 	Beta           float64   `json:"beta"`
+	BinSizes       []int     `json:"bin_sizes"`
+	Trainable      bool      `json:"trainable"`
+	TrainableZero  bool      `json:"-"`
+	NumEmbeddings  int       `json:"num_embeddings"`
+	EmbeddingDim   int       `json:"embedding_dim"`
+	Momentum       float64   `json:"momentum"`
+	MomentumZero   bool      `json:"-"`
+	MixProb        float64   `json:"mix_prob"`
+	MixProbZero    bool      `json:"-"`
+	LevelDepths    []int     `json:"level_depths"`
+	InitMethod     string    `json:"init_method"`
+	P              float64   `json:"p"`
+	Dilation       int       `json:"dilation"`
 }
 
+// weight initialization methods for LayerDef.InitMethod. An empty string
+// is the same as InitDefault. The method applies to the weights of conv,
+// deformable conv, causal conv, fc, and embedding layers, and to the fc
+// layer a loss layer is desugared to; other layers have no weights to
+// initialize and ignore it.
+const (
+	InitDefault = "default" // NewVolRand
+	InitKaiming = "kaiming" // NewVolKaiming
+	InitXavier  = "xavier"  // NewVolXavier
+)
+
 type Layer interface {
 	OutSx() int
 	OutSy() int
@@ -71,11 +171,35 @@ type Layer interface {
 	Backward()
 	ParamsAndGrads() []ParamsAndGrads
 
+	// Output returns the volume produced by the most recent call to
+	// Forward, or nil if Forward has not been called. The volume is owned
+	// by the layer and is only valid until the next call to Forward;
+	// callers that need to keep it should Clone it.
+	Output() *Vol
+
+	// GradOutput returns the gradient with respect to Output from the
+	// most recent call to Backward, as a new volume of the same shape, or
+	// nil if Forward has not been called.
+	GradOutput() *Vol
+
 	fromDef(LayerDef, *rand.Rand)
+	shareWeights() Layer // shallow copy that aliases the parameters
 	json.Marshaler
 	json.Unmarshaler
 }
 
+// copies the gradient held by out into a volume of its own
+func gradOutput(out *Vol) *Vol {
+	if out == nil || len(out.Dw) != len(out.W) {
+		return nil
+	}
+
+	g := NewVol(out.Sx, out.Sy, out.Depth, 0.0)
+	copy(g.W, out.Dw)
+
+	return g
+}
+
 type LossData struct {
 	Dim int
 	Val float64
@@ -91,12 +215,42 @@ type ParamsAndGrads struct {
 	Grads      []float64
 	L1DecayMul float64
 	L2DecayMul float64
+	Frozen     bool // the trainer leaves frozen parameters unchanged
+}
+
+// TrainableLayer is implemented by layers with parameters that can be
+// frozen, so that training leaves them unchanged while the rest of the
+// network learns.
+type TrainableLayer interface {
+	Layer
+	Trainable() bool
+	SetTrainable(trainable bool)
 }
 
 // Net manages a set of layers
 // For now constraints: Simple linear order of layers, first layer input last layer a cost layer
 type Net struct {
 	Layers []Layer `json:"layers"`
+
+	// when CheckpointEvery is positive, training passes only keep the
+	// activations at the boundaries of each block of CheckpointEvery
+	// layers, and the activations inside each block are recomputed during
+	// Backward. This trades extra computation for less memory in deep nets.
+	CheckpointEvery int `json:"-"`
+
+	checkpoints []*Vol // block boundaries from the last checkpointed Forward
+
+	predictors sync.Pool // ShareWeightsClones used by Predict
+	sharedFrom []Layer   // the layers a ShareWeightsClone was made from
+
+	profile []LayerTiming // nil unless profiling is enabled
+
+	compiled bool // set by Compile; the net cannot be trained
+
+	randSource *RandSource // set by SetRandSource
+
+	forwardHooks  [][]ForwardHook // indexed like Layers; see RegisterForwardHook
+	backwardHooks [][]BackwardHook
 }
 
 // desugar layer_defs for adding activation, dropout layers etc
@@ -106,16 +260,16 @@ func desugar(defs []LayerDef) []LayerDef {
 		if def.Type == LayerSoftmax || def.Type == LayerSVM {
 			// add an fc layer here, there is no reason the user should
 			// have to worry about this and we almost always want to
-			newDefs = append(newDefs, LayerDef{Type: LayerFC, NumNeurons: def.NumClasses})
+			newDefs = append(newDefs, LayerDef{Type: LayerFC, NumNeurons: def.NumClasses, InitMethod: def.InitMethod})
 		}
 
 		if def.Type == LayerRegression {
 			// add an fc layer here, there is no reason the user should
 			// have to worry about this and we almost always want to
-			newDefs = append(newDefs, LayerDef{Type: LayerFC, NumNeurons: def.NumNeurons})
+			newDefs = append(newDefs, LayerDef{Type: LayerFC, NumNeurons: def.NumNeurons, InitMethod: def.InitMethod})
 		}
 
-		if (def.Type == LayerFC || def.Type == LayerConv) && def.BiasPref == 0 && !def.BiasPrefZero {
+		if (def.Type == LayerFC || def.Type == LayerConv || def.Type == LayerDeformConv || def.Type == LayerCausalConv) && def.BiasPref == 0 && !def.BiasPrefZero {
 			def.BiasPref = 0.0
 			def.BiasPrefZero = true
 
@@ -137,6 +291,8 @@ func desugar(defs []LayerDef) []LayerDef {
 				newDefs = append(newDefs, LayerDef{Type: LayerSigmoid})
 			case LayerTanh:
 				newDefs = append(newDefs, LayerDef{Type: LayerTanh})
+			case LayerSwish: // also LayerSiLU
+				newDefs = append(newDefs, LayerDef{Type: LayerSwish})
 			case LayerMaxout:
 				// create maxout activation, and pass along group size, if provided
 				gs := def.GroupSize
@@ -166,12 +322,18 @@ func (n *Net) MakeLayers(defs []LayerDef, r *rand.Rand) {
 	}
 
 	defs = desugar(defs)
+	n.Layers = makeLayers(defs, nil, r)
+	n.ResetProfile()
+}
 
-	// create the layers
-	n.Layers = make([]Layer, len(defs))
-	for i, def := range defs {
-		if i > 0 {
-			prev := n.Layers[i-1]
+// creates layer objects from desugared definitions. The first layer takes
+// its input from prev, if prev is not nil. The input sizes of defs are
+// filled in as they would be seen by each layer.
+func makeLayers(defs []LayerDef, prev Layer, r *rand.Rand) []Layer {
+	layers := make([]Layer, len(defs))
+	for i := range defs {
+		def := &defs[i]
+		if prev != nil {
 			def.InSx = prev.OutSx()
 			def.InSy = prev.OutSy()
 			def.InDepth = prev.OutDepth()
@@ -179,68 +341,214 @@ func (n *Net) MakeLayers(defs []LayerDef, r *rand.Rand) {
 
 		switch def.Type {
 		case LayerFC:
-			n.Layers[i] = &FullyConnLayer{}
+			layers[i] = &FullyConnLayer{}
 		case LayerLRN:
-			n.Layers[i] = &LocalResponseNormalizationLayer{}
+			layers[i] = &LocalResponseNormalizationLayer{}
 		case LayerDropout:
-			n.Layers[i] = &DropoutLayer{}
+			layers[i] = &DropoutLayer{}
 		case LayerInput:
-			n.Layers[i] = &InputLayer{}
+			layers[i] = &InputLayer{}
 		case LayerSoftmax:
-			n.Layers[i] = &SoftmaxLayer{}
+			layers[i] = &SoftmaxLayer{}
 		case LayerRegression:
-			n.Layers[i] = &RegressionLayer{}
+			layers[i] = &RegressionLayer{}
 		case LayerConv:
-			n.Layers[i] = &ConvLayer{}
+			layers[i] = &ConvLayer{}
 		case LayerPool:
-			n.Layers[i] = &PoolLayer{}
+			layers[i] = &PoolLayer{}
 		case LayerRelu:
-			n.Layers[i] = &ReluLayer{}
+			layers[i] = &ReluLayer{}
 		case LayerSigmoid:
-			n.Layers[i] = &SigmoidLayer{}
+			layers[i] = &SigmoidLayer{}
 		case LayerTanh:
-			n.Layers[i] = &TanhLayer{}
+			layers[i] = &TanhLayer{}
 		case LayerMaxout:
-			n.Layers[i] = &MaxoutLayer{}
+			layers[i] = &MaxoutLayer{}
 		case LayerSVM:
-			n.Layers[i] = &SVMLayer{}
+			layers[i] = &SVMLayer{}
+		case LayerSPP:
+			layers[i] = &SPPLayer{}
+		case LayerDeformConv:
+			layers[i] = &DeformConvLayer{}
+		case LayerEmbedding:
+			layers[i] = &EmbeddingLayer{}
+		case LayerSwish:
+			layers[i] = &SwishLayer{}
+		case LayerBatchNorm:
+			layers[i] = &BatchNormLayer{}
+		case LayerMixout:
+			layers[i] = &MixoutLayer{}
+		case LayerFPN:
+			layers[i] = &FPNLayer{}
+		case LayerLpPool:
+			layers[i] = &LpPoolLayer{}
+		case LayerCausalConv:
+			layers[i] = &CausalConvLayer{}
 		default:
 			panic("convnet: unrecognized layer type: " + def.Type.String())
 		}
 
-		n.Layers[i].fromDef(def, r)
+		layers[i].fromDef(*def, r)
+		prev = layers[i]
 	}
+
+	return layers
+}
+
+// definedLayer is implemented by layers with parameters. def returns a
+// definition that makes a layer like this one, with the same settings and
+// InitMethod, when given the same input. Everything def needs is kept in
+// the layer's JSON, so it works for nets loaded from JSON too.
+type definedLayer interface {
+	Layer
+	def() LayerDef
+}
+
+var (
+	_ definedLayer = (*ConvLayer)(nil)
+	_ definedLayer = (*FullyConnLayer)(nil)
+	_ definedLayer = (*DeformConvLayer)(nil)
+	_ definedLayer = (*CausalConvLayer)(nil)
+	_ definedLayer = (*EmbeddingLayer)(nil)
+	_ definedLayer = (*FPNLayer)(nil)
+	_ definedLayer = (*BatchNormLayer)(nil)
+)
+
+// ColdStart reinitializes the net by replacing every layer that has
+// parameters with a new one made from the same definition, with random
+// numbers from r, so the weights are drawn again with the same InitMethod
+// and the biases, batch norm statistics, and so on are reset. Layers
+// without parameters are kept, and the architecture is unchanged.
+//
+// Nets saved as JSON before the init method and bias of each layer were
+// kept are remade with the default initialization and zero biases. As with
+// ReplaceHead, trainers for the net should be recreated.
+func (n *Net) ColdStart(r *rand.Rand) {
+	for i := 1; i < len(n.Layers); i++ {
+		if l, ok := n.Layers[i].(definedLayer); ok {
+			n.Layers[i] = makeLayers([]LayerDef{l.def()}, n.Layers[i-1], r)[0]
+		}
+	}
+
+	n.checkpoints = nil
+	n.ResetProfile()
 }
 
 // forward prop the network.
 // The trainer class passes is_training = true, but when this function is
 // called from outside (not from the trainer), it defaults to prediction mode
 func (n *Net) Forward(v *Vol, isTraining bool) *Vol {
-	act := n.Layers[0].Forward(v, isTraining)
+	if isTraining && n.CheckpointEvery > 0 {
+		return n.forwardCheckpointed(v)
+	}
+
+	n.checkpoints = nil
+
+	act := v
+	if !n.compiled {
+		act = n.forward(0, v, isTraining)
+	}
 
 	for i := 1; i < len(n.Layers); i++ {
-		act = n.Layers[i].Forward(act, isTraining)
+		act = n.forward(i, act, isTraining)
 	}
 
 	return act
 }
 
+// ForwardChecked is like Forward, but first checks that v has the shape
+// declared by the input layer and returns an error if it does not.
+func (n *Net) ForwardChecked(v *Vol, isTraining bool) (*Vol, error) {
+	in, ok := n.Layers[0].(*InputLayer)
+	if !ok {
+		return nil, &LayerError{LayerIndex: 0, Type: layerTypeOf(n.Layers[0]), Reason: "first layer must be the input layer"}
+	}
+
+	if err := in.checkInput(v); err != nil {
+		return nil, err
+	}
+
+	return n.Forward(v, isTraining), nil
+}
+
 func (n *Net) CostLoss(v *Vol, y LossData) float64 {
 	n.Forward(v, false)
 
 	return n.Layers[len(n.Layers)-1].(LossLayer).BackwardLoss(y)
 }
 
+// Score runs the net forward in prediction mode once and returns both the
+// predicted class, as Prediction would, and the loss for the true class
+// y, as CostLoss would. The weights and their gradients are not changed.
+// Like Prediction, it panics unless the last layer is a softmax or svm
+// layer.
+func (n *Net) Score(v *Vol, y int) (prediction int, loss float64) {
+	n.Forward(v, false)
+
+	prediction = n.Prediction()
+	loss = n.Layers[len(n.Layers)-1].(LossLayer).BackwardLoss(LossData{Dim: y})
+
+	return prediction, loss
+}
+
 // backprop: compute gradients wrt all parameters
 func (n *Net) Backward(y LossData) float64 {
-	loss := n.Layers[len(n.Layers)-1].(LossLayer).BackwardLoss(y) // last layer assumed to be loss layer
+	loss := n.backwardLoss(y) // last layer assumed to be loss layer
+
+	n.backwardHidden()
+
+	return loss
+}
+
+// backprop through every layer before the loss layer, which must already
+// have filled in the gradient with respect to its input
+func (n *Net) backwardHidden() {
+	if n.compiled {
+		panic("convnet: cannot train a compiled net")
+	}
+
+	if n.checkpoints != nil {
+		n.backwardCheckpointed()
+
+		return
+	}
 
 	// first layer assumed input
 	for i := len(n.Layers) - 2; i >= 0; i-- {
-		n.Layers[i].Backward()
+		n.backward(i)
 	}
+}
 
-	return loss
+// Activations returns the output of every layer from the most recent
+// call to Forward. Like Layer.Output, the volumes are only valid until
+// the next call to Forward. After a checkpointed training pass, the
+// activations inside each checkpointed block are nil.
+func (n *Net) Activations() []*Vol {
+	acts := make([]*Vol, len(n.Layers))
+
+	for i, l := range n.Layers {
+		acts[i] = l.Output()
+	}
+
+	return acts
+}
+
+// ActivationAt returns the output of layer i from the most recent call
+// to Forward. See Activations for the lifetime of the returned volume.
+func (n *Net) ActivationAt(i int) *Vol {
+	return n.Layers[i].Output()
+}
+
+// GradientAt returns the gradient with respect to the output of layer i
+// from the most recent call to Backward, laid out like the output's W. It
+// is only meaningful once Backward has reached layer i after a Forward.
+func (n *Net) GradientAt(i int) []float64 {
+	out := n.Layers[i].Output()
+	if out == nil {
+		return nil
+	}
+
+	return out.Dw
 }
 
 // accumulate parameters and gradients for the entire network
@@ -254,15 +562,76 @@ func (n *Net) ParamsAndGrads() []ParamsAndGrads {
 	return response
 }
 
-// this is a convenience function for returning the argmax
-// prediction, assuming the last layer of the net is a softmax
-func (n *Net) Prediction() int {
-	s, ok := n.Layers[len(n.Layers)-1].(*SoftmaxLayer)
+// ZeroGrads sets the gradients of every parameter in the net to zero,
+// for training loops that accumulate gradients with Backward themselves
+// instead of using a Trainer.
+func (n *Net) ZeroGrads() {
+	for _, pg := range n.ParamsAndGrads() {
+		for j := range pg.Grads {
+			pg.Grads[j] = 0.0
+		}
+	}
+}
+
+// SetTrainable freezes or unfreezes the parameters of layer i. Frozen
+// layers still pass gradients back to the layers before them, but the
+// trainer does not update their parameters.
+func (n *Net) SetTrainable(i int, trainable bool) error {
+	if i < 0 || i >= len(n.Layers) {
+		return fmt.Errorf("convnet: layer index %d out of range", i)
+	}
+
+	l, ok := n.Layers[i].(TrainableLayer)
 	if !ok {
-		panic("convnet: Net.Prediction assumes softmax as the last layer of the net!")
+		return &LayerError{LayerIndex: i, Type: layerTypeOf(n.Layers[i]), Reason: "layer has no trainable parameters"}
+	}
+
+	l.SetTrainable(trainable)
+
+	return nil
+}
+
+// this is a convenience function for returning the argmax prediction
+// from the most recent call to Forward, assuming the last layer of the
+// net is a softmax or svm. It panics for any other kind of net; see
+// PredictClass for a version that returns an error instead.
+func (n *Net) Prediction() int {
+	if err := n.checkClassifier(false); err != nil {
+		panic("convnet: Net.Prediction assumes softmax or svm as the last layer of the net!")
+	}
+
+	out := n.Layers[len(n.Layers)-1].Output()
+	if out == nil {
+		panic("convnet: Net.Prediction called before Forward")
+	}
+
+	return argmax(out.W)
+}
+
+// returns an error unless the last layer of the net outputs one score per
+// class. Regression outputs only count if allowRegression is set.
+func (n *Net) checkClassifier(allowRegression bool) error {
+	last := len(n.Layers) - 1
+
+	switch n.Layers[last].(type) {
+	case *SoftmaxLayer, *SVMLayer:
+		return nil
+	case *RegressionLayer:
+		if allowRegression {
+			return nil
+		}
 	}
 
-	p := s.outAct.W
+	reason := "last layer must be a softmax or svm layer"
+	if allowRegression {
+		reason = "last layer must be a softmax, svm, or regression layer"
+	}
+
+	return &LayerError{LayerIndex: last, Type: layerTypeOf(n.Layers[last]), Reason: reason}
+}
+
+// returns the index of the class with highest class probability
+func argmax(p []float64) int {
 	maxv, maxi := p[0], 0
 
 	for i := 1; i < len(p); i++ {
@@ -271,8 +640,183 @@ func (n *Net) Prediction() int {
 		}
 	}
 
-	return maxi // return index of the class with highest class probability
+	return maxi
 }
+
+// PredictClass runs v through the net in prediction mode and returns the
+// index of the highest scoring class. Unlike Prediction, it does its own
+// forward pass, and it returns an error if the last layer of the net is
+// not a softmax or svm. Like Predict, it does not change the activations
+// of n.
+func (n *Net) PredictClass(v *Vol) (int, error) {
+	if err := n.checkClassifier(false); err != nil {
+		return 0, err
+	}
+
+	return argmax(n.Predict(v).W), nil
+}
+
+// PredictArgmax is like PredictClass, but also accepts a net that ends in
+// a regression layer, in which case it returns the index of the largest
+// output. This is useful when each output estimates the value of a
+// choice, as in Q-learning.
+func (n *Net) PredictArgmax(v *Vol) (int, error) {
+	if err := n.checkClassifier(true); err != nil {
+		return 0, err
+	}
+
+	return argmax(n.Predict(v).W), nil
+}
+
+// Regression runs v through the net in prediction mode and returns a copy
+// of the outputs of the regression layer at the end of the net. Like
+// Predict, it does not change the activations of n.
+func (n *Net) Regression(v *Vol) ([]float64, error) {
+	last := len(n.Layers) - 1
+	if _, ok := n.Layers[last].(*RegressionLayer); !ok {
+		return nil, &LayerError{LayerIndex: last, Type: layerTypeOf(n.Layers[last]), Reason: "last layer must be a regression layer"}
+	}
+
+	return append([]float64(nil), n.Predict(v).W...), nil
+}
+
+// Clone returns a deep copy of the net. The parameters are copied, but
+// gradients and activations are not. Dropout and mixout layers in the
+// copy share the random number generator of the original, and the copy
+// shares its RandSource.
+func (n *Net) Clone() *Net {
+	b, err := json.Marshal(n)
+	if err != nil {
+		panic("convnet: cannot clone net: " + err.Error())
+	}
+
+	clone := &Net{CheckpointEvery: n.CheckpointEvery, compiled: n.compiled, randSource: n.randSource}
+	if err := clone.UnmarshalJSON(b); err != nil {
+		panic("convnet: cannot clone net: " + err.Error())
+	}
+
+	for i, l := range n.Layers {
+		switch l := l.(type) {
+		case *DropoutLayer:
+			clone.Layers[i].(*DropoutLayer).rand = l.rand
+		case *MixoutLayer:
+			clone.Layers[i].(*MixoutLayer).rand = l.rand
+		}
+	}
+
+	return clone
+}
+
+// InferenceMode returns a deep copy of the net, like Clone, that is meant
+// only for making predictions. Each dropout layer in the copy passes its
+// input through without copying it, in training and prediction alike.
+//
+// In prediction mode, a dropout layer scales its input by the drop
+// probability, so the copy folds that scale into the weights of the next
+// fully connected or conv layer instead. Relu, maxout, pool, and other
+// dropout layers in between are looked through, since scaling by a
+// positive number commutes with them. A dropout layer with no such layer
+// after it is left as it is, and keeps its usual behavior.
+func (n *Net) InferenceMode() *Net {
+	clone := n.Clone()
+
+	for i, l := range clone.Layers {
+		d, ok := l.(*DropoutLayer)
+		if !ok || d.inference {
+			continue
+		}
+
+		var filters []*Vol
+	search:
+		for _, next := range clone.Layers[i+1:] {
+			switch next := next.(type) {
+			case *FullyConnLayer:
+				filters = next.filters
+				break search
+			case *ConvLayer:
+				filters = next.filters
+				break search
+			case *ReluLayer, *MaxoutLayer, *PoolLayer, *DropoutLayer:
+			default:
+				break search
+			}
+		}
+
+		if filters == nil || d.dropProb <= 0 {
+			continue
+		}
+
+		for _, f := range filters {
+			for j := range f.W {
+				f.W[j] *= d.dropProb
+			}
+		}
+
+		d.inference = true
+	}
+
+	return clone
+}
+
+// ShareWeightsClone returns a copy of the net that has its own activations
+// and scratch space but shares its parameters (and their gradients) with
+// n. Forward may be called on n and any number of its weight-sharing
+// clones concurrently, as long as nothing is training them at the time.
+func (n *Net) ShareWeightsClone() *Net {
+	clone := &Net{
+		Layers:          make([]Layer, len(n.Layers)),
+		CheckpointEvery: n.CheckpointEvery,
+		compiled:        n.compiled,
+	}
+
+	for i, l := range n.Layers {
+		clone.Layers[i] = l.shareWeights()
+	}
+
+	clone.sharedFrom = append([]Layer(nil), n.Layers...)
+
+	return clone
+}
+
+// reports whether p is a ShareWeightsClone of the current layers of n
+func (n *Net) sharesWeightsWith(p *Net) bool {
+	if len(p.sharedFrom) != len(n.Layers) {
+		return false
+	}
+
+	for i, l := range n.Layers {
+		if p.sharedFrom[i] != l {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Predict runs a forward pass in prediction mode and returns the output of
+// the last layer. Unlike Forward, Predict is safe to call from multiple
+// goroutines at once, as long as the net is not being trained or modified
+// concurrently. The activations of n are not changed.
+func (n *Net) Predict(v *Vol) *Vol {
+	p, _ := n.predictors.Get().(*Net)
+	if p == nil || !n.sharesWeightsWith(p) {
+		p = n.ShareWeightsClone()
+	}
+
+	out := p.Forward(v, false)
+
+	// don't keep the caller's volumes alive in the pool
+	for _, l := range p.Layers {
+		if f, ok := l.(forgetter); ok {
+			f.forget()
+		}
+	}
+
+	n.predictors.Put(p)
+
+	return out
+}
+
 func (n *Net) UnmarshalJSON(b []byte) error {
 	var rawData struct {
 		Layers []json.RawMessage `json:"layers"`
@@ -282,56 +826,96 @@ func (n *Net) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	n.Layers = make([]Layer, 0, len(rawData.Layers))
+	layers, err := unmarshalLayers(rawData.Layers)
+	if err != nil {
+		return err
+	}
 
-	for _, lj := range rawData.Layers {
-		var t struct {
-			LayerType string `json:"layer_type"`
-		}
+	n.Layers = layers
 
-		if err := json.Unmarshal(lj, &t); err != nil {
-			return err
-		}
+	return nil
+}
 
-		var l Layer
-
-		switch t.LayerType {
-		case "input":
-			l = &InputLayer{}
-		case "relu":
-			l = &ReluLayer{}
-		case "sigmoid":
-			l = &SigmoidLayer{}
-		case "tanh":
-			l = &TanhLayer{}
-		case "dropout":
-			l = &DropoutLayer{}
-		case "conv":
-			l = &ConvLayer{}
-		case "pool":
-			l = &PoolLayer{}
-		case "lrn":
-			l = &LocalResponseNormalizationLayer{}
-		case "softmax":
-			l = &SoftmaxLayer{}
-		case "regression":
-			l = &RegressionLayer{}
-		case "fc":
-			l = &FullyConnLayer{}
-		case "maxout":
-			l = &MaxoutLayer{}
-		case "svm":
-			l = &SVMLayer{}
-		default:
-			return fmt.Errorf("convnet: unknown layer type %q", t.LayerType)
-		}
+func unmarshalLayers(raw []json.RawMessage) ([]Layer, error) {
+	layers := make([]Layer, 0, len(raw))
 
-		if err := l.UnmarshalJSON(lj); err != nil {
-			return err
+	for _, lj := range raw {
+		l, err := unmarshalLayer(lj)
+		if err != nil {
+			return nil, err
 		}
 
-		n.Layers = append(n.Layers, l)
+		layers = append(layers, l)
 	}
 
-	return nil
+	return layers, nil
+}
+
+// unmarshalLayer decodes a single layer, using its layer_type to decide
+// which kind of layer to create
+func unmarshalLayer(lj json.RawMessage) (Layer, error) {
+	var t struct {
+		LayerType string `json:"layer_type"`
+	}
+
+	if err := json.Unmarshal(lj, &t); err != nil {
+		return nil, err
+	}
+
+	var l Layer
+
+	switch t.LayerType {
+	case "input":
+		l = &InputLayer{}
+	case "relu":
+		l = &ReluLayer{}
+	case "sigmoid":
+		l = &SigmoidLayer{}
+	case "tanh":
+		l = &TanhLayer{}
+	case "dropout":
+		l = &DropoutLayer{}
+	case "conv":
+		l = &ConvLayer{}
+	case "pool":
+		l = &PoolLayer{}
+	case "lrn":
+		l = &LocalResponseNormalizationLayer{}
+	case "softmax":
+		l = &SoftmaxLayer{}
+	case "regression":
+		l = &RegressionLayer{}
+	case "fc":
+		l = &FullyConnLayer{}
+	case "maxout":
+		l = &MaxoutLayer{}
+	case "svm":
+		l = &SVMLayer{}
+	case "spp":
+		l = &SPPLayer{}
+	case "deformconv":
+		l = &DeformConvLayer{}
+	case "embedding":
+		l = &EmbeddingLayer{}
+	case "swish", "silu":
+		l = &SwishLayer{}
+	case "batchnorm":
+		l = &BatchNormLayer{}
+	case "mixout":
+		l = &MixoutLayer{}
+	case "fpn":
+		l = &FPNLayer{}
+	case "lppool":
+		l = &LpPoolLayer{}
+	case "causalconv":
+		l = &CausalConvLayer{}
+	default:
+		return nil, fmt.Errorf("convnet: unknown layer type %q", t.LayerType)
+	}
+
+	if err := l.UnmarshalJSON(lj); err != nil {
+		return nil, err
+	}
+
+	return l, nil
 }
@@ -0,0 +1,358 @@
+package convnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// ONNX opset and IR versions written by ExportONNX
+const (
+	onnxOpsetVersion = 13
+	onnxIRVersion    = 7
+)
+
+// ExportONNX writes n to outputPath as an ONNX model for inference.
+// inputShape is the shape of the model's input in ONNX (NCHW) order; the
+// channels, height, and width must match the net's input layer, and a
+// batch size of zero or less leaves the batch dimension unspecified.
+//
+// Volumes in this package are stored with the depth varying fastest, but
+// ONNX tensors are channels-first, so inputs to the exported model must
+// be arranged as input[n][d][y][x]. The weights are rearranged to match.
+//
+// Conv, fully connected, pool (as MaxPool), relu, sigmoid, tanh, swish,
+// dropout, lrn, and batch norm layers are supported. A softmax loss layer
+// becomes a Softmax operator, and svm and regression loss layers output
+// their inputs unchanged. Any other layer type results in an error.
+func ExportONNX(n *Net, inputShape [4]int, outputPath string) error {
+	b, err := encodeONNX(n, inputShape)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(outputPath, b, 0644)
+}
+
+func encodeONNX(n *Net, inputShape [4]int) ([]byte, error) {
+	if len(n.Layers) == 0 {
+		return nil, fmt.Errorf("convnet: cannot export an empty net")
+	}
+
+	in, ok := n.Layers[0].(*InputLayer)
+	if !ok {
+		return nil, &LayerError{LayerIndex: 0, Type: layerTypeOf(n.Layers[0]), Reason: "first layer must be the input layer"}
+	}
+	if want := [3]int{in.OutDepth(), in.OutSy(), in.OutSx()}; want != [3]int{inputShape[1], inputShape[2], inputShape[3]} {
+		return nil, fmt.Errorf("convnet: ONNX input shape %v does not match input layer (channels, height, width) %v", inputShape, want)
+	}
+
+	g := &onnxGraph{}
+
+	cur := "input"
+	flat := false // whether cur is [N, C] rather than [N, C, H, W]
+
+	flatten := func() {
+		if !flat {
+			cur = g.node("Flatten", []string{cur}, onnxAttrInt("axis", 1))
+			flat = true
+		}
+	}
+
+	for i := 1; i < len(n.Layers); i++ {
+		prev := n.Layers[i-1]
+		inSx, inSy, inDepth := prev.OutSx(), prev.OutSy(), prev.OutDepth()
+
+		// layers that only make sense on images can't follow a flatten
+		requireImage := func() error {
+			if flat {
+				return &LayerError{LayerIndex: i, Type: layerTypeOf(n.Layers[i]), Reason: "cannot export after the input has been flattened"}
+			}
+
+			return nil
+		}
+
+		switch l := n.Layers[i].(type) {
+		case *ConvLayer:
+			if err := requireImage(); err != nil {
+				return nil, err
+			}
+
+			// filters are stored x, y, depth; ONNX wants [out, in, y, x]
+			w := make([]float64, 0, l.outDepth*l.inDepth*l.sy*l.sx)
+			for _, f := range l.filters {
+				for d := 0; d < f.Depth; d++ {
+					for y := 0; y < f.Sy; y++ {
+						for x := 0; x < f.Sx; x++ {
+							w = append(w, f.Get(x, y, d))
+						}
+					}
+				}
+			}
+
+			wName := g.initializer([]int{l.outDepth, l.inDepth, l.sy, l.sx}, w)
+			bName := g.initializer([]int{l.outDepth}, l.biases.W)
+
+			cur = g.node("Conv", []string{cur, wName, bName},
+				onnxAttrInts("kernel_shape", l.sy, l.sx),
+				onnxAttrInts("strides", l.stride, l.stride),
+				onnxAttrInts("pads", l.pad, l.pad, l.pad, l.pad))
+		case *PoolLayer:
+			if err := requireImage(); err != nil {
+				return nil, err
+			}
+
+			cur = g.node("MaxPool", []string{cur},
+				onnxAttrInts("kernel_shape", l.sy, l.sx),
+				onnxAttrInts("strides", l.stride, l.stride),
+				onnxAttrInts("pads", l.pad, l.pad, l.pad, l.pad))
+		case *FullyConnLayer:
+			// the inputs of each neuron are stored x, y, depth, but
+			// flattening an ONNX tensor gives depth, y, x
+			w := make([]float64, 0, l.outDepth*l.numInputs)
+			for _, f := range l.filters {
+				for d := 0; d < inDepth; d++ {
+					for y := 0; y < inSy; y++ {
+						for x := 0; x < inSx; x++ {
+							w = append(w, f.W[((inSx*y)+x)*inDepth+d])
+						}
+					}
+				}
+			}
+
+			flatten()
+
+			wName := g.initializer([]int{l.outDepth, l.numInputs}, w)
+			bName := g.initializer([]int{l.outDepth}, l.biases.W)
+
+			cur = g.node("Gemm", []string{cur, wName, bName}, onnxAttrInt("transB", 1))
+		case *ReluLayer:
+			cur = g.node("Relu", []string{cur})
+		case *SigmoidLayer:
+			cur = g.node("Sigmoid", []string{cur})
+		case *TanhLayer:
+			cur = g.node("Tanh", []string{cur})
+		case *SwishLayer:
+			sig := g.node("Sigmoid", []string{cur})
+			cur = g.node("Mul", []string{cur, sig})
+		case *DropoutLayer:
+			if l.inference {
+				// the scale was folded into a later layer
+				continue
+			}
+
+			// dropout scales its input during prediction
+			scale := g.initializer(nil, []float64{l.dropProb})
+			cur = g.node("Mul", []string{cur, scale})
+		case *LocalResponseNormalizationLayer:
+			if err := requireImage(); err != nil {
+				return nil, err
+			}
+
+			cur = g.node("LRN", []string{cur},
+				onnxAttrFloat("alpha", l.alpha),
+				onnxAttrFloat("beta", l.beta),
+				onnxAttrFloat("bias", l.k),
+				onnxAttrInt("size", l.n))
+		case *BatchNormLayer:
+			mean, variance := l.stats()
+
+			scale := g.initializer([]int{l.outDepth}, l.gamma.W)
+			bias := g.initializer([]int{l.outDepth}, l.beta.W)
+			meanName := g.initializer([]int{l.outDepth}, mean)
+			varName := g.initializer([]int{l.outDepth}, variance)
+
+			cur = g.node("BatchNormalization", []string{cur, scale, bias, meanName, varName},
+				onnxAttrFloat("epsilon", l.eps))
+		case *SoftmaxLayer:
+			flatten()
+			cur = g.node("Softmax", []string{cur}, onnxAttrInt("axis", 1))
+		case *SVMLayer, *RegressionLayer:
+			flatten()
+		default:
+			return nil, &LayerError{LayerIndex: i, Type: layerTypeOf(l), Reason: "layer type is not supported by ONNX export"}
+		}
+	}
+
+	last := n.Layers[len(n.Layers)-1]
+	outShape := []int{inputShape[0], last.OutDepth(), last.OutSy(), last.OutSx()}
+	if flat {
+		outShape = []int{inputShape[0], last.OutDepth() * last.OutSy() * last.OutSx()}
+	}
+
+	var graph onnxProto
+	for _, node := range g.nodes {
+		graph.message(1, node)
+	}
+	graph.string(2, "convnet")
+	for _, t := range g.initializers {
+		graph.message(5, t)
+	}
+	graph.message(11, onnxValueInfo("input", inputShape[:]))
+	graph.message(12, onnxValueInfo(cur, outShape))
+
+	var opset onnxProto
+	opset.string(1, "")
+	opset.varint(2, onnxOpsetVersion)
+
+	var model onnxProto
+	model.varint(1, onnxIRVersion)
+	model.string(2, "convnet")
+	model.message(7, graph)
+	model.message(8, opset)
+
+	return model, nil
+}
+
+// onnxGraph collects the nodes and initializers of an ONNX graph, naming
+// the tensors as it goes
+type onnxGraph struct {
+	nodes        []onnxProto
+	initializers []onnxProto
+	names        int
+}
+
+func (g *onnxGraph) name(prefix string) string {
+	g.names++
+
+	return fmt.Sprintf("%s_%d", prefix, g.names)
+}
+
+// adds a node and returns the name of its output
+func (g *onnxGraph) node(op string, inputs []string, attrs ...onnxProto) string {
+	out := g.name(op)
+
+	var node onnxProto
+	for _, in := range inputs {
+		node.string(1, in)
+	}
+	node.string(2, out)
+	node.string(3, out)
+	node.string(4, op)
+	for _, a := range attrs {
+		node.message(5, a)
+	}
+
+	g.nodes = append(g.nodes, node)
+
+	return out
+}
+
+// adds a float tensor and returns its name
+func (g *onnxGraph) initializer(dims []int, data []float64) string {
+	name := g.name("param")
+
+	raw := make([]byte, 4*len(data))
+	for i, f := range data {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(float32(f)))
+	}
+
+	var t onnxProto
+	for _, d := range dims {
+		t.varint(1, uint64(d))
+	}
+	t.varint(2, 1) // FLOAT
+	t.string(8, name)
+	t.bytes(9, raw)
+
+	g.initializers = append(g.initializers, t)
+
+	return name
+}
+
+func onnxAttrInt(name string, v int) onnxProto {
+	var a onnxProto
+	a.string(1, name)
+	a.varint(3, uint64(int64(v)))
+	a.varint(20, 2) // INT
+
+	return a
+}
+
+func onnxAttrInts(name string, vs ...int) onnxProto {
+	var a onnxProto
+	a.string(1, name)
+	for _, v := range vs {
+		a.varint(8, uint64(int64(v)))
+	}
+	a.varint(20, 7) // INTS
+
+	return a
+}
+
+func onnxAttrFloat(name string, v float64) onnxProto {
+	var a onnxProto
+	a.string(1, name)
+	a.fixed32(2, math.Float32bits(float32(v)))
+	a.varint(20, 1) // FLOAT
+
+	return a
+}
+
+// a float tensor with the given shape; dimensions that are zero or less
+// are left unspecified
+func onnxValueInfo(name string, shape []int) onnxProto {
+	var dims onnxProto
+	for _, d := range shape {
+		var dim onnxProto
+		if d > 0 {
+			dim.varint(1, uint64(d))
+		} else {
+			dim.string(2, "N")
+		}
+		dims.message(1, dim)
+	}
+
+	var tensor onnxProto
+	tensor.varint(1, 1) // FLOAT
+	tensor.message(2, dims)
+
+	var typ onnxProto
+	typ.message(1, tensor)
+
+	var info onnxProto
+	info.string(1, name)
+	info.message(2, typ)
+
+	return info
+}
+
+// onnxProto is an encoded protocol buffer message
+type onnxProto []byte
+
+func (p *onnxProto) key(field, wireType int) {
+	p.uvarint(uint64(field<<3 | wireType))
+}
+
+func (p *onnxProto) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	*p = append(*p, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (p *onnxProto) varint(field int, v uint64) {
+	p.key(field, 0)
+	p.uvarint(v)
+}
+
+func (p *onnxProto) fixed32(field int, v uint32) {
+	p.key(field, 5)
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	*p = append(*p, buf[:]...)
+}
+
+func (p *onnxProto) bytes(field int, b []byte) {
+	p.key(field, 2)
+	p.uvarint(uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *onnxProto) string(field int, s string) {
+	p.bytes(field, []byte(s))
+}
+
+func (p *onnxProto) message(field int, m onnxProto) {
+	p.bytes(field, m)
+}
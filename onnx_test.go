@@ -0,0 +1,255 @@
+package convnet_test
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BenLubar/convnet"
+)
+
+// protoFields decodes one level of a protocol buffer message. Varints and
+// fixed32 values are returned as uint64, and length-delimited fields as
+// []byte.
+func protoFields(t *testing.T, b []byte) map[int][]interface{} {
+	t.Helper()
+
+	fields := make(map[int][]interface{})
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad protobuf key")
+		}
+		b = b[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad protobuf varint")
+			}
+			b = b[n:]
+			fields[field] = append(fields[field], v)
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b[n:]) {
+				t.Fatal("bad protobuf length")
+			}
+			fields[field] = append(fields[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		case 5:
+			fields[field] = append(fields[field], uint64(binary.LittleEndian.Uint32(b)))
+			b = b[4:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+
+	return fields
+}
+
+// onnxTensor is a dense float tensor for evaluating exported graphs
+type onnxTensor struct {
+	dims []int
+	data []float64
+}
+
+// evalONNX runs a decoded ONNX graph that uses the operators exported for
+// simple conv nets, on a single input.
+func evalONNX(t *testing.T, model []byte, input onnxTensor) onnxTensor {
+	t.Helper()
+
+	graph := protoFields(t, protoFields(t, model)[7][0].([]byte))
+
+	values := map[string]onnxTensor{"input": input}
+
+	for _, raw := range graph[5] {
+		init := protoFields(t, raw.([]byte))
+
+		var tensor onnxTensor
+		for _, d := range init[1] {
+			tensor.dims = append(tensor.dims, int(d.(uint64)))
+		}
+		data := init[9][0].([]byte)
+		for i := 0; i < len(data); i += 4 {
+			tensor.data = append(tensor.data, float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i:]))))
+		}
+
+		values[string(init[8][0].([]byte))] = tensor
+	}
+
+	var out string
+	for _, raw := range graph[1] {
+		node := protoFields(t, raw.([]byte))
+
+		var in []onnxTensor
+		for _, name := range node[1] {
+			v, ok := values[string(name.([]byte))]
+			if !ok {
+				t.Fatalf("undefined tensor %q", name)
+			}
+			in = append(in, v)
+		}
+
+		ints := make(map[string][]int)
+		for _, a := range node[5] {
+			attr := protoFields(t, a.([]byte))
+			name := string(attr[1][0].([]byte))
+			for _, v := range attr[3] {
+				ints[name] = append(ints[name], int(v.(uint64)))
+			}
+			for _, v := range attr[8] {
+				ints[name] = append(ints[name], int(v.(uint64)))
+			}
+		}
+
+		var result onnxTensor
+		x := in[0]
+
+		switch op := string(node[4][0].([]byte)); op {
+		case "Relu", "Tanh":
+			result = onnxTensor{dims: x.dims, data: make([]float64, len(x.data))}
+			for i, f := range x.data {
+				if op == "Relu" {
+					result.data[i] = math.Max(f, 0)
+				} else {
+					result.data[i] = math.Tanh(f)
+				}
+			}
+		case "Flatten":
+			result = onnxTensor{dims: []int{1, len(x.data)}, data: x.data}
+		case "Gemm":
+			w, b := in[1], in[2]
+			result = onnxTensor{dims: []int{1, w.dims[0]}, data: make([]float64, w.dims[0])}
+			for j := range result.data {
+				sum := b.data[j]
+				for k, f := range x.data {
+					sum += f * w.data[j*w.dims[1]+k]
+				}
+				result.data[j] = sum
+			}
+		case "Softmax":
+			result = onnxTensor{dims: x.dims, data: make([]float64, len(x.data))}
+			total := 0.0
+			for i, f := range x.data {
+				result.data[i] = math.Exp(f)
+				total += result.data[i]
+			}
+			for i := range result.data {
+				result.data[i] /= total
+			}
+		case "Conv", "MaxPool":
+			c, h, w := x.dims[1], x.dims[2], x.dims[3]
+			kh, kw := ints["kernel_shape"][0], ints["kernel_shape"][1]
+			stride, pad := ints["strides"][0], ints["pads"][0]
+			oh, ow := (h+2*pad-kh)/stride+1, (w+2*pad-kw)/stride+1
+			oc := c
+			if op == "Conv" {
+				oc = in[1].dims[0]
+			}
+
+			result = onnxTensor{dims: []int{1, oc, oh, ow}, data: make([]float64, oc*oh*ow)}
+			for o := 0; o < oc; o++ {
+				for oy := 0; oy < oh; oy++ {
+					for ox := 0; ox < ow; ox++ {
+						acc := math.Inf(-1)
+						if op == "Conv" {
+							acc = in[2].data[o]
+						}
+
+						for ky := 0; ky < kh; ky++ {
+							for kx := 0; kx < kw; kx++ {
+								iy, ix := oy*stride-pad+ky, ox*stride-pad+kx
+								if iy < 0 || iy >= h || ix < 0 || ix >= w {
+									continue
+								}
+
+								if op == "MaxPool" {
+									acc = math.Max(acc, x.data[(o*h+iy)*w+ix])
+									continue
+								}
+								for ic := 0; ic < c; ic++ {
+									acc += x.data[(ic*h+iy)*w+ix] * in[1].data[((o*c+ic)*kh+ky)*kw+kx]
+								}
+							}
+						}
+
+						result.data[(o*oh+oy)*ow+ox] = acc
+					}
+				}
+			}
+		default:
+			t.Fatalf("unexpected operator %q", op)
+		}
+
+		out = string(node[2][0].([]byte))
+		values[out] = result
+	}
+
+	return values[out]
+}
+
+// it should export a net that computes the same function
+func TestExportONNX(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	net := &convnet.Net{}
+	net.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 5, OutSy: 4, OutDepth: 2},
+		{Type: convnet.LayerConv, Sx: 3, Filters: 3, Stride: 1, Pad: 1, Activation: convnet.LayerRelu},
+		{Type: convnet.LayerPool, Sx: 2, Stride: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerTanh},
+		{Type: convnet.LayerSoftmax, NumClasses: 3},
+	}, r)
+
+	path := filepath.Join(t.TempDir(), "net.onnx")
+	if err := convnet.ExportONNX(net, [4]int{1, 2, 4, 5}, path); err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		x := convnet.NewVolRand(5, 4, 2, r)
+
+		// rearrange the input to channels-first
+		input := onnxTensor{dims: []int{1, 2, 4, 5}}
+		for d := 0; d < 2; d++ {
+			for y := 0; y < 4; y++ {
+				for xx := 0; xx < 5; xx++ {
+					input.data = append(input.data, x.Get(xx, y, d))
+				}
+			}
+		}
+
+		expected := net.Forward(x, false)
+		actual := evalONNX(t, model, input)
+
+		for j := range expected.W {
+			if math.Abs(expected.W[j]-actual.data[j]) > 1e-5 {
+				t.Errorf("input %d: expected output %d to be %f, but it is %f", i, j, expected.W[j], actual.data[j])
+			}
+		}
+	}
+
+	if err := convnet.ExportONNX(net, [4]int{1, 3, 4, 5}, path); err == nil {
+		t.Error("expected an error for a mismatched input shape")
+	}
+
+	maxout := &convnet.Net{}
+	maxout.MakeLayers([]convnet.LayerDef{
+		{Type: convnet.LayerInput, OutSx: 1, OutSy: 1, OutDepth: 2},
+		{Type: convnet.LayerFC, NumNeurons: 4, Activation: convnet.LayerMaxout},
+		{Type: convnet.LayerSoftmax, NumClasses: 2},
+	}, r)
+	if err := convnet.ExportONNX(maxout, [4]int{1, 2, 1, 1}, path); err == nil {
+		t.Error("expected an error for an unsupported layer")
+	}
+}
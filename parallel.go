@@ -0,0 +1,72 @@
+package convnet
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ForwardAll runs each of vols through the net in prediction mode using
+// up to workers goroutines, or GOMAXPROCS goroutines if workers is zero or
+// less. The outputs are returned in the same order as the inputs.
+//
+// Each worker uses its own ShareWeightsClone of the net, so the weights
+// are shared rather than copied, and the activations of n are not changed.
+// The net must not be trained or modified until ForwardAll returns.
+//
+// An input that does not fit the input layer gets a nil output and an
+// error at its index in the second result, and the rest of the batch is
+// still run. The second result is nil if every input was run.
+func (n *Net) ForwardAll(vols []*Vol, workers int) ([]*Vol, []error) {
+	in, ok := n.Layers[0].(*InputLayer)
+	if !ok {
+		panic("convnet: first layer must be the input layer, to declare size of inputs")
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(vols) {
+		workers = len(vols)
+	}
+
+	outs := make([]*Vol, len(vols))
+	errs := make([]error, len(vols))
+	var failed int32
+
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+
+			p := n.ShareWeightsClone()
+
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(vols) {
+					break
+				}
+
+				if err := in.checkInput(vols[i]); err != nil {
+					errs[i] = err
+					atomic.StoreInt32(&failed, 1)
+
+					continue
+				}
+
+				outs[i] = p.Forward(vols[i], false)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if failed == 0 {
+		errs = nil
+	}
+
+	return outs, errs
+}
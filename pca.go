@@ -0,0 +1,218 @@
+package convnet
+
+import (
+	"fmt"
+	"math"
+)
+
+// PCA finds the numComponents principal components of samples, which must
+// all have the same dimensions. Each component is a unit vector with one
+// element for each element of a sample, and the components are returned
+// in order of decreasing variance as the rows of a Vol with Sx equal to
+// the number of elements in a sample, Sy equal to numComponents, and a
+// Depth of 1. explained holds the fraction of the total variance of the
+// samples along each component, so it sums to 1 if every component is
+// requested.
+//
+// The eigenvectors of the covariance matrix are found one at a time by the
+// power method, removing each one from the matrix before finding the next.
+func PCA(samples []*Vol, numComponents int) (components *Vol, explained []float64, err error) {
+	mean, err := MeanVol(samples)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := len(mean.W)
+	if numComponents < 1 || numComponents > n {
+		return nil, nil, fmt.Errorf("convnet: cannot find %d principal components of %d-element samples", numComponents, n)
+	}
+
+	// covariance matrix, row-major
+	cov := make([]float64, n*n)
+	centered := make([]float64, n)
+	for _, v := range samples {
+		for i, w := range v.W {
+			centered[i] = w - mean.W[i]
+		}
+
+		for i, ci := range centered {
+			row := cov[i*n : (i+1)*n]
+			for j, cj := range centered {
+				row[j] += ci * cj
+			}
+		}
+	}
+
+	total := 0.0
+	for i := range cov {
+		cov[i] /= float64(len(samples))
+	}
+	for i := 0; i < n; i++ {
+		total += cov[i*n+i]
+	}
+
+	components = NewVol(n, numComponents, 1, 0.0)
+	explained = make([]float64, numComponents)
+
+	for c := 0; c < numComponents; c++ {
+		vec := components.W[c*n : (c+1)*n]
+		prev := components.W[:c*n]
+
+		eigenvalue := powerMethod(cov, vec, prev)
+
+		if total > 0 {
+			explained[c] = eigenvalue / total
+		}
+
+		// deflate, so the next component is the next largest
+		for i, vi := range vec {
+			row := cov[i*n : (i+1)*n]
+			for j, vj := range vec {
+				row[j] -= eigenvalue * vi * vj
+			}
+		}
+	}
+
+	return components, explained, nil
+}
+
+// powerMethod finds the eigenvector of the symmetric n by n matrix m with
+// the largest eigenvalue, writes it to vec, and returns the eigenvalue.
+// The vector is kept orthogonal to the unit vectors stored one after
+// another in prev, so that rounding errors in the deflated matrix cannot
+// bring back an earlier component. Its largest element is positive.
+func powerMethod(m, vec, prev []float64) float64 {
+	n := len(vec)
+	next := make([]float64, n)
+
+	// start from a vector that is unlikely to be orthogonal to the
+	// eigenvector we want
+	for i := range vec {
+		vec[i] = 1 + float64(i)/float64(n)
+	}
+	if !orthonormalize(vec, prev) {
+		startFromBasis(vec, prev)
+	}
+
+	eigenvalue := 0.0
+	for iter := 0; iter < 10000; iter++ {
+		for i := range next {
+			sum := 0.0
+			for j, x := range m[i*n : (i+1)*n] {
+				sum += x * vec[j]
+			}
+			next[i] = sum
+		}
+
+		// Rayleigh quotient, since vec is a unit vector
+		eigenvalue = 0.0
+		for i := range next {
+			eigenvalue += next[i] * vec[i]
+		}
+
+		if !orthonormalize(next, prev) {
+			// the rest of the matrix is zero; any remaining
+			// direction will do
+			startFromBasis(vec, prev)
+			return 0
+		}
+
+		diff := 0.0
+		for i := range next {
+			diff += math.Abs(next[i] - vec[i])
+		}
+		copy(vec, next)
+
+		if diff < 1e-12 {
+			break
+		}
+	}
+
+	// the largest element should be positive, so the sign is predictable
+	largest := 0
+	for i := range vec {
+		if math.Abs(vec[i]) > math.Abs(vec[largest]) {
+			largest = i
+		}
+	}
+	if vec[largest] < 0 {
+		for i := range vec {
+			vec[i] = -vec[i]
+		}
+	}
+
+	return eigenvalue
+}
+
+// orthonormalize removes the components of v along each of the unit
+// vectors in prev and scales the rest to unit length. It returns false if
+// nothing is left.
+func orthonormalize(v, prev []float64) bool {
+	n := len(v)
+
+	for k := 0; k < len(prev); k += n {
+		p := prev[k : k+n]
+
+		dot := 0.0
+		for i := range v {
+			dot += v[i] * p[i]
+		}
+		for i := range v {
+			v[i] -= dot * p[i]
+		}
+	}
+
+	norm := 0.0
+	for _, x := range v {
+		norm += x * x
+	}
+	norm = math.Sqrt(norm)
+
+	if norm < 1e-12 {
+		return false
+	}
+
+	for i := range v {
+		v[i] /= norm
+	}
+
+	return true
+}
+
+// startFromBasis sets v to the first standard basis vector that is not
+// spanned by prev, made orthogonal to prev
+func startFromBasis(v, prev []float64) {
+	for b := range v {
+		for i := range v {
+			v[i] = 0
+		}
+		v[b] = 1
+
+		if orthonormalize(v, prev) {
+			return
+		}
+	}
+}
+
+// ProjectPCA returns the coordinates of v along each of the components
+// returned by PCA, as a 1x1xnumComponents Vol. v is not centered first;
+// subtract the mean of the samples from it to get the usual projection.
+func (v *Vol) ProjectPCA(components *Vol) *Vol {
+	n := len(v.W)
+	if components.Sx != n {
+		panic(fmt.Sprintf("convnet: cannot project a %d-element Vol onto %d-element components", n, components.Sx))
+	}
+
+	out := NewVol(1, 1, components.Sy, 0.0)
+	for c := range out.W {
+		row := components.W[c*n : (c+1)*n]
+
+		sum := 0.0
+		for i, w := range v.W {
+			sum += w * row[i]
+		}
+		out.W[c] = sum
+	}
+
+	return out
+}
@@ -0,0 +1,28 @@
+package convnet
+
+// PolicyGradientTrain trains the net, whose last layer must be a softmax
+// over actions, with one term of the REINFORCE policy gradient (Williams
+// 1992): the gradient of -advantage * log p(action | x). A positive
+// advantage makes the action more likely in x, and a negative one makes it
+// less likely. The advantage is usually the discounted return from this
+// step, minus a baseline.
+func (t *Trainer) PolicyGradientTrain(x *Vol, action int, advantage float64) TrainingResult {
+	s, ok := t.Net.Layers[len(t.Net.Layers)-1].(*SoftmaxLayer)
+	if !ok {
+		panic("convnet: PolicyGradientTrain requires the last layer to be a softmax")
+	}
+
+	t.Net.Forward(x, true)
+
+	// the gradient of -log p(action) is scaled by the advantage
+	nll := s.BackwardLoss(LossData{Dim: action})
+	for i := range s.inAct.Dw {
+		s.inAct.Dw[i] *= advantage
+	}
+
+	t.Net.backwardHidden()
+
+	l1DecayLoss, l2DecayLoss := t.step(t.Net, 1)
+
+	return t.result(advantage*nll, l1DecayLoss, l2DecayLoss)
+}
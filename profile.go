@@ -0,0 +1,119 @@
+package convnet
+
+import "time"
+
+// LayerTiming is the time spent in one layer of a Net since profiling was
+// enabled or last reset.
+type LayerTiming struct {
+	Index int
+	Type  LayerType
+
+	ForwardCalls  int
+	ForwardTime   time.Duration
+	BackwardCalls int
+	BackwardTime  time.Duration
+}
+
+// Total returns the time spent in the layer in both directions.
+func (t LayerTiming) Total() time.Duration {
+	return t.ForwardTime + t.BackwardTime
+}
+
+// EnableProfiling turns on or off the collection of per-layer timings for
+// Forward and Backward. Turning it on resets the timings, as does changing
+// the layers of the net with MakeLayers, InsertLayer, RemoveLayer,
+// ReplaceHead, or ColdStart. Weight-sharing clones, such as those used by
+// Predict, are not profiled.
+func (n *Net) EnableProfiling(enabled bool) {
+	if !enabled {
+		n.profile = nil
+
+		return
+	}
+
+	n.profile = make([]LayerTiming, len(n.Layers))
+	n.ResetProfile()
+}
+
+// ResetProfile sets every timing collected so far back to zero.
+func (n *Net) ResetProfile() {
+	if n.profile == nil {
+		return
+	}
+
+	if len(n.profile) != len(n.Layers) {
+		n.profile = make([]LayerTiming, len(n.Layers))
+	}
+
+	for i, l := range n.Layers {
+		n.profile[i] = LayerTiming{Index: i, Type: layerTypeOf(l)}
+	}
+}
+
+// ProfileReport returns a copy of the timings of each layer, or nil if
+// profiling is not enabled.
+func (n *Net) ProfileReport() []LayerTiming {
+	if n.profile == nil {
+		return nil
+	}
+
+	return append([]LayerTiming(nil), n.profile...)
+}
+
+// runs layer i forward, timing it if profiling is enabled, and calls its
+// hooks
+func (n *Net) forward(i int, v *Vol, isTraining bool) *Vol {
+	if n.profile == nil {
+		out := n.Layers[i].Forward(v, isTraining)
+		n.runForwardHooks(i, v, out)
+
+		return out
+	}
+
+	start := time.Now()
+	out := n.Layers[i].Forward(v, isTraining)
+	n.profile[i].ForwardTime += time.Since(start)
+	n.profile[i].ForwardCalls++
+
+	n.runForwardHooks(i, v, out)
+
+	return out
+}
+
+// runs layer i backward, timing it if profiling is enabled, and calls its
+// hooks
+func (n *Net) backward(i int) {
+	if n.profile == nil {
+		n.Layers[i].Backward()
+		n.runBackwardHooks(i)
+
+		return
+	}
+
+	start := time.Now()
+	n.Layers[i].Backward()
+	n.profile[i].BackwardTime += time.Since(start)
+	n.profile[i].BackwardCalls++
+
+	n.runBackwardHooks(i)
+}
+
+// like backward, for the loss layer at the end of the net
+func (n *Net) backwardLoss(y LossData) float64 {
+	last := len(n.Layers) - 1
+	if n.profile == nil {
+		loss := n.Layers[last].(LossLayer).BackwardLoss(y)
+		n.runBackwardHooks(last)
+
+		return loss
+	}
+
+	start := time.Now()
+	loss := n.Layers[last].(LossLayer).BackwardLoss(y)
+	n.profile[last].BackwardTime += time.Since(start)
+	n.profile[last].BackwardCalls++
+
+	n.runBackwardHooks(last)
+
+	return loss
+}
@@ -0,0 +1,87 @@
+package convnet
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// RandSource is a source of random numbers whose state can be saved and
+// restored, for reproducing a training run exactly. It implements
+// math/rand.Source64 with the SplitMix64 generator, so it can be passed to
+// rand.New. A *rand.Rand keeps no state of its own apart from the source,
+// except in its Read method, so saving the state of the source is enough
+// to restore the Rand as well, as long as Read is not used.
+//
+// A RandSource is not safe for concurrent use.
+type RandSource struct {
+	state uint64
+}
+
+// NewRandSource returns a RandSource seeded with seed.
+func NewRandSource(seed int64) *RandSource {
+	return &RandSource{state: uint64(seed)}
+}
+
+// Seed resets the source as if it had been created with NewRandSource.
+func (s *RandSource) Seed(seed int64) { s.state = uint64(seed) }
+
+// Uint64 returns a uniformly distributed 64-bit value.
+func (s *RandSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+
+	return z ^ (z >> 31)
+}
+
+// Int63 returns a uniformly distributed non-negative 63-bit value.
+func (s *RandSource) Int63() int64 { return int64(s.Uint64() >> 1) }
+
+// State returns the current state of the source.
+func (s *RandSource) State() uint64 { return s.state }
+
+// SetState restores a state returned by State.
+func (s *RandSource) SetState(state uint64) { s.state = state }
+
+var errNoRandSource = errors.New("convnet: net has no RandSource; call SetRandSource first")
+
+// SetRandSource makes every dropout and mixout layer of the net draw from
+// src, so that RandState and SetRandState can save and restore their
+// random choices.
+func (n *Net) SetRandSource(src *RandSource) {
+	n.randSource = src
+
+	r := rand.New(src)
+	for _, l := range n.Layers {
+		switch l := l.(type) {
+		case *DropoutLayer:
+			l.SetRand(r)
+		case *MixoutLayer:
+			l.SetRand(r)
+		}
+	}
+}
+
+// RandState returns the state of the net's RandSource. It returns an
+// error if SetRandSource has not been called.
+func (n *Net) RandState() (uint64, error) {
+	if n.randSource == nil {
+		return 0, errNoRandSource
+	}
+
+	return n.randSource.State(), nil
+}
+
+// SetRandState restores a state returned by RandState. It returns an
+// error if SetRandSource has not been called.
+func (n *Net) SetRandState(state uint64) error {
+	if n.randSource == nil {
+		return errNoRandSource
+	}
+
+	n.randSource.SetState(state)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package convnet
+
+import "math"
+
+// Scheduler chooses the learning rate for each update. step counts the
+// parameter updates made so far, starting at 0; with a batch size larger
+// than 1, that is the number of completed batches rather than the number
+// of calls to Train.
+type Scheduler interface {
+	LearningRate(step int) float64
+}
+
+// SGDRScheduler implements stochastic gradient descent with warm restarts
+// (Loshchilov and Hutter 2017). Within each cycle the learning rate starts
+// at MaxLR and decays to MinLR along a cosine curve, then jumps back up to
+// MaxLR at the start of the next cycle. The first cycle is T0 steps long,
+// and each cycle after that is TMult times as long as the one before it.
+// A TMult less than 1 is treated as 1.
+type SGDRScheduler struct {
+	T0    int
+	TMult int
+	MaxLR float64
+	MinLR float64
+}
+
+// CycleInfo returns the zero-based index of the cycle containing step,
+// the position of step within that cycle, and the length of the cycle.
+func (s *SGDRScheduler) CycleInfo(step int) (cycleIndex, stepInCycle, cycleLength int) {
+	if s.T0 <= 0 {
+		panic("convnet: SGDRScheduler.T0 must be positive")
+	}
+
+	if step < 0 {
+		step = 0
+	}
+
+	if s.TMult <= 1 {
+		return step / s.T0, step % s.T0, s.T0
+	}
+
+	cycleLength = s.T0
+	for step >= cycleLength {
+		step -= cycleLength
+		cycleIndex++
+		cycleLength *= s.TMult
+	}
+
+	return cycleIndex, step, cycleLength
+}
+
+func (s *SGDRScheduler) LearningRate(step int) float64 {
+	_, t, length := s.CycleInfo(step)
+
+	return s.MinLR + 0.5*(s.MaxLR-s.MinLR)*(1+math.Cos(math.Pi*float64(t)/float64(length)))
+}
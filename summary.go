@@ -0,0 +1,172 @@
+package convnet
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// LayerInfo describes one layer of a Net.
+type LayerInfo struct {
+	Index     int
+	Type      LayerType
+	OutSx     int
+	OutSy     int
+	OutDepth  int
+	NumParams int // number of parameters (weights and biases), frozen or not
+}
+
+// NumParameters returns the number of parameters (weights and biases, not
+// gradients) of a single layer, including those of a frozen layer.
+func NumParameters(l Layer) int {
+	count := 0
+
+	for _, pg := range l.ParamsAndGrads() {
+		count += len(pg.Params)
+	}
+
+	return count
+}
+
+// NumParameters returns the number of parameters in the net, including
+// those of frozen layers.
+func (n *Net) NumParameters() int {
+	count := 0
+
+	for _, l := range n.Layers {
+		count += NumParameters(l)
+	}
+
+	return count
+}
+
+// Describe returns a LayerInfo for every layer of the net.
+func (n *Net) Describe() []LayerInfo {
+	info := make([]LayerInfo, len(n.Layers))
+
+	for i, l := range n.Layers {
+		info[i] = LayerInfo{
+			Index:     i,
+			Type:      layerTypeOf(l),
+			OutSx:     l.OutSx(),
+			OutSy:     l.OutSy(),
+			OutDepth:  l.OutDepth(),
+			NumParams: NumParameters(l),
+		}
+	}
+
+	return info
+}
+
+// NetReceptiveField returns the receptive field of one output of the
+// net's spatial layers: the width of the region of the input it depends
+// on, the distance in the input between neighboring outputs, and how far
+// the region of the first output reaches past the edge of the input into
+// the padding. Conv, deformable conv (not counting its offsets), causal
+// conv, pool, and Lp pool layers are chained, layers that work on each
+// position by itself are passed over, and the result is the receptive
+// field at the first layer that is not spatial, such as a fully connected
+// or loss layer.
+func NetReceptiveField(n *Net) (size, stride, pad int) {
+	size, stride = 1, 1
+
+	for _, l := range n.Layers {
+		var k, s, p int
+
+		switch l := l.(type) {
+		case *ConvLayer:
+			k, s, p = l.ReceptiveField()
+		case *DeformConvLayer:
+			k, s, p = l.sx, l.stride, l.pad
+		case *CausalConvLayer:
+			k, s, p = (l.sx-1)*l.dilation+1, 1, l.Pad()
+		case *PoolLayer:
+			k, s, p = l.sx, l.stride, l.pad
+		case *LpPoolLayer:
+			k, s, p = l.sx, l.stride, l.pad
+		case *InputLayer, *ReluLayer, *SigmoidLayer, *TanhLayer, *SwishLayer, *MaxoutLayer,
+			*DropoutLayer, *MixoutLayer, *BatchNormLayer, *LocalResponseNormalizationLayer:
+			continue
+		default:
+			return size, stride, pad
+		}
+
+		size += (k - 1) * stride
+		pad += p * stride
+		stride *= s
+	}
+
+	return size, stride, pad
+}
+
+// LayerStat holds diagnostics for one layer of a Net, for spotting
+// vanishing or exploding gradients and dead units.
+type LayerStat struct {
+	Index int
+	Type  LayerType
+
+	WeightNorm float64 // L2 norm of all parameters of the layer
+	GradNorm   float64 // L2 norm of the gradients accumulated so far
+
+	// statistics of the output from the most recent call to Forward, or
+	// zero if there is none
+	MeanAbsActivation float64
+	ZeroFraction      float64 // fraction of outputs that are exactly zero
+}
+
+// LayerStats returns a LayerStat for every layer of the net. Layers
+// without parameters have zero norms.
+func (n *Net) LayerStats() []LayerStat {
+	stats := make([]LayerStat, len(n.Layers))
+
+	for i, l := range n.Layers {
+		s := LayerStat{Index: i, Type: layerTypeOf(l)}
+
+		for _, pg := range l.ParamsAndGrads() {
+			for j := range pg.Params {
+				s.WeightNorm += pg.Params[j] * pg.Params[j]
+				s.GradNorm += pg.Grads[j] * pg.Grads[j]
+			}
+		}
+
+		s.WeightNorm = math.Sqrt(s.WeightNorm)
+		s.GradNorm = math.Sqrt(s.GradNorm)
+
+		if out := l.Output(); out != nil && len(out.W) != 0 {
+			zeros := 0
+			for _, w := range out.W {
+				s.MeanAbsActivation += math.Abs(w)
+				if w == 0 {
+					zeros++
+				}
+			}
+
+			s.MeanAbsActivation /= float64(len(out.W))
+			s.ZeroFraction = float64(zeros) / float64(len(out.W))
+		}
+
+		stats[i] = s
+	}
+
+	return stats
+}
+
+// Summary returns a human-readable table of the layers of the net, their
+// output shapes, and their number of parameters.
+func (n *Net) Summary() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%-5s %-12s %-14s %s\n", "#", "layer", "output shape", "params")
+
+	total := 0
+	for _, info := range n.Describe() {
+		shape := fmt.Sprintf("%dx%dx%d", info.OutSx, info.OutSy, info.OutDepth)
+		fmt.Fprintf(&b, "%-5d %-12v %-14s %d\n", info.Index, info.Type, shape, info.NumParams)
+
+		total += info.NumParams
+	}
+
+	fmt.Fprintf(&b, "total params: %d\n", total)
+
+	return b.String()
+}
@@ -0,0 +1,197 @@
+package convnet
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// ReplaceHead removes the classifier head of the net and attaches a new
+// one created from defs, keeping the layers before it (and their weights)
+// unchanged. The head is the loss layer at the end of the net, along with
+// the fully connected layer before it if there is one, which is the pair
+// that a softmax, svm, or regression definition desugars to.
+//
+// defs are desugared like the arguments to MakeLayers, take their input
+// size from the last remaining layer, and must end with a loss layer. If
+// they do not fit, an error is returned and the net is not changed. Layer
+// indices in the error refer to n.Layers as it would be after the change.
+//
+// Trainers keep state for each group of parameters, so a Trainer created
+// for the net before the change should not be used with it afterwards.
+func (n *Net) ReplaceHead(defs []LayerDef, r *rand.Rand) error {
+	if len(n.Layers) < 2 {
+		return fmt.Errorf("convnet: net has %d layers, but replacing the head needs an input layer and a loss layer", len(n.Layers))
+	}
+
+	i := len(n.Layers) - 1
+	if _, ok := n.Layers[i].(LossLayer); !ok {
+		return &LayerError{LayerIndex: i, Type: layerTypeOf(n.Layers[i]), Reason: "last layer must be a loss layer"}
+	}
+	if i > 1 {
+		if _, ok := n.Layers[i-1].(*FullyConnLayer); ok {
+			i--
+		}
+	}
+
+	return n.ReplaceHeadAt(i, defs, r)
+}
+
+// ReplaceHeadAt is like ReplaceHead, but removes every layer from index i
+// onwards. i must be at least 1, so that the input layer is kept.
+func (n *Net) ReplaceHeadAt(i int, defs []LayerDef, r *rand.Rand) error {
+	if i < 1 || i > len(n.Layers) {
+		return fmt.Errorf("convnet: layer index %d out of range", i)
+	}
+
+	prev := n.Layers[i-1]
+
+	// check the new layers as if the previous layer were an input layer,
+	// so that makeLayers does not panic on bad definitions
+	checkDefs := append([]LayerDef{{
+		Type:     LayerInput,
+		OutSx:    prev.OutSx(),
+		OutSy:    prev.OutSy(),
+		OutDepth: prev.OutDepth(),
+	}}, defs...)
+	if err := ValidateDefs(checkDefs); err != nil {
+		switch e := err.(type) {
+		case *LayerError:
+			e.LayerIndex += i - 1
+		case *ShapeError:
+			e.LayerIndex += i - 1
+		}
+
+		return err
+	}
+
+	head := makeLayers(desugar(defs), prev, r)
+
+	n.Layers = append(n.Layers[:i:i], head...)
+	n.checkpoints = nil
+	n.ResetProfile()
+
+	return nil
+}
+
+// InsertLayer creates a layer from def and inserts it into the net at
+// index, so that it takes the output of layer index-1 as its input. def is
+// desugared like the arguments to MakeLayers, so a def with an activation
+// inserts more than one layer. Loss layers cannot be inserted.
+//
+// Layers after the insertion point without parameters, such as
+// nonlinearities and dropout, are resized to fit their new inputs. If any
+// other layer no longer fits, an error is returned and the net is not
+// changed. As with ReplaceHead, trainers for the net should be recreated.
+func (n *Net) InsertLayer(index int, def LayerDef, r *rand.Rand) error {
+	if index < 1 || index >= len(n.Layers) {
+		return fmt.Errorf("convnet: cannot insert a layer at index %d", index)
+	}
+
+	prev := n.Layers[index-1]
+
+	defs := desugar([]LayerDef{def})
+	for i, d := range defs {
+		switch d.Type {
+		case LayerInput, LayerSoftmax, LayerSVM, LayerRegression:
+			return &LayerError{LayerIndex: index + i, Type: d.Type, Reason: "only hidden layers can be inserted"}
+		}
+	}
+
+	if err := checkDefShapes(append([]LayerDef{{
+		Type:     LayerInput,
+		OutSx:    prev.OutSx(),
+		OutSy:    prev.OutSy(),
+		OutDepth: prev.OutDepth(),
+	}}, defs...)); err != nil {
+		switch e := err.(type) {
+		case *LayerError:
+			e.LayerIndex += index - 1
+		case *ShapeError:
+			e.LayerIndex += index - 1
+		}
+
+		return err
+	}
+
+	inserted := makeLayers(defs, prev, r)
+
+	layers := make([]Layer, 0, len(n.Layers)+len(inserted))
+	layers = append(layers, n.Layers[:index]...)
+	layers = append(layers, inserted...)
+	layers = append(layers, n.Layers[index:]...)
+
+	return n.splice(layers, index+len(inserted))
+}
+
+// RemoveLayer removes the layer at index, which cannot be the input layer
+// or the loss layer at the end of the net. Layers after it are resized
+// or checked as described for InsertLayer.
+func (n *Net) RemoveLayer(index int) error {
+	if index < 1 || index >= len(n.Layers)-1 {
+		return fmt.Errorf("convnet: cannot remove layer %d", index)
+	}
+
+	layers := make([]Layer, 0, len(n.Layers)-1)
+	layers = append(layers, n.Layers[:index]...)
+	layers = append(layers, n.Layers[index+1:]...)
+
+	return n.splice(layers, index)
+}
+
+// splice replaces the layers of the net with layers, which were changed
+// before index, after resizing the layers from index on to fit and
+// checking the result
+func (n *Net) splice(layers []Layer, index int) error {
+	for i := index; i < len(layers); i++ {
+		layers[i] = resized(layers[i], layers[i-1])
+	}
+
+	if err := (&Net{Layers: layers}).Validate(); err != nil {
+		return err
+	}
+
+	n.Layers = layers
+	n.checkpoints = nil
+	n.ResetProfile()
+
+	return nil
+}
+
+// resized returns a layer that does the same thing as l, but takes its
+// input from prev. Layers with parameters are returned unchanged.
+func resized(l Layer, prev Layer) Layer {
+	in := shapeOf(prev)
+
+	var def LayerDef
+
+	switch l := l.(type) {
+	case *ReluLayer, *SigmoidLayer, *TanhLayer, *SwishLayer:
+		if in == shapeOf(l) {
+			return l
+		}
+
+		def = LayerDef{Type: layerTypeOf(l)}
+	case *DropoutLayer:
+		if in == shapeOf(l) {
+			return l
+		}
+
+		def = LayerDef{Type: LayerDropout, DropProb: l.dropProb, DropProbZero: true}
+	case *MaxoutLayer:
+		if in == [3]int{l.outSx, l.outSy, l.outDepth * l.groupSize} {
+			return l
+		}
+
+		def = LayerDef{Type: LayerMaxout, GroupSize: l.groupSize, GroupSizeZero: true}
+	default:
+		return l
+	}
+
+	c := makeLayers([]LayerDef{def}, prev, nil)[0]
+	if d, ok := l.(*DropoutLayer); ok {
+		c.(*DropoutLayer).rand = d.rand
+		c.(*DropoutLayer).inference = d.inference
+	}
+
+	return c
+}
@@ -14,11 +14,14 @@ func _() {
 	_ = x[MethodADADelta-3]
 	_ = x[MethodWindowGrad-4]
 	_ = x[MethodNetsterov-5]
+	_ = x[MethodAdaFactor-6]
+	_ = x[MethodDPSGD-7]
+	_ = x[MethodLion-8]
 }
 
-const _TrainerMethod_name = "sgdadamadagradadadeltawindowgradnetsterov"
+const _TrainerMethod_name = "sgdadamadagradadadeltawindowgradnetsterovadafactordpsgdlion"
 
-var _TrainerMethod_index = [...]uint8{0, 3, 7, 14, 22, 32, 41}
+var _TrainerMethod_index = [...]uint8{0, 3, 7, 14, 22, 32, 41, 50, 55, 59}
 
 func (i TrainerMethod) String() string {
 	if i < 0 || i >= TrainerMethod(len(_TrainerMethod_index)-1) {
@@ -2,7 +2,10 @@
 
 package convnet
 
-import "math"
+import (
+	"math"
+	"math/rand"
+)
 
 type TrainerMethod int
 
@@ -13,6 +16,9 @@ const (
 	MethodADADelta                        // adadelta
 	MethodWindowGrad                      // windowgrad
 	MethodNetsterov                       // netsterov
+	MethodAdaFactor                       // adafactor
+	MethodDPSGD                           // dpsgd
+	MethodLion                            // lion
 )
 
 type TrainerOptions struct {
@@ -25,8 +31,20 @@ type TrainerOptions struct {
 	Momentum float64
 	Ro       float64 // used in adadelta
 	Eps      float64 // used in adam or adadelta
-	Beta1    float64 // used in adam
-	Beta2    float64 // used in adam
+	Beta1    float64 // used in adam or lion
+	Beta2    float64 // used in adam or lion
+
+	AdaFactorEps1 float64 // used in adafactor: added to squared gradients
+	AdaFactorEps2 float64 // used in adafactor: smallest parameter scale
+
+	ClipNorm   float64 // used in dpsgd: largest L2 norm of one example's gradient
+	NoiseSigma float64 // used in dpsgd: noise standard deviation, relative to ClipNorm
+	DeltaDP    float64 // used in dpsgd: delta for DPEpsilon
+
+	// if GradClipValue is more than 0, each element of the batch gradient,
+	// weight decay included, is clamped to [-GradClipValue, GradClipValue]
+	// before it is used by any method
+	GradClipValue float64
 }
 
 var DefaultTrainerOptions = TrainerOptions{
@@ -41,6 +59,13 @@ var DefaultTrainerOptions = TrainerOptions{
 	Eps:      1e-8,
 	Beta1:    0.9,
 	Beta2:    0.999,
+
+	AdaFactorEps1: 1e-30,
+	AdaFactorEps2: 1e-3,
+
+	ClipNorm:   1.0,
+	NoiseSigma: 1.0,
+	DeltaDP:    1e-5,
 }
 
 type Trainer struct {
@@ -48,8 +73,23 @@ type Trainer struct {
 	TrainerOptions
 
 	k    int         // iteration counter
+	wsum float64     // sum of the weights of the examples in the batch so far
 	gsum [][]float64 // last iteration gradients (used for momentum calculations)
 	xsum [][]float64 // used in adam or adadelta
+
+	dpsum  [][]float64 // used in dpsgd: clipped gradients of the batch so far
+	dpRand *rand.Rand  // used in dpsgd if Rand is nil
+
+	history *History // records every call to Train, if enabled
+
+	// Scheduler, if it is not nil, sets LearningRate before every update.
+	Scheduler Scheduler
+
+	// Rand draws the noise added by MethodDPSGD. If it is nil, a source
+	// seeded from crypto/rand is used. Setting Rand to a source with a
+	// fixed seed makes the noise reproducible, which voids the privacy
+	// guarantee, so it is only meant for tests.
+	Rand *rand.Rand
 }
 
 type TrainingResult struct {
@@ -66,23 +106,134 @@ func NewTrainer(net *Net, opts TrainerOptions) *Trainer {
 	}
 }
 
+// ZeroGrad sets the gradients of every parameter of the trainer's net to
+// zero. Gradients accumulated by Backward but not yet applied by Train
+// are discarded; the trainer's own state, such as momentum, is kept.
+func (t *Trainer) ZeroGrad() {
+	t.Net.ZeroGrads()
+}
+
+// EnableHistory attaches a History to the trainer that records the result
+// of every subsequent call to Train, keeping at most maxLen entries (or
+// all of them if maxLen is zero).
+func (t *Trainer) EnableHistory(maxLen int) *History {
+	t.history = &History{MaxLen: maxLen}
+
+	return t.history
+}
+
+// Clone returns a copy of the trainer for net, which must have the same
+// parameters as t.Net, such as a Clone of it. The state of the method,
+// such as momentum and the iteration count, is copied, so the copy carries
+// on where t left off. The copy does not record history, and it shares
+// t's Scheduler and Rand.
+func (t *Trainer) Clone(net *Net) *Trainer {
+	clone := *t
+	clone.Net = net
+	clone.gsum = cloneSums(t.gsum)
+	clone.xsum = cloneSums(t.xsum)
+	clone.dpsum = cloneSums(t.dpsum)
+	clone.dpRand = nil
+	clone.history = nil
+
+	return &clone
+}
+
+func cloneSums(sums [][]float64) [][]float64 {
+	if sums == nil {
+		return nil
+	}
+
+	clone := make([][]float64, len(sums))
+	for i, s := range sums {
+		if s != nil {
+			clone[i] = append([]float64(nil), s...)
+		}
+	}
+
+	return clone
+}
+
 func (t *Trainer) Train(x *Vol, y LossData) TrainingResult {
 	t.Net.Forward(x, true) // also set the flag that lets the net know we're just training
 
 	costLoss := t.Net.Backward(y)
-	l2DecayLoss := 0.0
-	l1DecayLoss := 0.0
+	l1DecayLoss, l2DecayLoss := t.step(t.Net, 1)
+
+	return t.result(costLoss, l1DecayLoss, l2DecayLoss)
+}
+
+// TrainWeighted is like Train, but the gradient of this example is scaled
+// by weight, which must not be negative, before it is added to the batch.
+// At the end of each batch, the gradient is divided by the sum of the
+// weights of its examples rather than by BatchSize, so the update is the
+// weighted mean of their gradients. BatchSize still counts calls, not
+// weight. Giving every example a weight of 1 is the same as calling Train,
+// which counts as a weight of 1 when the two are mixed. CostLoss in the
+// result is scaled by weight too.
+func (t *Trainer) TrainWeighted(x *Vol, y LossData, weight float64) TrainingResult {
+	if !(weight >= 0) {
+		panic("convnet: TrainWeighted requires a weight of at least 0")
+	}
+
+	t.Net.Forward(x, true)
+
+	// scale the gradient before it reaches any parameters
+	costLoss := t.Net.backwardLoss(y)
+	in := t.Net.Layers[len(t.Net.Layers)-2].Output()
+	for i := range in.Dw {
+		in.Dw[i] *= weight
+	}
+
+	t.Net.backwardHidden()
+
+	l1DecayLoss, l2DecayLoss := t.step(t.Net, weight)
+
+	return t.result(weight*costLoss, l1DecayLoss, l2DecayLoss)
+}
+
+// anything with parameters that a Trainer can update
+type paramsAndGradser interface {
+	ParamsAndGrads() []ParamsAndGrads
+}
+
+// step counts an iteration with the given weight and, at the end of each
+// batch, updates the parameters of net using the gradients accumulated
+// since the last update. It returns the weight decay losses.
+func (t *Trainer) step(net paramsAndGradser, weight float64) (l1DecayLoss, l2DecayLoss float64) {
+	pglist := net.ParamsAndGrads()
+
+	if t.Method == MethodDPSGD {
+		// the gradient of each example must be clipped on its own
+		t.dpAccumulate(pglist)
+	}
 
 	t.k++
+	t.wsum += weight
 	if t.k%t.BatchSize == 0 {
-		pglist := t.Net.ParamsAndGrads()
+		batchWeight := t.wsum
+		t.wsum = 0
+		if batchWeight == 0 {
+			// every gradient in the batch is zero, so only weight
+			// decay is left; apply it as an unweighted batch would
+			batchWeight = float64(t.BatchSize)
+		}
+
+		if t.Scheduler != nil {
+			t.LearningRate = t.Scheduler.LearningRate(t.k/t.BatchSize - 1)
+		}
+
+		if t.Method == MethodDPSGD {
+			t.dpNoise(pglist)
+		}
 
 		// initialize lists for accumulators. Will only be done once on first iteration
-		if len(t.gsum) == 0 && (t.Method != MethodSGD || t.Momentum > 0.0) {
+		if len(t.gsum) == 0 && t.Method != MethodAdaFactor && (t.Method != MethodSGD || t.Momentum > 0.0) {
 			// only vanilla sgd doesnt need either lists
 			// momentum needs gsum
 			// adagrad needs gsum
 			// adam and adadelta needs gsum and xsum
+			// adafactor allocates its own factored accumulators
 			for i := 0; i < len(pglist); i++ {
 				t.gsum = append(t.gsum, make([]float64, len(pglist[i].Params)))
 
@@ -102,6 +253,15 @@ func (t *Trainer) Train(x *Vol, y LossData) TrainingResult {
 		for i, pg := range pglist {
 			p, g := pg.Params, pg.Grads
 
+			if pg.Frozen {
+				// frozen parameters keep their values, but their
+				// gradients must still be cleared for the next batch
+				for j := range g {
+					g[j] = 0.0
+				}
+				continue
+			}
+
 			// learning rate for some parameters.
 			l2Decay := t.L2Decay * pg.L2DecayMul
 			l1Decay := t.L1Decay * pg.L1DecayMul
@@ -112,7 +272,7 @@ func (t *Trainer) Train(x *Vol, y LossData) TrainingResult {
 				l1grad := l1Decay * math.Copysign(1, p[j])
 				l2grad := l2Decay * p[j]
 
-				gij := (l2grad + l1grad + g[j]) / float64(t.BatchSize) // raw batch gradient
+				gij := t.clipValue((l2grad + l1grad + g[j]) / batchWeight) // raw batch gradient
 
 				gsumi, xsumi := t.gsum[i], t.xsum[i]
 
@@ -142,13 +302,35 @@ func (t *Trainer) Train(x *Vol, y LossData) TrainingResult {
 					dx := -math.Sqrt((xsumi[j]+t.Eps)/(gsumi[j]+t.Eps)) * gij
 					xsumi[j] = t.Ro*xsumi[j] + (1-t.Ro)*dx*dx // yes, xsum lags behind gsum by 1.
 					p[j] += dx
+				case MethodAdaFactor:
+					// needs the whole gradient before it can update
+					// anything, so just store the batch gradient for now
+					g[j] = gij
+					continue
+				case MethodLion:
+					// lion (Chen et al. 2023) moves every parameter by
+					// the same amount, in the direction of the sign of
+					// an interpolation between the momentum and the
+					// gradient. l2 decay is applied to the parameters
+					// directly instead of through the gradient.
+					gij = t.clipValue((l1grad + g[j]) / batchWeight)
+					c := t.Beta1*gsumi[j] + (1-t.Beta1)*gij
+					update := 0.0
+					if c > 0 {
+						update = 1
+					} else if c < 0 {
+						update = -1
+					}
+					p[j] -= t.LearningRate * (update + l2Decay*p[j])
+					gsumi[j] = t.Beta2*gsumi[j] + (1-t.Beta2)*gij
 				case MethodNetsterov:
 					dx := gsumi[j]
 					gsumi[j] = gsumi[j]*t.Momentum + t.LearningRate*gij
 					dx = t.Momentum*dx - (1.0+t.Momentum)*gsumi[j]
 					p[j] += dx
 				default:
-					// assume SGD
+					// assume SGD (dpsgd has already clipped and
+					// noised the gradients)
 					if t.Momentum > 0.0 {
 						// momentum update
 						dx := t.Momentum*gsumi[j] - t.LearningRate*gij // step
@@ -163,12 +345,35 @@ func (t *Trainer) Train(x *Vol, y LossData) TrainingResult {
 				g[j] = 0.0 // zero out gradient so that we can begin accumulating anew
 			}
 		}
+
+		if t.Method == MethodAdaFactor {
+			t.adaFactor(pglist)
+		}
 	}
 
-	return TrainingResult{
+	return l1DecayLoss, l2DecayLoss
+}
+
+// clamps one element of a gradient to GradClipValue, if it is set
+func (t *Trainer) clipValue(g float64) float64 {
+	if t.GradClipValue > 0 {
+		return math.Max(-t.GradClipValue, math.Min(t.GradClipValue, g))
+	}
+
+	return g
+}
+
+func (t *Trainer) result(costLoss, l1DecayLoss, l2DecayLoss float64) TrainingResult {
+	result := TrainingResult{
 		Loss:        costLoss + l1DecayLoss + l2DecayLoss,
 		CostLoss:    costLoss,
 		L1DecayLoss: l1DecayLoss,
 		L2DecayLoss: l2DecayLoss,
 	}
+
+	if t.history != nil {
+		t.history.Record(t.k, result)
+	}
+
+	return result
 }
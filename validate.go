@@ -0,0 +1,422 @@
+package convnet
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ShapeError is returned when the dimensions of a layer do not match
+// the dimensions that are required of it. Shapes are (sx, sy, depth).
+type ShapeError struct {
+	LayerIndex int
+	Got        [3]int
+	Want       [3]int
+}
+
+func (e *ShapeError) Error() string {
+	return fmt.Sprintf("convnet: layer %d has shape %dx%dx%d, but %dx%dx%d is required", e.LayerIndex, e.Got[0], e.Got[1], e.Got[2], e.Want[0], e.Want[1], e.Want[2])
+}
+
+// LayerError is returned when a layer is not valid for reasons
+// other than its shape.
+type LayerError struct {
+	LayerIndex int
+	Type       LayerType
+	Reason     string
+}
+
+func (e *LayerError) Error() string {
+	return fmt.Sprintf("convnet: layer %d (%v): %s", e.LayerIndex, e.Type, e.Reason)
+}
+
+func shapeOf(l Layer) [3]int {
+	return [3]int{l.OutSx(), l.OutSy(), l.OutDepth()}
+}
+
+// ValidateDefs checks a list of layer definitions before any layers are
+// created. Layer indices in the returned errors refer to the desugared
+// list of definitions, which is the same as the index in Net.Layers after
+// a call to MakeLayers.
+func ValidateDefs(defs []LayerDef) error {
+	if len(defs) < 2 {
+		return errors.New("convnet: at least one input layer and one loss layer are required")
+	}
+	if defs[0].Type != LayerInput {
+		return &LayerError{LayerIndex: 0, Type: defs[0].Type, Reason: "first layer must be the input layer"}
+	}
+
+	defs = desugar(defs)
+
+	for i, def := range defs {
+		switch def.InitMethod {
+		case "", InitDefault, InitKaiming, InitXavier:
+		default:
+			return &LayerError{LayerIndex: i, Type: def.Type, Reason: fmt.Sprintf("unrecognized init method %q", def.InitMethod)}
+		}
+	}
+
+	if err := checkDefShapes(defs); err != nil {
+		return err
+	}
+
+	switch last := defs[len(defs)-1]; last.Type {
+	case LayerSoftmax, LayerSVM, LayerRegression:
+	default:
+		return &LayerError{LayerIndex: len(defs) - 1, Type: last.Type, Reason: "last layer must be a loss layer"}
+	}
+
+	return nil
+}
+
+// checkDefShapes checks that each of the desugared defs, the first of
+// which is an input layer, has a positive output size and fits the output
+// of the one before it.
+func checkDefShapes(defs []LayerDef) error {
+	var in [3]int
+	for i, def := range defs {
+		var out [3]int
+
+		switch def.Type {
+		case LayerInput:
+			if i != 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "input layer must be the first layer"}
+			}
+
+			out = [3]int{def.OutSx, def.OutSy, def.OutDepth}
+			if out[0] == 0 {
+				out[0] = 1
+			}
+			if out[1] == 0 {
+				out[1] = 1
+			}
+		case LayerFC:
+			if def.NumNeurons <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "number of neurons must be positive"}
+			}
+
+			out = [3]int{1, 1, def.NumNeurons}
+		case LayerConv, LayerDeformConv, LayerPool, LayerLpPool:
+			sx, sy, stride := def.Sx, def.Sy, def.Stride
+			if sy == 0 && !def.SyZero {
+				sy = sx
+			}
+			if stride == 0 && !def.StrideZero {
+				stride = 1
+				if def.Type == LayerPool || def.Type == LayerLpPool {
+					stride = 2
+				}
+			}
+			if def.Type == LayerLpPool && def.P != 0 && !(def.P >= 1) {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "p must be at least 1"}
+			}
+
+			if sx <= 0 || sy <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "filter size must be positive"}
+			}
+			if stride <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "stride must be positive"}
+			}
+			// the division below truncates toward zero, so a filter that
+			// doesn't fit would still seem to give an output of size 1
+			if in[0]+def.Pad*2 < sx || in[1]+def.Pad*2 < sy {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "filter is larger than the padded input"}
+			}
+
+			out = [3]int{
+				(in[0]+def.Pad*2-sx)/stride + 1,
+				(in[1]+def.Pad*2-sy)/stride + 1,
+				in[2],
+			}
+
+			if def.Type != LayerPool && def.Type != LayerLpPool {
+				if def.Filters <= 0 {
+					return &LayerError{LayerIndex: i, Type: def.Type, Reason: "number of filters must be positive"}
+				}
+
+				out[2] = def.Filters
+			}
+		case LayerCausalConv:
+			if def.Sx <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "filter size must be positive"}
+			}
+			if def.Dilation < 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "dilation must not be negative"}
+			}
+			if def.Filters <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "number of filters must be positive"}
+			}
+
+			out = [3]int{in[0], in[1], def.Filters}
+		case LayerLRN:
+			if def.N%2 == 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "n should be odd"}
+			}
+
+			out = in
+		case LayerMaxout:
+			gs := def.GroupSize
+			if gs == 0 && !def.GroupSizeZero {
+				gs = 2
+			}
+
+			if gs <= 0 || in[2]%gs != 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: fmt.Sprintf("group size %d does not divide input depth %d", gs, in[2])}
+			}
+
+			out = [3]int{in[0], in[1], in[2] / gs}
+		case LayerSPP:
+			out = [3]int{1, 1, 0}
+
+			binSizes := def.BinSizes
+			if len(binSizes) == 0 {
+				binSizes = []int{1, 2, 4}
+			}
+
+			for _, s := range binSizes {
+				if s <= 0 {
+					return &LayerError{LayerIndex: i, Type: def.Type, Reason: "bin sizes must be positive"}
+				}
+
+				out[2] += in[2] * s * s
+			}
+		case LayerEmbedding:
+			if def.NumEmbeddings <= 0 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "number of embeddings must be positive"}
+			}
+			if in != [3]int{1, 1, 1} {
+				return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{1, 1, 1}}
+			}
+
+			out = [3]int{1, 1, def.EmbeddingDim}
+		case LayerFPN:
+			def.InSx, def.InSy, def.InDepth = in[0], in[1], in[2]
+			if reason := fpnDefProblem(def); reason != "" {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: reason}
+			}
+			if len(def.LevelDepths) > 1 {
+				return &LayerError{LayerIndex: i, Type: def.Type, Reason: "an fpn layer with more than one level must be used with ForwardMulti"}
+			}
+
+			out = [3]int{in[0], in[1], def.Filters}
+		case LayerSoftmax, LayerSVM, LayerRegression:
+			out = [3]int{1, 1, in[0] * in[1] * in[2]}
+		case LayerRelu, LayerSigmoid, LayerTanh, LayerSwish, LayerDropout, LayerBatchNorm, LayerMixout:
+			out = in
+		default:
+			return &LayerError{LayerIndex: i, Type: def.Type, Reason: "unrecognized layer type"}
+		}
+
+		if out[0] <= 0 || out[1] <= 0 || out[2] <= 0 {
+			return &LayerError{LayerIndex: i, Type: def.Type, Reason: fmt.Sprintf("output size %dx%dx%d is not positive", out[0], out[1], out[2])}
+		}
+
+		in = out
+	}
+
+	return nil
+}
+
+// Validate checks that the layers of the network fit together: the first
+// layer is an input layer, the last layer is a loss layer, every layer has
+// a positive output size, and every layer accepts the output of the layer
+// before it.
+func (n *Net) Validate() error {
+	if len(n.Layers) < 2 {
+		return errors.New("convnet: at least one input layer and one loss layer are required")
+	}
+
+	if _, ok := n.Layers[0].(*InputLayer); !ok {
+		return &LayerError{LayerIndex: 0, Type: layerTypeOf(n.Layers[0]), Reason: "first layer must be the input layer"}
+	}
+
+	if _, ok := n.Layers[len(n.Layers)-1].(LossLayer); !ok {
+		return &LayerError{LayerIndex: len(n.Layers) - 1, Type: layerTypeOf(n.Layers[len(n.Layers)-1]), Reason: "last layer must be a loss layer"}
+	}
+
+	for i, l := range n.Layers {
+		out := shapeOf(l)
+		if out[0] <= 0 || out[1] <= 0 || out[2] <= 0 {
+			return &LayerError{LayerIndex: i, Type: layerTypeOf(l), Reason: fmt.Sprintf("output size %dx%dx%d is not positive", out[0], out[1], out[2])}
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		in := shapeOf(n.Layers[i-1])
+		if err := checkInputShape(i, l, in); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkInputShape verifies that layer l, at index i, can accept an input
+// of shape in.
+func checkInputShape(i int, l Layer, in [3]int) error {
+	out := shapeOf(l)
+	count := in[0] * in[1] * in[2]
+
+	switch l := l.(type) {
+	case *InputLayer:
+		return &LayerError{LayerIndex: i, Type: LayerInput, Reason: "input layer must be the first layer"}
+	case *FullyConnLayer:
+		if count != l.numInputs {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{1, 1, l.numInputs}}
+		}
+	case *ConvLayer:
+		if l.stride <= 0 {
+			return &LayerError{LayerIndex: i, Type: layerTypeOf(l), Reason: "stride must be positive"}
+		}
+
+		want := [3]int{(in[0]+l.pad*2-l.sx)/l.stride + 1, (in[1]+l.pad*2-l.sy)/l.stride + 1, l.outDepth}
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+		if out != want {
+			return &ShapeError{LayerIndex: i, Got: out, Want: want}
+		}
+	case *DeformConvLayer:
+		if l.stride <= 0 {
+			return &LayerError{LayerIndex: i, Type: LayerDeformConv, Reason: "stride must be positive"}
+		}
+
+		want := [3]int{(in[0]+l.pad*2-l.sx)/l.stride + 1, (in[1]+l.pad*2-l.sy)/l.stride + 1, l.outDepth}
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+		if out != want {
+			return &ShapeError{LayerIndex: i, Got: out, Want: want}
+		}
+	case *PoolLayer:
+		if l.stride <= 0 {
+			return &LayerError{LayerIndex: i, Type: layerTypeOf(l), Reason: "stride must be positive"}
+		}
+
+		want := [3]int{(in[0]+l.pad*2-l.sx)/l.stride + 1, (in[1]+l.pad*2-l.sy)/l.stride + 1, in[2]}
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+		if out != want {
+			return &ShapeError{LayerIndex: i, Got: out, Want: want}
+		}
+	case *LpPoolLayer:
+		if l.stride <= 0 {
+			return &LayerError{LayerIndex: i, Type: LayerLpPool, Reason: "stride must be positive"}
+		}
+		if !(l.p >= 1) {
+			return &LayerError{LayerIndex: i, Type: LayerLpPool, Reason: "p must be at least 1"}
+		}
+
+		want := [3]int{(in[0]+l.pad*2-l.sx)/l.stride + 1, (in[1]+l.pad*2-l.sy)/l.stride + 1, in[2]}
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+		if out != want {
+			return &ShapeError{LayerIndex: i, Got: out, Want: want}
+		}
+	case *CausalConvLayer:
+		if l.dilation <= 0 {
+			return &LayerError{LayerIndex: i, Type: LayerCausalConv, Reason: "dilation must be positive"}
+		}
+
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+		if want := [3]int{in[0], in[1], l.outDepth}; out != want {
+			return &ShapeError{LayerIndex: i, Got: out, Want: want}
+		}
+	case *LocalResponseNormalizationLayer:
+		if l.n%2 == 0 {
+			return &LayerError{LayerIndex: i, Type: LayerLRN, Reason: "n should be odd"}
+		}
+		if in != out {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: out}
+		}
+	case *MaxoutLayer:
+		if l.groupSize <= 0 || in[2]%l.groupSize != 0 {
+			return &LayerError{LayerIndex: i, Type: LayerMaxout, Reason: fmt.Sprintf("group size %d does not divide input depth %d", l.groupSize, in[2])}
+		}
+		if want := [3]int{out[0], out[1], out[2] * l.groupSize}; in != want {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: want}
+		}
+	case *SPPLayer:
+		if in[2] != l.inDepth {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{in[0], in[1], l.inDepth}}
+		}
+	case *FPNLayer:
+		if len(l.sizes) != 1 {
+			return &LayerError{LayerIndex: i, Type: LayerFPN, Reason: "an fpn layer with more than one level must be used with ForwardMulti"}
+		}
+		if in != l.sizes[0] {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: l.sizes[0]}
+		}
+	case *EmbeddingLayer:
+		if in != [3]int{1, 1, 1} {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{1, 1, 1}}
+		}
+	case *SoftmaxLayer, *SVMLayer, *RegressionLayer:
+		if count != out[2] {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: [3]int{1, 1, out[2]}}
+		}
+	default:
+		// elementwise layers
+		if in != out {
+			return &ShapeError{LayerIndex: i - 1, Got: in, Want: out}
+		}
+	}
+
+	return nil
+}
+
+func layerTypeOf(l Layer) LayerType {
+	switch l.(type) {
+	case *InputLayer:
+		return LayerInput
+	case *ReluLayer:
+		return LayerRelu
+	case *SigmoidLayer:
+		return LayerSigmoid
+	case *TanhLayer:
+		return LayerTanh
+	case *DropoutLayer:
+		return LayerDropout
+	case *ConvLayer:
+		return LayerConv
+	case *PoolLayer:
+		return LayerPool
+	case *LocalResponseNormalizationLayer:
+		return LayerLRN
+	case *SoftmaxLayer:
+		return LayerSoftmax
+	case *RegressionLayer:
+		return LayerRegression
+	case *FullyConnLayer:
+		return LayerFC
+	case *MaxoutLayer:
+		return LayerMaxout
+	case *SVMLayer:
+		return LayerSVM
+	case *SPPLayer:
+		return LayerSPP
+	case *FPNLayer:
+		return LayerFPN
+	case *LpPoolLayer:
+		return LayerLpPool
+	case *DeformConvLayer:
+		return LayerDeformConv
+	case *CausalConvLayer:
+		return LayerCausalConv
+	case *EmbeddingLayer:
+		return LayerEmbedding
+	case *SwishLayer:
+		return LayerSwish
+	case *BatchNormLayer:
+		return LayerBatchNorm
+	case *MixoutLayer:
+		return LayerMixout
+	default:
+		return 0
+	}
+}
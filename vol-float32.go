@@ -0,0 +1,89 @@
+package convnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ToFloat32 returns the values of v converted to float32, which halves
+// their size at the cost of precision. The gradients are not included.
+func (v *Vol) ToFloat32() []float32 {
+	data := make([]float32, len(v.W))
+
+	for i, w := range v.W {
+		data[i] = float32(w)
+	}
+
+	return data
+}
+
+// NewVolFromFloat32 creates a Vol with the given shape from values laid out
+// like Vol.W, such as those returned by ToFloat32. It panics if data does
+// not have exactly sx*sy*depth values.
+func NewVolFromFloat32(sx, sy, depth int, data []float32) *Vol {
+	if len(data) != sx*sy*depth {
+		panic(fmt.Sprintf("convnet: %d values cannot fill a %dx%dx%d Vol", len(data), sx, sy, depth))
+	}
+
+	v := NewVol(sx, sy, depth, 0.0)
+
+	for i, w := range data {
+		v.W[i] = float64(w)
+	}
+
+	return v
+}
+
+// WriteFloat32 writes the shape of v as three little-endian uint32s
+// followed by its values as little-endian float32s. ReadVolFloat32 reads
+// the result.
+func (v *Vol) WriteFloat32(w io.Writer) error {
+	buf := make([]byte, 12+4*len(v.W))
+
+	binary.LittleEndian.PutUint32(buf[0:], uint32(v.Sx))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(v.Sy))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(v.Depth))
+
+	for i, x := range v.W {
+		binary.LittleEndian.PutUint32(buf[12+4*i:], math.Float32bits(float32(x)))
+	}
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// ReadVolFloat32 reads a Vol written by WriteFloat32.
+func ReadVolFloat32(r io.Reader) (*Vol, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	sx := binary.LittleEndian.Uint32(header[0:])
+	sy := binary.LittleEndian.Uint32(header[4:])
+	depth := binary.LittleEndian.Uint32(header[8:])
+
+	n := uint64(sx) * uint64(sy) * uint64(depth)
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("convnet: %dx%dx%d Vol is too large to read", sx, sy, depth)
+	}
+
+	buf := make([]byte, 4*n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	v := NewVol(int(sx), int(sy), int(depth), 0.0)
+	for i := range v.W {
+		v.W[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:])))
+	}
+
+	return v, nil
+}
@@ -1,8 +1,14 @@
 package convnet
 
 import (
+	"container/heap"
+	"errors"
+	"fmt"
 	"image"
 	"image/draw"
+	"math"
+	"math/rand"
+	"sort"
 )
 
 // Volume utilities
@@ -54,6 +60,271 @@ func (v *Vol) Augment(crop, dx, dy int, fliplr bool) *Vol {
 	return w
 }
 
+// RandomCrop returns a cropSize x cropSize crop of v at an offset chosen
+// with r, like convnetjs's augment with no offsets given: dx is drawn from
+// [0, v.Sx-cropSize) and dy from [0, v.Sy-cropSize), or is 0 if that range
+// is empty.
+func (v *Vol) RandomCrop(cropSize int, r *rand.Rand) *Vol {
+	return v.RandomCropWithFlip(cropSize, 0, r)
+}
+
+// RandomCropWithFlip is like RandomCrop, but also flips the crop left to
+// right with probability flipProb.
+func (v *Vol) RandomCropWithFlip(cropSize int, flipProb float64, r *rand.Rand) *Vol {
+	dx, dy := 0, 0
+	if n := v.Sx - cropSize; n > 0 {
+		dx = r.Intn(n)
+	}
+	if n := v.Sy - cropSize; n > 0 {
+		dy = r.Intn(n)
+	}
+
+	fliplr := flipProb > 0 && r.Float64() < flipProb
+
+	return v.Augment(cropSize, dx, dy, fliplr)
+}
+
+// returns a copy of v with independent noise drawn
+// uniformly from (-scale, scale) added to every element
+func (v *Vol) UniformNoise(scale float64, r *rand.Rand) *Vol {
+	w := v.Clone()
+
+	for i := range w.W {
+		w.W[i] += (r.Float64()*2 - 1) * scale
+	}
+
+	return w
+}
+
+// returns a copy of v with independent gaussian noise
+// with standard deviation sigma added to every element
+func (v *Vol) GaussianNoise(sigma float64, r *rand.Rand) *Vol {
+	w := v.Clone()
+
+	for i := range w.W {
+		w.W[i] += r.NormFloat64() * sigma
+	}
+
+	return w
+}
+
+// Threshold returns a mask of v: a Vol of the same size that is 1 where v
+// is more than t and 0 everywhere else.
+func (v *Vol) Threshold(t float64) *Vol {
+	w := NewVol(v.Sx, v.Sy, v.Depth, 0.0)
+
+	for i, x := range v.W {
+		if x > t {
+			w.W[i] = 1
+		}
+	}
+
+	return w
+}
+
+// ThresholdInPlace is like Threshold, but replaces the values of v with
+// the mask instead of allocating a new Vol.
+func (v *Vol) ThresholdInPlace(t float64) {
+	for i, x := range v.W {
+		if x > t {
+			v.W[i] = 1
+		} else {
+			v.W[i] = 0
+		}
+	}
+}
+
+// Clamp returns a copy of v with every value below min raised to min and
+// every value above max lowered to max. It panics if min is more than max.
+func (v *Vol) Clamp(min, max float64) *Vol {
+	if min > max {
+		panic(fmt.Sprintf("convnet: cannot clamp to [%g, %g]", min, max))
+	}
+
+	w := v.Clone()
+
+	for i := range w.W {
+		w.W[i] = math.Max(min, math.Min(max, w.W[i]))
+	}
+
+	return w
+}
+
+// Permute returns a copy of v with its dimensions reordered. The
+// dimensions are numbered 0 for depth, 1 for x, and 2 for y, and dimension
+// i of the result (in that same order) is dimension dimOrder[i] of v, so
+// [0, 1, 2] is a plain copy and [0, 2, 1] swaps x and y. dimOrder must be
+// a permutation of [0, 1, 2].
+func (v *Vol) Permute(dimOrder [3]int) (*Vol, error) {
+	var seen [3]bool
+	for _, dim := range dimOrder {
+		if dim < 0 || dim > 2 || seen[dim] {
+			return nil, fmt.Errorf("convnet: %v is not a permutation of [0 1 2]", dimOrder)
+		}
+		seen[dim] = true
+	}
+
+	size := [3]int{v.Depth, v.Sx, v.Sy}
+	w := NewVol(size[dimOrder[1]], size[dimOrder[2]], size[dimOrder[0]], 0.0)
+
+	for y := 0; y < v.Sy; y++ {
+		for x := 0; x < v.Sx; x++ {
+			for d := 0; d < v.Depth; d++ {
+				i := [3]int{d, x, y}
+				w.Set(i[dimOrder[1]], i[dimOrder[2]], i[dimOrder[0]], v.Get(x, y, d))
+			}
+		}
+	}
+
+	return w, nil
+}
+
+// SumVol returns the element-wise sum of vols, which must all have the
+// same dimensions
+func SumVol(vols []*Vol) (*Vol, error) {
+	if len(vols) == 0 {
+		return nil, errors.New("convnet: cannot sum an empty list of Vols")
+	}
+
+	first := vols[0]
+	for i, v := range vols[1:] {
+		if !first.sameShape(v) {
+			return nil, fmt.Errorf("convnet: Vol %d is %dx%dx%d, but Vol 0 is %dx%dx%d", i+1, v.Sx, v.Sy, v.Depth, first.Sx, first.Sy, first.Depth)
+		}
+	}
+
+	sum := NewVol(first.Sx, first.Sy, first.Depth, 0.0)
+	for _, v := range vols {
+		for k, w := range v.W {
+			sum.W[k] += w
+		}
+	}
+
+	return sum, nil
+}
+
+// MeanVol returns the element-wise mean of vols, which must all have the
+// same dimensions
+func MeanVol(vols []*Vol) (*Vol, error) {
+	mean, err := SumVol(vols)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := 1 / float64(len(vols))
+	for k := range mean.W {
+		mean.W[k] *= scale
+	}
+
+	return mean, nil
+}
+
+// ArgSort returns the indices of v.W sorted so that the values they refer
+// to are in descending order. Equal values keep their original order.
+func (v *Vol) ArgSort() []int {
+	indices := make([]int, len(v.W))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		return v.W[indices[i]] > v.W[indices[j]]
+	})
+
+	return indices
+}
+
+// ArgSortN returns the first n indices that ArgSort would return, using a
+// heap of size n instead of sorting all of v.W. If n is larger than the
+// number of elements in v, every index is returned.
+func (v *Vol) ArgSortN(n int) []int {
+	if n > len(v.W) {
+		n = len(v.W)
+	}
+	if n <= 0 {
+		return []int{}
+	}
+
+	// min-heap of the best n so far, with the worst at the root
+	h := &argHeap{w: v.W, indices: make([]int, 0, n)}
+	for i := range v.W {
+		if len(h.indices) < n {
+			heap.Push(h, i)
+		} else if h.better(i, h.indices[0]) {
+			h.indices[0] = i
+			heap.Fix(h, 0)
+		}
+	}
+
+	// popping gives the worst first
+	top := make([]int, n)
+	for i := n - 1; i >= 0; i-- {
+		top[i] = heap.Pop(h).(int)
+	}
+
+	return top
+}
+
+type argHeap struct {
+	w       []float64
+	indices []int
+}
+
+// reports whether index i comes before index j in ArgSort order
+func (h *argHeap) better(i, j int) bool {
+	if h.w[i] != h.w[j] {
+		return h.w[i] > h.w[j]
+	}
+
+	return i < j
+}
+
+func (h *argHeap) Len() int           { return len(h.indices) }
+func (h *argHeap) Less(i, j int) bool { return h.better(h.indices[j], h.indices[i]) }
+func (h *argHeap) Swap(i, j int)      { h.indices[i], h.indices[j] = h.indices[j], h.indices[i] }
+func (h *argHeap) Push(x interface{}) { h.indices = append(h.indices, x.(int)) }
+func (h *argHeap) Pop() interface{} {
+	last := h.indices[len(h.indices)-1]
+	h.indices = h.indices[:len(h.indices)-1]
+
+	return last
+}
+
+// SpatialSoftmax treats each depth slice of v as a map of scores, takes a
+// softmax over its positions, and returns the expected position under the
+// resulting distribution. The result is a 1x1x(2*depth) Vol in which
+// elements 2*d and 2*d+1 are the expected x and y for depth d, in the
+// same units as the x and y arguments of Get. This is how keypoints are
+// read from feature maps in visuomotor control (Levine et al. 2016).
+func (v *Vol) SpatialSoftmax() *Vol {
+	out := NewVol(1, 1, 2*v.Depth, 0.0)
+
+	for d := 0; d < v.Depth; d++ {
+		// subtract the max for numerical stability
+		maxv := math.Inf(-1)
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				maxv = math.Max(maxv, v.Get(x, y, d))
+			}
+		}
+
+		sum, ex, ey := 0.0, 0.0, 0.0
+		for x := 0; x < v.Sx; x++ {
+			for y := 0; y < v.Sy; y++ {
+				e := math.Exp(v.Get(x, y, d) - maxv)
+				sum += e
+				ex += e * float64(x)
+				ey += e * float64(y)
+			}
+		}
+
+		out.W[d*2] = ex / sum
+		out.W[d*2+1] = ey / sum
+	}
+
+	return out
+}
+
 // returns a Vol of size (W, H, 4). 4 is for RGBA
 func ImgToVol(img image.Image, convertGrayscale bool) *Vol {
 	// ensure RGBA
@@ -2,6 +2,8 @@ package convnet
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 )
@@ -76,6 +78,63 @@ func NewVolRand(sx, sy, depth int, r *rand.Rand) *Vol {
 	return v
 }
 
+// NewVolKaiming returns a volume of random weights for a layer with fanIn
+// inputs per output, using Kaiming (He et al. 2015) initialization: the
+// weights are normally distributed with a standard deviation of
+// sqrt(2/fanIn), which keeps the variance of activations steady through
+// relu layers.
+func NewVolKaiming(sx, sy, depth int, fanIn int, r *rand.Rand) *Vol {
+	v := NewVol(sx, sy, depth, 0.0)
+
+	scale := math.Sqrt(2.0 / float64(fanIn))
+
+	for i := range v.W {
+		v.W[i] = r.NormFloat64() * scale
+	}
+
+	return v
+}
+
+// NewVolXavier returns a volume of random weights for a layer with fanIn
+// inputs and fanOut outputs per weight, using Xavier (Glorot and Bengio
+// 2010) initialization: the weights are uniformly distributed in
+// [-a, a], where a is sqrt(6/(fanIn+fanOut)).
+func NewVolXavier(sx, sy, depth int, fanIn, fanOut int, r *rand.Rand) *Vol {
+	v := NewVol(sx, sy, depth, 0.0)
+
+	scale := math.Sqrt(6.0 / (float64(fanIn) + float64(fanOut)))
+
+	for i := range v.W {
+		v.W[i] = (r.Float64()*2 - 1) * scale
+	}
+
+	return v
+}
+
+// OneHot returns a 1x1xnumClasses volume that is 1 at class and 0
+// everywhere else.
+func OneHot(class, numClasses int) (*Vol, error) {
+	return SoftOneHot(class, numClasses, 0)
+}
+
+// SoftOneHot is like OneHot, but with label smoothing: smoothing of the
+// probability is spread evenly over every class, so class gets
+// 1-smoothing+smoothing/numClasses and the others get smoothing/numClasses.
+func SoftOneHot(class, numClasses int, smoothing float64) (*Vol, error) {
+	if class < 0 || class >= numClasses {
+		return nil, fmt.Errorf("convnet: class %d is out of range for %d classes", class, numClasses)
+	}
+
+	if smoothing < 0 || smoothing > 1 {
+		return nil, fmt.Errorf("convnet: label smoothing must be between 0 and 1, but it is %g", smoothing)
+	}
+
+	v := NewVol(1, 1, numClasses, smoothing/float64(numClasses))
+	v.W[class] += 1 - smoothing
+
+	return v, nil
+}
+
 func (v *Vol) index(x, y, d int) int {
 	return ((v.Sx*y)+x)*v.Depth + d
 }
@@ -127,6 +186,111 @@ func (v *Vol) SetConst(a float64) {
 		v.W[k] = a
 	}
 }
+func (v *Vol) sameShape(v2 *Vol) bool {
+	return v.Sx == v2.Sx && v.Sy == v2.Sy && v.Depth == v2.Depth && len(v.W) == len(v2.W)
+}
+
+// Equal reports whether v and v2 have the same dimensions and exactly the
+// same values. Values are compared by their bit patterns rather than with
+// ==, which differs from == in two ways: 0 does not equal -0, and NaN
+// equals NaN (whatever its bits), as in ApproxEqual. So a Vol holding NaN
+// equals itself and its Clone.
+func (v *Vol) Equal(v2 *Vol) bool {
+	if !v.sameShape(v2) {
+		return false
+	}
+
+	for k := range v.W {
+		a, b := v.W[k], v2.W[k]
+		if math.Float64bits(a) != math.Float64bits(b) && !(math.IsNaN(a) && math.IsNaN(b)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ApproxEqual reports whether v and v2 have the same dimensions and every
+// pair of values is equal or differs by less than tolerance. NaN equals
+// NaN, as in Equal, and nothing else.
+func (v *Vol) ApproxEqual(v2 *Vol, tolerance float64) bool {
+	if !v.sameShape(v2) {
+		return false
+	}
+
+	for k := range v.W {
+		a, b := v.W[k], v2.W[k]
+		if a == b || (math.IsNaN(a) && math.IsNaN(b)) {
+			continue
+		}
+
+		if !(math.Abs(a-b) < tolerance) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MaxAbsDiff returns the largest absolute difference between
+// corresponding values of v and v2
+func (v *Vol) MaxAbsDiff(v2 *Vol) (float64, error) {
+	if !v.sameShape(v2) {
+		return 0, fmt.Errorf("convnet: cannot compare %dx%dx%d Vol to %dx%dx%d Vol", v.Sx, v.Sy, v.Depth, v2.Sx, v2.Sy, v2.Depth)
+	}
+
+	maxDiff := 0.0
+	for k := range v.W {
+		if d := math.Abs(v.W[k] - v2.W[k]); d > maxDiff || math.IsNaN(d) {
+			maxDiff = d
+		}
+	}
+
+	return maxDiff, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between v.W and
+// other.W, which must have the same number of values. It is an error for
+// either of them to be all zeros.
+func (v *Vol) CosineSimilarity(other *Vol) (float64, error) {
+	if len(v.W) != len(other.W) {
+		return 0, fmt.Errorf("convnet: cannot compare %d values to %d values", len(v.W), len(other.W))
+	}
+
+	dot, normV, normOther := 0.0, 0.0, 0.0
+	for k, w := range v.W {
+		dot += w * other.W[k]
+		normV += w * w
+		normOther += other.W[k] * other.W[k]
+	}
+
+	if normV == 0 || normOther == 0 {
+		return 0, errors.New("convnet: cosine similarity is undefined for a zero vector")
+	}
+
+	return dot / math.Sqrt(normV*normOther), nil
+}
+
+// NearestNeighbor returns the index of the Vol in gallery that has the
+// largest cosine similarity to query, and that similarity. Vols that
+// cannot be compared to query are skipped; if none can, it returns -1 and
+// 0.
+func NearestNeighbor(query *Vol, gallery []*Vol) (int, float64) {
+	best, bestSim := -1, 0.0
+
+	for i, g := range gallery {
+		sim, err := query.CosineSimilarity(g)
+		if err != nil {
+			continue
+		}
+
+		if best == -1 || sim > bestSim {
+			best, bestSim = i, sim
+		}
+	}
+
+	return best, bestSim
+}
 
 func (v *Vol) UnmarshalJSON(b []byte) error {
 	var data struct {
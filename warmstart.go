@@ -0,0 +1,125 @@
+package convnet
+
+// WarmStart copies the parameters of each layer of checkpoint into the
+// layer at the same index of n, for continuing training after the
+// architecture of n has changed. A layer is only loaded if it has the
+// same number of parameter groups as the checkpoint's layer, each group
+// is the same size, and its filters have the same width, height, and
+// depth; other layers keep their current parameters.
+// Layers of n past the end of checkpoint are skipped, and layers without
+// parameters are not counted either way.
+//
+// If matchByType is false, a layer whose type differs from the
+// checkpoint's is an error and nothing is loaded. If it is true, such
+// layers are skipped instead, even if their parameters are the same size.
+//
+// The running statistics of batch norm layers are copied along with
+// their parameters. Gradients are not copied.
+func (n *Net) WarmStart(checkpoint *Net, matchByType bool) (loaded, skipped int, err error) {
+	type match struct {
+		dst, src Layer
+	}
+
+	var matches []match
+
+	for i, l := range n.Layers {
+		pgs := l.ParamsAndGrads()
+		if len(pgs) == 0 {
+			continue
+		}
+
+		if i >= len(checkpoint.Layers) {
+			skipped++
+
+			continue
+		}
+
+		c := checkpoint.Layers[i]
+		if layerTypeOf(l) != layerTypeOf(c) {
+			if !matchByType {
+				return 0, 0, &LayerError{LayerIndex: i, Type: layerTypeOf(l), Reason: "checkpoint has a " + layerTypeOf(c).String() + " layer here"}
+			}
+
+			skipped++
+
+			continue
+		}
+
+		if !sameParamShapes(l, c) {
+			skipped++
+
+			continue
+		}
+
+		matches = append(matches, match{l, c})
+	}
+
+	for _, m := range matches {
+		src := m.src.ParamsAndGrads()
+		for j, pg := range m.dst.ParamsAndGrads() {
+			copy(pg.Params, src[j].Params)
+		}
+
+		if bn, ok := m.dst.(*BatchNormLayer); ok {
+			bn.copyStats(m.src.(*BatchNormLayer))
+		}
+	}
+
+	return len(matches), skipped, nil
+}
+
+// reports whether layers a and b, which are the same type, have
+// parameters of the same shapes, so that one can be copied into the other
+func sameParamShapes(a, b Layer) bool {
+	pa, pb := a.ParamsAndGrads(), b.ParamsAndGrads()
+	if len(pa) != len(pb) {
+		return false
+	}
+
+	for i := range pa {
+		if len(pa[i].Params) != len(pb[i].Params) {
+			return false
+		}
+	}
+
+	// a 3x1 filter has as many weights as a 1x3 filter
+	fa, fb := filtersOf(a), filtersOf(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+
+	for i := range fa {
+		if fa[i].Sx != fb[i].Sx || fa[i].Sy != fb[i].Sy || fa[i].Depth != fb[i].Depth {
+			return false
+		}
+	}
+
+	return true
+}
+
+// returns the volumes holding the weights of l, whose shapes matter as
+// well as their sizes. biases and other per-depth parameters are left
+// out, as their size determines their shape.
+func filtersOf(l Layer) []*Vol {
+	switch l := l.(type) {
+	case *ConvLayer:
+		return l.filters
+	case *FullyConnLayer:
+		return l.filters
+	case *DeformConvLayer:
+		return append(append([]*Vol(nil), l.filters...), l.offset.filters...)
+	case *CausalConvLayer:
+		return l.filters
+	case *EmbeddingLayer:
+		return []*Vol{l.table}
+	case *FPNLayer:
+		var filters []*Vol
+		for _, c := range l.laterals {
+			filters = append(filters, c.filters...)
+		}
+
+		return filters
+	}
+
+	return nil
+}